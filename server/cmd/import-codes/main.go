@@ -0,0 +1,111 @@
+// Command import-codes loads a CSV or JSON code set into the procedure or diagnosis template
+// catalog, using the same rules as the import endpoints. It prints the diff and only writes it
+// with -commit. The database settings come from the environment or .env, as for the server, and
+// the schema must already have been migrated by the server.
+//
+//	go run ./cmd/import-codes -kind procedure -file cdt.csv
+//	go run ./cmd/import-codes -kind diagnosis -file icd10-k00-k14.json -deactivate-missing -prefix K -commit
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joho/godotenv"
+
+	"dentika/server/database"
+	"dentika/server/models"
+)
+
+func main() {
+	kind := flag.String("kind", "", "catalog to import into: procedure or diagnosis")
+	file := flag.String("file", "", "CSV or JSON code set to import")
+	format := flag.String("format", "", "csv or json (default: from the file extension)")
+	clinicID := flag.Uint("clinic", uint(models.PlatformCatalogID), "clinic whose catalog to import into (0 for the platform catalog)")
+	categoryMap := flag.String("category-map", "", `JSON object renaming the file's categories, e.g. {"Diagnostic":"diagnostic"}`)
+	deactivateMissing := flag.Bool("deactivate-missing", false, "deactivate catalog codes the file leaves out")
+	prefix := flag.String("prefix", "", "with -deactivate-missing, only consider codes starting with this prefix")
+	commit := flag.Bool("commit", false, "write the changes (default is a dry run)")
+	flag.Parse()
+
+	if !models.IsValidCodeSetKind(models.CodeSetKind(*kind)) || *file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v", *file, err)
+	}
+	defer f.Close()
+
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*file)), ".")
+	}
+	entries, err := models.ParseCodeSet(f, *format)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", *file, err)
+	}
+
+	opts := models.CodeSetImport{
+		Kind:              models.CodeSetKind(*kind),
+		ClinicID:          *clinicID,
+		Entries:           entries,
+		DeactivateMissing: *deactivateMissing,
+		CodePrefix:        *prefix,
+	}
+	if *categoryMap != "" {
+		if err := json.Unmarshal([]byte(*categoryMap), &opts.CategoryMap); err != nil {
+			log.Fatalf("Invalid -category-map: %v", err)
+		}
+	}
+
+	database.ConnectDatabase()
+
+	var report *models.CodeSetReport
+	if *commit {
+		report, err = models.ApplyCodeSetImport(database.DB, opts)
+	} else {
+		report, err = models.PlanCodeSetImport(database.DB, opts)
+	}
+	if err != nil {
+		log.Fatalf("Import failed: %v", err)
+	}
+
+	printReport(report)
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+func printReport(report *models.CodeSetReport) {
+	for _, change := range report.Changes {
+		fmt.Printf("%-10s %-12s %s\n", change.Action, change.Code, change.Name)
+		for _, field := range change.Changes {
+			fmt.Printf("           %-18s %v -> %v\n", field.Field, field.From, field.To)
+		}
+	}
+	for _, rowErr := range report.Errors {
+		fmt.Printf("error      row %d %s: %s\n", rowErr.Row, rowErr.Code, rowErr.Error)
+	}
+
+	fmt.Printf("\n%d entries: %d to create, %d to update, %d to reactivate, %d to deactivate, %d unchanged\n",
+		report.Entries, report.Created, report.Updated, report.Reactivated, report.Deactivated, report.Unchanged)
+	switch {
+	case len(report.Errors) > 0:
+		fmt.Printf("%d errors; nothing was written\n", len(report.Errors))
+	case report.Applied:
+		fmt.Println("Changes written")
+	default:
+		fmt.Println("Dry run; run again with -commit to write these changes")
+	}
+}
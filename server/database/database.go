@@ -3,7 +3,9 @@ package database
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"time"
 
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -17,6 +19,9 @@ func ConnectDatabase() {
 	dbHost := os.Getenv("DB_HOST")
 	dbPort := os.Getenv("DB_PORT")
 	dbName := os.Getenv("DB_NAME")
+	// Zone DATETIME values are stored in. Databases written before per-branch timezones stored the
+	// server's TIMEZONE, so that is the default.
+	dbTimezone := os.Getenv("DB_TIMEZONE")
 
 	if dbUser == "" {
 		dbUser = "root"
@@ -33,9 +38,18 @@ func ConnectDatabase() {
 	if dbName == "" {
 		dbName = "app_template"
 	}
+	if dbTimezone == "" {
+		dbTimezone = os.Getenv("TIMEZONE")
+	}
+	if _, err := time.LoadLocation(dbTimezone); err != nil || dbTimezone == "" {
+		if dbTimezone != "" {
+			log.Printf("Invalid database timezone '%s', falling back to UTC: %v", dbTimezone, err)
+		}
+		dbTimezone = "UTC"
+	}
 
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		dbUser, dbPassword, dbHost, dbPort, dbName)
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=%s",
+		dbUser, dbPassword, dbHost, dbPort, dbName, url.QueryEscape(dbTimezone))
 
 	database, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
 	if err != nil {
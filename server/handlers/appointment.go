@@ -1,7 +1,7 @@
 package handlers
 
 import (
-	"encoding/json"
+	"log"
 	"strconv"
 	"time"
 
@@ -16,7 +16,7 @@ type CreateAppointmentRequest struct {
 	Description         string                   `json:"description"`
 	Date                string                   `json:"date"` // Format: "2006-01-02"
 	Time                string                   `json:"time"` // Format: "15:04"
-	StartTime           time.Time                `json:"-"`    // Calculated from date and time
+	StartTime           time.Time                `json:"-"`    // Calculated from date and time in the branch's timezone
 	EndTime             time.Time                `json:"-"`    // Calculated from start_time and duration
 	Duration            int                      `json:"duration"`
 	Status              models.AppointmentStatus `json:"status"`
@@ -26,78 +26,31 @@ type CreateAppointmentRequest struct {
 	EstimatedCost       *float64                 `json:"estimated_cost,omitempty"`
 	PreAppointmentNotes string                   `json:"pre_appointment_notes"`
 	// Procedures will be added separately via /appointments/{id}/procedures endpoint
-}
-
-// Custom time parsing for JSON
-func (t *CreateAppointmentRequest) UnmarshalJSON(data []byte) error {
-	// First, unmarshal into a map to extract date/time
-	var raw map[string]interface{}
-	if err := json.Unmarshal(data, &raw); err != nil {
-		return err
-	}
-
-	// Extract date and time
-	if dateVal, ok := raw["date"]; ok {
-		if dateStr, ok := dateVal.(string); ok {
-			t.Date = dateStr
-		}
-	}
-	if timeVal, ok := raw["time"]; ok {
-		if timeStr, ok := timeVal.(string); ok {
-			t.Time = timeStr
-		}
-	}
 
-	// Parse date and time to create start_time in Asia/Manila timezone
-	if t.Date != "" && t.Time != "" {
-		// Load Asia/Manila timezone
-		location, err := time.LoadLocation("Asia/Manila")
-		if err != nil {
-			return err
-		}
-
-		// Parse date and time
-		dateTimeStr := t.Date + " " + t.Time
-		parsedTime, err := time.ParseInLocation("2006-01-02 15:04", dateTimeStr, location)
-		if err != nil {
-			return err
-		}
-
-		t.StartTime = parsedTime
-
-		// Calculate end time based on duration
-		if t.Duration > 0 {
-			t.EndTime = parsedTime.Add(time.Duration(t.Duration) * time.Minute)
-		}
-	}
+	// Chairs, rooms and equipment to book; resources required by the planned procedures are added automatically
+	ResourceIDs          []uint `json:"resource_ids"`
+	ProcedureTemplateIDs []uint `json:"procedure_template_ids"`
+}
 
-	// Now unmarshal the rest normally
-	type Alias CreateAppointmentRequest
-	aux := &struct {
-		*Alias
-	}{
-		Alias: (*Alias)(t),
+// resolveTimes parses the date and time in the branch's timezone
+func (t *CreateAppointmentRequest) resolveTimes(location *time.Location) error {
+	if t.Date == "" || t.Time == "" {
+		return nil
 	}
-
-	// Remove date and time from raw to avoid conflict
-	delete(raw, "date")
-	delete(raw, "time")
-
-	// Marshal back and unmarshal into aux
-	data, err := json.Marshal(raw)
+	startTime, err := time.ParseInLocation("2006-01-02 15:04", t.Date+" "+t.Time, location)
 	if err != nil {
 		return err
 	}
-
-	if err := json.Unmarshal(data, aux); err != nil {
-		return err
+	t.StartTime = startTime
+	if t.Duration > 0 {
+		t.EndTime = startTime.Add(time.Duration(t.Duration) * time.Minute)
 	}
-
 	return nil
 }
 
 type UpdateAppointmentStatusRequest struct {
 	Status               models.AppointmentStatus `json:"status"`
+	Reason               string                   `json:"reason"` // required when cancelling
 	PostAppointmentNotes string                   `json:"post_appointment_notes"`
 	ActualCost           float64                  `json:"actual_cost"`
 	NextAppointmentDate  *time.Time               `json:"next_appointment_date"`
@@ -116,9 +69,12 @@ func GetAppointments(c *fiber.Ctx) error {
 			Where("branches.clinic_id = ?", user.ClinicID)
 	}
 
+	// Dates are days in the branch's local time, or the clinic's
+	location := requestLocation(c, user)
+
 	// Date filtering
 	if dateStr := c.Query("date"); dateStr != "" {
-		if date, err := time.Parse("2006-01-02", dateStr); err == nil {
+		if date, err := time.ParseInLocation("2006-01-02", dateStr, location); err == nil {
 			startOfDay := date
 			endOfDay := date.Add(24 * time.Hour)
 			query = query.Where("start_time >= ? AND start_time < ?", startOfDay, endOfDay)
@@ -127,12 +83,12 @@ func GetAppointments(c *fiber.Ctx) error {
 
 	// Date range filtering
 	if startStr := c.Query("start_date"); startStr != "" {
-		if startDate, err := time.Parse("2006-01-02", startStr); err == nil {
+		if startDate, err := time.ParseInLocation("2006-01-02", startStr, location); err == nil {
 			query = query.Where("start_time >= ?", startDate)
 		}
 	}
 	if endStr := c.Query("end_date"); endStr != "" {
-		if endDate, err := time.Parse("2006-01-02", endStr); err == nil {
+		if endDate, err := time.ParseInLocation("2006-01-02", endStr, location); err == nil {
 			query = query.Where("start_time <= ?", endDate.Add(24*time.Hour))
 		}
 	}
@@ -164,8 +120,8 @@ func GetAppointments(c *fiber.Ctx) error {
 
 	// Today's appointments
 	if c.Query("today") == "true" {
-		now := time.Now()
-		startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		now := time.Now().In(location)
+		startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
 		endOfDay := startOfDay.Add(24 * time.Hour)
 		query = query.Where("start_time >= ? AND start_time < ?", startOfDay, endOfDay)
 	}
@@ -199,7 +155,8 @@ func GetAppointment(c *fiber.Ctx) error {
 	var appointment models.Appointment
 	query := database.DB.Preload("Patient").Preload("Doctor").Preload("Branch").Preload("Branch.Clinic").
 		Preload("Procedures").Preload("Procedures.ProcedureTemplate").Preload("Procedures.PerformedBy").
-		Preload("Diagnoses").Preload("Diagnoses.DiagnosisTemplate").Preload("Diagnoses.DiagnosedBy")
+		Preload("Diagnoses").Preload("Diagnoses.DiagnosisTemplate").Preload("Diagnoses.DiagnosedBy").
+		Preload("Resources.Resource")
 
 	if err := query.First(&appointment, appointmentID).Error; err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Appointment not found"})
@@ -213,6 +170,32 @@ func GetAppointment(c *fiber.Ctx) error {
 	return c.JSON(appointment)
 }
 
+// GetAppointmentReminders lists the reminders planned for an appointment
+func GetAppointmentReminders(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+	appointmentID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid appointment ID"})
+	}
+
+	var appointment models.Appointment
+	if err := database.DB.First(&appointment, appointmentID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Appointment not found"})
+	}
+
+	// Check access
+	if !user.IsSuperAdmin() && user.ClinicID != appointment.ClinicID {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	var reminders []models.AppointmentReminder
+	if err := database.DB.Where("appointment_id = ?", appointment.ID).Order("reminder_time ASC").Find(&reminders).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch reminders"})
+	}
+
+	return c.JSON(reminders)
+}
+
 func CreateAppointment(c *fiber.Ctx) error {
 	user := c.Locals("user").(models.User)
 
@@ -236,8 +219,9 @@ func CreateAppointment(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Date and time are required"})
 	}
 
-	if req.StartTime.IsZero() {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid date or time format"})
+	// New appointments start out booked; later statuses go through PUT /appointments/:id/status
+	if req.Status != "" && req.Status != models.StatusScheduled && req.Status != models.StatusConfirmed {
+		return c.Status(400).JSON(fiber.Map{"error": "New appointments must be scheduled or confirmed"})
 	}
 
 	// Validate patient belongs to accessible clinic
@@ -271,6 +255,12 @@ func CreateAppointment(c *fiber.Ctx) error {
 		}
 	}
 
+	// The date and time are in the branch's local time
+	location := models.BranchLocation(database.DB, branch.ID)
+	if err := req.resolveTimes(location); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid date or time format"})
+	}
+
 	// Calculate end time if not provided
 	if req.EndTime.IsZero() {
 		if req.Duration > 0 {
@@ -285,9 +275,8 @@ func CreateAppointment(c *fiber.Ctx) error {
 
 	// Check for conflicting appointments with same doctor
 	var conflictingAppointments []models.Appointment
-	if err := database.DB.Where("doctor_id = ? AND status IN (?, ?) AND ((start_time <= ? AND end_time > ?) OR (start_time < ? AND end_time >= ?))",
-		req.DoctorID, models.StatusScheduled, models.StatusConfirmed,
-		req.StartTime, req.StartTime, req.EndTime, req.EndTime).Find(&conflictingAppointments).Error; err != nil {
+	if err := database.DB.Where("doctor_id = ? AND status IN ? AND start_time < ? AND end_time > ?",
+		req.DoctorID, models.BlockingStatuses, req.EndTime, req.StartTime).Find(&conflictingAppointments).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to check for conflicts"})
 	}
 
@@ -298,6 +287,13 @@ func CreateAppointment(c *fiber.Ctx) error {
 		})
 	}
 
+	// Check and assign chairs, rooms and equipment
+	resources, status, body := resolveAppointmentResources(req.BranchID, req.ResourceIDs, req.ProcedureTemplateIDs,
+		req.StartTime, req.EndTime, 0)
+	if status != 0 {
+		return c.Status(status).JSON(body)
+	}
+
 	// Set default estimated cost if not provided
 	estimatedCost := 0.0
 	if req.EstimatedCost != nil {
@@ -315,8 +311,10 @@ func CreateAppointment(c *fiber.Ctx) error {
 		DoctorID:            req.DoctorID,
 		BranchID:            req.BranchID,
 		ClinicID:            branch.ClinicID,
+		Timezone:            location.String(),
 		EstimatedCost:       estimatedCost,
 		PreAppointmentNotes: req.PreAppointmentNotes,
+		Resources:           appointmentResourceRows(0, resources),
 	}
 
 	// Set default status if not provided
@@ -324,12 +322,28 @@ func CreateAppointment(c *fiber.Ctx) error {
 		appointment.Status = models.StatusScheduled
 	}
 
-	if err := database.DB.Create(&appointment).Error; err != nil {
+	tx := database.DB.Begin()
+	conflicts, err := lockAppointmentResources(tx, resources, appointment.StartTime, appointment.EndTime, 0)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to check for conflicts"})
+	}
+	if len(conflicts) > 0 {
+		tx.Rollback()
+		return c.Status(409).JSON(fiber.Map{"error": "Resource is already booked at this time", "resource_conflicts": conflicts})
+	}
+	if err := tx.Create(&appointment).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create appointment"})
+	}
+	if err := tx.Commit().Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to create appointment"})
 	}
 
+	scheduleAppointmentReminders(&appointment)
+
 	// Reload with relationships
-	database.DB.Preload("Patient").Preload("Doctor").Preload("Branch").First(&appointment, appointment.ID)
+	database.DB.Preload("Patient").Preload("Doctor").Preload("Branch").Preload("Resources.Resource").First(&appointment, appointment.ID)
 
 	// Send WebSocket notification for new appointment (clinic-scoped)
 	go SendAppointmentUpdate(appointment.ID, appointment.Patient.FirstName+" "+appointment.Patient.LastName, "scheduled", appointment.Branch.ClinicID)
@@ -366,6 +380,25 @@ func UpdateAppointment(c *fiber.Ctx) error {
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
 	}
+	if req.Status != "" && req.Status != appointment.Status {
+		return c.Status(400).JSON(fiber.Map{"error": "Use PUT /appointments/:id/status to change an appointment's status"})
+	}
+
+	// What the patient's calendar invitation, if any, shows
+	previous := appointment
+
+	// A new date and time are in the local time of the branch the appointment will be at
+	branchID := appointment.BranchID
+	if req.BranchID > 0 {
+		branchID = req.BranchID
+	}
+	location := models.BranchLocation(database.DB, branchID)
+	if err := req.resolveTimes(location); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid date or time format"})
+	}
+	if !req.StartTime.IsZero() && req.EndTime.IsZero() {
+		req.EndTime = req.StartTime.Add(time.Duration(appointment.Duration) * time.Minute)
+	}
 
 	// Update appointment fields
 	if req.Title != "" {
@@ -383,9 +416,6 @@ func UpdateAppointment(c *fiber.Ctx) error {
 	if req.Duration > 0 {
 		appointment.Duration = req.Duration
 	}
-	if req.Status != "" {
-		appointment.Status = req.Status
-	}
 
 	// Update foreign key fields
 	if req.PatientID > 0 {
@@ -401,6 +431,7 @@ func UpdateAppointment(c *fiber.Ctx) error {
 		if err := database.DB.First(&branch, req.BranchID).Error; err == nil {
 			appointment.ClinicID = branch.ClinicID
 		}
+		appointment.Timezone = location.String()
 	}
 
 	if req.EstimatedCost != nil {
@@ -415,16 +446,60 @@ func UpdateAppointment(c *fiber.Ctx) error {
 		appointment.Duration = int(appointment.EndTime.Sub(appointment.StartTime).Minutes())
 	}
 
-	if err := database.DB.Save(&appointment).Error; err != nil {
+	// Re-check booked resources against the new time, or replace them when given
+	resourceIDs := req.ResourceIDs
+	if resourceIDs == nil {
+		database.DB.Model(&models.AppointmentResource{}).Where("appointment_id = ?", appointment.ID).Pluck("resource_id", &resourceIDs)
+	}
+	resources, status, body := resolveAppointmentResources(appointment.BranchID, resourceIDs, req.ProcedureTemplateIDs,
+		appointment.StartTime, appointment.EndTime, appointment.ID)
+	if status != 0 {
+		return c.Status(status).JSON(body)
+	}
+
+	tx := database.DB.Begin()
+	conflicts, err := lockAppointmentResources(tx, resources, appointment.StartTime, appointment.EndTime, appointment.ID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to check for conflicts"})
+	}
+	if len(conflicts) > 0 {
+		tx.Rollback()
+		return c.Status(409).JSON(fiber.Map{"error": "Resource is already booked at this time", "resource_conflicts": conflicts})
+	}
+	if err := tx.Omit("Resources").Save(&appointment).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update appointment"})
+	}
+	if err := tx.Where("appointment_id = ?", appointment.ID).Delete(&models.AppointmentResource{}).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update appointment"})
+	}
+	if rows := appointmentResourceRows(appointment.ID, resources); len(rows) > 0 {
+		if err := tx.Create(&rows).Error; err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update appointment"})
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update appointment"})
 	}
 
+	// Move or cancel reminders to match the new time and status
+	scheduleAppointmentReminders(&appointment)
+
 	// Reload with relationships
-	database.DB.Preload("Patient").Preload("Doctor").Preload("Branch").First(&appointment, appointment.ID)
+	database.DB.Preload("Patient").Preload("Doctor").Preload("Branch").Preload("Resources.Resource").First(&appointment, appointment.ID)
 
 	// Send WebSocket notification for appointment update (clinic-scoped)
 	go SendAppointmentUpdate(appointment.ID, appointment.Patient.FirstName+" "+appointment.Patient.LastName, "updated", appointment.Branch.ClinicID)
 
+	// Keep the patient's calendar in step
+	if !appointment.StartTime.Equal(previous.StartTime) || !appointment.EndTime.Equal(previous.EndTime) ||
+		appointment.BranchID != previous.BranchID {
+		go queueAppointmentCalendarUpdate(appointment.ID)
+	}
+
 	return c.JSON(appointment)
 }
 
@@ -450,6 +525,35 @@ func UpdateAppointmentStatus(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
 	}
 
+	// Enforce the status flow; resending the current status only updates the notes and costs
+	previousStatus := appointment.Status
+	if req.Status != previousStatus {
+		if req.Status == models.StatusRescheduled {
+			return c.Status(400).JSON(fiber.Map{"error": "Use POST /appointments/:id/reschedule to reschedule"})
+		}
+		if !models.IsValidAppointmentStatus(req.Status) {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid status"})
+		}
+		if err := models.ValidateTransition(previousStatus, req.Status, req.Reason); err != nil {
+			status := 400
+			if !models.CanTransition(previousStatus, req.Status) {
+				status = 409
+			}
+			return c.Status(status).JSON(fiber.Map{
+				"error":               err.Error(),
+				"allowed_transitions": models.AllowedTransitions(previousStatus),
+			})
+		}
+		if req.Status == models.StatusNoShow {
+			if appointment.PatientArrived {
+				return c.Status(409).JSON(fiber.Map{"error": "The patient has arrived for this appointment"})
+			}
+			if time.Now().Before(appointment.StartTime) {
+				return c.Status(409).JSON(fiber.Map{"error": "An appointment cannot be a no-show before it starts"})
+			}
+		}
+	}
+
 	appointment.Status = req.Status
 	if req.PostAppointmentNotes != "" {
 		appointment.PostAppointmentNotes = req.PostAppointmentNotes
@@ -461,13 +565,43 @@ func UpdateAppointmentStatus(c *fiber.Ctx) error {
 		appointment.NextAppointmentDate = req.NextAppointmentDate
 	}
 
-	if err := database.DB.Save(&appointment).Error; err != nil {
+	tx := database.DB.Begin()
+	if err := tx.Omit("Resources").Save(&appointment).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update appointment status"})
+	}
+	if appointment.Status != previousStatus {
+		if err := models.RecordStatusChange(tx, &appointment, previousStatus, req.Reason, &user.ID); err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update appointment status"})
+		}
+		// Treatment plan items booked into a visit that won't happen go back to be scheduled again
+		if appointment.Status == models.StatusCancelled || appointment.Status == models.StatusNoShow {
+			if err := models.ReleasePlanItems(tx, appointment.ID); err != nil {
+				tx.Rollback()
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to update appointment status"})
+			}
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update appointment status"})
 	}
 
+	scheduleAppointmentReminders(&appointment)
+
 	// Reload with relationships for notification
 	database.DB.Preload("Patient").Preload("Branch").First(&appointment, appointment.ID)
 
+	// Offer the freed slot to waitlisted patients
+	if appointment.Status != previousStatus && (appointment.Status == models.StatusCancelled || appointment.Status == models.StatusNoShow) {
+		go notifyWaitlistMatches(appointment)
+	}
+
+	// Take the appointment off the patient's calendar
+	if appointment.Status == models.StatusCancelled && previousStatus != models.StatusCancelled {
+		go queueAppointmentCalendarUpdate(appointment.ID)
+	}
+
 	// Send WebSocket notification for status update (clinic-scoped)
 	go SendAppointmentUpdate(appointment.ID, appointment.Patient.FirstName+" "+appointment.Patient.LastName, string(appointment.Status), appointment.Branch.ClinicID)
 
@@ -508,13 +642,41 @@ func MarkPatientArrived(c *fiber.Ctx) error {
 		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
 	}
 
-	appointment.MarkPatientArrived()
+	if !canMarkArrived(appointment.Status) {
+		return c.Status(409).JSON(fiber.Map{"error": "Cannot mark a " + string(appointment.Status) + " appointment as arrived"})
+	}
 
-	if err := database.DB.Save(&appointment).Error; err != nil {
+	entry, err := markAppointmentArrived(&appointment, user.ID)
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to mark patient as arrived"})
 	}
 
-	return c.JSON(fiber.Map{"message": "Patient marked as arrived", "is_late": appointment.IsLate})
+	return c.JSON(fiber.Map{"message": "Patient marked as arrived", "is_late": appointment.IsLate, "queue_entry": entry})
+}
+
+// canMarkArrived reports whether a patient can arrive for an appointment with the status
+func canMarkArrived(status models.AppointmentStatus) bool {
+	switch status {
+	case models.StatusScheduled, models.StatusConfirmed, models.StatusInProgress:
+		return true
+	}
+	return false
+}
+
+// markAppointmentArrived records the patient's arrival and puts them in the front desk queue.
+// actorID is 0 when the patient checked themselves in.
+func markAppointmentArrived(appointment *models.Appointment, actorID uint) (*models.QueueEntry, error) {
+	appointment.MarkPatientArrived()
+
+	if err := database.DB.Save(appointment).Error; err != nil {
+		return nil, err
+	}
+
+	entry, err := queueAppointmentArrival(appointment, actorID)
+	if err != nil {
+		log.Printf("Failed to add appointment %d to the queue: %v", appointment.ID, err)
+	}
+	return entry, nil
 }
 
 func CheckAppointmentAvailability(c *fiber.Ctx) error {
@@ -527,6 +689,8 @@ func CheckAppointmentAvailability(c *fiber.Ctx) error {
 		Duration  int    `json:"duration"`
 		BranchID  uint   `json:"branch_id,omitempty"`
 		ExcludeID uint   `json:"exclude_id,omitempty"` // For editing existing appointments
+
+		ResourceIDs []uint `json:"resource_ids,omitempty"`
 	}
 
 	if err := c.BodyParser(&req); err != nil {
@@ -534,14 +698,14 @@ func CheckAppointmentAvailability(c *fiber.Ctx) error {
 	}
 
 	// Validate required fields
-	if req.DoctorID == 0 || req.Date == "" || req.Time == "" || req.Duration == 0 {
-		return c.Status(400).JSON(fiber.Map{"error": "Doctor ID, date, time, and duration are required"})
+	if req.Date == "" || req.Time == "" || req.Duration == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Date, time, and duration are required"})
 	}
 
-	// Parse date and time to create start_time in Asia/Manila timezone
-	location, err := time.LoadLocation("Asia/Manila")
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to load timezone"})
+	// The date and time are in the branch's local time, or the clinic's without a branch
+	location := models.ClinicLocation(database.DB, user.ClinicID)
+	if req.BranchID != 0 {
+		location = models.BranchLocation(database.DB, req.BranchID)
 	}
 
 	dateTimeStr := req.Date + " " + req.Time
@@ -551,15 +715,118 @@ func CheckAppointmentAvailability(c *fiber.Ctx) error {
 	}
 
 	endTime := startTime.Add(time.Duration(req.Duration) * time.Minute)
+	slot := models.TimeRange{Start: startTime, End: endTime}
+
+	// Chairs, rooms and equipment, which must be at the branch, or in the clinic without one
+	if len(req.ResourceIDs) > 0 {
+		resourceQuery := database.DB.Model(&models.Resource{}).Where("id IN ?", req.ResourceIDs)
+		if req.BranchID != 0 {
+			resourceQuery = resourceQuery.Where("branch_id = ?", req.BranchID)
+		}
+		if !user.IsSuperAdmin() {
+			resourceQuery = resourceQuery.Where("branch_id IN (?)",
+				database.DB.Model(&models.Branch{}).Select("id").Where("clinic_id = ?", user.ClinicID))
+		}
+		var count int64
+		if err := resourceQuery.Count(&count).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to load resources"})
+		}
+		if int(count) != len(uniqueUints(req.ResourceIDs)) {
+			return c.Status(400).JSON(fiber.Map{"error": "Resources must belong to the appointment's branch"})
+		}
+
+		conflicts, err := models.FindResourceConflicts(database.DB, req.ResourceIDs, startTime, endTime, req.ExcludeID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to check for conflicts"})
+		}
+		if len(conflicts) > 0 {
+			return c.JSON(fiber.Map{
+				"available":          false,
+				"reason":             "resource_conflict",
+				"resource_conflicts": conflicts,
+				"message":            "Resource is already booked at this time",
+			})
+		}
+	}
+
+	// Without a doctor, report which doctors are free (pooled availability)
+	if req.DoctorID == 0 {
+		var branchID *uint
+		if req.BranchID != 0 {
+			branchID = &req.BranchID
+		}
+		availability, err := computeDoctorAvailability(startTime, user.ClinicID, branchID, nil)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to check availability"})
+		}
+
+		freeDoctors := []fiber.Map{}
+		seen := map[uint]bool{}
+		for _, a := range availability {
+			if seen[a.DoctorID] {
+				continue
+			}
+			if _, free := doctorAvailableFor(availability, a.DoctorID, a.BranchID, slot); free {
+				seen[a.DoctorID] = true
+				freeDoctors = append(freeDoctors, fiber.Map{
+					"doctor_id":   a.DoctorID,
+					"doctor_name": a.DoctorName,
+					"branch_id":   a.BranchID,
+				})
+			}
+		}
+
+		if len(freeDoctors) == 0 {
+			return c.JSON(fiber.Map{
+				"available": false,
+				"doctors":   freeDoctors,
+				"message":   "No doctor is available at this time",
+			})
+		}
+		return c.JSON(fiber.Map{
+			"available": true,
+			"doctors":   freeDoctors,
+			"message":   "Time slot is available",
+		})
+	}
+
+	// Check the doctor's working hours, holidays and leave
+	var doctor models.User
+	if err := database.DB.First(&doctor, req.DoctorID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Doctor not found"})
+	}
+	if !doctor.IsSuperAdmin() {
+		availability, err := computeDoctorAvailability(startTime, doctor.ClinicID, nil, &doctor.ID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to check availability"})
+		}
+		if working, _ := doctorAvailableFor(availability, doctor.ID, req.BranchID, slot); !working {
+			return c.JSON(fiber.Map{
+				"available": false,
+				"reason":    "not_working",
+				"message":   "Doctor is not scheduled to work at this time",
+			})
+		}
+
+		var timeOff models.DoctorTimeOff
+		if err := database.DB.Where("doctor_id = ? AND start_time < ? AND end_time > ?", doctor.ID, endTime, startTime).
+			First(&timeOff).Error; err == nil {
+			return c.JSON(fiber.Map{
+				"available": false,
+				"reason":    "time_off",
+				"time_off":  timeOff,
+				"message":   "Doctor is on leave at this time",
+			})
+		}
+	}
 
 	// Check for conflicting appointments
-	query := database.DB.Where("doctor_id = ? AND status IN (?, ?) AND ((start_time <= ? AND end_time > ?) OR (start_time < ? AND end_time >= ?))",
-		req.DoctorID, models.StatusScheduled, models.StatusConfirmed,
-		startTime, startTime, endTime, endTime)
+	query := database.DB.Where("doctor_id = ? AND status IN ? AND start_time < ? AND end_time > ?",
+		req.DoctorID, models.BlockingStatuses, endTime, startTime)
 
 	// Exclude the appointment being edited (if editing)
 	if req.ExcludeID > 0 {
-		query = query.Where("id != ?", req.ExcludeID)
+		query = query.Where("appointments.id <> ?", req.ExcludeID)
 	}
 
 	// Filter by clinic access if not super admin
@@ -0,0 +1,624 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type CreateAppointmentSeriesRequest struct {
+	// Either an RRULE string, e.g. "FREQ=WEEKLY;INTERVAL=4;COUNT=12", or the same fields as an object
+	RRule      string             `json:"rrule"`
+	Recurrence *models.Recurrence `json:"recurrence"`
+
+	// Book nothing if any occurrence cannot be booked; by default the free ones are booked
+	AllOrNothing bool `json:"all_or_nothing"`
+}
+
+type UpdateAppointmentSeriesRequest struct {
+	Scope               string  `json:"scope"` // this, following, all
+	Title               *string `json:"title"`
+	Description         *string `json:"description"`
+	Time                string  `json:"time"` // Format: "15:04", applied on each occurrence's own date
+	Duration            int     `json:"duration"`
+	DoctorID            uint    `json:"doctor_id"`
+	PreAppointmentNotes *string `json:"pre_appointment_notes"`
+}
+
+// OccurrenceResult reports what happened to one occurrence of a series
+type OccurrenceResult struct {
+	Index         int         `json:"index"`
+	AppointmentID uint        `json:"appointment_id,omitempty"`
+	StartTime     time.Time   `json:"start_time"`
+	EndTime       time.Time   `json:"end_time"`
+	Reason        string      `json:"reason,omitempty"`
+	Details       interface{} `json:"details,omitempty"`
+}
+
+// checkBranchOpen checks that the booking falls within the branch's opening hours that day, or
+// the holiday or exception replacing them. It returns the reason it doesn't, if so.
+func checkBranchOpen(branchID uint, start, end time.Time) (string, interface{}) {
+	var branch models.Branch
+	if err := database.DB.First(&branch, branchID).Error; err != nil {
+		return "error", "Branch not found"
+	}
+	date := start.In(models.BranchLocation(database.DB, branchID))
+
+	exceptions, err := models.BranchExceptionsOn(database.DB, []uint{branchID}, date)
+	if err != nil {
+		return "error", "Failed to check opening hours"
+	}
+	periods := branchOperatingPeriods(branch, date, exceptions[branchID])
+	if len(periods) == 0 {
+		if exception := exceptions[branchID]; exception != nil {
+			return "branch_closed", exception
+		}
+		return "branch_closed", nil
+	}
+	booking := models.TimeRange{Start: start, End: end}
+	for _, period := range periods {
+		periodStart, err := models.ParseClock(period.Start)
+		if err != nil {
+			continue
+		}
+		periodEnd, err := models.ParseClock(period.End)
+		if err != nil {
+			continue
+		}
+		if models.ClockRange(date, periodStart, periodEnd).Contains(booking) {
+			return "", nil
+		}
+	}
+	return "outside_opening_hours", periods
+}
+
+// checkOccurrence checks one occurrence against the branch's opening hours, the doctor's bookings
+// and leave and the branch's resources. It returns the resources to book, or the reason it cannot
+// be booked.
+func checkOccurrence(doctorID, branchID uint, start, end time.Time, excludeAppointmentID uint, resourceIDs, procedureTemplateIDs []uint) ([]models.Resource, string, interface{}) {
+	if reason, details := checkBranchOpen(branchID, start, end); reason != "" {
+		return nil, reason, details
+	}
+
+	var conflicts []models.Appointment
+	if err := database.DB.Where("doctor_id = ? AND id <> ? AND status IN ? AND start_time < ? AND end_time > ?",
+		doctorID, excludeAppointmentID, models.BlockingStatuses, end, start).
+		Find(&conflicts).Error; err != nil {
+		return nil, "error", "Failed to check for conflicts"
+	}
+	if len(conflicts) > 0 {
+		return nil, "doctor_conflict", conflicts
+	}
+
+	var timeOff []models.DoctorTimeOff
+	if err := database.DB.Where("doctor_id = ? AND start_time < ? AND end_time > ?", doctorID, end, start).
+		Find(&timeOff).Error; err != nil {
+		return nil, "error", "Failed to check for conflicts"
+	}
+	if len(timeOff) > 0 {
+		return nil, "time_off", timeOff
+	}
+
+	resources, status, body := resolveAppointmentResources(branchID, resourceIDs, procedureTemplateIDs, start, end, excludeAppointmentID)
+	if status != 0 {
+		if conflicts, ok := body["resource_conflicts"]; ok {
+			return nil, "resource_conflict", conflicts
+		}
+		if unmet, ok := body["unmet_requirements"]; ok {
+			return nil, "resources_unavailable", unmet
+		}
+		return nil, "error", body["error"]
+	}
+	return resources, "", nil
+}
+
+// CreateAppointmentSeries books a recurring appointment. Each occurrence is checked on its own and
+// the response lists the occurrences that could not be booked.
+func CreateAppointmentSeries(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var req CreateAppointmentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request", "details": err.Error()})
+	}
+	var seriesReq CreateAppointmentSeriesRequest
+	if err := c.BodyParser(&seriesReq); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request", "details": err.Error()})
+	}
+
+	if req.PatientID == 0 || req.DoctorID == 0 || req.BranchID == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Patient, doctor, and branch are required"})
+	}
+	if req.Date == "" || req.Time == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Date and time are required"})
+	}
+	if req.Duration <= 0 {
+		req.Duration = 30
+	}
+	// Occurrences keep the same local time at the branch across DST changes
+	location := models.BranchLocation(database.DB, req.BranchID)
+	if err := req.resolveTimes(location); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid date or time format"})
+	}
+
+	// Recurrence
+	var recurrence models.Recurrence
+	switch {
+	case seriesReq.RRule != "":
+		parsed, err := models.ParseRRule(seriesReq.RRule, req.StartTime.Location())
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid recurrence rule: " + err.Error()})
+		}
+		recurrence = parsed
+	case seriesReq.Recurrence != nil:
+		recurrence = *seriesReq.Recurrence
+		if recurrence.Interval == 0 {
+			recurrence.Interval = 1
+		}
+		if err := recurrence.Validate(); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid recurrence: " + err.Error()})
+		}
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "A recurrence rule is required"})
+	}
+
+	// Patient, doctor and branch must be in the user's clinic
+	var patient models.Patient
+	if err := database.DB.First(&patient, req.PatientID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Patient not found"})
+	}
+	var doctor models.User
+	if err := database.DB.First(&doctor, req.DoctorID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Doctor not found"})
+	}
+	var branch models.Branch
+	if err := database.DB.First(&branch, req.BranchID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Branch not found"})
+	}
+	if !user.IsSuperAdmin() {
+		if user.ClinicID != patient.ClinicID || user.ClinicID != branch.ClinicID {
+			return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+		}
+		if !doctor.IsSuperAdmin() && doctor.ClinicID != user.ClinicID {
+			return c.Status(400).JSON(fiber.Map{"error": "Doctor does not belong to the same clinic"})
+		}
+	}
+
+	occurrences := recurrence.Occurrences(req.StartTime)
+	if len(occurrences) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "The recurrence rule produces no occurrences"})
+	}
+
+	// New appointments start out booked; later statuses go through PUT /appointments/:id/status
+	status := req.Status
+	if status == "" {
+		status = models.StatusScheduled
+	}
+	if status != models.StatusScheduled && status != models.StatusConfirmed {
+		return c.Status(400).JSON(fiber.Map{"error": "New appointments must be scheduled or confirmed"})
+	}
+	estimatedCost := 0.0
+	if req.EstimatedCost != nil {
+		estimatedCost = *req.EstimatedCost
+	}
+
+	// Check every occurrence before booking anything
+	appointments := []models.Appointment{}
+	occurrenceResources := [][]models.Resource{}
+	booked := []OccurrenceResult{}
+	skipped := []OccurrenceResult{}
+	for i, start := range occurrences {
+		end := start.Add(time.Duration(req.Duration) * time.Minute)
+		resources, reason, details := checkOccurrence(req.DoctorID, branch.ID, start, end, 0, req.ResourceIDs, req.ProcedureTemplateIDs)
+		if reason != "" {
+			skipped = append(skipped, OccurrenceResult{Index: i + 1, StartTime: start, EndTime: end, Reason: reason, Details: details})
+			continue
+		}
+		occurrenceResources = append(occurrenceResources, resources)
+		appointments = append(appointments, models.Appointment{
+			Title:               req.Title,
+			Description:         req.Description,
+			StartTime:           start,
+			EndTime:             end,
+			Duration:            req.Duration,
+			Status:              status,
+			PatientID:           patient.ID,
+			DoctorID:            req.DoctorID,
+			BranchID:            branch.ID,
+			ClinicID:            branch.ClinicID,
+			Timezone:            location.String(),
+			EstimatedCost:       estimatedCost,
+			PreAppointmentNotes: req.PreAppointmentNotes,
+			SeriesIndex:         i + 1,
+			Resources:           appointmentResourceRows(0, resources),
+		})
+	}
+
+	if len(appointments) == 0 || (seriesReq.AllOrNothing && len(skipped) > 0) {
+		return c.Status(409).JSON(fiber.Map{
+			"error":   "Some occurrences could not be booked",
+			"skipped": skipped,
+		})
+	}
+
+	series := models.AppointmentSeries{
+		RRule:       recurrence.String(),
+		StartTime:   req.StartTime,
+		Duration:    req.Duration,
+		Title:       req.Title,
+		Description: req.Description,
+		Status:      models.SeriesActive,
+		PatientID:   patient.ID,
+		DoctorID:    req.DoctorID,
+		BranchID:    branch.ID,
+		CreatedByID: user.ID,
+		ClinicID:    branch.ClinicID,
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Create(&series).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create appointment series"})
+	}
+	for i := range appointments {
+		conflicts, err := lockAppointmentResources(tx, occurrenceResources[i], appointments[i].StartTime, appointments[i].EndTime, 0)
+		if err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to create appointment series"})
+		}
+		if len(conflicts) > 0 {
+			tx.Rollback()
+			return c.Status(409).JSON(fiber.Map{
+				"error":              "Resources were booked by someone else in the meantime; try again",
+				"resource_conflicts": conflicts,
+			})
+		}
+		appointments[i].SeriesID = &series.ID
+		if err := tx.Create(&appointments[i]).Error; err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to create appointment series"})
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create appointment series"})
+	}
+
+	for i := range appointments {
+		scheduleAppointmentReminders(&appointments[i])
+		booked = append(booked, OccurrenceResult{
+			Index:         appointments[i].SeriesIndex,
+			AppointmentID: appointments[i].ID,
+			StartTime:     appointments[i].StartTime,
+			EndTime:       appointments[i].EndTime,
+		})
+	}
+
+	go SendClinicNotification(
+		"Recurring Appointments Scheduled",
+		strconv.Itoa(len(appointments))+" appointments scheduled for "+patient.GetFullName()+" with Dr. "+doctor.FirstName+" "+doctor.LastName,
+		"appointment_scheduled",
+		branch.ClinicID,
+	)
+
+	return c.Status(201).JSON(fiber.Map{
+		"series":  series,
+		"booked":  booked,
+		"skipped": skipped,
+	})
+}
+
+// GetAppointmentSeries returns a series with all its occurrences
+func GetAppointmentSeries(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var series models.AppointmentSeries
+	if err := database.DB.Preload("Patient").Preload("Doctor").
+		Preload("Appointments", func(db *gorm.DB) *gorm.DB { return db.Order("series_index ASC") }).
+		First(&series, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Appointment series not found"})
+	}
+	if !user.CanAccessClinic(series.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	return c.JSON(series)
+}
+
+// seriesTargets loads the series of the appointment in the URL and the open occurrences the scope covers
+func seriesTargets(c *fiber.Ctx, user models.User, scope string) (*models.AppointmentSeries, []models.Appointment, error) {
+	var appointment models.Appointment
+	if err := database.DB.First(&appointment, c.Params("id")).Error; err != nil {
+		return nil, nil, c.Status(404).JSON(fiber.Map{"error": "Appointment not found"})
+	}
+	if !user.CanAccessClinic(appointment.ClinicID) {
+		return nil, nil, c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+	if appointment.SeriesID == nil {
+		return nil, nil, c.Status(400).JSON(fiber.Map{"error": "Appointment is not part of a series"})
+	}
+
+	var series models.AppointmentSeries
+	if err := database.DB.First(&series, *appointment.SeriesID).Error; err != nil {
+		return nil, nil, c.Status(404).JSON(fiber.Map{"error": "Appointment series not found"})
+	}
+
+	query := database.DB.Where("series_id = ? AND status IN (?, ?)", series.ID, models.StatusScheduled, models.StatusConfirmed)
+	switch scope {
+	case models.SeriesScopeThis:
+		query = query.Where("id = ?", appointment.ID)
+	case models.SeriesScopeFollowing:
+		query = query.Where("series_index >= ?", appointment.SeriesIndex)
+	case models.SeriesScopeAll:
+	default:
+		return nil, nil, c.Status(400).JSON(fiber.Map{"error": "Scope must be this, following or all"})
+	}
+
+	var targets []models.Appointment
+	if err := query.Order("series_index ASC").Find(&targets).Error; err != nil {
+		return nil, nil, c.Status(500).JSON(fiber.Map{"error": "Failed to fetch series appointments"})
+	}
+	if len(targets) == 0 {
+		return nil, nil, c.Status(409).JSON(fiber.Map{"error": "No open appointments in this scope"})
+	}
+	return &series, targets, nil
+}
+
+// UpdateAppointmentSeries edits one occurrence, it and the following ones, or the whole series.
+// Occurrences that would clash after the change are left as they were and reported.
+func UpdateAppointmentSeries(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var req UpdateAppointmentSeriesRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	series, targets, err := seriesTargets(c, user, req.Scope)
+	if series == nil {
+		return err
+	}
+
+	var clock *int
+	if req.Time != "" {
+		minutes, err := models.ParseClock(req.Time)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid time format"})
+		}
+		clock = &minutes
+	}
+	if req.DoctorID != 0 {
+		var doctor models.User
+		if err := database.DB.First(&doctor, req.DoctorID).Error; err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Doctor not found"})
+		}
+		if !doctor.IsSuperAdmin() && doctor.ClinicID != series.ClinicID {
+			return c.Status(400).JSON(fiber.Map{"error": "Doctor does not belong to the same clinic"})
+		}
+	}
+
+	updated := []OccurrenceResult{}
+	skipped := []OccurrenceResult{}
+	for i := range targets {
+		appointment := &targets[i]
+
+		start := appointment.StartTime
+		if clock != nil {
+			// Same date, new time of day, in the appointment's own timezone
+			start = models.ClockRange(start.In(appointment.Location()), *clock, *clock).Start
+		}
+		duration := appointment.Duration
+		if req.Duration > 0 {
+			duration = req.Duration
+		}
+		end := start.Add(time.Duration(duration) * time.Minute)
+		doctorID := appointment.DoctorID
+		if req.DoctorID != 0 {
+			doctorID = req.DoctorID
+		}
+
+		// Only re-check when the time or doctor changes
+		moved := !start.Equal(appointment.StartTime) || !end.Equal(appointment.EndTime) || doctorID != appointment.DoctorID
+		var resources []models.Resource
+		if moved {
+			var resourceIDs []uint
+			database.DB.Model(&models.AppointmentResource{}).Where("appointment_id = ?", appointment.ID).Pluck("resource_id", &resourceIDs)
+			var reason string
+			var details interface{}
+			if resources, reason, details = checkOccurrence(doctorID, appointment.BranchID, start, end, appointment.ID, resourceIDs, nil); reason != "" {
+				skipped = append(skipped, OccurrenceResult{
+					Index: appointment.SeriesIndex, AppointmentID: appointment.ID,
+					StartTime: start, EndTime: end, Reason: reason, Details: details,
+				})
+				continue
+			}
+		}
+
+		updates := map[string]interface{}{
+			"start_time": start,
+			"end_time":   end,
+			"duration":   duration,
+			"doctor_id":  doctorID,
+		}
+		if req.Title != nil {
+			updates["title"] = *req.Title
+		}
+		if req.Description != nil {
+			updates["description"] = *req.Description
+		}
+		if req.PreAppointmentNotes != nil {
+			updates["pre_appointment_notes"] = *req.PreAppointmentNotes
+		}
+
+		tx := database.DB.Begin()
+		if moved {
+			conflicts, err := lockAppointmentResources(tx, resources, start, end, appointment.ID)
+			if err != nil {
+				tx.Rollback()
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to check for conflicts"})
+			}
+			if len(conflicts) > 0 {
+				tx.Rollback()
+				skipped = append(skipped, OccurrenceResult{
+					Index: appointment.SeriesIndex, AppointmentID: appointment.ID,
+					StartTime: start, EndTime: end, Reason: "resource_conflict", Details: conflicts,
+				})
+				continue
+			}
+		}
+		if err := tx.Model(appointment).Updates(updates).Error; err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update appointment"})
+		}
+		if err := tx.Commit().Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update appointment"})
+		}
+		appointment.StartTime, appointment.EndTime, appointment.DoctorID = start, end, doctorID
+		scheduleAppointmentReminders(appointment)
+		go queueAppointmentCalendarUpdate(appointment.ID)
+
+		updated = append(updated, OccurrenceResult{
+			Index: appointment.SeriesIndex, AppointmentID: appointment.ID,
+			StartTime: start, EndTime: end,
+		})
+	}
+
+	// The series record describes future occurrences, so keep it in step for wider edits. Editing
+	// an occurrence and the following ones splits them off into a series of their own, leaving
+	// the earlier occurrences as they were.
+	if req.Scope != models.SeriesScopeThis && len(updated) > 0 {
+		if req.Scope == models.SeriesScopeFollowing && targets[0].SeriesIndex > 1 {
+			tx := database.DB.Begin()
+			split, err := models.SplitSeries(tx, series, targets[0].SeriesIndex, targets[0].StartTime)
+			if err != nil {
+				tx.Rollback()
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to split appointment series"})
+			}
+			if err := tx.Commit().Error; err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to split appointment series"})
+			}
+			series = split
+			for i := range updated {
+				updated[i].Index -= targets[0].SeriesIndex - 1
+			}
+			for i := range skipped {
+				skipped[i].Index -= targets[0].SeriesIndex - 1
+			}
+		}
+		seriesUpdates := map[string]interface{}{}
+		if req.Title != nil {
+			seriesUpdates["title"] = *req.Title
+		}
+		if req.Description != nil {
+			seriesUpdates["description"] = *req.Description
+		}
+		if req.Duration > 0 {
+			seriesUpdates["duration"] = req.Duration
+		}
+		if req.DoctorID != 0 {
+			seriesUpdates["doctor_id"] = req.DoctorID
+		}
+		if clock != nil {
+			seriesUpdates["start_time"] = models.ClockRange(series.StartTime.In(targets[0].Location()), *clock, *clock).Start
+		}
+		if len(seriesUpdates) > 0 {
+			database.DB.Model(series).Updates(seriesUpdates)
+		}
+	}
+
+	if len(updated) > 0 {
+		var patient models.Patient
+		database.DB.First(&patient, series.PatientID)
+		go SendAppointmentUpdate(targets[0].ID, patient.GetFullName(), "updated", series.ClinicID)
+	}
+
+	return c.JSON(fiber.Map{
+		"series_id": series.ID,
+		"updated":   updated,
+		"skipped":   skipped,
+	})
+}
+
+// CancelAppointmentSeries cancels one occurrence, it and the following ones, or the whole series
+func CancelAppointmentSeries(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var req struct {
+		Scope  string `json:"scope"`
+		Reason string `json:"reason"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	if strings.TrimSpace(req.Reason) == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "A reason is required to cancel appointments"})
+	}
+
+	series, targets, err := seriesTargets(c, user, req.Scope)
+	if series == nil {
+		return err
+	}
+
+	cancelled := []OccurrenceResult{}
+	for i := range targets {
+		appointment := &targets[i]
+		previousStatus := appointment.Status
+		tx := database.DB.Begin()
+		if err := tx.Model(appointment).Updates(map[string]interface{}{
+			"status":                 models.StatusCancelled,
+			"post_appointment_notes": "Cancelled: " + req.Reason,
+		}).Error; err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to cancel appointment"})
+		}
+		appointment.Status = models.StatusCancelled
+		if err := models.RecordStatusChange(tx, appointment, previousStatus, req.Reason, &user.ID); err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to cancel appointment"})
+		}
+		// Treatment plan items booked into it go back to be scheduled again
+		if err := models.ReleasePlanItems(tx, appointment.ID); err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to cancel appointment"})
+		}
+		if err := tx.Commit().Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to cancel appointment"})
+		}
+		scheduleAppointmentReminders(appointment)
+		go queueAppointmentCalendarUpdate(appointment.ID)
+		cancelled = append(cancelled, OccurrenceResult{
+			Index: appointment.SeriesIndex, AppointmentID: appointment.ID,
+			StartTime: appointment.StartTime, EndTime: appointment.EndTime,
+		})
+	}
+
+	// Close the series once nothing in it is still booked
+	var open int64
+	database.DB.Model(&models.Appointment{}).
+		Where("series_id = ? AND status IN (?, ?)", series.ID, models.StatusScheduled, models.StatusConfirmed).
+		Count(&open)
+	if open == 0 {
+		database.DB.Model(series).Update("status", models.SeriesCancelled)
+		series.Status = models.SeriesCancelled
+	}
+
+	var patient models.Patient
+	database.DB.First(&patient, series.PatientID)
+	go SendClinicNotification(
+		"Appointment Status Update",
+		strconv.Itoa(len(cancelled))+" recurring appointment(s) cancelled for "+patient.GetFullName(),
+		"appointment_cancelled",
+		series.ClinicID,
+	)
+
+	return c.JSON(fiber.Map{
+		"series":    series,
+		"cancelled": cancelled,
+	})
+}
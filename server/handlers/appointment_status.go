@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type RescheduleAppointmentRequest struct {
+	Date        string `json:"date"` // Format: "2006-01-02"
+	Time        string `json:"time"` // Format: "15:04"
+	Duration    int    `json:"duration"`
+	DoctorID    uint   `json:"doctor_id"`
+	BranchID    uint   `json:"branch_id"`
+	ResourceIDs []uint `json:"resource_ids"` // defaults to the resources booked for the old time
+	Reason      string `json:"reason"`
+}
+
+// occurrenceConflictResponse maps a checkOccurrence failure to an HTTP response
+func occurrenceConflictResponse(c *fiber.Ctx, reason string, details interface{}) error {
+	switch reason {
+	case "branch_closed":
+		return c.Status(409).JSON(fiber.Map{"error": "The branch is closed on this date", "exception": details})
+	case "outside_opening_hours":
+		return c.Status(409).JSON(fiber.Map{"error": "The appointment is outside the branch's opening hours", "opening_hours": details})
+	case "doctor_conflict":
+		return c.Status(409).JSON(fiber.Map{"error": "Doctor has conflicting appointment at this time", "conflicts": details})
+	case "time_off":
+		return c.Status(409).JSON(fiber.Map{"error": "Doctor is not available at this time", "time_off": details})
+	case "resource_conflict":
+		return c.Status(409).JSON(fiber.Map{"error": "Some resources are already booked at this time", "resource_conflicts": details})
+	case "resources_unavailable":
+		return c.Status(409).JSON(fiber.Map{"error": "No free resources meet the procedure requirements", "unmet_requirements": details})
+	default:
+		return c.Status(500).JSON(fiber.Map{"error": details})
+	}
+}
+
+// RescheduleAppointment moves an appointment to a new time by booking a new appointment and
+// marking the old one rescheduled, linking the two
+func RescheduleAppointment(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var old models.Appointment
+	if err := database.DB.First(&old, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Appointment not found"})
+	}
+	if !user.CanAccessClinic(old.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	var req RescheduleAppointmentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if err := models.ValidateTransition(old.Status, models.StatusRescheduled, req.Reason); err != nil {
+		status := 400
+		if !models.CanTransition(old.Status, models.StatusRescheduled) {
+			status = 409
+		}
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if req.Date == "" || req.Time == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Date and time are required"})
+	}
+
+	// The new booking keeps the old doctor, branch and length unless told otherwise
+	doctorID := old.DoctorID
+	if req.DoctorID != 0 {
+		var doctor models.User
+		if err := database.DB.Where("id = ? AND clinic_id = ? AND is_active = ?", req.DoctorID, old.ClinicID, true).First(&doctor).Error; err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid doctor for this clinic"})
+		}
+		doctorID = doctor.ID
+	}
+	branchID := old.BranchID
+	if req.BranchID != 0 {
+		var branch models.Branch
+		if err := database.DB.Where("id = ? AND clinic_id = ?", req.BranchID, old.ClinicID).First(&branch).Error; err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid branch for this clinic"})
+		}
+		branchID = branch.ID
+	}
+	duration := old.Duration
+	if req.Duration > 0 {
+		duration = req.Duration
+	}
+	if duration <= 0 {
+		duration = int(old.EndTime.Sub(old.StartTime).Minutes())
+	}
+
+	location := models.BranchLocation(database.DB, branchID)
+	start, err := time.ParseInLocation("2006-01-02 15:04", req.Date+" "+req.Time, location)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid date or time format"})
+	}
+	end := start.Add(time.Duration(duration) * time.Minute)
+
+	// The old appointment's time is being given up, so it does not conflict
+	resourceIDs := req.ResourceIDs
+	if resourceIDs == nil {
+		database.DB.Model(&models.AppointmentResource{}).Where("appointment_id = ?", old.ID).Pluck("resource_id", &resourceIDs)
+	}
+	resources, reason, details := checkOccurrence(doctorID, branchID, start, end, old.ID, resourceIDs, nil)
+	if reason != "" {
+		return occurrenceConflictResponse(c, reason, details)
+	}
+
+	appointment := models.Appointment{
+		Title:               old.Title,
+		Description:         old.Description,
+		StartTime:           start,
+		EndTime:             end,
+		Duration:            duration,
+		Status:              models.StatusScheduled,
+		Timezone:            location.String(),
+		PreAppointmentNotes: old.PreAppointmentNotes,
+		EstimatedCost:       old.EstimatedCost,
+		PatientID:           old.PatientID,
+		DoctorID:            doctorID,
+		BranchID:            branchID,
+		ClinicID:            old.ClinicID,
+		SeriesID:            old.SeriesID,
+		SeriesIndex:         old.SeriesIndex,
+		RescheduledFromID:   &old.ID,
+		Resources:           appointmentResourceRows(0, resources),
+	}
+
+	tx := database.DB.Begin()
+	conflicts, err := lockAppointmentResources(tx, resources, start, end, old.ID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to check for conflicts"})
+	}
+	if len(conflicts) > 0 {
+		tx.Rollback()
+		return occurrenceConflictResponse(c, "resource_conflict", conflicts)
+	}
+	if err := tx.Create(&appointment).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to reschedule appointment"})
+	}
+	previousStatus := old.Status
+	claim := tx.Model(&models.Appointment{}).Where("id = ? AND status = ?", old.ID, previousStatus).
+		Updates(map[string]interface{}{"status": models.StatusRescheduled, "rescheduled_to_id": appointment.ID})
+	if claim.Error != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to reschedule appointment"})
+	}
+	if claim.RowsAffected == 0 {
+		tx.Rollback()
+		return c.Status(409).JSON(fiber.Map{"error": "The appointment was changed by someone else; reload and try again"})
+	}
+	old.Status = models.StatusRescheduled
+	old.RescheduledToID = &appointment.ID
+	if err := models.RecordStatusChange(tx, &old, previousStatus, req.Reason, &user.ID); err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to reschedule appointment"})
+	}
+	if err := models.MovePlanItems(tx, old.ID, appointment.ID); err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to reschedule appointment"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to reschedule appointment"})
+	}
+
+	scheduleAppointmentReminders(&old)
+	scheduleAppointmentReminders(&appointment)
+
+	database.DB.Preload("Patient").Preload("Doctor").Preload("Branch").Preload("Resources.Resource").First(&appointment, appointment.ID)
+
+	go SendAppointmentUpdate(appointment.ID, appointment.Patient.GetFullName(), "rescheduled", appointment.ClinicID)
+	go notifyWaitlistMatches(old)
+
+	// A patient with the old time in their calendar gets it replaced by the new one
+	if patientHasInvite(old.ID) {
+		go func(oldID uint, appointment models.Appointment) {
+			queueAppointmentCalendarUpdate(oldID)
+			queueAppointmentConfirmation(&appointment)
+		}(old.ID, appointment)
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"appointment": appointment,
+		"previous":    old,
+	})
+}
+
+// GetAppointmentStatusHistory lists an appointment's status changes, oldest first, including those of
+// the appointments it was rescheduled from
+func GetAppointmentStatusHistory(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var appointment models.Appointment
+	if err := database.DB.First(&appointment, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Appointment not found"})
+	}
+	if !user.CanAccessClinic(appointment.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	// Follow the reschedule chain back to the original booking
+	ids := []uint{appointment.ID}
+	for from := appointment.RescheduledFromID; from != nil && len(ids) < 50; {
+		var previous models.Appointment
+		if err := database.DB.Select("id", "rescheduled_from_id").First(&previous, *from).Error; err != nil {
+			break
+		}
+		ids = append(ids, previous.ID)
+		from = previous.RescheduledFromID
+	}
+
+	var history []models.AppointmentStatusHistory
+	if err := database.DB.Preload("ChangedBy").Where("appointment_id IN ?", ids).
+		Order("changed_at ASC, id ASC").Find(&history).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch status history"})
+	}
+
+	return c.JSON(fiber.Map{
+		"appointment_id":      appointment.ID,
+		"status":              appointment.Status,
+		"allowed_transitions": models.AllowedTransitions(appointment.Status),
+		"rescheduled_from_id": appointment.RescheduledFromID,
+		"rescheduled_to_id":   appointment.RescheduledToID,
+		"history":             history,
+	})
+}
@@ -0,0 +1,758 @@
+package handlers
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type InvoiceLineRequest struct {
+	ProcedureCode string  `json:"procedure_code"`
+	Description   string  `json:"description"`
+	ToothNumber   string  `json:"tooth_number"`
+	Quantity      int     `json:"quantity"`
+	UnitPrice     float64 `json:"unit_price"`
+}
+
+type CreateInvoiceRequest struct {
+	AppointmentID  *uint                `json:"appointment_id"`
+	PatientID      uint                 `json:"patient_id"`
+	BranchID       *uint                `json:"branch_id"`
+	Lines          []InvoiceLineRequest `json:"lines"`
+	DiscountAmount float64              `json:"discount_amount"`
+	TaxAmount      float64              `json:"tax_amount"`
+	DueDate        *time.Time           `json:"due_date"`
+	Notes          string               `json:"notes"`
+
+	// Insurance defaults to the patient's primary policy
+	InsurancePolicyID *uint `json:"insurance_policy_id"`
+	SelfPay           bool  `json:"self_pay"`
+}
+
+type RecordPaymentRequest struct {
+	Amount    float64              `json:"amount"`
+	Method    models.PaymentMethod `json:"method"`
+	Reference string               `json:"reference"`
+	Notes     string               `json:"notes"`
+	PaidAt    *time.Time           `json:"paid_at"`
+}
+
+type RecordRefundRequest struct {
+	Amount     float64              `json:"amount"`
+	Method     models.PaymentMethod `json:"method"`
+	RefundOfID *uint                `json:"refund_of_id"`
+	Reference  string               `json:"reference"`
+	Reason     string               `json:"reason"`
+}
+
+type VoidInvoiceRequest struct {
+	Reason string `json:"reason"`
+}
+
+// LedgerEntry is a single debit or credit line on a patient's account
+type LedgerEntry struct {
+	Date          time.Time `json:"date"`
+	Type          string    `json:"type"` // invoice, payment, refund
+	Reference     string    `json:"reference"`
+	Description   string    `json:"description"`
+	InvoiceID     uint      `json:"invoice_id"`
+	PaymentID     *uint     `json:"payment_id,omitempty"`
+	Method        string    `json:"method,omitempty"`
+	Debit         float64   `json:"debit"`
+	Credit        float64   `json:"credit"`
+	Balance       float64   `json:"balance"`
+	InvoiceStatus string    `json:"invoice_status,omitempty"`
+}
+
+// findAccessibleInvoice loads an invoice the user is allowed to see, with payments preloaded
+func findAccessibleInvoice(db *gorm.DB, user models.User, id string) (*models.Invoice, error) {
+	var invoice models.Invoice
+	query := db.Preload("Payments", func(db *gorm.DB) *gorm.DB {
+		return db.Order("paid_at ASC, id ASC")
+	})
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	}
+	if err := query.First(&invoice, id).Error; err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// syncAppointmentPayment keeps the legacy appointment cost/paid fields in line with its invoice
+func syncAppointmentPayment(tx *gorm.DB, invoice *models.Invoice) error {
+	if invoice.AppointmentID == nil {
+		return nil
+	}
+
+	updates := map[string]interface{}{
+		"is_paid": invoice.IsPaid(),
+	}
+	if !invoice.IsVoid() {
+		updates["actual_cost"] = invoice.TotalAmount
+	}
+
+	return tx.Model(&models.Appointment{}).Where("id = ?", *invoice.AppointmentID).Updates(updates).Error
+}
+
+func loadInvoiceDetails(invoice *models.Invoice) {
+	database.DB.Preload("Patient").Preload("Branch").Preload("CreatedBy").Preload("InsurancePolicy.Payer").
+		Preload("Lines").Preload("Payments", func(db *gorm.DB) *gorm.DB {
+		return db.Order("paid_at ASC, id ASC")
+	}).Preload("Payments.ReceivedBy").First(invoice, invoice.ID)
+}
+
+// GetInvoices - List invoices for the user's clinic
+func GetInvoices(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	query := database.DB.Model(&models.Invoice{})
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	} else if clinicID := c.Query("clinic_id"); clinicID != "" {
+		query = query.Where("clinic_id = ?", clinicID)
+	}
+
+	if patientID := c.Query("patient_id"); patientID != "" {
+		query = query.Where("patient_id = ?", patientID)
+	}
+	if appointmentID := c.Query("appointment_id"); appointmentID != "" {
+		query = query.Where("appointment_id = ?", appointmentID)
+	}
+	if branchID := c.Query("branch_id"); branchID != "" {
+		query = query.Where("branch_id = ?", branchID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if c.Query("outstanding") == "true" {
+		query = query.Where("balance_due > 0 AND status NOT IN (?)", []models.InvoiceStatus{models.InvoiceStatusVoid, models.InvoiceStatusDraft})
+	}
+
+	// Date range filtering on issue date, in the branch's or clinic's local time
+	location := requestLocation(c, user)
+	if startStr := c.Query("start_date"); startStr != "" {
+		if startDate, err := time.ParseInLocation("2006-01-02", startStr, location); err == nil {
+			query = query.Where("issued_at >= ?", startDate)
+		}
+	}
+	if endStr := c.Query("end_date"); endStr != "" {
+		if endDate, err := time.ParseInLocation("2006-01-02", endStr, location); err == nil {
+			query = query.Where("issued_at < ?", endDate.Add(24*time.Hour))
+		}
+	}
+
+	var total int64
+	query.Count(&total)
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	offset := (page - 1) * limit
+
+	var invoices []models.Invoice
+	if err := query.Preload("Patient").Preload("Branch").
+		Order("issued_at DESC, id DESC").Offset(offset).Limit(limit).Find(&invoices).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch invoices"})
+	}
+
+	return c.JSON(fiber.Map{
+		"invoices": invoices,
+		"total":    total,
+		"page":     page,
+		"limit":    limit,
+	})
+}
+
+// GetInvoice - Get a single invoice with lines and payments
+func GetInvoice(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	invoice, err := findAccessibleInvoice(database.DB, user, c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Invoice not found"})
+	}
+
+	loadInvoiceDetails(invoice)
+
+	return c.JSON(invoice)
+}
+
+// CreateInvoice - Create an invoice, generated from an appointment's procedures when appointment_id is given
+func CreateInvoice(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var req CreateInvoiceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if req.DiscountAmount < 0 || req.TaxAmount < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Discount and tax amounts cannot be negative"})
+	}
+
+	invoice := models.Invoice{
+		Status:         models.InvoiceStatusIssued,
+		DiscountAmount: req.DiscountAmount,
+		TaxAmount:      req.TaxAmount,
+		IssuedAt:       time.Now(),
+		DueDate:        req.DueDate,
+		Notes:          req.Notes,
+		CreatedByID:    user.ID,
+	}
+
+	if req.AppointmentID != nil {
+		var appointment models.Appointment
+		if err := database.DB.Preload("Branch").First(&appointment, *req.AppointmentID).Error; err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Appointment not found"})
+		}
+
+		if !user.IsSuperAdmin() && user.ClinicID != appointment.Branch.ClinicID {
+			return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+		}
+
+		if appointment.Status == models.StatusCancelled || appointment.Status == models.StatusNoShow {
+			return c.Status(400).JSON(fiber.Map{"error": "Cannot invoice a cancelled or no-show appointment"})
+		}
+
+		var procedures []models.AppointmentProcedure
+		if err := database.DB.Preload("ProcedureTemplate").
+			Where("appointment_id = ? AND status <> ?", appointment.ID, "cancelled").
+			Order("id ASC").Find(&procedures).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch appointment procedures"})
+		}
+
+		for i := range procedures {
+			procedureID := procedures[i].ID
+			line := models.InvoiceLine{
+				AppointmentProcedureID: &procedureID,
+				ProcedureCode:          procedures[i].ProcedureTemplate.Code,
+				Description:            procedures[i].ProcedureTemplate.Name,
+				ToothNumber:            procedures[i].ToothNumber,
+				Quantity:               1,
+				UnitPrice:              procedures[i].Cost,
+				Category:               procedures[i].ProcedureTemplate.Category,
+			}
+			line.CalculateLineTotal()
+			invoice.Lines = append(invoice.Lines, line)
+		}
+
+		appointmentID := appointment.ID
+		branchID := appointment.BranchID
+		invoice.AppointmentID = &appointmentID
+		invoice.PatientID = appointment.PatientID
+		invoice.ClinicID = appointment.Branch.ClinicID
+		invoice.BranchID = &branchID
+	} else {
+		if req.PatientID == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "Appointment ID or patient ID is required"})
+		}
+
+		var patient models.Patient
+		if err := database.DB.First(&patient, req.PatientID).Error; err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Patient not found"})
+		}
+
+		if !user.IsSuperAdmin() && user.ClinicID != patient.ClinicID {
+			return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+		}
+
+		if req.BranchID != nil {
+			var branch models.Branch
+			if err := database.DB.Where("id = ? AND clinic_id = ?", *req.BranchID, patient.ClinicID).First(&branch).Error; err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid branch for this clinic"})
+			}
+		}
+
+		invoice.PatientID = patient.ID
+		invoice.ClinicID = patient.ClinicID
+		invoice.BranchID = req.BranchID
+	}
+
+	// Manual lines are appended to (or make up) the invoice
+	var codes []string
+	for _, lineReq := range req.Lines {
+		if lineReq.ProcedureCode != "" {
+			codes = append(codes, lineReq.ProcedureCode)
+		}
+	}
+	categories := procedureCategories(invoice.ClinicID, codes)
+	for _, lineReq := range req.Lines {
+		if lineReq.Description == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "Line description is required"})
+		}
+		if lineReq.UnitPrice < 0 || lineReq.Quantity < 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "Line quantity and price cannot be negative"})
+		}
+
+		line := models.InvoiceLine{
+			ProcedureCode: lineReq.ProcedureCode,
+			Description:   lineReq.Description,
+			ToothNumber:   lineReq.ToothNumber,
+			Quantity:      lineReq.Quantity,
+			UnitPrice:     lineReq.UnitPrice,
+			Category:      categories[lineReq.ProcedureCode],
+		}
+		line.CalculateLineTotal()
+		invoice.Lines = append(invoice.Lines, line)
+	}
+
+	if len(invoice.Lines) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Invoice has no billable lines"})
+	}
+
+	invoice.RecalculateTotals()
+
+	// Estimate what the insurer will pay
+	var policy *models.InsurancePolicy
+	if !req.SelfPay {
+		var err error
+		policy, err = findPatientInsurancePolicy(invoice.PatientID, invoice.ClinicID, req.InsurancePolicyID, invoice.IssuedAt)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid insurance policy for this patient"})
+		}
+	}
+	usedBenefit := 0.0
+	if policy != nil {
+		usedBenefit = models.PolicyBenefitUsed(database.DB, policy.ID, invoice.IssuedAt.Year())
+	}
+	invoice.ApplyInsuranceEstimate(policy, usedBenefit)
+
+	tx := database.DB.Begin()
+
+	// One open invoice per appointment. The appointment stays locked until the invoice is saved, so
+	// a second request for it waits and then finds this invoice.
+	if invoice.AppointmentID != nil {
+		var appointment models.Appointment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&appointment, *invoice.AppointmentID).Error; err != nil {
+			tx.Rollback()
+			return c.Status(404).JSON(fiber.Map{"error": "Appointment not found"})
+		}
+		var existing models.Invoice
+		if err := tx.Where("appointment_id = ? AND status <> ?", appointment.ID, models.InvoiceStatusVoid).First(&existing).Error; err == nil {
+			tx.Rollback()
+			return c.Status(409).JSON(fiber.Map{
+				"error":      "Appointment already has an invoice",
+				"invoice_id": existing.ID,
+			})
+		}
+	}
+
+	invoiceNumber, err := models.GenerateInvoiceNumber(invoice.ClinicID, tx)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to generate invoice number"})
+	}
+	invoice.InvoiceNumber = invoiceNumber
+
+	if err := tx.Create(&invoice).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create invoice"})
+	}
+	if err := syncAppointmentPayment(tx, &invoice); err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update appointment cost"})
+	}
+	tx.Commit()
+
+	loadInvoiceDetails(&invoice)
+
+	return c.Status(201).JSON(invoice)
+}
+
+// RecordInvoicePayment - Record a full or partial payment against an invoice
+func RecordInvoicePayment(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var req RecordPaymentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if req.Amount <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Payment amount must be positive"})
+	}
+	if !models.IsValidPaymentMethod(req.Method) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid payment method"})
+	}
+
+	tx := database.DB.Begin()
+
+	// Locked so concurrent payments and refunds see each other's effect on the balance
+	invoice, err := findAccessibleInvoice(tx.Clauses(clause.Locking{Strength: "UPDATE"}), user, c.Params("id"))
+	if err != nil {
+		tx.Rollback()
+		return c.Status(404).JSON(fiber.Map{"error": "Invoice not found"})
+	}
+
+	if invoice.IsVoid() || invoice.Status == models.InvoiceStatusDraft {
+		tx.Rollback()
+		return c.Status(400).JSON(fiber.Map{"error": "Payments can only be recorded on issued invoices"})
+	}
+
+	if req.Amount > invoice.BalanceDue+0.005 {
+		tx.Rollback()
+		return c.Status(400).JSON(fiber.Map{
+			"error":       "Payment exceeds balance due",
+			"balance_due": invoice.BalanceDue,
+		})
+	}
+
+	paidAt := time.Now()
+	if req.PaidAt != nil {
+		paidAt = *req.PaidAt
+	}
+
+	payment := models.Payment{
+		InvoiceID:    invoice.ID,
+		Type:         models.PaymentTypePayment,
+		Amount:       req.Amount,
+		Method:       req.Method,
+		Reference:    req.Reference,
+		Notes:        req.Notes,
+		PaidAt:       paidAt,
+		PatientID:    invoice.PatientID,
+		ClinicID:     invoice.ClinicID,
+		BranchID:     invoice.BranchID,
+		ReceivedByID: user.ID,
+	}
+
+	if err := tx.Create(&payment).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to record payment"})
+	}
+
+	invoice.Payments = append(invoice.Payments, payment)
+	invoice.ApplyPayments()
+
+	if err := tx.Omit("Payments", "Lines").Save(invoice).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update invoice"})
+	}
+	if err := syncAppointmentPayment(tx, invoice); err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update appointment payment status"})
+	}
+	tx.Commit()
+
+	loadInvoiceDetails(invoice)
+
+	return c.Status(201).JSON(fiber.Map{
+		"payment": payment,
+		"invoice": invoice,
+	})
+}
+
+// RecordInvoiceRefund - Refund money previously collected on an invoice
+func RecordInvoiceRefund(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var req RecordRefundRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if req.Amount <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Refund amount must be positive"})
+	}
+	if req.Reason == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Refund reason is required"})
+	}
+
+	tx := database.DB.Begin()
+
+	// Locked so concurrent payments and refunds see each other's effect on the balance
+	invoice, err := findAccessibleInvoice(tx.Clauses(clause.Locking{Strength: "UPDATE"}), user, c.Params("id"))
+	if err != nil {
+		tx.Rollback()
+		return c.Status(404).JSON(fiber.Map{"error": "Invoice not found"})
+	}
+
+	if req.Amount > invoice.RefundableAmount()+0.005 {
+		tx.Rollback()
+		return c.Status(400).JSON(fiber.Map{
+			"error":      "Refund exceeds amount paid",
+			"refundable": invoice.RefundableAmount(),
+		})
+	}
+
+	// Default to refunding through the original payment's method
+	method := req.Method
+	if req.RefundOfID != nil {
+		var original *models.Payment
+		for i := range invoice.Payments {
+			if invoice.Payments[i].ID == *req.RefundOfID && !invoice.Payments[i].IsRefund() {
+				original = &invoice.Payments[i]
+				break
+			}
+		}
+		if original == nil {
+			tx.Rollback()
+			return c.Status(400).JSON(fiber.Map{"error": "Original payment not found on this invoice"})
+		}
+		refundable := original.Amount
+		for _, payment := range invoice.Payments {
+			if payment.IsRefund() && payment.RefundOfID != nil && *payment.RefundOfID == original.ID {
+				refundable -= payment.Amount
+			}
+		}
+		if req.Amount > refundable+0.005 {
+			tx.Rollback()
+			return c.Status(400).JSON(fiber.Map{
+				"error":      "Refund exceeds the amount left on the original payment",
+				"refundable": math.Max(refundable, 0),
+			})
+		}
+		if method == "" {
+			method = original.Method
+		}
+	}
+	if !models.IsValidPaymentMethod(method) {
+		tx.Rollback()
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid refund method"})
+	}
+
+	refund := models.Payment{
+		InvoiceID:    invoice.ID,
+		Type:         models.PaymentTypeRefund,
+		Amount:       req.Amount,
+		Method:       method,
+		Reference:    req.Reference,
+		Notes:        req.Reason,
+		PaidAt:       time.Now(),
+		RefundOfID:   req.RefundOfID,
+		PatientID:    invoice.PatientID,
+		ClinicID:     invoice.ClinicID,
+		BranchID:     invoice.BranchID,
+		ReceivedByID: user.ID,
+	}
+
+	if err := tx.Create(&refund).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to record refund"})
+	}
+
+	invoice.Payments = append(invoice.Payments, refund)
+	invoice.ApplyPayments()
+
+	if err := tx.Omit("Payments", "Lines").Save(invoice).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update invoice"})
+	}
+	if err := syncAppointmentPayment(tx, invoice); err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update appointment payment status"})
+	}
+	tx.Commit()
+
+	loadInvoiceDetails(invoice)
+
+	return c.Status(201).JSON(fiber.Map{
+		"refund":  refund,
+		"invoice": invoice,
+	})
+}
+
+// VoidInvoice - Void an invoice that has no money left on it
+func VoidInvoice(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var req VoidInvoiceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if req.Reason == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Void reason is required"})
+	}
+
+	tx := database.DB.Begin()
+
+	// Locked so a payment recorded meanwhile is either seen here or waits for the void
+	invoice, err := findAccessibleInvoice(tx.Clauses(clause.Locking{Strength: "UPDATE"}), user, c.Params("id"))
+	if err != nil {
+		tx.Rollback()
+		return c.Status(404).JSON(fiber.Map{"error": "Invoice not found"})
+	}
+
+	if invoice.IsVoid() {
+		tx.Rollback()
+		return c.Status(400).JSON(fiber.Map{"error": "Invoice is already void"})
+	}
+	if invoice.AmountPaid > 0 {
+		tx.Rollback()
+		return c.Status(400).JSON(fiber.Map{"error": "Refund collected payments before voiding the invoice"})
+	}
+
+	invoice.Void(req.Reason)
+
+	if err := tx.Omit("Payments", "Lines").Save(invoice).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to void invoice"})
+	}
+	if err := syncAppointmentPayment(tx, invoice); err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update appointment payment status"})
+	}
+	tx.Commit()
+
+	loadInvoiceDetails(invoice)
+
+	return c.JSON(invoice)
+}
+
+// GetPayments - List payments and refunds, totalled per method for daily reconciliation
+func GetPayments(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	query := database.DB.Model(&models.Payment{})
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	} else if clinicID := c.Query("clinic_id"); clinicID != "" {
+		query = query.Where("clinic_id = ?", clinicID)
+	}
+
+	if branchID := c.Query("branch_id"); branchID != "" {
+		query = query.Where("branch_id = ?", branchID)
+	}
+	if method := c.Query("method"); method != "" {
+		query = query.Where("method = ?", method)
+	}
+	if receivedBy := c.Query("received_by_id"); receivedBy != "" {
+		query = query.Where("received_by_id = ?", receivedBy)
+	}
+
+	// Defaults to today's takings, in the branch's or clinic's local time
+	location := requestLocation(c, user)
+	date := time.Now().In(location)
+	if dateStr := c.Query("date"); dateStr != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", dateStr, location)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid date format, use YYYY-MM-DD"})
+		}
+		date = parsed
+	}
+	startOfDay := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	query = query.Where("paid_at >= ? AND paid_at < ?", startOfDay, startOfDay.Add(24*time.Hour))
+
+	var payments []models.Payment
+	if err := query.Preload("Invoice").Preload("ReceivedBy").Order("paid_at ASC").Find(&payments).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch payments"})
+	}
+
+	byMethod := map[models.PaymentMethod]float64{}
+	totalCollected := 0.0
+	totalRefunded := 0.0
+	for _, payment := range payments {
+		byMethod[payment.Method] += payment.SignedAmount()
+		if payment.IsRefund() {
+			totalRefunded += payment.Amount
+		} else {
+			totalCollected += payment.Amount
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"date":            startOfDay.Format("2006-01-02"),
+		"payments":        payments,
+		"by_method":       byMethod,
+		"total_collected": totalCollected,
+		"total_refunded":  totalRefunded,
+		"net_total":       totalCollected - totalRefunded,
+	})
+}
+
+// GetPatientLedger - Running account statement of invoices, payments and refunds for a patient
+func GetPatientLedger(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+	patientID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid patient ID"})
+	}
+
+	var patient models.Patient
+	if err := database.DB.First(&patient, patientID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Patient not found"})
+	}
+
+	if !user.IsSuperAdmin() && user.ClinicID != patient.ClinicID {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	var invoices []models.Invoice
+	if err := database.DB.Preload("Payments").
+		Where("patient_id = ? AND status NOT IN (?)", patientID, []models.InvoiceStatus{models.InvoiceStatusVoid, models.InvoiceStatusDraft}).
+		Find(&invoices).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch invoices"})
+	}
+
+	var entries []LedgerEntry
+	totalBilled := 0.0
+	totalPaid := 0.0
+	totalRefunded := 0.0
+	for _, invoice := range invoices {
+		entries = append(entries, LedgerEntry{
+			Date:          invoice.IssuedAt,
+			Type:          "invoice",
+			Reference:     invoice.InvoiceNumber,
+			Description:   "Invoice " + invoice.InvoiceNumber,
+			InvoiceID:     invoice.ID,
+			Debit:         invoice.TotalAmount,
+			InvoiceStatus: string(invoice.Status),
+		})
+		totalBilled += invoice.TotalAmount
+
+		for _, payment := range invoice.Payments {
+			paymentID := payment.ID
+			entry := LedgerEntry{
+				Date:      payment.PaidAt,
+				Reference: payment.Reference,
+				InvoiceID: invoice.ID,
+				PaymentID: &paymentID,
+				Method:    string(payment.Method),
+			}
+			if payment.IsRefund() {
+				entry.Type = "refund"
+				entry.Description = "Refund on " + invoice.InvoiceNumber
+				entry.Debit = payment.Amount
+				totalRefunded += payment.Amount
+			} else {
+				entry.Type = "payment"
+				entry.Description = "Payment on " + invoice.InvoiceNumber
+				entry.Credit = payment.Amount
+				totalPaid += payment.Amount
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Date.Before(entries[j].Date)
+	})
+
+	balance := 0.0
+	for i := range entries {
+		balance += entries[i].Debit - entries[i].Credit
+		entries[i].Balance = balance
+	}
+
+	if entries == nil {
+		entries = []LedgerEntry{}
+	}
+
+	return c.JSON(fiber.Map{
+		"patient_id":     patient.ID,
+		"entries":        entries,
+		"total_billed":   totalBilled,
+		"total_paid":     totalPaid,
+		"total_refunded": totalRefunded,
+		"balance":        balance,
+	})
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type CreateBranchExceptionRequest struct {
+	Date           string                     `json:"date"` // Format: "2006-01-02"
+	Type           models.BranchExceptionType `json:"type"`
+	StartTime      string                     `json:"start_time"` // HH:MM, for special_hours and extra_day
+	EndTime        string                     `json:"end_time"`   // HH:MM
+	Reason         string                     `json:"reason"`
+	RecursAnnually bool                       `json:"recurs_annually"`
+}
+
+// findAccessibleBranch loads the branch in the URL if the user may see it.
+// On failure the error response has already been sent.
+func findAccessibleBranch(c *fiber.Ctx, user models.User) (*models.Branch, error) {
+	var branch models.Branch
+	if err := database.DB.First(&branch, c.Params("id")).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "Branch not found"})
+	}
+	if !user.CanAccessClinic(branch.ClinicID) {
+		return nil, c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+	return &branch, nil
+}
+
+// GetBranchExceptions lists a branch's closures and special hours. With from and/or to (YYYY-MM-DD)
+// only one-off exceptions in that range are listed; annual ones are always included.
+func GetBranchExceptions(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	branch, err := findAccessibleBranch(c, user)
+	if branch == nil {
+		return err
+	}
+
+	query := database.DB.Where("branch_id = ?", branch.ID)
+	if from, err := time.Parse("2006-01-02", c.Query("from")); err == nil {
+		query = query.Where("date >= ? OR recurs_annually = ?", from, true)
+	}
+	if to, err := time.Parse("2006-01-02", c.Query("to")); err == nil {
+		query = query.Where("date <= ? OR recurs_annually = ?", to, true)
+	}
+
+	var exceptions []models.BranchScheduleException
+	if err := query.Order("date ASC").Find(&exceptions).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch schedule exceptions"})
+	}
+	return c.JSON(exceptions)
+}
+
+// CreateBranchException closes a branch, changes its hours or opens it on an extra day. Scheduled
+// appointments that the change leaves outside opening hours are returned with a warning.
+func CreateBranchException(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	branch, err := findAccessibleBranch(c, user)
+	if branch == nil {
+		return err
+	}
+
+	var req CreateBranchExceptionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	date, err := time.Parse("2006-01-02", req.Date)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Valid date is required (YYYY-MM-DD)"})
+	}
+
+	exception := models.BranchScheduleException{
+		BranchID:       branch.ID,
+		Date:           date,
+		Type:           req.Type,
+		Reason:         strings.TrimSpace(req.Reason),
+		RecursAnnually: req.RecursAnnually,
+		CreatedByID:    user.ID,
+		ClinicID:       branch.ClinicID,
+	}
+	if req.Type != models.ExceptionClosed {
+		exception.StartTime = req.StartTime
+		exception.EndTime = req.EndTime
+	}
+	if err := exception.Validate(); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// One exception of each kind per date, and none on a holiday, which closes the branch anyway
+	existing, err := models.BranchExceptionsOn(database.DB, []uint{branch.ID}, date)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to check existing exceptions"})
+	}
+	if current, ok := existing[branch.ID]; ok && current.ID == 0 {
+		return c.Status(409).JSON(fiber.Map{
+			"error":    "The branch is closed for a holiday on this date",
+			"conflict": current,
+		})
+	}
+	if current, ok := existing[branch.ID]; ok && current.RecursAnnually == exception.RecursAnnually {
+		return c.Status(409).JSON(fiber.Map{
+			"error":    "The branch already has a schedule exception on this date",
+			"conflict": current,
+		})
+	}
+
+	if err := database.DB.Create(&exception).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create schedule exception"})
+	}
+
+	response := fiber.Map{"exception": exception}
+	if affected := branchExceptionConflicts(branch, &exception); len(affected) > 0 {
+		response["affected_appointments"] = affected
+		response["warning"] = strconv.Itoa(len(affected)) + " appointment(s) fall outside the branch's hours on " +
+			affected[0].StartTime.Format("January 2, 2006")
+	}
+	return c.Status(201).JSON(response)
+}
+
+// branchExceptionConflicts lists the scheduled appointments at the branch on the exception's next
+// date that are no longer within opening hours
+func branchExceptionConflicts(branch *models.Branch, exception *models.BranchScheduleException) []models.Appointment {
+	if exception.Type == models.ExceptionExtraDay {
+		return nil
+	}
+	location := models.BranchLocation(database.DB, branch.ID)
+	day, ok := exception.NextOccurrence(time.Now().In(location))
+	if !ok {
+		return nil
+	}
+	dayStart := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, location)
+
+	var appointments []models.Appointment
+	database.DB.Preload("Patient").Preload("Doctor").
+		Where("branch_id = ? AND start_time >= ? AND start_time < ? AND status IN ?", branch.ID, dayStart, dayStart.AddDate(0, 0, 1),
+			[]models.AppointmentStatus{models.StatusScheduled, models.StatusConfirmed}).
+		Order("start_time ASC").Find(&appointments)
+
+	open, hasHours := exception.Range(dayStart)
+	affected := []models.Appointment{}
+	for _, appointment := range appointments {
+		if hasHours && open.Contains(models.TimeRange{Start: appointment.StartTime, End: appointment.EndTime}) {
+			continue
+		}
+		affected = append(affected, appointment)
+	}
+	return affected
+}
+
+// DeleteBranchException removes a closure or special hours
+func DeleteBranchException(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var exception models.BranchScheduleException
+	if err := database.DB.First(&exception, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Schedule exception not found"})
+	}
+	if !user.CanAccessClinic(exception.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	if err := database.DB.Delete(&exception).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete schedule exception"})
+	}
+	return c.JSON(fiber.Map{"message": "Schedule exception deleted"})
+}
@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/ical"
+	"dentika/server/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Calendar feeds cover this window around today
+const (
+	calendarFeedPast   = 30 * 24 * time.Hour
+	calendarFeedFuture = 365 * 24 * time.Hour
+	calendarFeedTTL    = time.Hour
+)
+
+type CreateCalendarFeedRequest struct {
+	BranchID *uint `json:"branch_id"` // a front desk feed for the branch; omit for your own appointments
+}
+
+// appointmentEventStatus maps an appointment's status to the iCalendar event status
+func appointmentEventStatus(status models.AppointmentStatus) string {
+	switch status {
+	case models.StatusScheduled:
+		return ical.StatusTentative
+	case models.StatusCancelled, models.StatusNoShow, models.StatusRescheduled:
+		return ical.StatusCancelled
+	default:
+		return ical.StatusConfirmed
+	}
+}
+
+// staffAppointmentEvent is an appointment as shown in a doctor's or front desk feed.
+// Patient, Doctor and Branch must be loaded.
+func staffAppointmentEvent(appointment *models.Appointment, withDoctor bool) ical.Event {
+	summary := appointment.Patient.GetFullName()
+	if appointment.Title != "" {
+		summary += " - " + appointment.Title
+	}
+	if withDoctor && appointment.Doctor.ID != 0 {
+		summary += " (" + appointment.Doctor.GetDisplayName() + ")"
+	}
+
+	var description strings.Builder
+	if appointment.Description != "" {
+		description.WriteString(appointment.Description + "\n")
+	}
+	if appointment.Patient.Phone != "" {
+		fmt.Fprintf(&description, "Patient phone: %s\n", appointment.Patient.Phone)
+	}
+	if appointment.PreAppointmentNotes != "" {
+		fmt.Fprintf(&description, "Notes: %s\n", appointment.PreAppointmentNotes)
+	}
+
+	event := appointmentEvent(appointment)
+	event.Summary = summary
+	event.Description = strings.TrimSpace(description.String())
+	event.Location = firstNonEmpty(appointment.Branch.Name, appointment.Branch.Address)
+	if publicAppURL != "" {
+		event.URL = publicAppURL + "/appointments/" + strconv.FormatUint(uint64(appointment.ID), 10)
+	}
+	return event
+}
+
+// patientAppointmentEvent is an appointment as sent to the patient. Patient and Branch must be loaded.
+func patientAppointmentEvent(appointment *models.Appointment, clinic models.Clinic) ical.Event {
+	place := clinicDisplayName(clinic, appointment.Branch)
+
+	var description strings.Builder
+	if appointment.Title != "" {
+		description.WriteString(appointment.Title + "\n")
+	}
+	if contact := firstNonEmpty(appointment.Branch.Phone, clinic.Phone); contact != "" {
+		fmt.Fprintf(&description, "To reschedule, please call %s.\n", contact)
+	}
+
+	event := appointmentEvent(appointment)
+	event.Summary = "Dental appointment at " + place
+	event.Description = strings.TrimSpace(description.String())
+	event.Location = firstNonEmpty(appointment.Branch.Address, place)
+	event.Organizer = clinic.Email
+	event.Attendee = strings.TrimSpace(appointment.Patient.Email)
+	event.AttendeeName = appointment.Patient.GetFullName()
+	return event
+}
+
+// appointmentEvent holds the fields every view of an appointment shares, including its stable UID
+func appointmentEvent(appointment *models.Appointment) ical.Event {
+	return ical.Event{
+		UID:          appointment.CalendarUID(),
+		Sequence:     appointment.CalendarSequence(),
+		Start:        appointment.StartTime,
+		End:          appointment.EndTime,
+		Stamp:        appointment.UpdatedAt,
+		LastModified: appointment.UpdatedAt,
+		Status:       appointmentEventStatus(appointment.Status),
+	}
+}
+
+// appointmentInvite renders the .ics file sent to the patient: an invitation, or a cancellation
+// once the appointment is cancelled. Returns the file name, content type and contents.
+func appointmentInvite(appointment *models.Appointment, clinic models.Clinic) (string, string, string) {
+	method := ical.MethodRequest
+	if appointmentEventStatus(appointment.Status) == ical.StatusCancelled {
+		method = ical.MethodCancel
+	}
+	calendar := ical.Calendar{
+		Method: method,
+		Events: []ical.Event{patientAppointmentEvent(appointment, clinic)},
+	}
+	return "appointment.ics", ical.ContentType + "; method=" + method, string(calendar.Bytes())
+}
+
+// queueAppointmentConfirmation emails and texts the patient that their appointment is booked, with
+// the appointment attached to the email as an .ics file. Patient and Branch must be loaded.
+func queueAppointmentConfirmation(appointment *models.Appointment) {
+	if messagingService == nil {
+		return
+	}
+
+	var clinic models.Clinic
+	database.DB.Select("id", "name", "phone", "email").First(&clinic, appointment.ClinicID)
+
+	place := clinicDisplayName(clinic, appointment.Branch)
+	contact := firstNonEmpty(appointment.Branch.Phone, clinic.Phone)
+	when := formatMessageTime(appointment.StartTime)
+
+	var email strings.Builder
+	fmt.Fprintf(&email, "Hi %s,\n\n", appointment.Patient.FirstName)
+	fmt.Fprintf(&email, "Your appointment at %s is booked.\n\n", place)
+	fmt.Fprintf(&email, "When: %s\n", when)
+	if appointment.Branch.Address != "" {
+		fmt.Fprintf(&email, "Where: %s\n", appointment.Branch.Address)
+	}
+	fmt.Fprintf(&email, "Booking code: %s\n", appointment.BookingCode)
+	email.WriteString("\nAdd it to your calendar with the attached file. When you arrive, you can check in at the kiosk with your booking code and date of birth.\n")
+	sms := fmt.Sprintf("Hi %s, your appointment at %s is booked for %s.", appointment.Patient.FirstName, place, when)
+	if contact != "" {
+		fmt.Fprintf(&email, "\nQuestions? Call us at %s.\n", contact)
+		sms += " Call " + contact + " for help."
+	}
+	fmt.Fprintf(&email, "\n%s\n", clinic.Name)
+
+	queueAppointmentMessages(appointment, clinic, models.MessageCategoryAppointmentConfirmation,
+		"Your appointment is booked: "+when, email.String(), sms)
+}
+
+// queueAppointmentCalendarUpdate sends an updated .ics, or a cancellation, to a patient who was
+// sent one for the appointment before, so their calendar follows reschedules and cancellations
+func queueAppointmentCalendarUpdate(appointmentID uint) {
+	if messagingService == nil || !patientHasInvite(appointmentID) {
+		return
+	}
+
+	var appointment models.Appointment
+	if err := database.DB.Preload("Patient").Preload("Branch").First(&appointment, appointmentID).Error; err != nil {
+		return
+	}
+	var clinic models.Clinic
+	database.DB.Select("id", "name", "phone", "email").First(&clinic, appointment.ClinicID)
+
+	place := clinicDisplayName(clinic, appointment.Branch)
+	when := formatMessageTime(appointment.StartTime)
+
+	var subject string
+	var email strings.Builder
+	fmt.Fprintf(&email, "Hi %s,\n\n", appointment.Patient.FirstName)
+	if appointmentEventStatus(appointment.Status) == ical.StatusCancelled {
+		subject = "Your appointment has been cancelled"
+		fmt.Fprintf(&email, "Your appointment at %s on %s has been cancelled.\n", place, when)
+		email.WriteString("The attached file removes it from your calendar.\n")
+	} else {
+		subject = "Your appointment has changed: " + when
+		fmt.Fprintf(&email, "Your appointment at %s has been updated.\n\n", place)
+		fmt.Fprintf(&email, "When: %s\n", when)
+		if appointment.Branch.Address != "" {
+			fmt.Fprintf(&email, "Where: %s\n", appointment.Branch.Address)
+		}
+		email.WriteString("\nThe attached file updates it in your calendar.\n")
+	}
+	if contact := firstNonEmpty(appointment.Branch.Phone, clinic.Phone); contact != "" {
+		fmt.Fprintf(&email, "\nQuestions? Call us at %s.\n", contact)
+	}
+	fmt.Fprintf(&email, "\n%s\n", clinic.Name)
+
+	queueAppointmentMessages(&appointment, clinic, models.MessageCategoryAppointmentUpdate, subject, email.String(), "")
+}
+
+// patientHasInvite reports whether the patient was emailed an .ics file for the appointment
+func patientHasInvite(appointmentID uint) bool {
+	var invited int64
+	database.DB.Model(&models.OutboundMessage{}).
+		Where("appointment_id = ? AND channel = ? AND attachment_name <> ''", appointmentID, models.ChannelEmail).
+		Count(&invited)
+	return invited > 0
+}
+
+// queueAppointmentMessages sends a notice about an appointment to its patient by email, with the
+// .ics attached, and by SMS when smsBody is set
+func queueAppointmentMessages(appointment *models.Appointment, clinic models.Clinic, category, subject, emailBody, smsBody string) {
+	name, contentType, invite := appointmentInvite(appointment, clinic)
+
+	patientID := appointment.PatientID
+	appointmentID := appointment.ID
+	messages := []models.OutboundMessage{
+		{Channel: models.ChannelEmail, Recipient: appointment.Patient.Email, Subject: subject, Body: emailBody,
+			AttachmentName: name, AttachmentContentType: contentType, Attachment: invite},
+	}
+	if smsBody != "" {
+		messages = append(messages, models.OutboundMessage{Channel: models.ChannelSMS, Recipient: appointment.Patient.Phone, Body: smsBody})
+	}
+	for i := range messages {
+		message := &messages[i]
+		message.Recipient = strings.TrimSpace(message.Recipient)
+		if message.Recipient == "" || !messagingService.HasChannel(message.Channel) {
+			continue
+		}
+		message.PatientID = &patientID
+		message.AppointmentID = &appointmentID
+		message.Category = category
+		message.ClinicID = appointment.ClinicID
+		if err := messagingService.Enqueue(message); err != nil {
+			log.Printf("Failed to queue %s message for appointment %d: %v", message.Channel, appointment.ID, err)
+		}
+	}
+}
+
+// GetAppointmentCalendarFile downloads an appointment as an .ics file, e.g. to hand to the patient
+func GetAppointmentCalendarFile(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var appointment models.Appointment
+	if err := database.DB.Preload("Patient").Preload("Branch").First(&appointment, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Appointment not found"})
+	}
+	if !user.CanAccessClinic(appointment.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	var clinic models.Clinic
+	database.DB.Select("id", "name", "phone", "email").First(&clinic, appointment.ClinicID)
+
+	calendar := ical.Calendar{
+		Method: ical.MethodPublish,
+		Events: []ical.Event{patientAppointmentEvent(&appointment, clinic)},
+	}
+	c.Set(fiber.HeaderContentType, ical.ContentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="appointment-%d.ics"`, appointment.ID))
+	return c.Send(calendar.Bytes())
+}
+
+// calendarFeedURL is the subscription URL for a feed, served by this API
+func calendarFeedURL(c *fiber.Ctx, feed *models.CalendarFeed) string {
+	return c.BaseURL() + "/api/public/calendar/" + feed.Token + ".ics"
+}
+
+func calendarFeedView(c *fiber.Ctx, feed *models.CalendarFeed) fiber.Map {
+	return fiber.Map{"feed": feed, "url": calendarFeedURL(c, feed)}
+}
+
+// GetCalendarFeeds lists the user's own feed and, for clinic managers, the clinic's branch feeds
+func GetCalendarFeeds(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	query := database.DB.Preload("Branch")
+	if user.HasRole(models.SuperAdmin, models.Admin, models.Secretary) {
+		query = query.Where("user_id = ? OR (branch_id IS NOT NULL AND clinic_id = ?)", user.ID, user.ClinicID)
+	} else {
+		query = query.Where("user_id = ?", user.ID)
+	}
+
+	var feeds []models.CalendarFeed
+	if err := query.Order("id ASC").Find(&feeds).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch calendar feeds"})
+	}
+	result := make([]fiber.Map, 0, len(feeds))
+	for i := range feeds {
+		result = append(result, calendarFeedView(c, &feeds[i]))
+	}
+	return c.JSON(result)
+}
+
+// CreateCalendarFeed issues a feed URL for the doctor's own appointments or for a branch. Asking
+// again replaces the old URL, which stops working.
+func CreateCalendarFeed(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var req CreateCalendarFeedRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+	}
+
+	// The feed this one replaces
+	feed := models.CalendarFeed{CreatedByID: user.ID, ClinicID: user.ClinicID}
+	replaces, replacesID := "user_id", user.ID
+	if req.BranchID != nil {
+		if !user.HasRole(models.SuperAdmin, models.Admin, models.Secretary) {
+			return c.Status(403).JSON(fiber.Map{"error": "Only clinic staff managers can create branch feeds"})
+		}
+		var branch models.Branch
+		if err := database.DB.First(&branch, *req.BranchID).Error; err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Branch not found"})
+		}
+		if !user.CanAccessClinic(branch.ClinicID) {
+			return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+		}
+		feed.BranchID = &branch.ID
+		feed.ClinicID = branch.ClinicID
+		replaces, replacesID = "branch_id", branch.ID
+	} else {
+		if !user.HasRole(models.Doctor) {
+			return c.Status(400).JSON(fiber.Map{"error": "Personal feeds list your appointments as a doctor; choose a branch instead"})
+		}
+		feed.UserID = &user.ID
+	}
+
+	token, err := models.GenerateToken()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create calendar feed"})
+	}
+	feed.Token = token
+
+	tx := database.DB.Begin()
+	if err := tx.Where(replaces+" = ?", replacesID).Delete(&models.CalendarFeed{}).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create calendar feed"})
+	}
+	if err := tx.Create(&feed).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create calendar feed"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create calendar feed"})
+	}
+
+	return c.Status(201).JSON(calendarFeedView(c, &feed))
+}
+
+// DeleteCalendarFeed revokes a feed URL
+func DeleteCalendarFeed(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var feed models.CalendarFeed
+	if err := database.DB.First(&feed, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Calendar feed not found"})
+	}
+	ownFeed := feed.UserID != nil && *feed.UserID == user.ID
+	manager := feed.BranchID != nil && user.HasRole(models.SuperAdmin, models.Admin, models.Secretary) && user.CanAccessClinic(feed.ClinicID)
+	if !ownFeed && !manager {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	if err := database.DB.Delete(&feed).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete calendar feed"})
+	}
+	return c.JSON(fiber.Map{"message": "Calendar feed deleted"})
+}
+
+// GetPublicCalendarFeed serves a feed to calendar apps. The secret token in the URL is the only
+// credential, since calendar apps cannot log in.
+func GetPublicCalendarFeed(c *fiber.Ctx) error {
+	token := strings.TrimSuffix(c.Params("token"), ".ics")
+
+	var feed models.CalendarFeed
+	if token == "" || database.DB.Preload("User").Preload("Branch").Where("token = ?", token).First(&feed).Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Calendar feed not found"})
+	}
+
+	now := time.Now()
+	query := database.DB.Preload("Patient").Preload("Doctor").Preload("Branch").
+		Where("clinic_id = ? AND start_time >= ? AND start_time < ?", feed.ClinicID, now.Add(-calendarFeedPast), now.Add(calendarFeedFuture))
+	calendar := ical.Calendar{Method: ical.MethodPublish, TTL: calendarFeedTTL}
+	if feed.BranchID != nil {
+		query = query.Where("branch_id = ?", *feed.BranchID)
+		if feed.Branch != nil {
+			calendar.Name = "Dentika - " + feed.Branch.Name
+		}
+	} else if feed.UserID != nil {
+		// A deactivated or removed user's schedule is no longer published
+		if feed.User == nil || !feed.User.IsActive {
+			return c.Status(404).JSON(fiber.Map{"error": "Calendar feed not found"})
+		}
+		query = query.Where("doctor_id = ?", *feed.UserID)
+		calendar.Name = "Dentika - " + feed.User.GetDisplayName()
+	} else {
+		return c.Status(404).JSON(fiber.Map{"error": "Calendar feed not found"})
+	}
+
+	var appointments []models.Appointment
+	if err := query.Order("start_time ASC").Find(&appointments).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to load calendar feed"})
+	}
+	for i := range appointments {
+		calendar.Events = append(calendar.Events, staffAppointmentEvent(&appointments[i], feed.BranchID != nil))
+	}
+
+	database.DB.Model(&feed).UpdateColumn("last_accessed_at", now)
+
+	c.Set(fiber.HeaderContentType, ical.ContentType)
+	c.Set(fiber.HeaderCacheControl, "private, max-age=300")
+	return c.Send(calendar.Bytes())
+}
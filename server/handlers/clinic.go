@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"strconv"
+	"time"
 
 	"dentika/server/database"
 	"dentika/server/models"
@@ -16,6 +17,8 @@ type CreateClinicRequest struct {
 	Email   string `json:"email"`
 	Website string `json:"website"`
 	Tagline string `json:"tagline"`
+	// IANA timezone, e.g. "Asia/Manila"
+	Timezone string `json:"timezone"`
 }
 
 type CreateBranchRequest struct {
@@ -25,6 +28,8 @@ type CreateBranchRequest struct {
 	IsMainBranch  bool   `json:"is_main_branch"`
 	Schedule      string `json:"schedule"`
 	IsClosedToday bool   `json:"is_closed_today"`
+	// IANA timezone; empty uses the clinic's, omitted leaves it unchanged
+	Timezone *string `json:"timezone"`
 }
 
 func GetClinics(c *fiber.Ctx) error {
@@ -81,6 +86,9 @@ func CreateClinic(c *fiber.Ctx) error {
 	if req.Name == "" {
 		return c.Status(400).JSON(fiber.Map{"error": "Clinic name is required"})
 	}
+	if req.Timezone != "" && !models.IsValidTimezone(req.Timezone) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid timezone"})
+	}
 
 	clinic := models.Clinic{
 		Name:     req.Name,
@@ -89,6 +97,7 @@ func CreateClinic(c *fiber.Ctx) error {
 		Email:    req.Email,
 		Website:  req.Website,
 		Tagline:  req.Tagline,
+		Timezone: req.Timezone,
 		IsActive: true,
 	}
 
@@ -159,11 +168,28 @@ func UpdateClinic(c *fiber.Ctx) error {
 	if req.Tagline != "" {
 		clinic.Tagline = req.Tagline
 	}
+	timezoneChanged := false
+	if req.Timezone != "" && req.Timezone != clinic.Timezone {
+		if !models.IsValidTimezone(req.Timezone) {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid timezone"})
+		}
+		clinic.Timezone = req.Timezone
+		timezoneChanged = true
+	}
 
 	if err := database.DB.Save(&clinic).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update clinic"})
 	}
 
+	// Branches without their own timezone follow the clinic's
+	if timezoneChanged {
+		var branchIDs []uint
+		database.DB.Model(&models.Branch{}).Where("clinic_id = ? AND (timezone = '' OR timezone IS NULL)", clinic.ID).Pluck("id", &branchIDs)
+		for _, branchID := range branchIDs {
+			models.SyncAppointmentTimezones(database.DB, branchID)
+		}
+	}
+
 	// Reload with relationships
 	database.DB.Preload("Branches").Preload("Staff").First(&clinic, clinic.ID)
 
@@ -210,6 +236,13 @@ func CreateBranch(c *fiber.Ctx) error {
 	if req.Name == "" {
 		return c.Status(400).JSON(fiber.Map{"error": "Branch name is required"})
 	}
+	timezone := ""
+	if req.Timezone != nil && *req.Timezone != "" {
+		if !models.IsValidTimezone(*req.Timezone) {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid timezone"})
+		}
+		timezone = *req.Timezone
+	}
 
 	// If this is supposed to be main branch, unset other main branches
 	if req.IsMainBranch {
@@ -224,6 +257,7 @@ func CreateBranch(c *fiber.Ctx) error {
 		IsActive:      true,
 		Schedule:      req.Schedule,
 		IsClosedToday: req.IsClosedToday,
+		Timezone:      timezone,
 		ClinicID:      uint(clinicID),
 	}
 
@@ -312,10 +346,23 @@ func UpdateBranch(c *fiber.Ctx) error {
 	branch.Schedule = req.Schedule
 	branch.IsClosedToday = req.IsClosedToday
 
+	timezoneChanged := false
+	if req.Timezone != nil && *req.Timezone != branch.Timezone {
+		if *req.Timezone != "" && !models.IsValidTimezone(*req.Timezone) {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid timezone"})
+		}
+		branch.Timezone = *req.Timezone
+		timezoneChanged = true
+	}
+
 	if err := database.DB.Save(&branch).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update branch"})
 	}
 
+	if timezoneChanged {
+		models.SyncAppointmentTimezones(database.DB, branch.ID)
+	}
+
 	return c.JSON(branch)
 }
 
@@ -351,3 +398,18 @@ func DeleteBranch(c *fiber.Ctx) error {
 
 	return c.SendStatus(204)
 }
+
+// requestLocation is the timezone date filters are read in: the branch_id query's branch,
+// otherwise the clinic's
+func requestLocation(c *fiber.Ctx, user models.User) *time.Location {
+	if id, err := strconv.ParseUint(c.Query("branch_id"), 10, 32); err == nil {
+		return models.BranchLocation(database.DB, uint(id))
+	}
+	clinicID := user.ClinicID
+	if user.IsSuperAdmin() {
+		if id, err := strconv.ParseUint(c.Query("clinic_id"), 10, 32); err == nil {
+			clinicID = uint(id)
+		}
+	}
+	return models.ClinicLocation(database.DB, clinicID)
+}
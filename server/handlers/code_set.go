@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"dentika/server/database"
+	"dentika/server/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ImportProcedureTemplates loads a procedure code set, e.g. a CDT list, into the catalog
+func ImportProcedureTemplates(c *fiber.Ctx) error {
+	return importTemplateCodeSet(c, models.CodeSetProcedures)
+}
+
+// ImportDiagnosisTemplates loads a diagnosis code set, e.g. ICD-10 K00–K14, into the catalog
+func ImportDiagnosisTemplates(c *fiber.Ctx) error {
+	return importTemplateCodeSet(c, models.CodeSetDiagnoses)
+}
+
+// importTemplateCodeSet reads a CSV or JSON code set from the "file" upload or the request body.
+// It is a dry run returning the diff unless dry_run=false. Other options, as query or form values:
+// format (csv or json, else taken from the file name or content type), category_map (a JSON object
+// of file category to catalog category), deactivate_missing and code_prefix, and for super admins
+// clinic_id (the platform catalog by default).
+func importTemplateCodeSet(c *fiber.Ctx, kind models.CodeSetKind) error {
+	user := c.Locals("user").(models.User)
+
+	option := func(name string) string {
+		if value := c.Query(name); value != "" {
+			return value
+		}
+		return c.FormValue(name)
+	}
+
+	var data []byte
+	format := strings.ToLower(option("format"))
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Failed to read uploaded file"})
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(f); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Failed to read uploaded file"})
+		}
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(filepath.Ext(file.Filename)), ".")
+		}
+	} else {
+		data = c.Body()
+		if format == "" && strings.Contains(string(c.Request().Header.ContentType()), "json") {
+			format = "json"
+		}
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "A CSV or JSON code set is required"})
+	}
+
+	entries, err := models.ParseCodeSet(bytes.NewReader(data), format)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	opts := models.CodeSetImport{
+		Kind:              kind,
+		ClinicID:          user.ClinicID,
+		Entries:           entries,
+		DeactivateMissing: option("deactivate_missing") == "true",
+		CodePrefix:        option("code_prefix"),
+	}
+	if user.IsSuperAdmin() {
+		opts.ClinicID = models.PlatformCatalogID
+		if id, err := strconv.ParseUint(option("clinic_id"), 10, 32); err == nil {
+			opts.ClinicID = uint(id)
+		}
+	}
+	if raw := option("category_map"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &opts.CategoryMap); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "category_map must be a JSON object"})
+		}
+	}
+
+	var report *models.CodeSetReport
+	if option("dry_run") == "false" {
+		report, err = models.ApplyCodeSetImport(database.DB, opts)
+	} else {
+		report, err = models.PlanCodeSetImport(database.DB, opts)
+	}
+	if err != nil {
+		log.Printf("Failed to import %s code set: %v", kind, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to import code set"})
+	}
+	if len(report.Errors) > 0 {
+		return c.Status(422).JSON(report)
+	}
+
+	return c.JSON(report)
+}
@@ -1,13 +1,17 @@
 package handlers
 
 import (
-	"strings"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
 	"time"
 
 	"dentika/server/database"
 	"dentika/server/models"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 // Consent Template Handlers
@@ -57,6 +61,10 @@ func CreateConsentTemplate(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Code, name, and content are required"})
 	}
 
+	if unknown := models.UnknownConsentMergeFields(req.Content); len(unknown) > 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Content contains unknown merge fields", "unknown_fields": unknown})
+	}
+
 	// Set clinic ID for non-super-admin users
 	if !user.IsSuperAdmin() {
 		req.ClinicID = user.ClinicID
@@ -124,15 +132,74 @@ func UpdateConsentTemplate(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
 	}
 
-	// Update fields
-	template.Name = req.Name
-	template.Description = req.Description
-	template.Content = req.Content
-	template.Category = req.Category
+	var opts struct {
+		ChangeNotes string `json:"change_notes"`
+	}
+	c.BodyParser(&opts)
+
+	if unknown := models.UnknownConsentMergeFields(req.Content); len(unknown) > 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Content contains unknown merge fields", "unknown_fields": unknown})
+	}
+
+	// Wording changes are published as a new version rather than overwriting the old one. While
+	// a draft is being prepared they go through the draft, so publishing it doesn't discard them.
+	wordingChanged := req.Name != template.Name || req.Description != template.Description ||
+		req.Content != template.Content || req.Category != template.Category
+	if wordingChanged {
+		var draft models.ConsentTemplateVersion
+		if err := database.DB.Where("consent_template_id = ? AND status = ?", template.ID, models.ConsentVersionDraft).
+			First(&draft).Error; err == nil {
+			return c.Status(409).JSON(fiber.Map{
+				"error": "This template has a draft version; edit the draft and publish it to change the wording",
+				"draft": draft,
+			})
+		}
+	}
+
+	tx := database.DB.Begin()
+
+	if wordingChanged {
+		if _, err := template.CreateInitialVersion(tx, nil); err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update consent template"})
+		}
+
+		number, err := models.NextConsentTemplateVersion(tx, template.ID)
+		if err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update consent template"})
+		}
+
+		version := template.NewVersion(number, &user.ID)
+		version.Name = req.Name
+		version.Description = req.Description
+		version.Content = req.Content
+		version.Category = req.Category
+		version.ChangeNotes = opts.ChangeNotes
+
+		if err := tx.Create(version).Error; err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update consent template"})
+		}
+		if err := version.Publish(tx, &template, &user.ID); err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update consent template"})
+		}
+	}
+
+	// Update metadata
 	template.IsActive = req.IsActive
 	template.IsDefault = req.IsDefault
 
-	if err := database.DB.Save(&template).Error; err != nil {
+	if err := tx.Model(&template).Updates(map[string]interface{}{
+		"is_active":  template.IsActive,
+		"is_default": template.IsDefault,
+	}).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update consent template"})
+	}
+
+	if err := tx.Commit().Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update consent template"})
 	}
 
@@ -167,6 +234,97 @@ func DeleteConsentTemplate(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{"message": "Consent template deleted successfully"})
 }
 
+// GetConsentMergeFields lists the merge fields that can be used in consent template content
+func GetConsentMergeFields(c *fiber.Ctx) error {
+	return c.JSON(models.GetConsentMergeFields())
+}
+
+// PreviewConsentTemplate renders a template against a patient, doctor and appointment without creating a form.
+// An unsaved "content" can be passed to preview edits before saving.
+func PreviewConsentTemplate(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+	id := c.Params("id")
+
+	var template models.ConsentTemplate
+	query := database.DB.Where("id = ?", id)
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	}
+
+	if err := query.First(&template).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent template not found"})
+	}
+
+	var req struct {
+		PatientID     *uint  `json:"patient_id"`
+		DoctorID      *uint  `json:"doctor_id"`
+		AppointmentID *uint  `json:"appointment_id"`
+		Content       string `json:"content"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	data, errMsg := loadConsentMergeData(template.ClinicID, req.PatientID, req.DoctorID, req.AppointmentID)
+	if errMsg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": errMsg})
+	}
+
+	content := template.Content
+	if req.Content != "" {
+		content = req.Content
+	}
+
+	return c.JSON(fiber.Map{
+		"title":  template.Name,
+		"result": models.RenderConsentContent(content, data),
+	})
+}
+
+// loadConsentMergeData loads the records used to render consent merge fields, all scoped to the clinic.
+// The doctor defaults to the appointment's doctor. Returns an error message for invalid references.
+func loadConsentMergeData(clinicID uint, patientID, doctorID, appointmentID *uint) (*models.ConsentMergeData, string) {
+	data := &models.ConsentMergeData{Date: time.Now()}
+
+	var clinic models.Clinic
+	if err := database.DB.First(&clinic, clinicID).Error; err == nil {
+		data.Clinic = &clinic
+	}
+
+	if patientID != nil && *patientID != 0 {
+		var patient models.Patient
+		if err := database.DB.Where("id = ? AND clinic_id = ?", *patientID, clinicID).First(&patient).Error; err != nil {
+			return nil, "Invalid patient"
+		}
+		data.Patient = &patient
+	}
+
+	if appointmentID != nil {
+		var appointment models.Appointment
+		if err := database.DB.Preload("Branch").Preload("Doctor").Preload("Procedures.ProcedureTemplate").
+			Where("id = ? AND clinic_id = ?", *appointmentID, clinicID).First(&appointment).Error; err != nil {
+			return nil, "Invalid appointment"
+		}
+		if data.Patient != nil && appointment.PatientID != data.Patient.ID {
+			return nil, "Appointment does not belong to this patient"
+		}
+		data.Appointment = &appointment
+		if doctorID == nil {
+			data.Doctor = &appointment.Doctor
+		}
+	}
+
+	if doctorID != nil {
+		var doctor models.User
+		if err := database.DB.Where("id = ? AND clinic_id = ?", *doctorID, clinicID).First(&doctor).Error; err != nil {
+			return nil, "Invalid doctor"
+		}
+		data.Doctor = &doctor
+	}
+
+	return data, ""
+}
+
 // Consent Form Handlers
 func GetConsentForms(c *fiber.Ctx) error {
 	user := c.Locals("user").(models.User)
@@ -216,62 +374,73 @@ func CreateConsentForm(c *fiber.Ctx) error {
 	// Set created by
 	req.CreatedByID = user.ID
 
-	// Set status to draft if not provided
-	if req.Status == "" {
+	// A new form starts unsigned; signatures are only collected through signing, which records
+	// the evidence and signing hash
+	if req.Status != models.DocStatusPending {
 		req.Status = models.DocStatusDraft
 	}
+	req.ID = 0
+	req.PatientSignature = ""
+	req.PatientSignedAt = nil
+	req.WitnessSignature = ""
+	req.WitnessSignedAt = nil
+	req.PatientSignedVia = ""
+	req.PatientSignedIP = ""
+	req.PatientSignedUserAgent = ""
+	req.SigningRecord = nil
+	req.PDFPath = ""
 
-	// Generate content from template with signature areas
-	if req.ConsentTemplateID != nil {
-		var template models.ConsentTemplate
-		if err := database.DB.First(&template, *req.ConsentTemplateID).Error; err != nil {
-			return c.Status(400).JSON(fiber.Map{"error": "Invalid consent template"})
-		}
-
-		// Load patient for placeholder replacement
-		var patient models.Patient
-		if err := database.DB.First(&patient, req.PatientID).Error; err != nil {
-			return c.Status(400).JSON(fiber.Map{"error": "Invalid patient"})
-		}
-
-		// Replace placeholders in template content
-		content := template.Content
-		content = strings.ReplaceAll(content, "[PATIENT_NAME]", patient.FirstName+" "+patient.LastName)
-		content = strings.ReplaceAll(content, "[PATIENT_FIRST_NAME]", patient.FirstName)
-		content = strings.ReplaceAll(content, "[PATIENT_LAST_NAME]", patient.LastName)
+	var opts struct {
+		AllowMissingFields bool `json:"allow_missing_fields"`
+	}
+	c.BodyParser(&opts)
 
-		currentDate := time.Now().Format("January 2, 2006")
-		content = strings.ReplaceAll(content, "[CURRENT_DATE]", currentDate)
-		content = strings.ReplaceAll(content, "[TODAY]", currentDate)
-		content = strings.ReplaceAll(content, "[DATE]", currentDate)
+	// Render the template's merge fields from the patient, doctor, clinic and appointment
+	var template models.ConsentTemplate
+	templateQuery := database.DB.Where("id = ?", *req.ConsentTemplateID)
+	if !user.IsSuperAdmin() {
+		templateQuery = templateQuery.Where("clinic_id = ?", user.ClinicID)
+	}
+	if err := templateQuery.First(&template).Error; err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid consent template"})
+	}
 
-		if patient.DateOfBirth != nil {
-			dob := patient.DateOfBirth.Format("January 2, 2006")
-			content = strings.ReplaceAll(content, "[PATIENT_DOB]", dob)
-		}
+	if req.ClinicID == 0 {
+		req.ClinicID = template.ClinicID
+	}
 
-		if patient.Phone != "" {
-			content = strings.ReplaceAll(content, "[PATIENT_PHONE]", patient.Phone)
-		}
+	// Pin the form to the template's published wording
+	version, err := publishedConsentTemplateVersion(&template)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to load consent template version"})
+	}
+	req.ConsentTemplateVersionID = &version.ID
 
-		if patient.Email != "" {
-			content = strings.ReplaceAll(content, "[PATIENT_EMAIL]", patient.Email)
-		}
+	data, errMsg := loadConsentMergeData(req.ClinicID, &req.PatientID, req.DoctorID, req.AppointmentID)
+	if errMsg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": errMsg})
+	}
 
-		// Handle doctor placeholder if doctor is specified
-		if req.DoctorID != nil {
-			var doctor models.User
-			if err := database.DB.First(&doctor, *req.DoctorID).Error; err == nil {
-				doctorName := doctor.FirstName + " " + doctor.LastName
-				content = strings.ReplaceAll(content, "[DOCTOR_NAME]", doctorName)
-			}
-		} else {
-			// Use default if no doctor specified
-			content = strings.ReplaceAll(content, "[DOCTOR_NAME]", "Doctor")
-		}
+	rendered := models.RenderConsentContent(version.Content, data)
+	if len(rendered.UnknownFields) > 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error":          "Consent template contains unknown merge fields",
+			"unknown_fields": rendered.UnknownFields,
+		})
+	}
+	if len(rendered.MissingFields) > 0 && !opts.AllowMissingFields {
+		return c.Status(422).JSON(fiber.Map{
+			"error":          "Missing data for consent template merge fields",
+			"missing_fields": rendered.MissingFields,
+		})
+	}
 
-		// Set the populated content
-		req.Content = content
+	req.Content = rendered.Content
+	if req.Title == "" {
+		req.Title = version.Name
+	}
+	if req.DoctorID == nil && data.Doctor != nil {
+		req.DoctorID = &data.Doctor.ID
 	}
 
 	if err := database.DB.Create(&req).Error; err != nil {
@@ -279,7 +448,7 @@ func CreateConsentForm(c *fiber.Ctx) error {
 	}
 
 	// Load the created form with relations
-	if err := database.DB.Preload("Patient").Preload("ConsentTemplate").Preload("Doctor").First(&req, req.ID).Error; err != nil {
+	if err := database.DB.Preload("Patient").Preload("ConsentTemplate").Preload("ConsentTemplateVersion").Preload("Doctor").First(&req, req.ID).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to load created consent form"})
 	}
 
@@ -291,7 +460,7 @@ func GetConsentForm(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	var form models.ConsentForm
-	query := database.DB.Preload("Patient").Preload("ConsentTemplate").Preload("Doctor").Preload("Witness")
+	query := database.DB.Preload("Patient").Preload("ConsentTemplate").Preload("ConsentTemplateVersion").Preload("Doctor").Preload("Witness").Preload("SigningRecord")
 
 	// Filter by clinic for non-super-admin users
 	if !user.IsSuperAdmin() {
@@ -305,6 +474,24 @@ func GetConsentForm(c *fiber.Ctx) error {
 	return c.JSON(form)
 }
 
+// UpdateConsentFormRequest changes an unsigned consent form. Fields left out keep their value.
+type UpdateConsentFormRequest struct {
+	Title                *string                `json:"title"`
+	Content              *string                `json:"content"`
+	Status               *models.DocumentStatus `json:"status"`
+	ProcedureDescription *string                `json:"procedure_description"`
+	Risks                *string                `json:"risks"`
+	Alternatives         *string                `json:"alternatives"`
+	PostCareInstructions *string                `json:"post_care_instructions"`
+	UnderstandsTreatment *bool                  `json:"understands_treatment"`
+	UnderstandsRisks     *bool                  `json:"understands_risks"`
+	ConsentsToTreatment  *bool                  `json:"consents_to_treatment"`
+	HadOpportunityToAsk  *bool                  `json:"had_opportunity_to_ask"`
+	PatientSignature     *string                `json:"patient_signature"`
+	WitnessSignature     *string                `json:"witness_signature"`
+	WitnessID            *uint                  `json:"witness_id"`
+}
+
 func UpdateConsentForm(c *fiber.Ctx) error {
 	user := c.Locals("user").(models.User)
 	id := c.Params("id")
@@ -315,45 +502,97 @@ func UpdateConsentForm(c *fiber.Ctx) error {
 		query = query.Where("clinic_id = ?", user.ClinicID)
 	}
 
-	if err := query.First(&form).Error; err != nil {
+	if err := query.Preload("SigningRecord").First(&form).Error; err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Consent form not found"})
 	}
 
-	var req models.ConsentForm
+	// Signed forms are covered by their signing hash and cannot be changed
+	if form.IsLocked() {
+		return c.Status(409).JSON(fiber.Map{"error": "Signed consent forms cannot be modified"})
+	}
+	// Nor can what the patient signed, which their signing evidence refers to
+	if form.PatientSignedAt != nil {
+		return c.Status(409).JSON(fiber.Map{"error": "The patient has signed this consent form; use POST /consent-forms/:id/sign to add the witness signature"})
+	}
+
+	// The patient may be reading the form through a signing link; it must not change under them
+	var pendingLinks int64
+	database.DB.Model(&models.ConsentSigningLink{}).
+		Where("consent_form_id = ? AND used_at IS NULL AND revoked_at IS NULL AND expires_at > ?", form.ID, time.Now()).
+		Count(&pendingLinks)
+	if pendingLinks > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "The form has an outstanding signing link; revoke it before editing the form"})
+	}
+
+	var req UpdateConsentFormRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
 	}
 
-	// Update fields
-	form.Title = req.Title
-	form.Content = req.Content
-	form.Status = req.Status
-	form.ProcedureDescription = req.ProcedureDescription
-	form.Risks = req.Risks
-	form.Alternatives = req.Alternatives
-	form.PostCareInstructions = req.PostCareInstructions
-	form.UnderstandsTreatment = req.UnderstandsTreatment
-	form.UnderstandsRisks = req.UnderstandsRisks
-	form.ConsentsToTreatment = req.ConsentsToTreatment
-	form.HadOpportunityToAsk = req.HadOpportunityToAsk
-	form.PatientSignature = req.PatientSignature
-	form.WitnessSignature = req.WitnessSignature
-	form.WitnessID = req.WitnessID
+	// A form generated from a template keeps the wording of the version it is pinned to
+	if form.ConsentTemplateVersionID != nil &&
+		(req.Title != nil && *req.Title != form.Title || req.Content != nil && *req.Content != form.Content) {
+		return c.Status(409).JSON(fiber.Map{"error": "The wording of a consent form generated from a template cannot be changed; create a new form instead"})
+	}
+
+	// Update the fields given
+	setString := func(field *string, value *string) {
+		if value != nil {
+			*field = *value
+		}
+	}
+	setBool := func(field *bool, value *bool) {
+		if value != nil {
+			*field = *value
+		}
+	}
+	setString(&form.Title, req.Title)
+	setString(&form.Content, req.Content)
+	if req.Status != nil {
+		form.Status = *req.Status
+	}
+	setString(&form.ProcedureDescription, req.ProcedureDescription)
+	setString(&form.Risks, req.Risks)
+	setString(&form.Alternatives, req.Alternatives)
+	setString(&form.PostCareInstructions, req.PostCareInstructions)
+	setBool(&form.UnderstandsTreatment, req.UnderstandsTreatment)
+	setBool(&form.UnderstandsRisks, req.UnderstandsRisks)
+	setBool(&form.ConsentsToTreatment, req.ConsentsToTreatment)
+	setBool(&form.HadOpportunityToAsk, req.HadOpportunityToAsk)
+	setString(&form.PatientSignature, req.PatientSignature)
+	setString(&form.WitnessSignature, req.WitnessSignature)
+	if req.WitnessID != nil {
+		form.WitnessID = req.WitnessID
+	}
 
 	// Set timestamps if signatures are provided
-	if req.PatientSignature != "" && form.PatientSignedAt == nil {
+	if form.PatientSignature != "" && form.PatientSignedAt == nil {
 		now := time.Now()
 		form.PatientSignedAt = &now
+		form.RecordPatientSigningEvidence(models.ConsentSignedInPerson, c.IP(), c.Get("User-Agent"))
 	}
-	if req.WitnessSignature != "" && form.WitnessSignedAt == nil {
+	if form.WitnessSignature != "" && form.WitnessSignedAt == nil {
 		now := time.Now()
 		form.WitnessSignedAt = &now
 	}
 
-	if err := database.DB.Save(&form).Error; err != nil {
+	// Signed status can only be reached by actually collecting both signatures
+	if form.IsFullySigned() {
+		form.Status = models.DocStatusSigned
+	} else if form.Status == models.DocStatusSigned || form.Status == models.DocStatusCompleted {
+		return c.Status(400).JSON(fiber.Map{"error": "Consent form must be signed by the patient and witness"})
+	}
+
+	if err := saveConsentFormSigning(&form, &user.ID, c.IP(), c.Get("User-Agent")); err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update consent form"})
 	}
 
+	if form.Status == models.DocStatusSigned {
+		if err := generateConsentFormPDF(&form); err != nil {
+			log.Printf("Failed to generate PDF for consent form %d: %v", form.ID, err)
+		}
+	}
+
 	// Load the updated form with relations
 	if err := database.DB.Preload("Patient").Preload("ConsentTemplate").Preload("Witness").First(&form, form.ID).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to load updated consent form"})
@@ -372,10 +611,14 @@ func SignConsentForm(c *fiber.Ctx) error {
 		query = query.Where("clinic_id = ?", user.ClinicID)
 	}
 
-	if err := query.First(&form).Error; err != nil {
+	if err := query.Preload("SigningRecord").First(&form).Error; err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Consent form not found"})
 	}
 
+	if form.IsLocked() {
+		return c.Status(409).JSON(fiber.Map{"error": "Consent form has already been signed"})
+	}
+
 	var req struct {
 		PatientSignature string `json:"patient_signature"`
 		WitnessSignature string `json:"witness_signature"`
@@ -386,11 +629,15 @@ func SignConsentForm(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
 	}
 
-	// Update signatures
+	// Update signatures; a patient signature already given is not replaced
 	if req.PatientSignature != "" {
+		if form.PatientSignedAt != nil {
+			return c.Status(409).JSON(fiber.Map{"error": "The patient has already signed this consent form"})
+		}
 		form.PatientSignature = req.PatientSignature
 		now := time.Now()
 		form.PatientSignedAt = &now
+		form.RecordPatientSigningEvidence(models.ConsentSignedInPerson, c.IP(), c.Get("User-Agent"))
 	}
 
 	if req.WitnessSignature != "" {
@@ -407,9 +654,122 @@ func SignConsentForm(c *fiber.Ctx) error {
 		form.Status = models.DocStatusPending
 	}
 
-	if err := database.DB.Save(&form).Error; err != nil {
+	if err := saveConsentFormSigning(&form, &user.ID, c.IP(), c.Get("User-Agent")); err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to sign consent form"})
 	}
 
+	// Produce the signed paper-equivalent record once both parties have signed
+	if form.Status == models.DocStatusSigned {
+		if err := generateConsentFormPDF(&form); err != nil {
+			log.Printf("Failed to generate PDF for consent form %d: %v", form.ID, err)
+		}
+	}
+
 	return c.JSON(form)
 }
+
+// DownloadConsentFormPDF returns the generated PDF for a signed consent form,
+// rendering it first if it has not been generated yet
+func DownloadConsentFormPDF(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+	id := c.Params("id")
+
+	var form models.ConsentForm
+	query := database.DB.Where("id = ?", id)
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	}
+
+	if err := query.First(&form).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent form not found"})
+	}
+
+	if form.Status != models.DocStatusSigned && form.Status != models.DocStatusCompleted {
+		return c.Status(400).JSON(fiber.Map{"error": "Consent form has not been fully signed"})
+	}
+
+	// Generate the PDF if it was never written or has gone missing
+	fullPath := filepath.Join(PrivateBaseDir, form.PDFPath)
+	if _, err := os.Stat(fullPath); form.PDFPath == "" || os.IsNotExist(err) {
+		if err := generateConsentFormPDF(&form); err != nil {
+			log.Printf("Failed to generate PDF for consent form %d: %v", form.ID, err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to generate consent form PDF"})
+		}
+		fullPath = filepath.Join(PrivateBaseDir, form.PDFPath)
+	}
+
+	return c.Download(fullPath, fmt.Sprintf("consent-form-%d.pdf", form.ID))
+}
+
+// VerifyConsentForm reports whether a signed consent form still matches the hash taken at signing
+func VerifyConsentForm(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+	id := c.Params("id")
+
+	var form models.ConsentForm
+	query := database.DB.Preload("SigningRecord").Where("id = ?", id)
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	}
+
+	if err := query.First(&form).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent form not found"})
+	}
+
+	if form.SigningRecord == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent form has no signing record"})
+	}
+
+	result, err := form.Verify(form.SigningRecord)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to verify consent form"})
+	}
+
+	return c.JSON(result)
+}
+
+// saveConsentFormSigning saves the form and, once it is fully signed, writes its signing record
+// in the same transaction so a signed form never exists without its hash
+func saveConsentFormSigning(form *models.ConsentForm, finalizedByID *uint, ipAddress, userAgent string) error {
+	tx := database.DB.Begin()
+
+	if err := saveConsentFormSigningTx(tx, form, finalizedByID, ipAddress, userAgent); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+func saveConsentFormSigningTx(tx *gorm.DB, form *models.ConsentForm, finalizedByID *uint, ipAddress, userAgent string) error {
+	if err := tx.Omit("SigningRecord").Save(form).Error; err != nil {
+		return err
+	}
+
+	if form.Status == models.DocStatusSigned {
+		record, err := form.NewSigningRecord(finalizedByID, ipAddress, userAgent)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		form.SigningRecord = record
+	}
+
+	return nil
+}
+
+// generateConsentFormPDF renders the consent form PDF and stores its path on the form
+func generateConsentFormPDF(form *models.ConsentForm) error {
+	if err := database.DB.Preload("Clinic").Preload("Patient").Preload("Doctor").Preload("Witness").Preload("SigningRecord").
+		First(form, form.ID).Error; err != nil {
+		return err
+	}
+
+	if err := form.GeneratePDF(UploadBaseDir, PrivateBaseDir); err != nil {
+		return err
+	}
+
+	return database.DB.Model(&models.ConsentForm{}).Where("id = ?", form.ID).Update("pdf_path", form.PDFPath).Error
+}
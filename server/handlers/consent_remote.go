@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type CreateConsentSigningLinkRequest struct {
+	ExpiresInHours int `json:"expires_in_hours"`
+}
+
+type RemoteSignConsentRequest struct {
+	PatientSignature     string `json:"patient_signature"`
+	UnderstandsTreatment bool   `json:"understands_treatment"`
+	UnderstandsRisks     bool   `json:"understands_risks"`
+	ConsentsToTreatment  bool   `json:"consents_to_treatment"`
+	HadOpportunityToAsk  bool   `json:"had_opportunity_to_ask"`
+}
+
+// validate returns what is missing before the patient can sign, or "" if nothing is
+func (req *RemoteSignConsentRequest) validate() string {
+	if req.PatientSignature == "" {
+		return "Signature is required"
+	}
+	if !req.UnderstandsTreatment || !req.UnderstandsRisks || !req.ConsentsToTreatment || !req.HadOpportunityToAsk {
+		return "All acknowledgements must be accepted before signing"
+	}
+	return ""
+}
+
+// apply puts the patient's signature and acknowledgements on the form, with the evidence of how
+// it was signed
+func (req *RemoteSignConsentRequest) apply(form *models.ConsentForm, method models.ConsentSigningMethod, ipAddress, userAgent string, now time.Time) {
+	form.UnderstandsTreatment = req.UnderstandsTreatment
+	form.UnderstandsRisks = req.UnderstandsRisks
+	form.ConsentsToTreatment = req.ConsentsToTreatment
+	form.HadOpportunityToAsk = req.HadOpportunityToAsk
+	form.PatientSignature = req.PatientSignature
+	form.PatientSignedAt = &now
+	form.RecordPatientSigningEvidence(method, ipAddress, userAgent)
+
+	if form.IsFullySigned() {
+		form.Status = models.DocStatusSigned
+	} else {
+		form.Status = models.DocStatusPending
+	}
+}
+
+// CreateConsentSigningLink issues a single-use link the patient can use to sign from their own device.
+// Any earlier unused links for the form are revoked.
+func CreateConsentSigningLink(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+	id := c.Params("id")
+
+	var form models.ConsentForm
+	query := database.DB.Preload("SigningRecord").Where("id = ?", id)
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	}
+
+	if err := query.First(&form).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent form not found"})
+	}
+
+	if form.IsLocked() || form.PatientSignedAt != nil {
+		return c.Status(409).JSON(fiber.Map{"error": "Patient has already signed this consent form"})
+	}
+
+	var req CreateConsentSigningLinkRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+	}
+
+	ttl := models.DefaultConsentLinkTTL
+	if req.ExpiresInHours > 0 {
+		ttl = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+	if ttl > models.MaxConsentLinkTTL {
+		return c.Status(400).JSON(fiber.Map{"error": "Signing links can be valid for at most 7 days"})
+	}
+
+	token, err := models.GenerateToken()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to generate signing link"})
+	}
+
+	link := models.ConsentSigningLink{
+		Token:         token,
+		ConsentFormID: form.ID,
+		ExpiresAt:     time.Now().Add(ttl),
+		ClinicID:      form.ClinicID,
+		CreatedByID:   user.ID,
+	}
+
+	tx := database.DB.Begin()
+
+	now := time.Now()
+	if err := tx.Model(&models.ConsentSigningLink{}).
+		Where("consent_form_id = ? AND used_at IS NULL AND revoked_at IS NULL", form.ID).
+		Update("revoked_at", now).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to revoke previous signing links"})
+	}
+
+	if err := tx.Create(&link).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create signing link"})
+	}
+
+	// Remote signing means the form is now waiting on the patient
+	if form.Status == models.DocStatusDraft {
+		if err := tx.Model(&form).Update("status", models.DocStatusPending).Error; err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update consent form"})
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create signing link"})
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"id":              link.ID,
+		"consent_form_id": link.ConsentFormID,
+		"token":           link.Token,
+		"path":            "/api/public/consent/" + link.Token,
+		"expires_at":      link.ExpiresAt,
+	})
+}
+
+// RevokeConsentSigningLinks revokes all outstanding signing links for a consent form
+func RevokeConsentSigningLinks(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+	id := c.Params("id")
+
+	var form models.ConsentForm
+	query := database.DB.Where("id = ?", id)
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	}
+
+	if err := query.First(&form).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent form not found"})
+	}
+
+	result := database.DB.Model(&models.ConsentSigningLink{}).
+		Where("consent_form_id = ? AND used_at IS NULL AND revoked_at IS NULL", form.ID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to revoke signing links"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Signing links revoked", "revoked": result.RowsAffected})
+}
+
+// GetConsentSigningLinks lists the signing links issued for a consent form
+func GetConsentSigningLinks(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+	id := c.Params("id")
+
+	var form models.ConsentForm
+	query := database.DB.Where("id = ?", id)
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	}
+
+	if err := query.First(&form).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent form not found"})
+	}
+
+	var links []models.ConsentSigningLink
+	if err := database.DB.Omit("token").Where("consent_form_id = ?", form.ID).Order("created_at DESC").Find(&links).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch signing links"})
+	}
+
+	return c.JSON(links)
+}
+
+// findUsableSigningLink loads a signing link by token, rejecting used, revoked or expired links
+func findUsableSigningLink(c *fiber.Ctx) (*models.ConsentSigningLink, error) {
+	var link models.ConsentSigningLink
+	if err := database.DB.Where("token = ?", c.Params("token")).First(&link).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "Signing link not found"})
+	}
+
+	if !link.IsUsable() {
+		return nil, c.Status(410).JSON(fiber.Map{"error": "This signing link has expired or has already been used"})
+	}
+
+	return &link, nil
+}
+
+// GetPublicConsentForm shows a consent form to a patient through a signing link
+func GetPublicConsentForm(c *fiber.Ctx) error {
+	link, errResp := findUsableSigningLink(c)
+	if link == nil {
+		return errResp
+	}
+
+	var form models.ConsentForm
+	if err := database.DB.Preload("Clinic").Preload("Patient").Preload("Doctor").First(&form, link.ConsentFormID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent form not found"})
+	}
+
+	if link.ViewedAt == nil {
+		database.DB.Model(link).Update("viewed_at", time.Now())
+	}
+
+	view := patientConsentFormView(&form)
+	view["expires_at"] = link.ExpiresAt
+	return c.JSON(view)
+}
+
+// patientConsentFormView is what a patient sees of a consent form when signing it on their own:
+// only what they need to review - no internal IDs or other patient data
+func patientConsentFormView(form *models.ConsentForm) fiber.Map {
+	doctorName := ""
+	if form.Doctor != nil {
+		doctorName = form.Doctor.GetFullName()
+	}
+
+	return fiber.Map{
+		"title":                  form.Title,
+		"content":                form.Content,
+		"procedure_description":  form.ProcedureDescription,
+		"risks":                  form.Risks,
+		"alternatives":           form.Alternatives,
+		"post_care_instructions": form.PostCareInstructions,
+		"patient_name":           form.Patient.GetFullName(),
+		"doctor_name":            doctorName,
+		"clinic": fiber.Map{
+			"name":  form.Clinic.Name,
+			"logo":  form.Clinic.Logo,
+			"phone": form.Clinic.Phone,
+		},
+	}
+}
+
+// SignPublicConsentForm records the patient signature submitted through a signing link.
+// The link is consumed in the same transaction, so it can only be used once.
+func SignPublicConsentForm(c *fiber.Ctx) error {
+	link, errResp := findUsableSigningLink(c)
+	if link == nil {
+		return errResp
+	}
+
+	var req RemoteSignConsentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	if message := req.validate(); message != "" {
+		return c.Status(400).JSON(fiber.Map{"error": message})
+	}
+
+	var form models.ConsentForm
+	if err := database.DB.Preload("SigningRecord").First(&form, link.ConsentFormID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent form not found"})
+	}
+
+	if form.IsLocked() || form.PatientSignedAt != nil {
+		return c.Status(409).JSON(fiber.Map{"error": "Consent form has already been signed"})
+	}
+
+	ipAddress := c.IP()
+	userAgent := c.Get("User-Agent")
+	now := time.Now()
+	req.apply(&form, models.ConsentSignedRemoteLink, ipAddress, userAgent, now)
+
+	tx := database.DB.Begin()
+
+	// Claim the link; a concurrent request that got here first leaves nothing to update
+	claim := tx.Model(&models.ConsentSigningLink{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", link.ID).
+		Updates(map[string]interface{}{
+			"used_at":    now,
+			"ip_address": form.PatientSignedIP,
+			"user_agent": form.PatientSignedUserAgent,
+		})
+	if claim.Error != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to sign consent form"})
+	}
+	if claim.RowsAffected == 0 {
+		tx.Rollback()
+		return c.Status(410).JSON(fiber.Map{"error": "This signing link has expired or has already been used"})
+	}
+
+	if err := saveConsentFormSigningTx(tx, &form, nil, ipAddress, userAgent); err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to sign consent form"})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to sign consent form"})
+	}
+
+	if form.Status == models.DocStatusSigned {
+		if err := generateConsentFormPDF(&form); err != nil {
+			log.Printf("Failed to generate PDF for consent form %d: %v", form.ID, err)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"message":   "Thank you, your consent has been recorded",
+		"signed_at": form.PatientSignedAt,
+	})
+}
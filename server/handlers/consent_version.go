@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"strconv"
+
+	"dentika/server/database"
+	"dentika/server/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type ConsentTemplateVersionRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Content     *string `json:"content"`
+	Category    *string `json:"category"`
+	ChangeNotes *string `json:"change_notes"`
+}
+
+// findAccessibleConsentTemplate loads a consent template scoped to the user's clinic
+func findAccessibleConsentTemplate(user models.User, id string) (*models.ConsentTemplate, error) {
+	var template models.ConsentTemplate
+	query := database.DB.Where("id = ?", id)
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	}
+
+	if err := query.First(&template).Error; err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// publishedConsentTemplateVersion returns the template's published version,
+// creating version 1 for templates that predate versioning
+func publishedConsentTemplateVersion(template *models.ConsentTemplate) (*models.ConsentTemplateVersion, error) {
+	if template.PublishedVersionID == nil {
+		if _, err := template.CreateInitialVersion(database.DB, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	var version models.ConsentTemplateVersion
+	query := database.DB.Where("consent_template_id = ?", template.ID)
+	if template.PublishedVersionID != nil {
+		query = query.Where("id = ?", *template.PublishedVersionID)
+	} else {
+		query = query.Where("status = ?", models.ConsentVersionPublished)
+	}
+	if err := query.Order("version DESC").First(&version).Error; err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+func GetConsentTemplateVersions(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	template, err := findAccessibleConsentTemplate(user, c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent template not found"})
+	}
+
+	var versions []models.ConsentTemplateVersion
+	if err := database.DB.Preload("CreatedBy").Preload("PublishedBy").
+		Where("consent_template_id = ?", template.ID).Order("version DESC").Find(&versions).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch consent template versions"})
+	}
+
+	return c.JSON(versions)
+}
+
+func GetConsentTemplateVersion(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	template, err := findAccessibleConsentTemplate(user, c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent template not found"})
+	}
+
+	var version models.ConsentTemplateVersion
+	if err := database.DB.Preload("CreatedBy").Preload("PublishedBy").
+		Where("consent_template_id = ? AND version = ?", template.ID, c.Params("version")).First(&version).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent template version not found"})
+	}
+
+	// Show how many forms were generated from this exact wording
+	var formCount int64
+	database.DB.Model(&models.ConsentForm{}).Where("consent_template_version_id = ?", version.ID).Count(&formCount)
+
+	return c.JSON(fiber.Map{
+		"version":    version,
+		"form_count": formCount,
+	})
+}
+
+// CreateConsentTemplateVersion starts a new draft from the published wording, with any fields overridden.
+// A template can only have one draft at a time.
+func CreateConsentTemplateVersion(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	template, err := findAccessibleConsentTemplate(user, c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent template not found"})
+	}
+
+	var req ConsentTemplateVersionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	var draftCount int64
+	database.DB.Model(&models.ConsentTemplateVersion{}).
+		Where("consent_template_id = ? AND status = ?", template.ID, models.ConsentVersionDraft).Count(&draftCount)
+	if draftCount > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "This template already has a draft version"})
+	}
+
+	tx := database.DB.Begin()
+
+	if _, err := template.CreateInitialVersion(tx, nil); err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create consent template version"})
+	}
+
+	number, err := models.NextConsentTemplateVersion(tx, template.ID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create consent template version"})
+	}
+
+	version := template.NewVersion(number, &user.ID)
+	applyConsentVersionRequest(version, &req)
+
+	if version.Name == "" || version.Content == "" {
+		tx.Rollback()
+		return c.Status(400).JSON(fiber.Map{"error": "Name and content are required"})
+	}
+	if unknown := models.UnknownConsentMergeFields(version.Content); len(unknown) > 0 {
+		tx.Rollback()
+		return c.Status(400).JSON(fiber.Map{"error": "Content contains unknown merge fields", "unknown_fields": unknown})
+	}
+
+	if err := tx.Create(version).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create consent template version"})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create consent template version"})
+	}
+
+	return c.Status(201).JSON(version)
+}
+
+// UpdateConsentTemplateVersion edits a draft version; published versions are immutable
+func UpdateConsentTemplateVersion(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	template, err := findAccessibleConsentTemplate(user, c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent template not found"})
+	}
+
+	var version models.ConsentTemplateVersion
+	if err := database.DB.Where("consent_template_id = ? AND version = ?", template.ID, c.Params("version")).First(&version).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent template version not found"})
+	}
+
+	if !version.IsDraft() {
+		return c.Status(409).JSON(fiber.Map{"error": "Published consent template versions cannot be changed"})
+	}
+
+	var req ConsentTemplateVersionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	applyConsentVersionRequest(&version, &req)
+
+	if version.Name == "" || version.Content == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Name and content are required"})
+	}
+	if unknown := models.UnknownConsentMergeFields(version.Content); len(unknown) > 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Content contains unknown merge fields", "unknown_fields": unknown})
+	}
+
+	if err := database.DB.Model(&version).Updates(map[string]interface{}{
+		"name":         version.Name,
+		"description":  version.Description,
+		"content":      version.Content,
+		"category":     version.Category,
+		"change_notes": version.ChangeNotes,
+	}).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update consent template version"})
+	}
+
+	return c.JSON(version)
+}
+
+// PublishConsentTemplateVersion makes a draft the template's current wording
+func PublishConsentTemplateVersion(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	template, err := findAccessibleConsentTemplate(user, c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent template not found"})
+	}
+
+	var version models.ConsentTemplateVersion
+	if err := database.DB.Where("consent_template_id = ? AND version = ?", template.ID, c.Params("version")).First(&version).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent template version not found"})
+	}
+
+	if !version.IsDraft() {
+		return c.Status(409).JSON(fiber.Map{"error": "Only draft versions can be published"})
+	}
+
+	tx := database.DB.Begin()
+
+	if err := version.Publish(tx, template, &user.ID); err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to publish consent template version"})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to publish consent template version"})
+	}
+
+	return c.JSON(fiber.Map{
+		"template": template,
+		"version":  version,
+	})
+}
+
+// DiffConsentTemplateVersions compares two versions of a template.
+// Defaults to comparing the published version with the one before it.
+func DiffConsentTemplateVersions(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	template, err := findAccessibleConsentTemplate(user, c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent template not found"})
+	}
+
+	to := template.PublishedVersion
+	if toParam := c.Query("to"); toParam != "" {
+		if to, err = strconv.Atoi(toParam); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid 'to' version"})
+		}
+	}
+
+	from := to - 1
+	if fromParam := c.Query("from"); fromParam != "" {
+		if from, err = strconv.Atoi(fromParam); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid 'from' version"})
+		}
+	}
+
+	var fromVersion, toVersion models.ConsentTemplateVersion
+	if err := database.DB.Where("consent_template_id = ? AND version = ?", template.ID, from).First(&fromVersion).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent template version " + strconv.Itoa(from) + " not found"})
+	}
+	if err := database.DB.Where("consent_template_id = ? AND version = ?", template.ID, to).First(&toVersion).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Consent template version " + strconv.Itoa(to) + " not found"})
+	}
+
+	return c.JSON(models.DiffConsentTemplateVersions(&fromVersion, &toVersion))
+}
+
+func applyConsentVersionRequest(version *models.ConsentTemplateVersion, req *ConsentTemplateVersionRequest) {
+	if req.Name != nil {
+		version.Name = *req.Name
+	}
+	if req.Description != nil {
+		version.Description = *req.Description
+	}
+	if req.Content != nil {
+		version.Content = *req.Content
+	}
+	if req.Category != nil {
+		version.Category = *req.Category
+	}
+	if req.ChangeNotes != nil {
+		version.ChangeNotes = *req.ChangeNotes
+	}
+}
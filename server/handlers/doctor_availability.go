@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// slotInterval is the spacing of bookable slot start times
+const slotInterval = 30 * time.Minute
+
+// AvailableSlot is a bookable start time. The text fields are in the branch's local time.
+type AvailableSlot struct {
+	StartTime time.Time `json:"start_time"` // RFC3339 with the branch's offset
+	Time      string    `json:"time"`       // 15:04
+	Datetime  string    `json:"datetime"`   // 2006-01-02 15:04
+	Display   string    `json:"display"`    // 3:04 PM
+	DoctorIDs []uint    `json:"doctor_ids,omitempty"`
+}
+
+// DoctorAvailability is one doctor's working time and free time at a branch on a day
+type DoctorAvailability struct {
+	DoctorID   uint               `json:"doctor_id"`
+	DoctorName string             `json:"doctor_name"`
+	BranchID   uint               `json:"branch_id"`
+	BranchName string             `json:"branch_name"`
+	Working    []models.TimeRange `json:"working"`
+	Free       []models.TimeRange `json:"free"`
+	Slots      []AvailableSlot    `json:"slots,omitempty"`
+}
+
+// computeDoctorAvailability works out when each doctor of the clinic is free on a date. Working time is
+// the branch's opening hours, narrowed to the doctor's weekly schedule at that branch when they have
+// one; holidays close the clinic or branch, dated branch exceptions replace its hours, and time off
+// and booked appointments are taken out.
+// Only the calendar date is used: each branch's day runs midnight to midnight in its own timezone.
+func computeDoctorAvailability(date time.Time, clinicID uint, branchID *uint, doctorID *uint) ([]DoctorAvailability, error) {
+	date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	// Wide enough to cover the date in any timezone
+	day := models.TimeRange{Start: date.Add(-14 * time.Hour), End: date.Add(24*time.Hour + 14*time.Hour)}
+	clinicLocation := models.ClinicLocation(database.DB, clinicID)
+
+	// Branches
+	var branches []models.Branch
+	branchQuery := database.DB.Where("clinic_id = ? AND is_active = ?", clinicID, true)
+	if branchID != nil {
+		branchQuery = branchQuery.Where("id = ?", *branchID)
+	}
+	if err := branchQuery.Order("is_main_branch DESC, id ASC").Find(&branches).Error; err != nil {
+		return nil, err
+	}
+
+	// Holidays, closures and special hours
+	branchIDs := make([]uint, len(branches))
+	for i, branch := range branches {
+		branchIDs[i] = branch.ID
+	}
+	exceptions, err := models.BranchExceptionsOn(database.DB, branchIDs, date)
+	if err != nil {
+		return nil, err
+	}
+
+	// Doctors
+	var doctors []models.User
+	doctorQuery := database.DB.Where("clinic_id = ? AND role = ? AND is_active = ?", clinicID, models.Doctor, true)
+	if doctorID != nil {
+		doctorQuery = database.DB.Where("id = ? AND clinic_id = ? AND is_active = ?", *doctorID, clinicID, true)
+	}
+	if err := doctorQuery.Order("first_name, last_name").Find(&doctors).Error; err != nil {
+		return nil, err
+	}
+	if len(doctors) == 0 || len(branches) == 0 {
+		return []DoctorAvailability{}, nil
+	}
+	doctorIDs := make([]uint, len(doctors))
+	for i, doctor := range doctors {
+		doctorIDs[i] = doctor.ID
+	}
+
+	// Weekly schedules
+	var schedules []models.DoctorSchedule
+	if err := database.DB.Where("doctor_id IN ?", doctorIDs).Find(&schedules).Error; err != nil {
+		return nil, err
+	}
+	hasSchedule := map[uint]bool{}
+	for _, schedule := range schedules {
+		hasSchedule[schedule.DoctorID] = true
+	}
+
+	// Time off and appointments that touch the day
+	busy := map[uint][]models.TimeRange{}
+	var timeOff []models.DoctorTimeOff
+	if err := database.DB.Where("doctor_id IN ? AND start_time < ? AND end_time > ?", doctorIDs, day.End, day.Start).
+		Find(&timeOff).Error; err != nil {
+		return nil, err
+	}
+	for _, off := range timeOff {
+		busy[off.DoctorID] = append(busy[off.DoctorID], models.TimeRange{Start: off.StartTime, End: off.EndTime})
+	}
+
+	var appointments []models.Appointment
+	if err := database.DB.Where("doctor_id IN ? AND start_time < ? AND end_time > ? AND status IN ?", doctorIDs, day.End, day.Start,
+		models.BlockingStatuses).
+		Find(&appointments).Error; err != nil {
+		return nil, err
+	}
+	for _, appointment := range appointments {
+		busy[appointment.DoctorID] = append(busy[appointment.DoctorID], models.TimeRange{Start: appointment.StartTime, End: appointment.EndTime})
+	}
+
+	result := []DoctorAvailability{}
+	for _, branch := range branches {
+		location := clinicLocation
+		if branch.Timezone != "" {
+			location = models.LoadLocation(branch.Timezone)
+		}
+		branchDate := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, location)
+
+		open := []models.TimeRange{}
+		for _, period := range branchOperatingPeriods(branch, branchDate, exceptions[branch.ID]) {
+			start, err := models.ParseClock(period.Start)
+			if err != nil {
+				continue
+			}
+			end, err := models.ParseClock(period.End)
+			if err != nil {
+				continue
+			}
+			open = append(open, models.ClockRange(branchDate, start, end))
+		}
+		if len(open) == 0 {
+			continue
+		}
+
+		for _, doctor := range doctors {
+			working := open
+			if hasSchedule[doctor.ID] {
+				shifts := []models.TimeRange{}
+				for i := range schedules {
+					schedule := &schedules[i]
+					if schedule.DoctorID != doctor.ID || schedule.BranchID != branch.ID || !schedule.AppliesOn(branchDate) {
+						continue
+					}
+					if shift, ok := schedule.Range(branchDate); ok {
+						shifts = append(shifts, shift)
+					}
+				}
+				working = models.IntersectRanges(open, shifts)
+			}
+			if len(working) == 0 {
+				continue
+			}
+
+			result = append(result, DoctorAvailability{
+				DoctorID:   doctor.ID,
+				DoctorName: doctor.GetDisplayName(),
+				BranchID:   branch.ID,
+				BranchName: branch.Name,
+				Working:    models.NormalizeRanges(working),
+				Free:       models.SubtractRanges(working, busy[doctor.ID]),
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// slotsFromRanges lists the slot start times on the half hour where an appointment of the given
+// length fits entirely in free time and starts after now
+func slotsFromRanges(free []models.TimeRange, duration time.Duration, now time.Time) []AvailableSlot {
+	slots := []AvailableSlot{}
+	for _, r := range free {
+		midnight := time.Date(r.Start.Year(), r.Start.Month(), r.Start.Day(), 0, 0, 0, 0, r.Start.Location())
+		start := midnight.Add((r.Start.Sub(midnight) + slotInterval - 1) / slotInterval * slotInterval)
+		for ; !start.Add(duration).After(r.End); start = start.Add(slotInterval) {
+			if !start.After(now) {
+				continue
+			}
+			slots = append(slots, AvailableSlot{
+				StartTime: start,
+				Time:      start.Format("15:04"),
+				Datetime:  start.Format("2006-01-02 15:04"),
+				Display:   start.Format("3:04 PM"),
+			})
+		}
+	}
+	return slots
+}
+
+// poolSlots merges the doctors' slots into one list, recording which doctors can take each slot
+func poolSlots(availability []DoctorAvailability) []AvailableSlot {
+	byTime := map[string]*AvailableSlot{}
+	for _, doctor := range availability {
+		for _, slot := range doctor.Slots {
+			// Keyed with the offset so branches in different timezones don't share slots
+			key := slot.StartTime.Format(time.RFC3339)
+			pooled, ok := byTime[key]
+			if !ok {
+				pooled = &AvailableSlot{StartTime: slot.StartTime, Time: slot.Time, Datetime: slot.Datetime, Display: slot.Display}
+				byTime[key] = pooled
+			}
+			if !containsUint(pooled.DoctorIDs, doctor.DoctorID) {
+				pooled.DoctorIDs = append(pooled.DoctorIDs, doctor.DoctorID)
+			}
+		}
+	}
+
+	pooled := make([]AvailableSlot, 0, len(byTime))
+	for _, slot := range byTime {
+		pooled = append(pooled, *slot)
+	}
+	sort.Slice(pooled, func(i, j int) bool { return pooled[i].StartTime.Before(pooled[j].StartTime) })
+	return pooled
+}
+
+func containsUint(values []uint, value uint) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// doctorAvailableFor reports whether the doctor works and is free for the whole of the given time
+func doctorAvailableFor(availability []DoctorAvailability, doctorID, branchID uint, slot models.TimeRange) (working bool, free bool) {
+	for _, a := range availability {
+		if a.DoctorID != doctorID || (branchID != 0 && a.BranchID != branchID) {
+			continue
+		}
+		for _, r := range a.Working {
+			if r.Contains(slot) {
+				working = true
+			}
+		}
+		for _, r := range a.Free {
+			if r.Contains(slot) {
+				return true, true
+			}
+		}
+	}
+	return working, false
+}
+
+// GetDoctorAvailability returns each doctor's working and free time for a date, with bookable slots
+func GetDoctorAvailability(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	date, err := time.Parse("2006-01-02", c.Query("date"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Valid date is required (YYYY-MM-DD)"})
+	}
+
+	clinicID := user.ClinicID
+	if user.IsSuperAdmin() {
+		if id, err := strconv.ParseUint(c.Query("clinic_id"), 10, 32); err == nil {
+			clinicID = uint(id)
+		}
+	}
+
+	var branchID, doctorID *uint
+	if id, err := strconv.ParseUint(c.Query("branch_id"), 10, 32); err == nil {
+		branchID = &[]uint{uint(id)}[0]
+	}
+	if id, err := strconv.ParseUint(c.Query("doctor_id"), 10, 32); err == nil {
+		doctorID = &[]uint{uint(id)}[0]
+	}
+
+	duration := slotInterval
+	if minutes, err := strconv.Atoi(c.Query("duration")); err == nil && minutes > 0 && minutes <= 8*60 {
+		duration = time.Duration(minutes) * time.Minute
+	}
+
+	slots, doctors := generateAvailableTimeSlots(date, clinicID, branchID, doctorID, duration)
+
+	return c.JSON(fiber.Map{
+		"date":            date.Format("2006-01-02"),
+		"doctors":         doctors,
+		"available_slots": slots,
+	})
+}
@@ -0,0 +1,419 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type DoctorScheduleEntry struct {
+	BranchID       uint   `json:"branch_id"`
+	DayOfWeek      int    `json:"day_of_week"` // 0 = Sunday ... 6 = Saturday
+	StartTime      string `json:"start_time"`  // HH:MM
+	EndTime        string `json:"end_time"`    // HH:MM
+	EffectiveFrom  string `json:"effective_from,omitempty"`
+	EffectiveUntil string `json:"effective_until,omitempty"`
+}
+
+type CreateDoctorTimeOffRequest struct {
+	// Either whole days (inclusive) ...
+	StartDate string `json:"start_date"` // Format: "2006-01-02"
+	EndDate   string `json:"end_date"`   // Format: "2006-01-02"
+	// ... or exact times
+	StartTime *time.Time `json:"start_time"`
+	EndTime   *time.Time `json:"end_time"`
+
+	Type   models.DoctorTimeOffType `json:"type"`
+	Reason string                   `json:"reason"`
+}
+
+type CreateClinicHolidayRequest struct {
+	Date     string `json:"date"` // Format: "2006-01-02"
+	Name     string `json:"name"`
+	BranchID *uint  `json:"branch_id"`
+}
+
+// findClinicDoctor loads the doctor in the URL if the user may see their schedule.
+// Doctors may manage only their own schedule; admins and secretaries manage their clinic's doctors.
+func findClinicDoctor(c *fiber.Ctx, user models.User, manage bool) (*models.User, error) {
+	doctorID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Invalid doctor ID"})
+	}
+
+	var doctor models.User
+	if err := database.DB.First(&doctor, doctorID).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "Doctor not found"})
+	}
+	if !user.CanAccessClinic(doctor.ClinicID) {
+		return nil, c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+	if manage && !user.HasRole(models.SuperAdmin, models.Admin, models.Secretary) && user.ID != doctor.ID {
+		return nil, c.Status(403).JSON(fiber.Map{"error": "You can only manage your own schedule"})
+	}
+	return &doctor, nil
+}
+
+func parseOptionalDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	// Calendar dates are stored as DATE columns, so they carry no timezone
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	return &date, nil
+}
+
+// GetDoctorSchedule returns a doctor's weekly working hours by branch
+func GetDoctorSchedule(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	doctor, err := findClinicDoctor(c, user, false)
+	if doctor == nil {
+		return err
+	}
+
+	var schedules []models.DoctorSchedule
+	if err := database.DB.Preload("Branch").Where("doctor_id = ?", doctor.ID).
+		Order("day_of_week, start_time").Find(&schedules).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch schedule"})
+	}
+
+	return c.JSON(fiber.Map{
+		"doctor_id": doctor.ID,
+		"schedules": schedules,
+	})
+}
+
+// UpdateDoctorSchedule replaces a doctor's weekly working hours. An empty list means the doctor
+// works whenever their branches are open.
+func UpdateDoctorSchedule(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	doctor, err := findClinicDoctor(c, user, true)
+	if doctor == nil {
+		return err
+	}
+
+	var req struct {
+		Schedules []DoctorScheduleEntry `json:"schedules"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	var branches []models.Branch
+	database.DB.Where("clinic_id = ?", doctor.ClinicID).Find(&branches)
+	clinicBranches := map[uint]bool{}
+	for _, branch := range branches {
+		clinicBranches[branch.ID] = true
+	}
+
+	schedules := make([]models.DoctorSchedule, 0, len(req.Schedules))
+	for i, entry := range req.Schedules {
+		if !clinicBranches[entry.BranchID] {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid branch for this clinic", "index": i})
+		}
+		from, err := parseOptionalDate(entry.EffectiveFrom)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid effective_from date", "index": i})
+		}
+		until, err := parseOptionalDate(entry.EffectiveUntil)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid effective_until date", "index": i})
+		}
+
+		schedule := models.DoctorSchedule{
+			DoctorID:       doctor.ID,
+			BranchID:       entry.BranchID,
+			DayOfWeek:      entry.DayOfWeek,
+			StartTime:      entry.StartTime,
+			EndTime:        entry.EndTime,
+			EffectiveFrom:  from,
+			EffectiveUntil: until,
+			ClinicID:       doctor.ClinicID,
+		}
+		if err := schedule.Validate(); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error(), "index": i})
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	// A doctor cannot be in two places at once
+	for i := range schedules {
+		for j := i + 1; j < len(schedules); j++ {
+			a, b := &schedules[i], &schedules[j]
+			if a.DayOfWeek != b.DayOfWeek || !schedulesShareDates(a, b) {
+				continue
+			}
+			if a.StartTime < b.EndTime && b.StartTime < a.EndTime {
+				return c.Status(400).JSON(fiber.Map{"error": "Schedule entries overlap", "index": j})
+			}
+		}
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Where("doctor_id = ?", doctor.ID).Delete(&models.DoctorSchedule{}).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update schedule"})
+	}
+	if len(schedules) > 0 {
+		if err := tx.Create(&schedules).Error; err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update schedule"})
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update schedule"})
+	}
+
+	database.DB.Preload("Branch").Where("doctor_id = ?", doctor.ID).Order("day_of_week, start_time").Find(&schedules)
+	return c.JSON(fiber.Map{
+		"doctor_id": doctor.ID,
+		"schedules": schedules,
+	})
+}
+
+// schedulesShareDates reports whether two rows' validity windows overlap
+func schedulesShareDates(a, b *models.DoctorSchedule) bool {
+	if a.EffectiveUntil != nil && b.EffectiveFrom != nil && a.EffectiveUntil.Before(*b.EffectiveFrom) {
+		return false
+	}
+	if b.EffectiveUntil != nil && a.EffectiveFrom != nil && b.EffectiveUntil.Before(*a.EffectiveFrom) {
+		return false
+	}
+	return true
+}
+
+// GetDoctorTimeOff lists a doctor's leave, optionally between from and to dates
+func GetDoctorTimeOff(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	doctor, err := findClinicDoctor(c, user, false)
+	if doctor == nil {
+		return err
+	}
+
+	query := database.DB.Where("doctor_id = ?", doctor.ID)
+	if from, err := parseOptionalDate(c.Query("from")); err == nil && from != nil {
+		query = query.Where("end_time > ?", *from)
+	} else if c.Query("from") == "" {
+		// Default to current and upcoming time off
+		query = query.Where("end_time > ?", time.Now())
+	}
+	if to, err := parseOptionalDate(c.Query("to")); err == nil && to != nil {
+		query = query.Where("start_time < ?", to.AddDate(0, 0, 1))
+	}
+
+	var timeOff []models.DoctorTimeOff
+	if err := query.Preload("CreatedBy").Order("start_time ASC").Find(&timeOff).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch time off"})
+	}
+
+	return c.JSON(timeOff)
+}
+
+// CreateDoctorTimeOff records leave for a doctor and reports appointments that fall inside it
+func CreateDoctorTimeOff(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	doctor, err := findClinicDoctor(c, user, true)
+	if doctor == nil {
+		return err
+	}
+
+	var req CreateDoctorTimeOffRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	var start, end time.Time
+	switch {
+	case req.StartTime != nil && req.EndTime != nil:
+		start, end = *req.StartTime, *req.EndTime
+	case req.StartDate != "":
+		// Whole days in the clinic's local time
+		location := models.ClinicLocation(database.DB, doctor.ClinicID)
+		startDate, err := time.ParseInLocation("2006-01-02", req.StartDate, location)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid start_date"})
+		}
+		endDate := startDate
+		if req.EndDate != "" {
+			if endDate, err = time.ParseInLocation("2006-01-02", req.EndDate, location); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid end_date"})
+			}
+		}
+		start, end = startDate, endDate.AddDate(0, 0, 1)
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "start_date or start_time and end_time are required"})
+	}
+	if !end.After(start) {
+		return c.Status(400).JSON(fiber.Map{"error": "Time off must end after it starts"})
+	}
+
+	switch req.Type {
+	case "":
+		req.Type = models.TimeOffLeave
+	case models.TimeOffLeave, models.TimeOffSick, models.TimeOffConference, models.TimeOffPersonal, models.TimeOffOther:
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid time off type"})
+	}
+
+	timeOff := models.DoctorTimeOff{
+		DoctorID:    doctor.ID,
+		StartTime:   start,
+		EndTime:     end,
+		Type:        req.Type,
+		Reason:      strings.TrimSpace(req.Reason),
+		CreatedByID: user.ID,
+		ClinicID:    doctor.ClinicID,
+	}
+	if err := database.DB.Create(&timeOff).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create time off"})
+	}
+
+	// Appointments that now need rebooking
+	var affected []models.Appointment
+	database.DB.Preload("Patient").
+		Where("doctor_id = ? AND start_time < ? AND end_time > ? AND status IN ?", doctor.ID, end, start,
+			[]models.AppointmentStatus{models.StatusScheduled, models.StatusConfirmed}).
+		Order("start_time ASC").Find(&affected)
+
+	response := fiber.Map{"time_off": timeOff}
+	if len(affected) > 0 {
+		response["affected_appointments"] = affected
+		response["warning"] = strconv.Itoa(len(affected)) + " appointment(s) fall within this time off"
+	}
+	return c.Status(201).JSON(response)
+}
+
+// DeleteDoctorTimeOff removes a time off entry
+func DeleteDoctorTimeOff(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var timeOff models.DoctorTimeOff
+	if err := database.DB.First(&timeOff, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Time off not found"})
+	}
+	if !user.CanAccessClinic(timeOff.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+	if !user.HasRole(models.SuperAdmin, models.Admin, models.Secretary) && user.ID != timeOff.DoctorID {
+		return c.Status(403).JSON(fiber.Map{"error": "You can only manage your own schedule"})
+	}
+
+	if err := database.DB.Delete(&timeOff).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete time off"})
+	}
+	return c.JSON(fiber.Map{"message": "Time off deleted"})
+}
+
+// GetClinicHolidays lists the clinic's holidays, optionally for one year
+func GetClinicHolidays(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	query := database.DB.Preload("Branch")
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	} else if clinicID := c.Query("clinic_id"); clinicID != "" {
+		query = query.Where("clinic_id = ?", clinicID)
+	}
+	if year, err := strconv.Atoi(c.Query("year")); err == nil {
+		query = query.Where("date >= ? AND date < ?",
+			time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(year+1, 1, 1, 0, 0, 0, 0, time.UTC))
+	}
+
+	var holidays []models.ClinicHoliday
+	if err := query.Order("date ASC").Find(&holidays).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch holidays"})
+	}
+	return c.JSON(holidays)
+}
+
+// CreateClinicHoliday closes the clinic, or one branch, for a day
+func CreateClinicHoliday(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var req CreateClinicHolidayRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	date, err := time.Parse("2006-01-02", req.Date)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Valid date is required (YYYY-MM-DD)"})
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Name is required"})
+	}
+
+	clinicID := user.ClinicID
+	if user.IsSuperAdmin() {
+		if id, err := strconv.ParseUint(c.Query("clinic_id"), 10, 32); err == nil {
+			clinicID = uint(id)
+		}
+	}
+	if req.BranchID != nil {
+		var branch models.Branch
+		if err := database.DB.Where("id = ? AND clinic_id = ?", *req.BranchID, clinicID).First(&branch).Error; err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid branch for this clinic"})
+		}
+	}
+
+	holiday := models.ClinicHoliday{
+		Date:     date,
+		Name:     strings.TrimSpace(req.Name),
+		BranchID: req.BranchID,
+		ClinicID: clinicID,
+	}
+	if err := database.DB.Create(&holiday).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create holiday"})
+	}
+
+	// Appointments booked on the closed day, in the branch's or clinic's local time
+	location := models.ClinicLocation(database.DB, clinicID)
+	if req.BranchID != nil {
+		location = models.BranchLocation(database.DB, *req.BranchID)
+	}
+	dayStart := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, location)
+	var affected []models.Appointment
+	query := database.DB.Preload("Patient").
+		Where("clinic_id = ? AND start_time >= ? AND start_time < ? AND status IN ?", clinicID, dayStart, dayStart.AddDate(0, 0, 1),
+			[]models.AppointmentStatus{models.StatusScheduled, models.StatusConfirmed})
+	if req.BranchID != nil {
+		query = query.Where("branch_id = ?", *req.BranchID)
+	}
+	query.Order("start_time ASC").Find(&affected)
+
+	response := fiber.Map{"holiday": holiday}
+	if len(affected) > 0 {
+		response["affected_appointments"] = affected
+		response["warning"] = strconv.Itoa(len(affected)) + " appointment(s) are booked on this holiday"
+	}
+	return c.Status(201).JSON(response)
+}
+
+// DeleteClinicHoliday removes a holiday
+func DeleteClinicHoliday(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var holiday models.ClinicHoliday
+	if err := database.DB.First(&holiday, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Holiday not found"})
+	}
+	if !user.CanAccessClinic(holiday.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	if err := database.DB.Delete(&holiday).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete holiday"})
+	}
+	return c.JSON(fiber.Map{"message": "Holiday deleted"})
+}
@@ -0,0 +1,445 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type FeeScheduleRequest struct {
+	Name     string                   `json:"name"`
+	BranchID *uint                    `json:"branch_id"`
+	PayerID  *uint                    `json:"payer_id"`
+	Notes    string                   `json:"notes"`
+	IsActive *bool                    `json:"is_active"`
+	Items    []FeeScheduleItemRequest `json:"items"`
+}
+
+type FeeScheduleItemRequest struct {
+	ProcedureTemplateID uint    `json:"procedure_template_id"`
+	Price               float64 `json:"price"`
+	EffectiveFrom       string  `json:"effective_from"` // Format: "2006-01-02"; today when empty
+}
+
+type FeeSchedulePricesRequest struct {
+	Items []FeeScheduleItemRequest `json:"items"`
+}
+
+// findAccessibleFeeSchedule loads a fee schedule the user is allowed to see
+func findAccessibleFeeSchedule(c *fiber.Ctx, user models.User) (*models.FeeSchedule, error) {
+	var schedule models.FeeSchedule
+	if err := database.DB.First(&schedule, c.Params("id")).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "Fee schedule not found"})
+	}
+	if !user.CanAccessClinic(schedule.ClinicID) {
+		return nil, c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+	return &schedule, nil
+}
+
+// procedureFeeScope is the pricing scope for treating a patient at a branch: the payer is the one
+// on the patient's primary policy on that date, if any
+func procedureFeeScope(clinicID uint, branchID *uint, patientID uint, date time.Time) models.FeeScope {
+	scope := models.FeeScope{ClinicID: clinicID, BranchID: branchID}
+	if policy, err := findPatientInsurancePolicy(patientID, clinicID, nil, date); err == nil && policy != nil {
+		scope.PayerID = &policy.PayerID
+	}
+	return scope
+}
+
+// validateFeeScheduleScope checks the branch and payer belong to the clinic and that no other
+// active schedule already covers the same scope. Run it in the transaction that saves the schedule,
+// after lockClinicFeeSchedules. Returns the status and error to respond with.
+func validateFeeScheduleScope(db *gorm.DB, schedule *models.FeeSchedule) (int, string) {
+	if schedule.BranchID != nil {
+		var branch models.Branch
+		if err := db.Select("id", "clinic_id").First(&branch, *schedule.BranchID).Error; err != nil || branch.ClinicID != schedule.ClinicID {
+			return 400, "Branch not found"
+		}
+	}
+	if schedule.PayerID != nil {
+		var payer models.InsurancePayer
+		if err := db.Select("id", "clinic_id").First(&payer, *schedule.PayerID).Error; err != nil || payer.ClinicID != schedule.ClinicID {
+			return 400, "Insurance payer not found"
+		}
+	}
+	if !schedule.IsActive {
+		return 0, ""
+	}
+
+	query := db.Model(&models.FeeSchedule{}).Where("clinic_id = ? AND is_active = ? AND id <> ?", schedule.ClinicID, true, schedule.ID)
+	if schedule.BranchID != nil {
+		query = query.Where("branch_id = ?", *schedule.BranchID)
+	} else {
+		query = query.Where("branch_id IS NULL")
+	}
+	if schedule.PayerID != nil {
+		query = query.Where("payer_id = ?", *schedule.PayerID)
+	} else {
+		query = query.Where("payer_id IS NULL")
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return 500, "Failed to check fee schedules"
+	}
+	if count > 0 {
+		return 409, "Another active fee schedule already covers this branch and payer"
+	}
+	return 0, ""
+}
+
+// lockClinicFeeSchedules locks the clinic row until tx ends, so two requests cannot both find a
+// scope free and each activate a schedule for it
+func lockClinicFeeSchedules(tx *gorm.DB, clinicID uint) error {
+	var clinic models.Clinic
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&clinic, clinicID).Error
+}
+
+// buildFeeScheduleItems validates requested prices for procedures in the clinic's catalog and turns
+// them into items for a schedule. Prices without a date start today where the schedule applies.
+func buildFeeScheduleItems(schedule *models.FeeSchedule, reqs []FeeScheduleItemRequest) ([]models.FeeScheduleItem, string) {
+	clinicID := schedule.ClinicID
+	now := models.FeeScope{ClinicID: clinicID, BranchID: schedule.BranchID}.Today(database.DB)
+	today, _ := time.Parse("2006-01-02", now.Format("2006-01-02"))
+
+	templateIDs := make([]uint, 0, len(reqs))
+	for _, req := range reqs {
+		templateIDs = append(templateIDs, req.ProcedureTemplateID)
+	}
+	var known int64
+	models.ProcedureCatalog(database.DB, clinicID).Where("procedure_templates.id IN ?", templateIDs).Count(&known)
+
+	items := make([]models.FeeScheduleItem, 0, len(reqs))
+	seen := map[string]bool{}
+	distinct := map[uint]bool{}
+	for _, req := range reqs {
+		if req.ProcedureTemplateID == 0 {
+			return nil, "Procedure template is required for every price"
+		}
+		if req.Price < 0 {
+			return nil, "Prices cannot be negative"
+		}
+		effectiveFrom := today
+		if req.EffectiveFrom != "" {
+			date, err := time.Parse("2006-01-02", req.EffectiveFrom)
+			if err != nil {
+				return nil, "Invalid effective_from date, use YYYY-MM-DD"
+			}
+			effectiveFrom = date
+		}
+		key := strconv.FormatUint(uint64(req.ProcedureTemplateID), 10) + "@" + effectiveFrom.Format("2006-01-02")
+		if seen[key] {
+			return nil, "A procedure has two prices for the same date"
+		}
+		seen[key] = true
+		distinct[req.ProcedureTemplateID] = true
+
+		items = append(items, models.FeeScheduleItem{
+			FeeScheduleID:       schedule.ID,
+			ProcedureTemplateID: req.ProcedureTemplateID,
+			Price:               req.Price,
+			EffectiveFrom:       effectiveFrom,
+		})
+	}
+	if int(known) != len(distinct) {
+		return nil, "Procedure template not found"
+	}
+	return items, ""
+}
+
+// saveFeeScheduleItems stores prices, replacing any price the schedule already has for the same
+// procedure and date
+func saveFeeScheduleItems(tx *gorm.DB, items []models.FeeScheduleItem) error {
+	for i := range items {
+		item := &items[i]
+		var existing models.FeeScheduleItem
+		err := tx.Where("fee_schedule_id = ? AND procedure_template_id = ? AND effective_from = ?",
+			item.FeeScheduleID, item.ProcedureTemplateID, item.EffectiveFrom.Format("2006-01-02")).First(&existing).Error
+		if err == nil {
+			existing.Price = item.Price
+			if err := tx.Save(&existing).Error; err != nil {
+				return err
+			}
+			*item = existing
+			continue
+		}
+		if err := tx.Create(item).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadFeeScheduleDetails(schedule *models.FeeSchedule) {
+	database.DB.Preload("Branch").Preload("Payer").
+		Preload("Items", func(db *gorm.DB) *gorm.DB {
+			return db.Order("procedure_template_id ASC, effective_from DESC")
+		}).
+		Preload("Items.ProcedureTemplate").First(schedule, schedule.ID)
+}
+
+// GetFeeSchedules lists the clinic's fee schedules, optionally for one branch or payer
+func GetFeeSchedules(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	query := database.DB.Where("clinic_id = ?", user.ClinicID)
+	if user.IsSuperAdmin() && c.Query("clinic_id") != "" {
+		query = database.DB.Where("clinic_id = ?", c.Query("clinic_id"))
+	}
+	if branchID := c.Query("branch_id"); branchID != "" {
+		query = query.Where("branch_id = ?", branchID)
+	}
+	if payerID := c.Query("payer_id"); payerID != "" {
+		query = query.Where("payer_id = ?", payerID)
+	}
+	if c.Query("active") == "true" {
+		query = query.Where("is_active = ?", true)
+	}
+
+	var schedules []models.FeeSchedule
+	if err := query.Preload("Branch").Preload("Payer").Order("name ASC").Find(&schedules).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch fee schedules"})
+	}
+	return c.JSON(schedules)
+}
+
+// GetFeeSchedule returns a fee schedule with its full price history
+func GetFeeSchedule(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	schedule, err := findAccessibleFeeSchedule(c, user)
+	if schedule == nil {
+		return err
+	}
+
+	loadFeeScheduleDetails(schedule)
+	return c.JSON(schedule)
+}
+
+// CreateFeeSchedule adds a price list for the clinic, a branch or a payer
+func CreateFeeSchedule(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var req FeeScheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Fee schedule name is required"})
+	}
+
+	schedule := models.FeeSchedule{
+		Name:        strings.TrimSpace(req.Name),
+		BranchID:    req.BranchID,
+		PayerID:     req.PayerID,
+		Notes:       req.Notes,
+		IsActive:    req.IsActive == nil || *req.IsActive,
+		CreatedByID: user.ID,
+		ClinicID:    user.ClinicID,
+	}
+	items, msg := buildFeeScheduleItems(&schedule, req.Items)
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	tx := database.DB.Begin()
+	if err := lockClinicFeeSchedules(tx, schedule.ClinicID); err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create fee schedule"})
+	}
+	if status, msg := validateFeeScheduleScope(tx, &schedule); msg != "" {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	if err := tx.Create(&schedule).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create fee schedule"})
+	}
+	for i := range items {
+		items[i].FeeScheduleID = schedule.ID
+	}
+	if err := saveFeeScheduleItems(tx, items); err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save fee schedule prices"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create fee schedule"})
+	}
+
+	loadFeeScheduleDetails(&schedule)
+	return c.Status(201).JSON(schedule)
+}
+
+// UpdateFeeSchedule changes a schedule's name, scope or active flag. Prices are changed with
+// SetFeeSchedulePrices so their history is kept.
+func UpdateFeeSchedule(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	schedule, errResp := findAccessibleFeeSchedule(c, user)
+	if schedule == nil {
+		return errResp
+	}
+
+	var req FeeScheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if strings.TrimSpace(req.Name) != "" {
+		schedule.Name = strings.TrimSpace(req.Name)
+	}
+	schedule.BranchID = req.BranchID
+	schedule.PayerID = req.PayerID
+	schedule.Notes = req.Notes
+	if req.IsActive != nil {
+		schedule.IsActive = *req.IsActive
+	}
+
+	tx := database.DB.Begin()
+	if err := lockClinicFeeSchedules(tx, schedule.ClinicID); err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update fee schedule"})
+	}
+	if status, msg := validateFeeScheduleScope(tx, schedule); msg != "" {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	if err := tx.Omit("Items", "Branch", "Payer").Save(schedule).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update fee schedule"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update fee schedule"})
+	}
+
+	loadFeeScheduleDetails(schedule)
+	return c.JSON(schedule)
+}
+
+// DeleteFeeSchedule removes a fee schedule; prices already on appointments and invoices stay
+func DeleteFeeSchedule(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	schedule, errResp := findAccessibleFeeSchedule(c, user)
+	if schedule == nil {
+		return errResp
+	}
+
+	if err := database.DB.Delete(schedule).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete fee schedule"})
+	}
+	return c.JSON(fiber.Map{"message": "Fee schedule deleted"})
+}
+
+// SetFeeSchedulePrices adds or changes prices in a schedule. A price with a future effective_from
+// is a scheduled price change; the current price applies until then.
+func SetFeeSchedulePrices(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	schedule, errResp := findAccessibleFeeSchedule(c, user)
+	if schedule == nil {
+		return errResp
+	}
+
+	var req FeeSchedulePricesRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if len(req.Items) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "At least one price is required"})
+	}
+
+	items, msg := buildFeeScheduleItems(schedule, req.Items)
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	tx := database.DB.Begin()
+	if err := saveFeeScheduleItems(tx, items); err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save fee schedule prices"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save fee schedule prices"})
+	}
+
+	loadFeeScheduleDetails(schedule)
+	return c.JSON(schedule)
+}
+
+// DeleteFeeSchedulePrice removes one price from a schedule, e.g. a price change entered by mistake
+func DeleteFeeSchedulePrice(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	schedule, errResp := findAccessibleFeeSchedule(c, user)
+	if schedule == nil {
+		return errResp
+	}
+
+	result := database.DB.Where("fee_schedule_id = ?", schedule.ID).Delete(&models.FeeScheduleItem{}, c.Params("itemId"))
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete price"})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Price not found"})
+	}
+	return c.JSON(fiber.Map{"message": "Price deleted"})
+}
+
+// GetProcedureFees quotes the prices that apply to procedures. Pass procedure_template_id (comma
+// separated for several) and optionally branch_id, payer_id or patient_id (to use the patient's
+// primary policy) and date (YYYY-MM-DD, default today).
+func GetProcedureFees(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var ids []uint
+	for _, part := range strings.Split(c.Query("procedure_template_id"), ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 32)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "procedure_template_id is required"})
+		}
+		ids = append(ids, uint(id))
+	}
+
+	scope := models.FeeScope{ClinicID: user.ClinicID}
+	if branchID, err := strconv.ParseUint(c.Query("branch_id"), 10, 32); err == nil {
+		id := uint(branchID)
+		scope.BranchID = &id
+	}
+
+	date := scope.Today(database.DB)
+	if c.Query("date") != "" {
+		parsed, err := time.Parse("2006-01-02", c.Query("date"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid date, use YYYY-MM-DD"})
+		}
+		date = parsed
+	}
+
+	if payerID, err := strconv.ParseUint(c.Query("payer_id"), 10, 32); err == nil {
+		id := uint(payerID)
+		scope.PayerID = &id
+	} else if patientID, err := strconv.ParseUint(c.Query("patient_id"), 10, 32); err == nil {
+		scope = procedureFeeScope(user.ClinicID, scope.BranchID, uint(patientID), date)
+	}
+
+	var templates []models.ProcedureTemplate
+	if err := models.ProcedureCatalog(database.DB, user.ClinicID).Where("procedure_templates.id IN ?", ids).Find(&templates).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch procedure templates"})
+	}
+	if len(templates) == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Procedure template not found"})
+	}
+
+	fees := make([]models.ProcedureFee, 0, len(templates))
+	for _, template := range templates {
+		fees = append(fees, models.ResolveProcedureFee(database.DB, scope, template, date))
+	}
+	return c.JSON(fees)
+}
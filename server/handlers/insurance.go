@@ -0,0 +1,505 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type InsurancePayerRequest struct {
+	Name      string `json:"name"`
+	PayerCode string `json:"payer_code"`
+	Phone     string `json:"phone"`
+	Email     string `json:"email"`
+	Address   string `json:"address"`
+	Notes     string `json:"notes"`
+	IsActive  *bool  `json:"is_active"`
+}
+
+type InsuranceCoverageRequest struct {
+	Category   string  `json:"category"` // empty for everything not listed
+	Percentage float64 `json:"percentage"`
+}
+
+type InsurancePolicyRequest struct {
+	PayerID               uint                       `json:"payer_id"`
+	PolicyNumber          string                     `json:"policy_number"`
+	GroupNumber           string                     `json:"group_number"`
+	IsPrimary             *bool                      `json:"is_primary"`
+	SubscriberName        string                     `json:"subscriber_name"`
+	SubscriberDateOfBirth *time.Time                 `json:"subscriber_date_of_birth"`
+	Relationship          models.PolicyRelationship  `json:"relationship"`
+	EffectiveDate         *time.Time                 `json:"effective_date"`
+	ExpiryDate            *time.Time                 `json:"expiry_date"`
+	AnnualMaximum         float64                    `json:"annual_maximum"`
+	IsActive              *bool                      `json:"is_active"`
+	Coverages             []InsuranceCoverageRequest `json:"coverages"`
+}
+
+// findPatientInsurancePolicy picks the policy to bill for a patient's treatment on a date: the one
+// asked for, or else the patient's active primary policy. Returns nil when the patient has none.
+func findPatientInsurancePolicy(patientID, clinicID uint, policyID *uint, date time.Time) (*models.InsurancePolicy, error) {
+	var policy models.InsurancePolicy
+	query := database.DB.Preload("Payer").Preload("Coverages").Where("patient_id = ? AND clinic_id = ?", patientID, clinicID)
+	if policyID != nil {
+		if err := query.First(&policy, *policyID).Error; err != nil {
+			return nil, err
+		}
+		return &policy, nil
+	}
+
+	var policies []models.InsurancePolicy
+	if err := query.Where("is_active = ?", true).Order("is_primary DESC, id ASC").Find(&policies).Error; err != nil {
+		return nil, err
+	}
+	for i := range policies {
+		if policies[i].IsActiveOn(date) {
+			return &policies[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// procedureCategories maps procedure codes to their categories in the clinic's catalog, for lines
+// that only carry a code
+func procedureCategories(clinicID uint, codes []string) map[string]string {
+	categories := map[string]string{}
+	if len(codes) == 0 {
+		return categories
+	}
+	var templates []models.ProcedureTemplate
+	models.ProcedureCatalog(database.DB, clinicID).Select("code", "category").Where("code IN ?", codes).Find(&templates)
+	for _, template := range templates {
+		categories[template.Code] = template.Category
+	}
+	return categories
+}
+
+// GetInsurancePayers lists the clinic's insurance payers
+func GetInsurancePayers(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	query := database.DB.Where("clinic_id = ?", user.ClinicID)
+	if user.IsSuperAdmin() && c.Query("clinic_id") != "" {
+		query = database.DB.Where("clinic_id = ?", c.Query("clinic_id"))
+	}
+	if c.Query("active") == "true" {
+		query = query.Where("is_active = ?", true)
+	}
+
+	var payers []models.InsurancePayer
+	if err := query.Order("name ASC").Find(&payers).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch insurance payers"})
+	}
+	return c.JSON(payers)
+}
+
+// CreateInsurancePayer adds an insurance company the clinic bills
+func CreateInsurancePayer(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var req InsurancePayerRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Payer name is required"})
+	}
+
+	payer := models.InsurancePayer{
+		Name:      strings.TrimSpace(req.Name),
+		PayerCode: strings.TrimSpace(req.PayerCode),
+		Phone:     req.Phone,
+		Email:     req.Email,
+		Address:   req.Address,
+		Notes:     req.Notes,
+		IsActive:  req.IsActive == nil || *req.IsActive,
+		ClinicID:  user.ClinicID,
+	}
+	if err := database.DB.Create(&payer).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create insurance payer"})
+	}
+	return c.Status(201).JSON(payer)
+}
+
+// UpdateInsurancePayer changes a payer's details
+func UpdateInsurancePayer(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var payer models.InsurancePayer
+	if err := database.DB.First(&payer, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Insurance payer not found"})
+	}
+	if !user.CanAccessClinic(payer.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	var req InsurancePayerRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if strings.TrimSpace(req.Name) != "" {
+		payer.Name = strings.TrimSpace(req.Name)
+	}
+	payer.PayerCode = strings.TrimSpace(req.PayerCode)
+	payer.Phone = req.Phone
+	payer.Email = req.Email
+	payer.Address = req.Address
+	payer.Notes = req.Notes
+	if req.IsActive != nil {
+		payer.IsActive = *req.IsActive
+	}
+
+	if err := database.DB.Save(&payer).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update insurance payer"})
+	}
+	return c.JSON(payer)
+}
+
+// DeleteInsurancePayer removes a payer no policy uses; payers with policies are deactivated instead
+func DeleteInsurancePayer(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var payer models.InsurancePayer
+	if err := database.DB.First(&payer, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Insurance payer not found"})
+	}
+	if !user.CanAccessClinic(payer.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	var policies int64
+	database.DB.Model(&models.InsurancePolicy{}).Where("payer_id = ?", payer.ID).Count(&policies)
+	if policies > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "Patients have policies with this payer; deactivate it instead", "policies": policies})
+	}
+
+	if err := database.DB.Delete(&payer).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete insurance payer"})
+	}
+	return c.JSON(fiber.Map{"message": "Insurance payer deleted"})
+}
+
+// GetPatientInsurancePolicies lists a patient's insurance policies, primary first
+func GetPatientInsurancePolicies(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var patient models.Patient
+	if err := database.DB.First(&patient, c.Params("patientId")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Patient not found"})
+	}
+	if !user.CanAccessClinic(patient.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	var policies []models.InsurancePolicy
+	if err := database.DB.Preload("Payer").Preload("Coverages").Where("patient_id = ?", patient.ID).
+		Order("is_active DESC, is_primary DESC, id ASC").Find(&policies).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch insurance policies"})
+	}
+	return c.JSON(policies)
+}
+
+// applyPolicyRequest validates the request and copies it onto the policy, replacing its coverages
+func applyPolicyRequest(policy *models.InsurancePolicy, req *InsurancePolicyRequest) string {
+	if req.PayerID != 0 {
+		var payer models.InsurancePayer
+		if err := database.DB.Where("id = ? AND clinic_id = ?", req.PayerID, policy.ClinicID).First(&payer).Error; err != nil {
+			return "Invalid insurance payer for this clinic"
+		}
+		policy.PayerID = payer.ID
+	}
+	if policy.PayerID == 0 {
+		return "Insurance payer is required"
+	}
+	if strings.TrimSpace(req.PolicyNumber) != "" {
+		policy.PolicyNumber = strings.TrimSpace(req.PolicyNumber)
+	}
+	if policy.PolicyNumber == "" {
+		return "Policy number is required"
+	}
+	if req.Relationship != "" {
+		if !models.IsValidPolicyRelationship(req.Relationship) {
+			return "Relationship must be self, spouse, child or other"
+		}
+		policy.Relationship = req.Relationship
+	}
+	if policy.Relationship == "" {
+		policy.Relationship = models.RelationshipSelf
+	}
+	if req.EffectiveDate != nil && req.ExpiryDate != nil && req.ExpiryDate.Before(*req.EffectiveDate) {
+		return "Expiry date must be after the effective date"
+	}
+	if req.AnnualMaximum < 0 {
+		return "Annual maximum cannot be negative"
+	}
+
+	policy.GroupNumber = strings.TrimSpace(req.GroupNumber)
+	policy.SubscriberName = strings.TrimSpace(req.SubscriberName)
+	policy.SubscriberDateOfBirth = req.SubscriberDateOfBirth
+	policy.EffectiveDate = req.EffectiveDate
+	policy.ExpiryDate = req.ExpiryDate
+	policy.AnnualMaximum = req.AnnualMaximum
+	if req.IsPrimary != nil {
+		policy.IsPrimary = *req.IsPrimary
+	}
+	if req.IsActive != nil {
+		policy.IsActive = *req.IsActive
+	}
+
+	if req.Coverages != nil {
+		seen := map[string]bool{}
+		policy.Coverages = nil
+		for _, coverage := range req.Coverages {
+			category := strings.TrimSpace(coverage.Category)
+			if coverage.Percentage < 0 || coverage.Percentage > 100 {
+				return "Coverage percentages must be between 0 and 100"
+			}
+			if seen[strings.ToLower(category)] {
+				return "Each procedure category can only be listed once"
+			}
+			seen[strings.ToLower(category)] = true
+			policy.Coverages = append(policy.Coverages, models.InsuranceCoverage{Category: category, Percentage: coverage.Percentage})
+		}
+	}
+	return ""
+}
+
+// savePolicy stores the policy with its coverages. A primary policy demotes the patient's other
+// policies and is copied to the patient's insurance fields.
+func savePolicy(tx *gorm.DB, policy *models.InsurancePolicy, replaceCoverages bool) error {
+	if err := tx.Omit("Coverages", "Payer", "Patient").Save(policy).Error; err != nil {
+		return err
+	}
+	if replaceCoverages {
+		if err := tx.Where("policy_id = ?", policy.ID).Delete(&models.InsuranceCoverage{}).Error; err != nil {
+			return err
+		}
+		for i := range policy.Coverages {
+			policy.Coverages[i].ID = 0
+			policy.Coverages[i].PolicyID = policy.ID
+		}
+		if len(policy.Coverages) > 0 {
+			if err := tx.Create(&policy.Coverages).Error; err != nil {
+				return err
+			}
+		}
+	}
+	if !policy.IsPrimary || !policy.IsActive {
+		return nil
+	}
+
+	if err := tx.Model(&models.InsurancePolicy{}).Where("patient_id = ? AND id <> ?", policy.PatientID, policy.ID).
+		Update("is_primary", false).Error; err != nil {
+		return err
+	}
+	var payer models.InsurancePayer
+	tx.Select("name").First(&payer, policy.PayerID)
+	return tx.Model(&models.Patient{}).Where("id = ?", policy.PatientID).Updates(map[string]interface{}{
+		"insurance_provider": payer.Name,
+		"insurance_number":   policy.PolicyNumber,
+	}).Error
+}
+
+// CreatePatientInsurancePolicy adds an insurance policy to a patient
+func CreatePatientInsurancePolicy(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var patient models.Patient
+	if err := database.DB.First(&patient, c.Params("patientId")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Patient not found"})
+	}
+	if !user.CanAccessClinic(patient.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	var req InsurancePolicyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	policy := models.InsurancePolicy{
+		PatientID:   patient.ID,
+		IsPrimary:   true,
+		IsActive:    true,
+		ClinicID:    patient.ClinicID,
+		CreatedByID: user.ID,
+	}
+	if message := applyPolicyRequest(&policy, &req); message != "" {
+		return c.Status(400).JSON(fiber.Map{"error": message})
+	}
+	if policy.Relationship == models.RelationshipSelf && policy.SubscriberName == "" {
+		policy.SubscriberName = patient.GetFullName()
+		policy.SubscriberDateOfBirth = patient.DateOfBirth
+	}
+
+	tx := database.DB.Begin()
+	if err := savePolicy(tx, &policy, true); err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create insurance policy"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create insurance policy"})
+	}
+
+	database.DB.Preload("Payer").Preload("Coverages").First(&policy, policy.ID)
+	return c.Status(201).JSON(policy)
+}
+
+// UpdateInsurancePolicy changes a policy. Coverages are replaced when given.
+func UpdateInsurancePolicy(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var policy models.InsurancePolicy
+	if err := database.DB.First(&policy, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Insurance policy not found"})
+	}
+	if !user.CanAccessClinic(policy.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	var req InsurancePolicyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if message := applyPolicyRequest(&policy, &req); message != "" {
+		return c.Status(400).JSON(fiber.Map{"error": message})
+	}
+
+	tx := database.DB.Begin()
+	if err := savePolicy(tx, &policy, req.Coverages != nil); err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update insurance policy"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update insurance policy"})
+	}
+
+	database.DB.Preload("Payer").Preload("Coverages").First(&policy, policy.ID)
+	return c.JSON(policy)
+}
+
+// DeleteInsurancePolicy removes a policy that was never claimed against; claimed policies are
+// deactivated instead so the claims keep their policy
+func DeleteInsurancePolicy(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var policy models.InsurancePolicy
+	if err := database.DB.First(&policy, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Insurance policy not found"})
+	}
+	if !user.CanAccessClinic(policy.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	var claims int64
+	database.DB.Model(&models.InsuranceClaim{}).Where("policy_id = ?", policy.ID).Count(&claims)
+	if claims > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "The policy has claims; deactivate it instead", "claims": claims})
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Where("policy_id = ?", policy.ID).Delete(&models.InsuranceCoverage{}).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete insurance policy"})
+	}
+	if err := tx.Delete(&policy).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete insurance policy"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete insurance policy"})
+	}
+	return c.JSON(fiber.Map{"message": "Insurance policy deleted"})
+}
+
+// GetTreatmentPlanInsuranceEstimate splits a treatment plan's procedures between the insurer and
+// the patient, using the policy_id given or the patient's primary policy. Procedures without an
+// estimated cost are priced from the fee schedule for the payer and branch_id, if given.
+func GetTreatmentPlanInsuranceEstimate(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var plan models.PatientTreatmentPlan
+	query := database.DB.Where("patient_id = ?", c.Params("patientId"))
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	}
+	if err := query.First(&plan, c.Params("treatmentPlanId")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Patient treatment plan not found"})
+	}
+
+	var policyID *uint
+	if id, err := strconv.ParseUint(c.Query("policy_id"), 10, 32); err == nil {
+		value := uint(id)
+		policyID = &value
+	}
+	policy, err := findPatientInsurancePolicy(plan.PatientID, plan.ClinicID, policyID, time.Now())
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Insurance policy not found"})
+	}
+
+	var procedures []models.TreatmentPlanProcedure
+	if err := database.DB.Preload("ProcedureTemplate").Where("treatment_plan_id = ? AND status <> ?", plan.ID, "skipped").
+		Order("sequence ASC, id ASC").Find(&procedures).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch treatment plan procedures"})
+	}
+
+	scope := models.FeeScope{ClinicID: plan.ClinicID}
+	if id, err := strconv.ParseUint(c.Query("branch_id"), 10, 32); err == nil {
+		branchID := uint(id)
+		scope.BranchID = &branchID
+	}
+	if policy != nil {
+		scope.PayerID = &policy.PayerID
+	}
+
+	categories := make([]string, len(procedures))
+	charges := make([]float64, len(procedures))
+	for i, procedure := range procedures {
+		categories[i] = procedure.ProcedureTemplate.Category
+		charges[i] = procedure.EstimatedCost
+		if charges[i] == 0 {
+			charges[i] = models.ResolveProcedureFee(database.DB, scope, procedure.ProcedureTemplate, scope.Today(database.DB)).Price
+		}
+	}
+
+	var estimates []models.CoverageEstimate
+	usedBenefit := 0.0
+	if policy != nil {
+		usedBenefit = models.PolicyBenefitUsed(database.DB, policy.ID, time.Now().Year())
+		estimates = policy.EstimateCoverage(categories, charges, usedBenefit)
+	} else {
+		estimates = (&models.InsurancePolicy{}).EstimateCoverage(categories, charges, 0)
+	}
+
+	items := make([]fiber.Map, 0, len(procedures))
+	var total, insurerTotal, patientTotal float64
+	for i, procedure := range procedures {
+		items = append(items, fiber.Map{
+			"treatment_plan_procedure_id": procedure.ID,
+			"procedure_code":              procedure.ProcedureTemplate.Code,
+			"description":                 procedure.ProcedureTemplate.Name,
+			"tooth_number":                procedure.ToothNumber,
+			"estimate":                    estimates[i],
+		})
+		total += estimates[i].Charge
+		insurerTotal += estimates[i].InsurerAmount
+		patientTotal += estimates[i].PatientAmount
+	}
+
+	return c.JSON(fiber.Map{
+		"treatment_plan_id": plan.ID,
+		"policy":            policy,
+		"benefit_used":      usedBenefit,
+		"items":             items,
+		"total":             total,
+		"insurer_estimate":  insurerTotal,
+		"patient_estimate":  patientTotal,
+	})
+}
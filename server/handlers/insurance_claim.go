@@ -0,0 +1,569 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type UpdateInvoiceInsuranceRequest struct {
+	InsurancePolicyID *uint `json:"insurance_policy_id"`
+	SelfPay           bool  `json:"self_pay"`
+}
+
+type CreateInsuranceClaimRequest struct {
+	Notes string `json:"notes"`
+}
+
+type UpdateClaimStatusRequest struct {
+	Status         models.ClaimStatus `json:"status"`
+	ApprovedAmount float64            `json:"approved_amount"`
+	PayerReference string             `json:"payer_reference"`
+	DenialReason   string             `json:"denial_reason"`
+	Notes          string             `json:"notes"`
+}
+
+type RecordClaimPaymentRequest struct {
+	Amount    float64    `json:"amount"`
+	Reference string     `json:"reference"`
+	Notes     string     `json:"notes"`
+	PaidAt    *time.Time `json:"paid_at"`
+}
+
+// findAccessibleClaim loads a claim the user is allowed to see
+func findAccessibleClaim(c *fiber.Ctx, user models.User) (*models.InsuranceClaim, error) {
+	var claim models.InsuranceClaim
+	if err := database.DB.First(&claim, c.Params("id")).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "Insurance claim not found"})
+	}
+	if !user.CanAccessClinic(claim.ClinicID) {
+		return nil, c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+	return &claim, nil
+}
+
+func loadClaimDetails(claim *models.InsuranceClaim) {
+	database.DB.Preload("Lines").Preload("Patient").Preload("Payer").Preload("Policy").Preload("Invoice").
+		Preload("CreatedBy").First(claim, claim.ID)
+}
+
+// UpdateInvoiceInsurance changes the policy an invoice is billed to, or makes it self-pay, and
+// estimates the split again. Not possible once the invoice has been claimed.
+func UpdateInvoiceInsurance(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	invoice, err := findAccessibleInvoice(database.DB, user, c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Invoice not found"})
+	}
+	if invoice.IsVoid() {
+		return c.Status(400).JSON(fiber.Map{"error": "Invoice is void"})
+	}
+
+	var req UpdateInvoiceInsuranceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	var claims int64
+	database.DB.Model(&models.InsuranceClaim{}).Where("invoice_id = ?", invoice.ID).Count(&claims)
+	if claims > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "The invoice has been claimed; its insurance can no longer change"})
+	}
+
+	var policy *models.InsurancePolicy
+	if !req.SelfPay {
+		policy, err = findPatientInsurancePolicy(invoice.PatientID, invoice.ClinicID, req.InsurancePolicyID, invoice.IssuedAt)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid insurance policy for this patient"})
+		}
+	}
+	usedBenefit := 0.0
+	if policy != nil {
+		usedBenefit = models.PolicyBenefitUsed(database.DB, policy.ID, invoice.IssuedAt.Year())
+	}
+
+	database.DB.Where("invoice_id = ?", invoice.ID).Order("id ASC").Find(&invoice.Lines)
+	invoice.ApplyInsuranceEstimate(policy, usedBenefit)
+
+	tx := database.DB.Begin()
+	if err := tx.Model(invoice).Select("insurance_policy_id", "insurer_estimate", "patient_estimate").Updates(invoice).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update invoice insurance"})
+	}
+	for i := range invoice.Lines {
+		line := &invoice.Lines[i]
+		if err := tx.Model(line).Select("coverage_percent", "insurer_amount", "patient_amount").Updates(line).Error; err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update invoice insurance"})
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update invoice insurance"})
+	}
+
+	loadInvoiceDetails(invoice)
+	return c.JSON(invoice)
+}
+
+// GetInsuranceClaims lists claims, optionally by status, payer, patient or invoice
+func GetInsuranceClaims(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	query := database.DB.Model(&models.InsuranceClaim{})
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	} else if clinicID := c.Query("clinic_id"); clinicID != "" {
+		query = query.Where("clinic_id = ?", clinicID)
+	}
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status IN ?", strings.Split(status, ","))
+	}
+	if payerID := c.Query("payer_id"); payerID != "" {
+		query = query.Where("payer_id = ?", payerID)
+	}
+	if patientID := c.Query("patient_id"); patientID != "" {
+		query = query.Where("patient_id = ?", patientID)
+	}
+	if invoiceID := c.Query("invoice_id"); invoiceID != "" {
+		query = query.Where("invoice_id = ?", invoiceID)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	offset := (page - 1) * limit
+
+	var claims []models.InsuranceClaim
+	if err := query.Preload("Patient").Preload("Payer").
+		Order("created_at DESC, id DESC").Offset(offset).Limit(limit).Find(&claims).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch insurance claims"})
+	}
+
+	// What payers still owe on approved claims, for the receivables view
+	var outstanding float64
+	database.DB.Model(&models.InsuranceClaim{}).Where("clinic_id = ? AND status IN ?", user.ClinicID,
+		[]models.ClaimStatus{models.ClaimStatusApproved, models.ClaimStatusPartiallyPaid}).
+		Select("COALESCE(SUM(approved_amount - paid_amount), 0)").Scan(&outstanding)
+
+	return c.JSON(fiber.Map{
+		"claims":      claims,
+		"total":       total,
+		"page":        page,
+		"limit":       limit,
+		"outstanding": outstanding,
+	})
+}
+
+// GetInsuranceClaim returns a claim with its lines
+func GetInsuranceClaim(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	claim, err := findAccessibleClaim(c, user)
+	if claim == nil {
+		return err
+	}
+
+	loadClaimDetails(claim)
+	return c.JSON(claim)
+}
+
+// CreateInsuranceClaim drafts a claim for the insurer's share of an invoice
+func CreateInsuranceClaim(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	invoice, err := findAccessibleInvoice(database.DB, user, c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Invoice not found"})
+	}
+	if invoice.IsVoid() || invoice.Status == models.InvoiceStatusDraft {
+		return c.Status(400).JSON(fiber.Map{"error": "Only issued invoices can be claimed"})
+	}
+	if invoice.InsurancePolicyID == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "The invoice is not billed to an insurance policy"})
+	}
+
+	var req CreateInsuranceClaimRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+	}
+
+	var policy models.InsurancePolicy
+	if err := database.DB.First(&policy, *invoice.InsurancePolicyID).Error; err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "The invoice's insurance policy no longer exists"})
+	}
+
+	var lines []models.InvoiceLine
+	database.DB.Where("invoice_id = ?", invoice.ID).Order("id ASC").Find(&lines)
+
+	serviceDate := invoice.IssuedAt
+	if invoice.AppointmentID != nil {
+		var appointment models.Appointment
+		if database.DB.Select("id", "start_time", "timezone").First(&appointment, *invoice.AppointmentID).Error == nil {
+			serviceDate = appointment.StartTime
+		}
+	}
+
+	claim := models.InsuranceClaim{
+		Status:      models.ClaimStatusDraft,
+		InvoiceID:   invoice.ID,
+		PolicyID:    policy.ID,
+		PayerID:     policy.PayerID,
+		PatientID:   invoice.PatientID,
+		ServiceDate: serviceDate,
+		Notes:       req.Notes,
+		ClinicID:    invoice.ClinicID,
+		CreatedByID: user.ID,
+	}
+	for _, line := range lines {
+		lineID := line.ID
+		claim.Lines = append(claim.Lines, models.InsuranceClaimLine{
+			InvoiceLineID:   &lineID,
+			ProcedureCode:   line.ProcedureCode,
+			Description:     line.Description,
+			ToothNumber:     line.ToothNumber,
+			Category:        line.Category,
+			Quantity:        line.Quantity,
+			Charge:          line.LineTotal,
+			CoveragePercent: line.CoveragePercent,
+			ClaimedAmount:   line.InsurerAmount,
+		})
+		claim.TotalCharge += line.LineTotal
+		claim.ClaimedAmount += line.InsurerAmount
+	}
+	if claim.ClaimedAmount <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Nothing on this invoice is covered by the policy"})
+	}
+
+	tx := database.DB.Begin()
+
+	// One claim per invoice. The invoice stays locked until the claim is saved, so a second request
+	// for it waits and then finds this claim.
+	var locked models.Invoice
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&locked, invoice.ID).Error; err != nil {
+		tx.Rollback()
+		return c.Status(404).JSON(fiber.Map{"error": "Invoice not found"})
+	}
+	var existing models.InsuranceClaim
+	if err := tx.Where("invoice_id = ?", invoice.ID).First(&existing).Error; err == nil {
+		tx.Rollback()
+		return c.Status(409).JSON(fiber.Map{"error": "The invoice already has a claim", "claim_id": existing.ID})
+	}
+
+	claimNumber, err := models.GenerateClaimNumber(invoice.ClinicID, tx)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to generate claim number"})
+	}
+	claim.ClaimNumber = claimNumber
+
+	if err := tx.Create(&claim).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create insurance claim"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create insurance claim"})
+	}
+
+	loadClaimDetails(&claim)
+	return c.Status(201).JSON(claim)
+}
+
+// UpdateInsuranceClaimStatus submits a claim or records the payer's decision. Approval and denial
+// update the invoice's estimate of what the insurer and the patient will pay.
+func UpdateInsuranceClaimStatus(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	claim, errResp := findAccessibleClaim(c, user)
+	if claim == nil {
+		return errResp
+	}
+
+	var req UpdateClaimStatusRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if req.Status == models.ClaimStatusPartiallyPaid || req.Status == models.ClaimStatusPaid {
+		return c.Status(400).JSON(fiber.Map{"error": "Record the payer's payment to mark a claim paid"})
+	}
+	if err := claim.ValidateTransition(req.Status); err != nil {
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	now := time.Now()
+	switch req.Status {
+	case models.ClaimStatusSubmitted:
+		claim.SubmittedAt = &now
+		claim.DecidedAt = nil
+		claim.DenialReason = ""
+		claim.ApprovedAmount = 0
+	case models.ClaimStatusApproved:
+		if req.ApprovedAmount <= 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "Approved amount is required"})
+		}
+		if req.ApprovedAmount > claim.TotalCharge+0.005 {
+			return c.Status(400).JSON(fiber.Map{"error": "Approved amount cannot exceed the claim's charges", "total_charge": claim.TotalCharge})
+		}
+		claim.ApprovedAmount = req.ApprovedAmount
+		claim.DecidedAt = &now
+	case models.ClaimStatusDenied:
+		if strings.TrimSpace(req.DenialReason) == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "Denial reason is required"})
+		}
+		claim.DenialReason = strings.TrimSpace(req.DenialReason)
+		claim.ApprovedAmount = 0
+		claim.DecidedAt = &now
+	}
+	claim.Status = req.Status
+	if req.PayerReference != "" {
+		claim.PayerReference = req.PayerReference
+	}
+	if req.Notes != "" {
+		claim.Notes = req.Notes
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Omit("Lines", "Invoice", "Policy", "Payer", "Patient", "CreatedBy").Save(claim).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update insurance claim"})
+	}
+	if claim.Status == models.ClaimStatusApproved || claim.Status == models.ClaimStatusDenied {
+		if err := updateInvoiceInsurerShare(tx, claim.InvoiceID, claim.ApprovedAmount); err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update invoice estimate"})
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update insurance claim"})
+	}
+
+	loadClaimDetails(claim)
+	return c.JSON(claim)
+}
+
+// updateInvoiceInsurerShare replaces the invoice's estimated split with what the payer decided
+func updateInvoiceInsurerShare(tx *gorm.DB, invoiceID uint, insurerAmount float64) error {
+	var invoice models.Invoice
+	if err := tx.Select("id", "total_amount").First(&invoice, invoiceID).Error; err != nil {
+		return err
+	}
+	if insurerAmount > invoice.TotalAmount {
+		insurerAmount = invoice.TotalAmount
+	}
+	return tx.Model(&invoice).Updates(map[string]interface{}{
+		"insurer_estimate": insurerAmount,
+		"patient_estimate": invoice.TotalAmount - insurerAmount,
+	}).Error
+}
+
+// RecordInsuranceClaimPayment records money received from the payer for a claim as an insurance
+// payment on the invoice
+func RecordInsuranceClaimPayment(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var req RecordClaimPaymentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.Amount <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Payment amount must be positive"})
+	}
+
+	claim, errResp := findAccessibleClaim(c, user)
+	if claim == nil {
+		return errResp
+	}
+
+	tx := database.DB.Begin()
+
+	// Read the claim again under lock so concurrent payments cannot both fit the amount owed
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(claim, claim.ID).Error; err != nil {
+		tx.Rollback()
+		return c.Status(404).JSON(fiber.Map{"error": "Insurance claim not found"})
+	}
+	if claim.Status != models.ClaimStatusApproved && claim.Status != models.ClaimStatusPartiallyPaid {
+		tx.Rollback()
+		return c.Status(409).JSON(fiber.Map{"error": "Payments can only be recorded on approved claims"})
+	}
+	if req.Amount > claim.OutstandingAmount()+0.005 {
+		tx.Rollback()
+		return c.Status(400).JSON(fiber.Map{"error": "Payment exceeds the approved amount still owed", "outstanding": claim.OutstandingAmount()})
+	}
+
+	invoice, err := findAccessibleInvoice(tx.Clauses(clause.Locking{Strength: "UPDATE"}), user, strconv.FormatUint(uint64(claim.InvoiceID), 10))
+	if err != nil {
+		tx.Rollback()
+		return c.Status(404).JSON(fiber.Map{"error": "Invoice not found"})
+	}
+	if invoice.IsVoid() {
+		tx.Rollback()
+		return c.Status(400).JSON(fiber.Map{"error": "The claim's invoice is void"})
+	}
+	if req.Amount > invoice.BalanceDue+0.005 {
+		tx.Rollback()
+		return c.Status(400).JSON(fiber.Map{"error": "Payment exceeds balance due", "balance_due": invoice.BalanceDue})
+	}
+
+	paidAt := time.Now()
+	if req.PaidAt != nil {
+		paidAt = *req.PaidAt
+	}
+	claimID := claim.ID
+	payment := models.Payment{
+		InvoiceID:        invoice.ID,
+		Type:             models.PaymentTypePayment,
+		Amount:           req.Amount,
+		Method:           models.PaymentMethodInsurance,
+		Reference:        firstNonEmpty(req.Reference, claim.PayerReference, claim.ClaimNumber),
+		Notes:            req.Notes,
+		PaidAt:           paidAt,
+		InsuranceClaimID: &claimID,
+		PatientID:        invoice.PatientID,
+		ClinicID:         invoice.ClinicID,
+		BranchID:         invoice.BranchID,
+		ReceivedByID:     user.ID,
+	}
+	if err := tx.Create(&payment).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to record payment"})
+	}
+
+	invoice.Payments = append(invoice.Payments, payment)
+	invoice.ApplyPayments()
+	if err := tx.Omit("Payments", "Lines").Save(invoice).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update invoice"})
+	}
+	if err := syncAppointmentPayment(tx, invoice); err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update appointment payment status"})
+	}
+
+	claim.ApplyPayment(req.Amount, paidAt)
+	if err := tx.Model(claim).Select("paid_amount", "status", "paid_at").Updates(claim).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update insurance claim"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to record payment"})
+	}
+
+	loadClaimDetails(claim)
+	return c.Status(201).JSON(fiber.Map{
+		"payment": payment,
+		"claim":   claim,
+	})
+}
+
+// ExportInsuranceClaim downloads a claim for sending to the payer, as CSV (default) or JSON
+func ExportInsuranceClaim(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	claim, errResp := findAccessibleClaim(c, user)
+	if claim == nil {
+		return errResp
+	}
+	loadClaimDetails(claim)
+
+	var clinic models.Clinic
+	database.DB.First(&clinic, claim.ClinicID)
+
+	patientDOB, subscriberDOB := "", ""
+	if claim.Patient != nil && claim.Patient.DateOfBirth != nil {
+		patientDOB = claim.Patient.DateOfBirth.Format("2006-01-02")
+	}
+	if claim.Policy != nil && claim.Policy.SubscriberDateOfBirth != nil {
+		subscriberDOB = claim.Policy.SubscriberDateOfBirth.Format("2006-01-02")
+	}
+	var patientName string
+	if claim.Patient != nil {
+		patientName = claim.Patient.GetFullName()
+	}
+	var payerName, payerCode string
+	if claim.Payer != nil {
+		payerName, payerCode = claim.Payer.Name, claim.Payer.PayerCode
+	}
+	var policyNumber, groupNumber, subscriberName, relationship string
+	if claim.Policy != nil {
+		policyNumber, groupNumber = claim.Policy.PolicyNumber, claim.Policy.GroupNumber
+		subscriberName, relationship = claim.Policy.SubscriberName, string(claim.Policy.Relationship)
+	}
+	var invoiceNumber string
+	if claim.Invoice != nil {
+		invoiceNumber = claim.Invoice.InvoiceNumber
+	}
+
+	header := [][2]string{
+		{"claim_number", claim.ClaimNumber},
+		{"status", string(claim.Status)},
+		{"service_date", claim.ServiceDate.Format("2006-01-02")},
+		{"provider", clinic.Name},
+		{"provider_phone", clinic.Phone},
+		{"payer", payerName},
+		{"payer_code", payerCode},
+		{"policy_number", policyNumber},
+		{"group_number", groupNumber},
+		{"subscriber_name", subscriberName},
+		{"subscriber_date_of_birth", subscriberDOB},
+		{"relationship", relationship},
+		{"patient_name", patientName},
+		{"patient_date_of_birth", patientDOB},
+		{"invoice_number", invoiceNumber},
+		{"total_charge", fmt.Sprintf("%.2f", claim.TotalCharge)},
+		{"claimed_amount", fmt.Sprintf("%.2f", claim.ClaimedAmount)},
+	}
+
+	if c.Query("format") == "json" {
+		fields := fiber.Map{}
+		for _, field := range header {
+			fields[field[0]] = field[1]
+		}
+		fields["lines"] = claim.Lines
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+claim.ClaimNumber+`.json"`)
+		return c.JSON(fields)
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	for _, field := range header {
+		writer.Write([]string{field[0], field[1]})
+	}
+	writer.Write(nil)
+	writer.Write([]string{"line", "procedure_code", "description", "tooth_number", "category", "quantity", "charge", "coverage_percent", "claimed_amount"})
+	for i, line := range claim.Lines {
+		writer.Write([]string{
+			strconv.Itoa(i + 1),
+			line.ProcedureCode,
+			line.Description,
+			line.ToothNumber,
+			line.Category,
+			strconv.Itoa(line.Quantity),
+			fmt.Sprintf("%.2f", line.Charge),
+			fmt.Sprintf("%.2f", line.CoveragePercent),
+			fmt.Sprintf("%.2f", line.ClaimedAmount),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to export insurance claim"})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+claim.ClaimNumber+`.csv"`)
+	return c.Send(buf.Bytes())
+}
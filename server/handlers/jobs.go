@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"dentika/server/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var jobScheduler *services.Scheduler
+
+// SetJobScheduler sets the background job scheduler instance
+func SetJobScheduler(scheduler *services.Scheduler) {
+	jobScheduler = scheduler
+}
+
+// GetJobs lists background jobs with their schedule, lock and last run status
+func GetJobs(c *fiber.Ctx) error {
+	if jobScheduler == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "Job scheduler is not running"})
+	}
+
+	jobs, err := jobScheduler.Jobs()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch jobs"})
+	}
+
+	return c.JSON(jobs)
+}
+
+// RunJob triggers a job immediately
+func RunJob(c *fiber.Ctx) error {
+	if jobScheduler == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "Job scheduler is not running"})
+	}
+
+	switch err := jobScheduler.Trigger(c.Params("name")); err {
+	case nil:
+		return c.Status(202).JSON(fiber.Map{"message": "Job started"})
+	case services.ErrJobNotFound:
+		return c.Status(404).JSON(fiber.Map{"error": "Job not found"})
+	case services.ErrJobAlreadyLocked:
+		return c.Status(409).JSON(fiber.Map{"error": "Job is already running"})
+	default:
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start job"})
+	}
+}
+
+// UpdateJob enables or disables a job
+func UpdateJob(c *fiber.Ctx) error {
+	if jobScheduler == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "Job scheduler is not running"})
+	}
+
+	var req struct {
+		IsEnabled *bool `json:"is_enabled"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.IsEnabled == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "is_enabled is required"})
+	}
+
+	switch err := jobScheduler.SetEnabled(c.Params("name"), *req.IsEnabled); err {
+	case nil:
+		return c.JSON(fiber.Map{"message": "Job updated"})
+	case services.ErrJobNotFound:
+		return c.Status(404).JSON(fiber.Map{"error": "Job not found"})
+	default:
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update job"})
+	}
+}
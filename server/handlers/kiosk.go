@@ -0,0 +1,548 @@
+package handlers
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// KioskTokenHeader carries a kiosk device's token on every kiosk request
+const KioskTokenHeader = "X-Kiosk-Token"
+
+type CreateKioskDeviceRequest struct {
+	Name string `json:"name"`
+}
+
+type KioskIdentifyRequest struct {
+	Phone       string `json:"phone"`
+	BookingCode string `json:"booking_code"`
+	DateOfBirth string `json:"date_of_birth"` // Format: "2006-01-02"
+}
+
+type KioskMedicalUpdateRequest struct {
+	Allergies          *string `json:"allergies"`
+	CurrentMedications *string `json:"current_medications"`
+}
+
+type KioskCheckInRequest struct {
+	AppointmentID uint `json:"appointment_id"` // may be left out when the patient has one appointment today
+}
+
+// errKioskNotFound is deliberately vague, so the kiosk does not reveal which detail was wrong
+const errKioskNotFound = "We could not find an appointment today with those details. Please ask at the front desk."
+
+// findKioskDevice authenticates the kiosk device making the request
+func findKioskDevice(c *fiber.Ctx) (*models.KioskDevice, error) {
+	token := c.Get(KioskTokenHeader)
+	var device models.KioskDevice
+	if token == "" || database.DB.Preload("Branch").Where("token = ? AND is_active = ?", token, true).First(&device).Error != nil {
+		return nil, c.Status(401).JSON(fiber.Map{"error": "Kiosk device not recognised"})
+	}
+
+	database.DB.Model(&device).UpdateColumn("last_seen_at", time.Now())
+	return &device, nil
+}
+
+// findKioskSession loads the check-in session of the device's current patient
+func findKioskSession(c *fiber.Ctx, device *models.KioskDevice) (*models.KioskSession, error) {
+	var session models.KioskSession
+	if err := database.DB.Preload("Patient").Where("token = ? AND device_id = ?", c.Params("token"), device.ID).First(&session).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "Check-in session not found"})
+	}
+	if !session.IsUsable() {
+		return nil, c.Status(410).JSON(fiber.Map{"error": "This check-in has finished or timed out. Please start again."})
+	}
+	return &session, nil
+}
+
+// kioskAppointments lists the patient's appointments at the branch today that they can check in for
+func kioskAppointments(patientID uint, branch models.Branch) ([]models.Appointment, error) {
+	location := models.BranchLocation(database.DB, branch.ID)
+	now := time.Now().In(location)
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+
+	var appointments []models.Appointment
+	err := database.DB.Preload("Doctor").
+		Where("patient_id = ? AND branch_id = ? AND start_time >= ? AND start_time < ? AND status IN ? AND patient_arrived = ?",
+			patientID, branch.ID, dayStart, dayStart.AddDate(0, 0, 1),
+			[]models.AppointmentStatus{models.StatusScheduled, models.StatusConfirmed}, false).
+		Order("start_time ASC").Find(&appointments).Error
+	return appointments, err
+}
+
+// kioskPendingConsents lists the patient's consent forms still waiting on their signature, either
+// for one of today's appointments or not tied to an appointment
+func kioskPendingConsents(session *models.KioskSession, appointments []models.Appointment) ([]models.ConsentForm, error) {
+	appointmentIDs := []uint{0}
+	for _, appointment := range appointments {
+		appointmentIDs = append(appointmentIDs, appointment.ID)
+	}
+
+	var forms []models.ConsentForm
+	err := database.DB.
+		Where("patient_id = ? AND clinic_id = ? AND patient_signed_at IS NULL AND status IN ?",
+			session.PatientID, session.ClinicID, []models.DocumentStatus{models.DocStatusDraft, models.DocStatusPending}).
+		Where("appointment_id IN ? OR appointment_id IS NULL", appointmentIDs).
+		Order("id ASC").Find(&forms).Error
+	return forms, err
+}
+
+// kioskSessionView is what the kiosk shows the patient: only their own details for today
+func kioskSessionView(c *fiber.Ctx, device *models.KioskDevice, session *models.KioskSession) error {
+	appointments, err := kioskAppointments(session.PatientID, device.Branch)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to load appointments"})
+	}
+	forms, err := kioskPendingConsents(session, appointments)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to load consent forms"})
+	}
+
+	appointmentViews := make([]fiber.Map, 0, len(appointments))
+	for i := range appointments {
+		appointment := &appointments[i]
+		appointmentViews = append(appointmentViews, fiber.Map{
+			"id":           appointment.ID,
+			"booking_code": appointment.BookingCode,
+			"title":        appointment.Title,
+			"start_time":   appointment.StartTime,
+			"doctor_name":  appointment.Doctor.GetDisplayName(),
+		})
+	}
+	consentViews := make([]fiber.Map, 0, len(forms))
+	for _, form := range forms {
+		consentViews = append(consentViews, fiber.Map{
+			"id":             form.ID,
+			"title":          form.Title,
+			"appointment_id": form.AppointmentID,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"token":      session.Token,
+		"expires_at": session.ExpiresAt,
+		"patient": fiber.Map{
+			"first_name":          session.Patient.FirstName,
+			"allergies":           session.Patient.Allergies,
+			"current_medications": session.Patient.CurrentMedications,
+		},
+		"appointments":     appointmentViews,
+		"pending_consents": consentViews,
+	})
+}
+
+// GetKioskInfo tells a kiosk which branch it belongs to, for its welcome screen
+func GetKioskInfo(c *fiber.Ctx) error {
+	device, errResp := findKioskDevice(c)
+	if device == nil {
+		return errResp
+	}
+
+	var clinic models.Clinic
+	database.DB.Select("id", "name", "logo", "phone").First(&clinic, device.ClinicID)
+
+	return c.JSON(fiber.Map{
+		"name":        device.Name,
+		"branch_name": device.Branch.Name,
+		"clinic": fiber.Map{
+			"name":  clinic.Name,
+			"logo":  clinic.Logo,
+			"phone": firstNonEmpty(device.Branch.Phone, clinic.Phone),
+		},
+	})
+}
+
+// IdentifyKioskPatient starts a check-in: the patient gives their phone number or booking code,
+// and their date of birth, and gets a short session for today's appointments at the branch
+func IdentifyKioskPatient(c *fiber.Ctx) error {
+	device, errResp := findKioskDevice(c)
+	if device == nil {
+		return errResp
+	}
+
+	var req KioskIdentifyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	req.Phone = strings.TrimSpace(req.Phone)
+	if req.Phone == "" && strings.TrimSpace(req.BookingCode) == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Phone number or booking code is required"})
+	}
+	dateOfBirth, err := time.Parse("2006-01-02", req.DateOfBirth)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Date of birth is required (YYYY-MM-DD)"})
+	}
+
+	// Too many wrong guesses from this kiosk, or for this phone number or code, lock it out
+	bookingCode := models.NormalizeBookingCode(req.BookingCode)
+	identifier := models.KioskIdentifier(firstNonEmpty(req.Phone, bookingCode))
+	lockedUntil, err := models.KioskIdentifyLockedUntil(database.DB, device.ID, identifier)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start check-in"})
+	}
+	if lockedUntil != nil {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(time.Until(*lockedUntil).Seconds())+1))
+		return c.Status(429).JSON(fiber.Map{"error": "Too many attempts. Please ask at the front desk."})
+	}
+	notFound := func() error {
+		failure := models.KioskIdentifyFailure{DeviceID: device.ID, Identifier: identifier}
+		if err := database.DB.Create(&failure).Error; err != nil {
+			log.Printf("Failed to record kiosk identification failure: %v", err)
+		}
+		database.DB.Where("created_at < ?", time.Now().Add(-24*time.Hour)).Delete(&models.KioskIdentifyFailure{})
+		return c.Status(404).JSON(fiber.Map{"error": errKioskNotFound})
+	}
+
+	// The patients the details could belong to; the date of birth settles which
+	var candidates []models.Patient
+	if req.Phone != "" {
+		database.DB.Where("phone = ? AND clinic_id = ? AND is_active = ?", req.Phone, device.ClinicID, true).Find(&candidates)
+	} else {
+		var appointments []models.Appointment
+		database.DB.Preload("Patient").Where("booking_code = ? AND branch_id = ?", bookingCode, device.BranchID).Find(&appointments)
+		for _, appointment := range appointments {
+			if appointment.Patient.IsActive {
+				candidates = append(candidates, appointment.Patient)
+			}
+		}
+	}
+
+	var patient *models.Patient
+	for i := range candidates {
+		if candidates[i].DateOfBirth != nil && candidates[i].DateOfBirth.Format("2006-01-02") == dateOfBirth.Format("2006-01-02") {
+			patient = &candidates[i]
+			break
+		}
+	}
+	if patient == nil {
+		return notFound()
+	}
+
+	appointments, err := kioskAppointments(patient.ID, device.Branch)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to load appointments"})
+	}
+	if len(appointments) == 0 {
+		return notFound()
+	}
+	database.DB.Where("identifier = ?", identifier).Delete(&models.KioskIdentifyFailure{})
+
+	token, err := models.GenerateToken()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start check-in"})
+	}
+	session := models.KioskSession{
+		Token:     token,
+		DeviceID:  device.ID,
+		BranchID:  device.BranchID,
+		PatientID: patient.ID,
+		Patient:   *patient,
+		ExpiresAt: time.Now().Add(models.KioskSessionTTL),
+		ClinicID:  device.ClinicID,
+	}
+	if err := database.DB.Omit("Patient").Create(&session).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start check-in"})
+	}
+
+	c.Status(201)
+	return kioskSessionView(c, device, &session)
+}
+
+// GetKioskSession shows the patient's appointments, medical details and forms to sign
+func GetKioskSession(c *fiber.Ctx) error {
+	device, errResp := findKioskDevice(c)
+	if device == nil {
+		return errResp
+	}
+	session, errResp := findKioskSession(c, device)
+	if session == nil {
+		return errResp
+	}
+
+	return kioskSessionView(c, device, session)
+}
+
+// UpdateKioskMedicalInfo lets the patient bring their allergies and medications up to date
+func UpdateKioskMedicalInfo(c *fiber.Ctx) error {
+	device, errResp := findKioskDevice(c)
+	if device == nil {
+		return errResp
+	}
+	session, errResp := findKioskSession(c, device)
+	if session == nil {
+		return errResp
+	}
+
+	var req KioskMedicalUpdateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	updates := map[string]interface{}{}
+	if req.Allergies != nil {
+		updates["allergies"] = strings.TrimSpace(*req.Allergies)
+	}
+	if req.CurrentMedications != nil {
+		updates["current_medications"] = strings.TrimSpace(*req.CurrentMedications)
+	}
+	if len(updates) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Nothing to update"})
+	}
+
+	now := time.Now()
+	tx := database.DB.Begin()
+	if err := tx.Model(&models.Patient{}).Where("id = ?", session.PatientID).Updates(updates).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update medical details"})
+	}
+	if err := tx.Model(session).UpdateColumn("medical_updated_at", now).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update medical details"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update medical details"})
+	}
+
+	database.DB.First(&session.Patient, session.PatientID)
+	return kioskSessionView(c, device, session)
+}
+
+// findKioskConsentForm loads one of the session patient's consent forms that is waiting on them
+func findKioskConsentForm(c *fiber.Ctx, session *models.KioskSession) (*models.ConsentForm, error) {
+	var form models.ConsentForm
+	if err := database.DB.Preload("Clinic").Preload("Patient").Preload("Doctor").Preload("SigningRecord").
+		Where("id = ? AND patient_id = ? AND clinic_id = ?", c.Params("formId"), session.PatientID, session.ClinicID).
+		First(&form).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "Consent form not found"})
+	}
+	if form.IsLocked() || form.PatientSignedAt != nil || form.Status == models.DocStatusCancelled {
+		return nil, c.Status(409).JSON(fiber.Map{"error": "This consent form has already been signed"})
+	}
+	return &form, nil
+}
+
+// GetKioskConsentForm shows a consent form for the patient to review at the kiosk
+func GetKioskConsentForm(c *fiber.Ctx) error {
+	device, errResp := findKioskDevice(c)
+	if device == nil {
+		return errResp
+	}
+	session, errResp := findKioskSession(c, device)
+	if session == nil {
+		return errResp
+	}
+	form, errResp := findKioskConsentForm(c, session)
+	if form == nil {
+		return errResp
+	}
+
+	view := patientConsentFormView(form)
+	view["id"] = form.ID
+	return c.JSON(view)
+}
+
+// SignKioskConsentForm records the patient's signature given at the kiosk. Any signing links sent
+// for the form stop working.
+func SignKioskConsentForm(c *fiber.Ctx) error {
+	device, errResp := findKioskDevice(c)
+	if device == nil {
+		return errResp
+	}
+	session, errResp := findKioskSession(c, device)
+	if session == nil {
+		return errResp
+	}
+	form, errResp := findKioskConsentForm(c, session)
+	if form == nil {
+		return errResp
+	}
+
+	var req RemoteSignConsentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if message := req.validate(); message != "" {
+		return c.Status(400).JSON(fiber.Map{"error": message})
+	}
+
+	ipAddress := c.IP()
+	userAgent := c.Get("User-Agent")
+	now := time.Now()
+	req.apply(form, models.ConsentSignedKiosk, ipAddress, userAgent, now)
+
+	tx := database.DB.Begin()
+	if err := tx.Model(&models.ConsentSigningLink{}).
+		Where("consent_form_id = ? AND used_at IS NULL AND revoked_at IS NULL", form.ID).
+		Update("revoked_at", now).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to sign consent form"})
+	}
+	if err := saveConsentFormSigningTx(tx, form, nil, ipAddress, userAgent); err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to sign consent form"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to sign consent form"})
+	}
+
+	if form.Status == models.DocStatusSigned {
+		if err := generateConsentFormPDF(form); err != nil {
+			log.Printf("Failed to generate PDF for consent form %d: %v", form.ID, err)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"message":   "Thank you, your consent has been recorded",
+		"signed_at": form.PatientSignedAt,
+	})
+}
+
+// KioskCheckIn confirms the patient's appointment and marks them arrived, which ends the session
+func KioskCheckIn(c *fiber.Ctx) error {
+	device, errResp := findKioskDevice(c)
+	if device == nil {
+		return errResp
+	}
+	session, errResp := findKioskSession(c, device)
+	if session == nil {
+		return errResp
+	}
+
+	var req KioskCheckInRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+	}
+
+	appointments, err := kioskAppointments(session.PatientID, device.Branch)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to load appointments"})
+	}
+	var appointment *models.Appointment
+	for i := range appointments {
+		if appointments[i].ID == req.AppointmentID || (req.AppointmentID == 0 && len(appointments) == 1) {
+			appointment = &appointments[i]
+		}
+	}
+	if appointment == nil {
+		if req.AppointmentID == 0 && len(appointments) > 1 {
+			return c.Status(400).JSON(fiber.Map{"error": "Please choose the appointment you are here for"})
+		}
+		return c.Status(404).JSON(fiber.Map{"error": errKioskNotFound})
+	}
+
+	// Claim the session first, so a double tap does not check the patient in twice
+	now := time.Now()
+	claim := database.DB.Model(&models.KioskSession{}).Where("id = ? AND checked_in_at IS NULL", session.ID).
+		Updates(map[string]interface{}{"checked_in_at": now, "checked_in_appointment_id": appointment.ID})
+	if claim.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to check in"})
+	}
+	if claim.RowsAffected == 0 {
+		return c.Status(410).JSON(fiber.Map{"error": "This check-in has finished or timed out. Please start again."})
+	}
+
+	entry, err := markAppointmentArrived(appointment, 0)
+	if err != nil {
+		database.DB.Model(session).Updates(map[string]interface{}{"checked_in_at": nil, "checked_in_appointment_id": nil})
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to check in"})
+	}
+
+	go SendClinicNotification(
+		"Patient Checked In",
+		session.Patient.GetFullName()+" checked in at "+firstNonEmpty(device.Name, "the kiosk")+" for their "+appointment.StartTime.Format("15:04")+" appointment",
+		"appointment_arrived",
+		appointment.ClinicID,
+	)
+	go SendAppointmentUpdate(appointment.ID, session.Patient.GetFullName(), "arrived", appointment.ClinicID)
+
+	response := fiber.Map{
+		"message":    "You are checked in. Please take a seat, we will call you shortly.",
+		"start_time": appointment.StartTime,
+		"is_late":    appointment.IsLate,
+	}
+	if entry != nil {
+		response["ticket"] = entry.TicketLabel()
+	}
+	return c.JSON(response)
+}
+
+// GetKioskDevices lists the check-in kiosks of a branch
+func GetKioskDevices(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	branch, err := findAccessibleBranch(c, user)
+	if branch == nil {
+		return err
+	}
+
+	var devices []models.KioskDevice
+	if err := database.DB.Where("branch_id = ?", branch.ID).Order("id ASC").Find(&devices).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch kiosk devices"})
+	}
+	return c.JSON(devices)
+}
+
+// CreateKioskDevice registers a check-in kiosk at a branch. The device token is only shown here.
+func CreateKioskDevice(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	branch, err := findAccessibleBranch(c, user)
+	if branch == nil {
+		return err
+	}
+
+	var req CreateKioskDeviceRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+	}
+
+	token, err := models.GenerateToken()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to register kiosk device"})
+	}
+	device := models.KioskDevice{
+		Token:       token,
+		Name:        firstNonEmpty(strings.TrimSpace(req.Name), branch.Name+" kiosk"),
+		BranchID:    branch.ID,
+		IsActive:    true,
+		CreatedByID: user.ID,
+		ClinicID:    branch.ClinicID,
+	}
+	if err := database.DB.Create(&device).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to register kiosk device"})
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"device": device,
+		"token":  device.Token,
+		"header": KioskTokenHeader,
+	})
+}
+
+// DeleteKioskDevice revokes a kiosk's token, e.g. when a tablet goes missing
+func DeleteKioskDevice(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var device models.KioskDevice
+	if err := database.DB.First(&device, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Kiosk device not found"})
+	}
+	if !user.CanAccessClinic(device.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	if err := database.DB.Delete(&device).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete kiosk device"})
+	}
+	return c.JSON(fiber.Map{"message": "Kiosk device deleted"})
+}
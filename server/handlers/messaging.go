@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+	"dentika/server/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var messagingService *services.MessagingService
+
+// errNoRecipient means the patient has no contact details for the channel
+var errNoRecipient = errors.New("patient has no contact details for this channel")
+
+// selfScheduleMessagesPerDay caps the request confirmations sent to one address or number a day
+const selfScheduleMessagesPerDay = 3
+
+// SetMessagingService sets the outbound email/SMS service instance
+func SetMessagingService(service *services.MessagingService) {
+	messagingService = service
+}
+
+// ProcessOutboundMessages is the scheduled job that delivers queued email and SMS messages
+func ProcessOutboundMessages(ctx context.Context) error {
+	if messagingService == nil {
+		return nil
+	}
+	return messagingService.ProcessOutbox(ctx)
+}
+
+// messageRecipient returns the patient's address for the channel
+func messageRecipient(channel models.MessageChannelType, email, phone string) string {
+	if channel == models.ChannelEmail {
+		return strings.TrimSpace(email)
+	}
+	return strings.TrimSpace(phone)
+}
+
+// queueAppointmentReminderMessage queues an email or SMS reminder to the patient
+func queueAppointmentReminderMessage(reminder *models.AppointmentReminder) error {
+	if messagingService == nil {
+		return fmt.Errorf("messaging service is not available")
+	}
+
+	appointment := reminder.Appointment
+	channel := models.MessageChannelType(reminder.ReminderType)
+	recipient := messageRecipient(channel, appointment.Patient.Email, appointment.Patient.Phone)
+	if recipient == "" {
+		return errNoRecipient
+	}
+
+	var clinic models.Clinic
+	database.DB.Select("id", "name", "phone").First(&clinic, appointment.ClinicID)
+
+	when := formatMessageTime(appointment.StartTime)
+	subject := fmt.Sprintf("Appointment reminder: %s", when)
+	var body string
+	if channel == models.ChannelSMS {
+		body = fmt.Sprintf("Hi %s, this is a reminder of your appointment at %s on %s.",
+			appointment.Patient.FirstName, clinicDisplayName(clinic, appointment.Branch), when)
+		if contact := firstNonEmpty(appointment.Branch.Phone, clinic.Phone); contact != "" {
+			body += " To reschedule call " + contact + "."
+		}
+	} else {
+		var b strings.Builder
+		fmt.Fprintf(&b, "Hi %s,\n\n", appointment.Patient.FirstName)
+		fmt.Fprintf(&b, "This is a reminder of your appointment at %s.\n\n", clinicDisplayName(clinic, appointment.Branch))
+		fmt.Fprintf(&b, "When: %s\n", when)
+		if appointment.Branch.Address != "" {
+			fmt.Fprintf(&b, "Where: %s\n", appointment.Branch.Address)
+		}
+		if appointment.Title != "" {
+			fmt.Fprintf(&b, "What: %s\n", appointment.Title)
+		}
+		if contact := firstNonEmpty(appointment.Branch.Phone, clinic.Phone); contact != "" {
+			fmt.Fprintf(&b, "\nIf you need to reschedule, please call us at %s.\n", contact)
+		}
+		fmt.Fprintf(&b, "\n%s\n", clinic.Name)
+		body = b.String()
+	}
+
+	patientID := appointment.PatientID
+	reminderID := reminder.ID
+	return messagingService.Enqueue(&models.OutboundMessage{
+		Channel:               channel,
+		Recipient:             recipient,
+		Subject:               subject,
+		Body:                  body,
+		PatientID:             &patientID,
+		AppointmentReminderID: &reminderID,
+		Category:              models.MessageCategoryAppointmentReminder,
+		ClinicID:              appointment.ClinicID,
+	})
+}
+
+// queueSelfScheduleConfirmation lets the patient know their self-schedule request was received
+func queueSelfScheduleConfirmation(request *models.PatientSelfScheduleRequest) {
+	if messagingService == nil {
+		return
+	}
+
+	preferred := "a time that suits you"
+	if when := request.GetPreferredDateTime(); when != nil {
+		if request.PreferredTime != "" {
+			preferred = when.Format("Monday, January 2, 2006 at 3:04 PM")
+		} else {
+			preferred = when.Format("Monday, January 2, 2006")
+		}
+	}
+	place := clinicDisplayName(request.Clinic, request.Branch)
+	contact := firstNonEmpty(request.Branch.Phone, request.Clinic.Phone)
+
+	var email strings.Builder
+	fmt.Fprintf(&email, "Hi %s,\n\n", request.FirstName)
+	fmt.Fprintf(&email, "We received your appointment request at %s for %s.\n", place, preferred)
+	email.WriteString("Our staff will review it and contact you to confirm the schedule.\n")
+	if contact != "" {
+		fmt.Fprintf(&email, "\nQuestions? Call us at %s.\n", contact)
+	}
+	fmt.Fprintf(&email, "\n%s\n", request.Clinic.Name)
+
+	sms := fmt.Sprintf("Hi %s, %s received your appointment request for %s. We will contact you to confirm.",
+		request.FirstName, place, preferred)
+
+	queueSelfScheduleMessages(request, "We received your appointment request", email.String(), sms, nil, true)
+}
+
+// queueSelfScheduleDecision tells the patient their self-schedule request was booked or declined.
+// A nil appointment means the request was rejected.
+func queueSelfScheduleDecision(request *models.PatientSelfScheduleRequest, appointment *models.Appointment) {
+	if messagingService == nil {
+		return
+	}
+
+	place := clinicDisplayName(request.Clinic, request.Branch)
+	contact := firstNonEmpty(request.Branch.Phone, request.Clinic.Phone)
+
+	var subject, sms string
+	var email strings.Builder
+	fmt.Fprintf(&email, "Hi %s,\n\n", request.FirstName)
+	if appointment != nil {
+		when := formatMessageTime(appointment.StartTime)
+		subject = "Your appointment is booked: " + when
+		place = clinicDisplayName(request.Clinic, appointment.Branch)
+		contact = firstNonEmpty(appointment.Branch.Phone, request.Clinic.Phone)
+		fmt.Fprintf(&email, "Your appointment at %s is booked.\n\n", place)
+		fmt.Fprintf(&email, "When: %s\n", when)
+		if appointment.Branch.Address != "" {
+			fmt.Fprintf(&email, "Where: %s\n", appointment.Branch.Address)
+		}
+		email.WriteString("\nAdd it to your calendar with the attached file.\n")
+		sms = fmt.Sprintf("Hi %s, your appointment at %s is booked for %s.", request.FirstName, place, when)
+	} else {
+		subject = "About your appointment request"
+		fmt.Fprintf(&email, "We are sorry, we could not book your appointment request at %s.\n", place)
+		if request.ReviewNotes != "" {
+			fmt.Fprintf(&email, "\n%s\n", request.ReviewNotes)
+		}
+		sms = fmt.Sprintf("Hi %s, we could not book your appointment request at %s.", request.FirstName, place)
+	}
+	if contact != "" {
+		fmt.Fprintf(&email, "\nQuestions? Call us at %s.\n", contact)
+		sms += " Call " + contact + " for help."
+	}
+	fmt.Fprintf(&email, "\n%s\n", request.Clinic.Name)
+
+	queueSelfScheduleMessages(request, subject, email.String(), sms, appointment, false)
+}
+
+// queueSelfScheduleMessages sends the same notice to a self-schedule requester by email and SMS.
+// When the request was booked, the email carries the appointment as an .ics file. Throttled
+// messages are skipped for recipients who already had their daily share.
+func queueSelfScheduleMessages(request *models.PatientSelfScheduleRequest, subject, emailBody, smsBody string, appointment *models.Appointment, throttled bool) {
+	var patientID *uint
+	if request.ConvertedToAppointmentID != nil && request.ConvertedToAppointment.PatientID != 0 {
+		id := request.ConvertedToAppointment.PatientID
+		patientID = &id
+	}
+
+	requestID := request.ID
+	messages := []models.OutboundMessage{
+		{Channel: models.ChannelEmail, Recipient: request.Email, Subject: subject, Body: emailBody},
+		{Channel: models.ChannelSMS, Recipient: request.Phone, Body: smsBody},
+	}
+	var appointmentID *uint
+	if appointment != nil {
+		appointmentID = &appointment.ID
+		messages[0].AttachmentName, messages[0].AttachmentContentType, messages[0].Attachment = appointmentInvite(appointment, request.Clinic)
+	}
+	for i := range messages {
+		message := &messages[i]
+		message.Recipient = strings.TrimSpace(message.Recipient)
+		if message.Recipient == "" || !messagingService.HasChannel(message.Channel) {
+			continue
+		}
+		if throttled && selfScheduleRecipientThrottled(message.Channel, message.Recipient) {
+			log.Printf("Not sending another self-schedule %s message to %s today", message.Channel, message.Recipient)
+			continue
+		}
+		message.PatientID = patientID
+		message.SelfScheduleRequestID = &requestID
+		message.AppointmentID = appointmentID
+		message.Category = models.MessageCategorySelfScheduleConfirmation
+		message.ClinicID = request.ClinicID
+		if err := messagingService.Enqueue(message); err != nil {
+			log.Printf("Failed to queue %s message for self-schedule request %d: %v", message.Channel, request.ID, err)
+		}
+	}
+}
+
+// selfScheduleRecipientThrottled reports whether a recipient has had as many self-schedule
+// messages in the past day as anyone asking from the public form should get. The form takes any
+// address or number, so this keeps it from being used to send messages to others.
+func selfScheduleRecipientThrottled(channel models.MessageChannelType, recipient string) bool {
+	var count int64
+	database.DB.Model(&models.OutboundMessage{}).
+		Where("channel = ? AND recipient = ? AND category = ? AND created_at > ?",
+			channel, recipient, models.MessageCategorySelfScheduleConfirmation, time.Now().Add(-24*time.Hour)).
+		Count(&count)
+	return count >= selfScheduleMessagesPerDay
+}
+
+// formatMessageTime formats a time for patients. Times should already be in the branch's timezone.
+func formatMessageTime(t time.Time) string {
+	return t.Format("Monday, January 2, 2006 at 3:04 PM")
+}
+
+func clinicDisplayName(clinic models.Clinic, branch models.Branch) string {
+	if branch.Name != "" && branch.Name != clinic.Name {
+		return clinic.Name + " (" + branch.Name + ")"
+	}
+	return clinic.Name
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// GetOutboundMessages lists email and SMS messages sent to patients
+func GetOutboundMessages(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	query := database.DB.Model(&models.OutboundMessage{})
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	} else if clinicID := c.Query("clinic_id"); clinicID != "" {
+		query = query.Where("clinic_id = ?", clinicID)
+	}
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if channel := c.Query("channel"); channel != "" {
+		query = query.Where("channel = ?", channel)
+	}
+	if category := c.Query("category"); category != "" {
+		query = query.Where("category = ?", category)
+	}
+	if patientID := c.Query("patient_id"); patientID != "" {
+		query = query.Where("patient_id = ?", patientID)
+	}
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var messages []models.OutboundMessage
+	if err := query.Order("created_at DESC").Offset((page - 1) * limit).Limit(limit).Find(&messages).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch messages"})
+	}
+
+	return c.JSON(fiber.Map{
+		"messages": messages,
+		"total":    total,
+		"page":     page,
+		"limit":    limit,
+	})
+}
+
+// RetryOutboundMessage queues a failed message for another round of delivery attempts
+func RetryOutboundMessage(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	if messagingService == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "Messaging is not configured"})
+	}
+
+	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid message ID"})
+	}
+
+	var message models.OutboundMessage
+	query := database.DB.Where("id = ?", id)
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	}
+	if err := query.First(&message).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Message not found"})
+	}
+
+	if !message.CanRetry() {
+		return c.Status(409).JSON(fiber.Map{"error": "Only failed messages can be retried"})
+	}
+	if err := messagingService.Retry(&message); err != nil {
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	database.DB.First(&message, message.ID)
+	return c.JSON(message)
+}
@@ -1,6 +1,9 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"time"
 
@@ -8,50 +11,119 @@ import (
 	"dentika/server/models"
 )
 
-// StartAppointmentReminderService starts a background service to send appointment reminders
-func StartAppointmentReminderService() {
-	go func() {
-		ticker := time.NewTicker(5 * time.Minute) // Check every 5 minutes
-		defer ticker.Stop()
+var reminderOffsets = models.DefaultReminderOffsets
 
-		for {
-			select {
-			case <-ticker.C:
-				checkAndSendReminders()
-			}
-		}
-	}()
-	log.Println("Appointment reminder service started")
+// SetReminderOffsets sets how long before appointments reminders are sent
+func SetReminderOffsets(offsets []models.ReminderOffset) {
+	reminderOffsets = offsets
+}
+
+// scheduleAppointmentReminders plans, moves or cancels the stored reminders for an appointment
+func scheduleAppointmentReminders(appointment *models.Appointment) {
+	if err := models.SyncAppointmentReminders(database.DB, appointment, reminderOffsets); err != nil {
+		log.Printf("Failed to schedule reminders for appointment %d: %v", appointment.ID, err)
+	}
+}
+
+// SendAppointmentReminders is the scheduled job that sends reminders for upcoming appointments
+func SendAppointmentReminders(ctx context.Context) error {
+	if err := planMissingReminders(); err != nil {
+		log.Printf("Error planning appointment reminders: %v", err)
+	}
+	return sendDueReminders(ctx)
 }
 
-// checkAndSendReminders checks for upcoming appointments and sends reminders
-func checkAndSendReminders() {
+// planMissingReminders plans reminders for upcoming appointments that have none yet,
+// such as appointments booked before reminders were stored
+func planMissingReminders() error {
+	var longest time.Duration
+	for _, offset := range reminderOffsets {
+		if offset.Before > longest {
+			longest = offset.Before
+		}
+	}
+
 	now := time.Now()
-	fifteenMinutesFromNow := now.Add(15 * time.Minute)
-	thirtyMinutesFromNow := now.Add(30 * time.Minute)
-
-	// Find appointments starting in 15-30 minutes
-	var upcomingAppointments []models.Appointment
-	err := database.DB.Preload("Patient").Preload("Branch").
-		Where("start_time > ? AND start_time <= ? AND status IN (?, ?)",
-			fifteenMinutesFromNow, thirtyMinutesFromNow,
+	var appointments []models.Appointment
+	err := database.DB.
+		Where("start_time > ? AND start_time <= ? AND status IN (?, ?)", now, now.Add(longest),
 			models.StatusScheduled, models.StatusConfirmed).
-		Find(&upcomingAppointments).Error
+		Where("NOT EXISTS (SELECT 1 FROM appointment_reminders ar WHERE ar.appointment_id = appointments.id)").
+		Find(&appointments).Error
+	if err != nil {
+		return err
+	}
 
+	for i := range appointments {
+		scheduleAppointmentReminders(&appointments[i])
+	}
+	return nil
+}
+
+// sendDueReminders sends each due reminder once. A reminder is claimed by flipping is_sent
+// before sending, so overlapping runs and replicas cannot send it twice.
+func sendDueReminders(ctx context.Context) error {
+	now := time.Now()
+
+	var reminders []models.AppointmentReminder
+	err := database.DB.Preload("Appointment.Patient").Preload("Appointment.Branch").
+		Joins("JOIN appointments ON appointments.id = appointment_reminders.appointment_id AND appointments.deleted_at IS NULL").
+		Where("appointment_reminders.is_sent = ? AND appointment_reminders.cancelled_at IS NULL AND appointment_reminders.reminder_time <= ?", false, now).
+		Where("appointments.start_time > ? AND appointments.status IN (?, ?)", now, models.StatusScheduled, models.StatusConfirmed).
+		Order("appointment_reminders.reminder_time ASC").
+		Find(&reminders).Error
 	if err != nil {
-		log.Printf("Error fetching upcoming appointments: %v", err)
-		return
+		log.Printf("Error fetching due appointment reminders: %v", err)
+		return err
 	}
 
-	for _, appointment := range upcomingAppointments {
-		minutesUntil := int(appointment.StartTime.Sub(now).Minutes())
+	for _, reminder := range reminders {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
-		// Send reminder via notification service
-		if notificationService != nil {
-			go notificationService.CreateAppointmentReminder(appointment, minutesUntil)
+		claim := database.DB.Model(&models.AppointmentReminder{}).
+			Where("id = ? AND is_sent = ?", reminder.ID, false).
+			Updates(map[string]interface{}{"is_sent": true, "sent_at": now})
+		if claim.Error != nil || claim.RowsAffected == 0 {
+			continue
 		}
 
-		log.Printf("Sent reminder for appointment %d in clinic %d (%d minutes until start)", appointment.ID, appointment.Branch.ClinicID, minutesUntil)
+		if err := deliverAppointmentReminder(&reminder); err != nil {
+			if errors.Is(err, errNoRecipient) {
+				// Nothing to retry: keep the claim and record why it was not sent
+				database.DB.Model(&models.AppointmentReminder{}).Where("id = ?", reminder.ID).
+					Updates(map[string]interface{}{"sent_at": nil, "cancelled_at": now, "message": err.Error()})
+				continue
+			}
+			log.Printf("Failed to send reminder %d for appointment %d: %v", reminder.ID, reminder.AppointmentID, err)
+			// Release the claim so the next run retries
+			database.DB.Model(&models.AppointmentReminder{}).Where("id = ?", reminder.ID).
+				Updates(map[string]interface{}{"is_sent": false, "sent_at": nil})
+			continue
+		}
+
+		log.Printf("Sent %s reminder %d for appointment %d in clinic %d", reminder.OffsetLabel(), reminder.ID, reminder.AppointmentID, reminder.Appointment.ClinicID)
+	}
+	return nil
+}
+
+// deliverAppointmentReminder sends a reminder through its channel. Email and SMS reminders
+// are queued to the patient and delivered with retries by the outbound message job.
+func deliverAppointmentReminder(reminder *models.AppointmentReminder) error {
+	appointment := reminder.Appointment
+	minutesUntil := int(time.Until(appointment.StartTime).Minutes())
+
+	switch reminder.ReminderType {
+	case models.ReminderTypeInApp:
+		if notificationService == nil {
+			return fmt.Errorf("notification service is not available")
+		}
+		return notificationService.CreateAppointmentReminder(appointment, minutesUntil)
+	case models.ReminderTypeEmail, models.ReminderTypeSMS:
+		return queueAppointmentReminderMessage(reminder)
+	default:
+		return fmt.Errorf("unsupported reminder type %q", reminder.ReminderType)
 	}
 }
 
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"fmt"
 	"strconv"
 	"time"
 
@@ -38,6 +39,7 @@ type CreatePatientTreatmentPlanRequest struct {
 	EstimatedDuration int                             `json:"estimated_duration"`
 	StartDate         *time.Time                      `json:"start_date"`
 	TargetCompletion  *time.Time                      `json:"target_completion"`
+	BranchID          *uint                           `json:"branch_id"` // branch whose fee schedule prices the procedures
 	Procedures        []TreatmentPlanProcedureRequest `json:"procedures"`
 }
 
@@ -158,14 +160,14 @@ func CreatePatientDiagnosis(c *fiber.Ctx) error {
 		clinicID = user.ClinicID
 	}
 
-	// Verify diagnosis template exists
-	var template models.DiagnosisTemplate
-	if err := database.DB.First(&template, req.DiagnosisTemplateID).Error; err != nil {
+	// Verify the diagnosis is in the clinic's catalog, using the clinic's version if it has one
+	template, err := models.ResolveDiagnosisTemplate(database.DB, clinicID, req.DiagnosisTemplateID)
+	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid diagnosis template ID"})
 	}
 
 	diagnosis := models.PatientDiagnosis{
-		DiagnosisTemplateID: req.DiagnosisTemplateID,
+		DiagnosisTemplateID: template.ID,
 		PatientID:           uint(patientID),
 		ClinicID:            clinicID,
 		ToothNumber:         req.ToothNumber,
@@ -385,6 +387,21 @@ func CreatePatientTreatmentPlan(c *fiber.Ctx) error {
 		CreatedByID:       user.ID,
 	}
 
+	// Every procedure must be in the clinic's catalog. Use the clinic's version of each, and price
+	// it from the applicable fee schedule when no estimate is given
+	scope := procedureFeeScope(clinicID, req.BranchID, uint(patientID), time.Now())
+	today := scope.Today(database.DB)
+	for i, procReq := range req.Procedures {
+		template, err := models.ResolveProcedureTemplate(database.DB, clinicID, procReq.ProcedureTemplateID)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Procedure template %d not found", procReq.ProcedureTemplateID)})
+		}
+		req.Procedures[i].ProcedureTemplateID = template.ID
+		if procReq.EstimatedCost == 0 {
+			req.Procedures[i].EstimatedCost = models.ResolveProcedureFee(database.DB, scope, *template, today).Price
+		}
+	}
+
 	if err := database.DB.Create(&treatmentPlan).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to create patient treatment plan"})
 	}
@@ -403,6 +420,7 @@ func CreatePatientTreatmentPlan(c *fiber.Ctx) error {
 			}
 			database.DB.Create(&procedure)
 		}
+		recalculateTreatmentPlan(treatmentPlan.ID)
 	}
 
 	// Preload relationships for response
@@ -487,6 +505,10 @@ func UpdatePatientTreatmentPlan(c *fiber.Ctx) error {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update patient treatment plan"})
 	}
 
+	// Plans with items track their cost and visits from the items
+	recalculateTreatmentPlan(treatmentPlan.ID)
+	database.DB.First(&treatmentPlan, treatmentPlan.ID)
+
 	return c.JSON(treatmentPlan)
 }
 
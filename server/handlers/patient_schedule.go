@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"log"
 	"strconv"
 	"strings"
 	"time"
@@ -103,6 +104,8 @@ func CreatePatientSelfSchedule(c *fiber.Ctx) error {
 	// Reload with relationships
 	database.DB.Preload("Clinic").Preload("Branch").First(&scheduleRequest, scheduleRequest.ID)
 
+	queueSelfScheduleConfirmation(&scheduleRequest)
+
 	return c.Status(201).JSON(scheduleRequest)
 }
 
@@ -142,13 +145,11 @@ func GetAvailableTimeSlots(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Date is required"})
 	}
 
-	// Parse date
+	// Parse date; slots are generated in each branch's own timezone
 	date, err := time.Parse("2006-01-02", dateStr)
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid date format"})
 	}
-	// Ensure date uses the same location as the server
-	date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.Local)
 
 	// Get clinic
 	var clinic models.Clinic
@@ -170,120 +171,54 @@ func GetAvailableTimeSlots(c *fiber.Ctx) error {
 		}
 	}
 
-	// Generate available time slots (9 AM to 5 PM in 30-minute increments)
-	availableSlots := generateAvailableTimeSlots(date, clinic.ID, branchID)
+	// Optionally for one doctor
+	var doctorID *uint
+	if doctorIDStr := c.Query("doctor_id"); doctorIDStr != "" {
+		if id, err := strconv.ParseUint(doctorIDStr, 10, 32); err == nil {
+			doctorID = &[]uint{uint(id)}[0]
+		}
+	}
+
+	duration := slotInterval
+	if minutes, err := strconv.Atoi(c.Query("duration")); err == nil && minutes > 0 && minutes <= 8*60 {
+		duration = time.Duration(minutes) * time.Minute
+	}
+
+	// Generate 30-minute slots from each doctor's working hours, leave and bookings
+	availableSlots, doctors := generateAvailableTimeSlots(date, clinic.ID, branchID, doctorID, duration)
+
+	// Public view of each doctor's slots
+	perDoctor := make([]fiber.Map, 0, len(doctors))
+	for _, doctor := range doctors {
+		perDoctor = append(perDoctor, fiber.Map{
+			"doctor_id":   doctor.DoctorID,
+			"doctor_name": doctor.DoctorName,
+			"branch_id":   doctor.BranchID,
+			"slots":       doctor.Slots,
+		})
+	}
 
 	return c.JSON(fiber.Map{
 		"available_slots": availableSlots,
+		"doctors":         perDoctor,
 		"date":            dateStr,
 	})
 }
 
-func generateAvailableTimeSlots(date time.Time, clinicID uint, branchID *uint) []fiber.Map {
-	var availableSlots []fiber.Map
-
-	// Get the operating hours for this date
-	operatingHours := getBranchOperatingHours(date, clinicID, branchID)
-	if len(operatingHours) == 0 {
-		// No operating hours available for this date
-		return availableSlots
-	}
-
-	// Get existing appointments for this date
-	var existingAppointments []models.Appointment
-
-	// Use time range instead of DATE() function to avoid timezone issues
-	startOfDay := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.Local)
-	endOfDay := startOfDay.Add(24 * time.Hour)
-
-	query := database.DB.Where("start_time >= ? AND start_time < ? AND clinic_id = ? AND status IN (?)",
-		startOfDay, endOfDay, clinicID,
-		[]models.AppointmentStatus{models.StatusScheduled, models.StatusConfirmed})
-
-	// If a specific branch is selected, filter by that branch
-	// If no branch is selected, we still want to find all appointments to block those times
-	// (since the patient might be assigned to any branch)
-	if branchID != nil {
-		query = query.Where("branch_id = ?", *branchID)
-	}
-
-	query.Find(&existingAppointments)
-
-	// Create a map of occupied time slots
-	occupiedSlots := make(map[string]bool)
-	for _, appointment := range existingAppointments {
-		// Mark the appointment time and any overlapping 30-minute slots
-		appointmentStart := appointment.StartTime
-		appointmentEnd := appointment.EndTime
-
-		// Generate all 30-minute slots that this appointment occupies
-		currentSlot := time.Date(appointmentStart.Year(), appointmentStart.Month(), appointmentStart.Day(),
-			appointmentStart.Hour(), appointmentStart.Minute(), 0, 0, time.Local)
-
-		// Round current slot to nearest 30-minute boundary
-		minutes := currentSlot.Minute()
-		if minutes%30 != 0 {
-			// Round up to next 30-minute boundary
-			currentSlot = currentSlot.Add(time.Duration(30-minutes%30) * time.Minute)
-		}
-
-		// Mark all slots that this appointment occupies
-		for currentSlot.Before(appointmentEnd) || currentSlot.Equal(appointmentEnd) {
-			// Mark the slot as occupied regardless of operating hours
-			// We'll filter by operating hours when generating available slots
-			slotKey := currentSlot.Format("15:04")
-			occupiedSlots[slotKey] = true
-			currentSlot = currentSlot.Add(30 * time.Minute)
-		}
+// generateAvailableTimeSlots pools the open slots of every doctor working on the date. A slot is
+// available while at least one doctor is free for it; doctor_ids lists who can take it.
+func generateAvailableTimeSlots(date time.Time, clinicID uint, branchID *uint, doctorID *uint, duration time.Duration) ([]AvailableSlot, []DoctorAvailability) {
+	availability, err := computeDoctorAvailability(date, clinicID, branchID, doctorID)
+	if err != nil {
+		log.Printf("Failed to compute doctor availability for clinic %d on %s: %v", clinicID, date.Format("2006-01-02"), err)
+		return []AvailableSlot{}, []DoctorAvailability{}
 	}
 
-	// Generate all possible 30-minute time slots for each operating period
 	now := time.Now()
-	for _, period := range operatingHours {
-		// Parse start and end times
-		startTime, err := time.Parse("15:04", period.Start)
-		if err != nil {
-			continue // Skip invalid time format
-		}
-		endTime, err := time.Parse("15:04", period.End)
-		if err != nil {
-			continue // Skip invalid time format
-		}
-
-		startHour := startTime.Hour()
-		startMinute := startTime.Minute()
-		endHour := endTime.Hour()
-		endMinute := endTime.Minute()
-
-		// Generate slots for this period
-		currentSlot := time.Date(date.Year(), date.Month(), date.Day(), startHour, startMinute, 0, 0, time.Local)
-		endSlot := time.Date(date.Year(), date.Month(), date.Day(), endHour, endMinute, 0, 0, time.Local)
-
-		// Round start time to nearest 30-minute boundary (up)
-		if currentSlot.Minute()%30 != 0 {
-			minutes := currentSlot.Minute()
-			currentSlot = currentSlot.Add(time.Duration(30-minutes%30) * time.Minute)
-		}
-
-		for currentSlot.Before(endSlot) {
-			slotKey := currentSlot.Format("15:04")
-
-			// Skip slots that are occupied by existing appointments
-			if !occupiedSlots[slotKey] {
-				// Only include slots that are in the future (if today) or any slot for future dates
-				if currentSlot.After(now) || currentSlot.Format("2006-01-02") != now.Format("2006-01-02") {
-					availableSlots = append(availableSlots, fiber.Map{
-						"time":     slotKey,
-						"datetime": currentSlot.Format("2006-01-02 15:04"),
-						"display":  currentSlot.Format("3:04 PM"),
-					})
-				}
-			}
-			currentSlot = currentSlot.Add(30 * time.Minute)
-		}
+	for i := range availability {
+		availability[i].Slots = slotsFromRanges(availability[i].Free, duration, now)
 	}
-
-	return availableSlots
+	return poolSlots(availability), availability
 }
 
 // SchedulePeriod represents a time period (e.g., 09:00-13:00)
@@ -313,32 +248,21 @@ type BranchSchedule struct {
 	Timezone string `json:"timezone"`
 }
 
-// getBranchOperatingHours returns the operating hours for a given date and branch
-func getBranchOperatingHours(date time.Time, clinicID uint, branchID *uint) []SchedulePeriod {
-	// Get branches
-	var branches []models.Branch
-	query := database.DB.Where("clinic_id = ? AND is_active = ?", clinicID, true)
-
-	if branchID != nil {
-		query = query.Where("id = ?", *branchID)
-	}
-
-	if err := query.Find(&branches).Error; err != nil {
-		return []SchedulePeriod{}
-	}
-
-	if len(branches) == 0 {
-		return []SchedulePeriod{}
-	}
-
-	// Use the first branch (or the specified branch)
-	branch := branches[0]
-
+// branchOperatingPeriods returns a branch's opening periods on a given date. A schedule exception
+// for the date, if any, replaces the weekly schedule.
+func branchOperatingPeriods(branch models.Branch, date time.Time, exception *models.BranchScheduleException) []SchedulePeriod {
 	// Check if the branch is closed today (emergency override)
 	if branch.IsClosedToday {
 		return []SchedulePeriod{}
 	}
 
+	if exception != nil {
+		if exception.Type == models.ExceptionClosed {
+			return []SchedulePeriod{}
+		}
+		return []SchedulePeriod{{Start: exception.StartTime, End: exception.EndTime}}
+	}
+
 	// Parse the schedule JSON
 	if branch.Schedule == "" {
 		// Fallback to default hours if no schedule is set
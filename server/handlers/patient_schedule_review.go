@@ -0,0 +1,399 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type ConvertSelfScheduleRequest struct {
+	// Link to an existing patient; when empty a patient is matched by phone or email, or created
+	PatientID *uint `json:"patient_id"`
+
+	DoctorID uint  `json:"doctor_id"`
+	BranchID *uint `json:"branch_id"` // defaults to the requested branch
+
+	// Defaults to the patient's preferred date and time
+	Date     string `json:"date"` // Format: "2006-01-02"
+	Time     string `json:"time"` // Format: "15:04"
+	Duration int    `json:"duration"`
+
+	ResourceIDs []uint `json:"resource_ids"` // chairs, rooms and equipment to book
+
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	ReviewNotes string `json:"review_notes"`
+}
+
+// findSelfScheduleRequest loads a request the user is allowed to act on
+func findSelfScheduleRequest(c *fiber.Ctx, user models.User) (*models.PatientSelfScheduleRequest, error) {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Invalid request ID"})
+	}
+
+	var request models.PatientSelfScheduleRequest
+	query := database.DB.Preload("Clinic").Preload("Branch").Preload("ReviewedBy").Where("id = ?", id)
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	}
+	if err := query.First(&request).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "Self-schedule request not found"})
+	}
+	return &request, nil
+}
+
+// GetPatientSelfScheduleRequests lists self-schedule requests for the clinic, newest first
+func GetPatientSelfScheduleRequests(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	query := database.DB.Model(&models.PatientSelfScheduleRequest{})
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	} else if clinicID := c.Query("clinic_id"); clinicID != "" {
+		query = query.Where("clinic_id = ?", clinicID)
+	}
+
+	if branchID := c.Query("branch_id"); branchID != "" {
+		query = query.Where("branch_id = ?", branchID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status IN ?", strings.Split(status, ","))
+	}
+	if search := c.Query("search"); search != "" {
+		like := "%" + search + "%"
+		query = query.Where("first_name LIKE ? OR last_name LIKE ? OR email LIKE ? OR phone LIKE ?", like, like, like, like)
+	}
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var requests []models.PatientSelfScheduleRequest
+	if err := query.Preload("Branch").Preload("ReviewedBy").
+		Order("created_at DESC").Offset((page - 1) * limit).Limit(limit).
+		Find(&requests).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch self-schedule requests"})
+	}
+
+	return c.JSON(fiber.Map{
+		"requests": requests,
+		"total":    total,
+		"page":     page,
+		"limit":    limit,
+	})
+}
+
+// GetPatientSelfScheduleRequest returns a single self-schedule request
+func GetPatientSelfScheduleRequest(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	request, err := findSelfScheduleRequest(c, user)
+	if request == nil {
+		return err
+	}
+
+	if request.ConvertedToAppointmentID != nil {
+		database.DB.Preload("Patient").Preload("Doctor").First(&request.ConvertedToAppointment, *request.ConvertedToAppointmentID)
+	}
+
+	return c.JSON(request)
+}
+
+// ReviewPatientSelfScheduleRequest marks a request reviewed, approved or rejected with notes
+func ReviewPatientSelfScheduleRequest(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	request, err := findSelfScheduleRequest(c, user)
+	if request == nil {
+		return err
+	}
+
+	var req UpdatePatientSelfScheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	switch req.Status {
+	case models.PatientScheduleStatusReviewed, models.PatientScheduleStatusApproved, models.PatientScheduleStatusRejected:
+	case models.PatientScheduleStatusConverted:
+		return c.Status(400).JSON(fiber.Map{"error": "Use the convert action to convert a request into an appointment"})
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "Status must be reviewed, approved or rejected"})
+	}
+
+	if request.IsClosed() {
+		return c.Status(409).JSON(fiber.Map{"error": fmt.Sprintf("Request is already %s", request.Status)})
+	}
+	if req.Status == models.PatientScheduleStatusRejected && strings.TrimSpace(req.ReviewNotes) == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Review notes are required when rejecting a request"})
+	}
+
+	// Reviewer is always the current user
+	now := time.Now()
+	result := database.DB.Model(&models.PatientSelfScheduleRequest{}).
+		Where("id = ? AND status NOT IN ?", request.ID, []models.PatientSelfScheduleStatus{
+			models.PatientScheduleStatusRejected, models.PatientScheduleStatusConverted,
+		}).
+		Updates(map[string]interface{}{
+			"status":         req.Status,
+			"review_notes":   req.ReviewNotes,
+			"reviewed_by_id": user.ID,
+			"reviewed_at":    now,
+		})
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update self-schedule request"})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "Request was already closed"})
+	}
+
+	database.DB.Preload("Clinic").Preload("Branch").Preload("ReviewedBy").First(request, request.ID)
+
+	if request.Status == models.PatientScheduleStatusRejected {
+		queueSelfScheduleDecision(request, nil)
+	}
+
+	return c.JSON(request)
+}
+
+// ConvertPatientSelfScheduleRequest books an appointment for a request, linking or creating the patient
+func ConvertPatientSelfScheduleRequest(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	request, err := findSelfScheduleRequest(c, user)
+	if request == nil {
+		return err
+	}
+	if request.IsClosed() {
+		return c.Status(409).JSON(fiber.Map{"error": fmt.Sprintf("Request is already %s", request.Status)})
+	}
+
+	var req ConvertSelfScheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if req.DoctorID == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Doctor is required"})
+	}
+
+	// Branch
+	branchID := req.BranchID
+	if branchID == nil {
+		branchID = request.BranchID
+	}
+	if branchID == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Branch is required"})
+	}
+	var branch models.Branch
+	if err := database.DB.Where("id = ? AND clinic_id = ?", *branchID, request.ClinicID).First(&branch).Error; err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid branch for this clinic"})
+	}
+
+	// Doctor
+	var doctor models.User
+	if err := database.DB.First(&doctor, req.DoctorID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Doctor not found"})
+	}
+	if !doctor.IsSuperAdmin() && doctor.ClinicID != request.ClinicID {
+		return c.Status(400).JSON(fiber.Map{"error": "Doctor does not belong to the same clinic"})
+	}
+
+	// Time, defaulting to what the patient asked for
+	date, clock := req.Date, req.Time
+	if date == "" && request.PreferredDate != nil {
+		date = request.PreferredDate.Format("2006-01-02")
+	}
+	if clock == "" {
+		clock = request.PreferredTime
+	}
+	if date == "" || clock == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Date and time are required"})
+	}
+	location := models.BranchLocation(database.DB, branch.ID)
+	startTime, err := time.ParseInLocation("2006-01-02 15:04", date+" "+clock, location)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid date or time format"})
+	}
+	if req.Duration <= 0 {
+		req.Duration = 30
+	}
+	endTime := startTime.Add(time.Duration(req.Duration) * time.Minute)
+
+	// The same checks as any other booking: opening hours, the doctor's time and the resources
+	resources, reason, details := checkOccurrence(req.DoctorID, branch.ID, startTime, endTime, 0, req.ResourceIDs, nil)
+	if reason != "" {
+		return occurrenceConflictResponse(c, reason, details)
+	}
+
+	title := req.Title
+	if title == "" {
+		title = "Consultation"
+	}
+	description := req.Description
+	if description == "" {
+		description = request.Symptoms
+	}
+
+	tx := database.DB.Begin()
+
+	// Claim the request so it cannot be converted twice
+	claim := tx.Model(&models.PatientSelfScheduleRequest{}).
+		Where("id = ? AND status NOT IN ?", request.ID, []models.PatientSelfScheduleStatus{
+			models.PatientScheduleStatusRejected, models.PatientScheduleStatusConverted,
+		}).
+		Update("status", models.PatientScheduleStatusConverted)
+	if claim.Error != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to convert self-schedule request"})
+	}
+	if claim.RowsAffected == 0 {
+		tx.Rollback()
+		return c.Status(409).JSON(fiber.Map{"error": "Request was already closed"})
+	}
+
+	var conflicts []models.Appointment
+	if err := tx.Where("doctor_id = ? AND status IN ? AND start_time < ? AND end_time > ?",
+		req.DoctorID, models.BlockingStatuses, endTime, startTime).Find(&conflicts).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to check for conflicts"})
+	}
+	if len(conflicts) > 0 {
+		tx.Rollback()
+		return c.Status(409).JSON(fiber.Map{
+			"error":     "Doctor has conflicting appointment at this time",
+			"conflicts": conflicts,
+		})
+	}
+	resourceConflicts, err := lockAppointmentResources(tx, resources, startTime, endTime, 0)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to check for conflicts"})
+	}
+	if len(resourceConflicts) > 0 {
+		tx.Rollback()
+		return occurrenceConflictResponse(c, "resource_conflict", resourceConflicts)
+	}
+
+	patient, created, err := resolveSelfSchedulePatient(tx, request, req.PatientID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	appointment := models.Appointment{
+		Title:               title,
+		Description:         description,
+		StartTime:           startTime,
+		EndTime:             endTime,
+		Duration:            req.Duration,
+		Status:              models.StatusScheduled,
+		PatientID:           patient.ID,
+		DoctorID:            req.DoctorID,
+		BranchID:            branch.ID,
+		ClinicID:            request.ClinicID,
+		PreAppointmentNotes: request.AdditionalNotes,
+		Resources:           appointmentResourceRows(0, resources),
+	}
+	if err := tx.Create(&appointment).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create appointment"})
+	}
+
+	reviewNotes := req.ReviewNotes
+	if reviewNotes == "" {
+		reviewNotes = request.ReviewNotes
+	}
+	now := time.Now()
+	if err := tx.Model(&models.PatientSelfScheduleRequest{}).Where("id = ?", request.ID).Updates(map[string]interface{}{
+		"converted_to_appointment_id": appointment.ID,
+		"review_notes":                reviewNotes,
+		"reviewed_by_id":              user.ID,
+		"reviewed_at":                 now,
+	}).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to convert self-schedule request"})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to convert self-schedule request"})
+	}
+
+	if created {
+		go createInitialDentalRecords(patient.ID, patient.ClinicID)
+	}
+	scheduleAppointmentReminders(&appointment)
+
+	database.DB.Preload("Patient").Preload("Doctor").Preload("Branch").Preload("Resources.Resource").First(&appointment, appointment.ID)
+	database.DB.Preload("Clinic").Preload("Branch").Preload("ReviewedBy").First(request, request.ID)
+	request.ConvertedToAppointment = appointment
+
+	queueSelfScheduleDecision(request, &appointment)
+	go SendAppointmentUpdate(appointment.ID, appointment.Patient.GetFullName(), "scheduled", appointment.ClinicID)
+
+	return c.Status(201).JSON(fiber.Map{
+		"request":         request,
+		"appointment":     appointment,
+		"patient_created": created,
+	})
+}
+
+// resolveSelfSchedulePatient returns the patient to book for a request: the given patient,
+// an active patient in the clinic with the same phone or email, or a newly created one
+func resolveSelfSchedulePatient(tx *gorm.DB, request *models.PatientSelfScheduleRequest, patientID *uint) (*models.Patient, bool, error) {
+	var patient models.Patient
+
+	if patientID != nil {
+		if err := tx.Where("id = ? AND clinic_id = ?", *patientID, request.ClinicID).First(&patient).Error; err != nil {
+			return nil, false, fmt.Errorf("patient not found in this clinic")
+		}
+		return &patient, false, nil
+	}
+
+	phone := cleanPhoneNumber(request.Phone)
+	query := tx.Where("clinic_id = ? AND is_active = ?", request.ClinicID, true)
+	if request.Email != "" {
+		query = query.Where("phone IN ? OR email = ?", []string{phone, request.Phone}, request.Email)
+	} else {
+		query = query.Where("phone IN ?", []string{phone, request.Phone})
+	}
+	if err := query.Order("id ASC").First(&patient).Error; err == nil {
+		return &patient, false, nil
+	}
+
+	patientNumber, err := models.GenerateUniquePatientNumber(request.ClinicID, tx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to generate patient number")
+	}
+	patient = models.Patient{
+		PatientNumber:     patientNumber,
+		FirstName:         request.FirstName,
+		LastName:          request.LastName,
+		Email:             request.Email,
+		Phone:             phone,
+		PreferredLanguage: "English",
+		IsActive:          true,
+		ClinicID:          request.ClinicID,
+	}
+	if err := tx.Create(&patient).Error; err != nil {
+		log.Printf("Failed to create patient for self-schedule request %d: %v", request.ID, err)
+		return nil, false, fmt.Errorf("failed to create patient")
+	}
+	return &patient, true, nil
+}
@@ -10,10 +10,120 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
+type ProcedureTemplateRequest struct {
+	Code              *string  `json:"code"`
+	Name              *string  `json:"name"`
+	Description       *string  `json:"description"`
+	Category          *string  `json:"category"`
+	EstimatedDuration *int     `json:"estimated_duration"`
+	DefaultCost       *float64 `json:"default_cost"`
+	IsActive          *bool    `json:"is_active"`
+}
+
+type DiagnosisTemplateRequest struct {
+	Code        *string `json:"code"`
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Category    *string `json:"category"`
+	Severity    *string `json:"severity"`
+	IsActive    *bool   `json:"is_active"`
+}
+
+// templateCatalogClinic is the catalog a template listing shows: the user's clinic, or for super
+// admins the platform catalog unless a clinic_id is asked for
+func templateCatalogClinic(c *fiber.Ctx, user models.User) uint {
+	if !user.IsSuperAdmin() {
+		return user.ClinicID
+	}
+	if id, err := strconv.ParseUint(c.Query("clinic_id"), 10, 32); err == nil {
+		return uint(id)
+	}
+	return models.PlatformCatalogID
+}
+
+// findManagedProcedureTemplate loads a procedure template the user may change: a platform template
+// or one of their clinic's
+func findManagedProcedureTemplate(c *fiber.Ctx, user models.User) (*models.ProcedureTemplate, error) {
+	var template models.ProcedureTemplate
+	query := database.DB.Preload("ResourceRequirements")
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id IN ?", []uint{models.PlatformCatalogID, user.ClinicID})
+	}
+	if err := query.First(&template, c.Params("id")).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "Procedure template not found"})
+	}
+	return &template, nil
+}
+
+// procedureTemplateForEdit returns the template a change applies to. Clinic users never change a
+// platform template; their change goes to the clinic's override of it, created on first change.
+func procedureTemplateForEdit(template *models.ProcedureTemplate, user models.User) (*models.ProcedureTemplate, error) {
+	if !template.IsPlatform() || user.IsSuperAdmin() {
+		return template, nil
+	}
+	var override models.ProcedureTemplate
+	if err := database.DB.Preload("ResourceRequirements").Where("code = ? AND clinic_id = ?", template.Code, user.ClinicID).First(&override).Error; err == nil {
+		return &override, nil
+	}
+	override = template.OverrideFor(user.ClinicID)
+	tx := database.DB.Begin()
+	if err := tx.Create(&override).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := models.RepointClinicFeeItems(tx, user.ClinicID, template.ID, override.ID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return &override, nil
+}
+
+// findManagedDiagnosisTemplate loads a diagnosis template the user may change: a platform template
+// or one of their clinic's
+func findManagedDiagnosisTemplate(c *fiber.Ctx, user models.User) (*models.DiagnosisTemplate, error) {
+	var template models.DiagnosisTemplate
+	query := database.DB
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id IN ?", []uint{models.PlatformCatalogID, user.ClinicID})
+	}
+	if err := query.First(&template, c.Params("id")).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "Diagnosis template not found"})
+	}
+	return &template, nil
+}
+
+// diagnosisTemplateForEdit returns the template a change applies to. Clinic users never change a
+// platform template; their change goes to the clinic's override of it, created on first change.
+func diagnosisTemplateForEdit(template *models.DiagnosisTemplate, user models.User) (*models.DiagnosisTemplate, error) {
+	if !template.IsPlatform() || user.IsSuperAdmin() {
+		return template, nil
+	}
+	var override models.DiagnosisTemplate
+	if err := database.DB.Where("code = ? AND clinic_id = ?", template.Code, user.ClinicID).First(&override).Error; err == nil {
+		return &override, nil
+	}
+	override = template.OverrideFor(user.ClinicID)
+	if err := database.DB.Create(&override).Error; err != nil {
+		return nil, err
+	}
+	return &override, nil
+}
+
 // Procedure Template Handlers
+
+// GetProcedureTemplates lists the clinic's procedure catalog: the platform templates with the
+// clinic's overrides and additions. Admins can pass include_inactive=true to see deactivated ones.
 func GetProcedureTemplates(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
 	var templates []models.ProcedureTemplate
-	query := database.DB.Where("is_active = ?", true)
+	query := models.ProcedureCatalog(database.DB, templateCatalogClinic(c, user))
+	if c.Query("include_inactive") != "true" || (!user.IsSuperAdmin() && !user.HasRole(models.Admin)) {
+		query = query.Where("is_active = ?", true)
+	}
 
 	// Filter by category if provided
 	if category := c.Query("category"); category != "" {
@@ -26,13 +136,15 @@ func GetProcedureTemplates(c *fiber.Ctx) error {
 			"%"+search+"%", "%"+search+"%", "%"+search+"%")
 	}
 
-	if err := query.Order("category ASC, name ASC").Find(&templates).Error; err != nil {
+	if err := query.Preload("ResourceRequirements").Order("category ASC, name ASC").Find(&templates).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch procedure templates"})
 	}
 
 	return c.JSON(templates)
 }
 
+// CreateProcedureTemplate adds a template to the clinic's catalog, or to the platform catalog for
+// super admins. A clinic template with a platform template's code overrides it for the clinic.
 func CreateProcedureTemplate(c *fiber.Ctx) error {
 	user := c.Locals("user").(models.User)
 
@@ -50,9 +162,15 @@ func CreateProcedureTemplate(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Code and name are required"})
 	}
 
-	// Check for duplicate code
+	// Super admins create in the platform catalog unless they name a clinic
+	req.ID = 0
+	if !user.IsSuperAdmin() {
+		req.ClinicID = user.ClinicID
+	}
+
+	// Check for duplicate code within the same catalog
 	var existing models.ProcedureTemplate
-	if err := database.DB.Where("code = ?", req.Code).First(&existing).Error; err == nil {
+	if err := database.DB.Where("code = ? AND clinic_id = ?", req.Code, req.ClinicID).First(&existing).Error; err == nil {
 		return c.Status(409).JSON(fiber.Map{"error": "Procedure code already exists"})
 	}
 
@@ -65,10 +183,128 @@ func CreateProcedureTemplate(c *fiber.Ctx) error {
 	return c.Status(201).JSON(req)
 }
 
+// UpdateProcedureTemplate changes a procedure template. Changing a platform template as a clinic
+// user changes the clinic's own copy, leaving other clinics unaffected.
+func UpdateProcedureTemplate(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	found, err := findManagedProcedureTemplate(c, user)
+	if found == nil {
+		return err
+	}
+
+	var req ProcedureTemplateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if req.Code != nil && *req.Code != found.Code {
+		if found.IsPlatform() && !user.IsSuperAdmin() {
+			return c.Status(400).JSON(fiber.Map{"error": "The code of a platform template cannot be changed; create a new template instead"})
+		}
+		if *req.Code == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "Code is required"})
+		}
+		var existing models.ProcedureTemplate
+		if err := database.DB.Where("code = ? AND clinic_id = ? AND id <> ?", *req.Code, found.ClinicID, found.ID).First(&existing).Error; err == nil {
+			return c.Status(409).JSON(fiber.Map{"error": "Procedure code already exists"})
+		}
+	}
+	if req.Name != nil && *req.Name == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Name is required"})
+	}
+	if req.DefaultCost != nil && *req.DefaultCost < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Default cost cannot be negative"})
+	}
+
+	// Validate everything first: editing a platform template creates the clinic's override
+	template, err := procedureTemplateForEdit(found, user)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update procedure template"})
+	}
+
+	if req.Code != nil {
+		template.Code = *req.Code
+	}
+	if req.Name != nil {
+		template.Name = *req.Name
+	}
+	if req.Description != nil {
+		template.Description = *req.Description
+	}
+	if req.Category != nil {
+		template.Category = *req.Category
+	}
+	if req.EstimatedDuration != nil {
+		template.EstimatedDuration = *req.EstimatedDuration
+	}
+	if req.DefaultCost != nil {
+		template.DefaultCost = *req.DefaultCost
+	}
+	if req.IsActive != nil {
+		template.IsActive = *req.IsActive
+	}
+
+	if err := database.DB.Omit("ResourceRequirements").Save(template).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update procedure template"})
+	}
+
+	return c.JSON(template)
+}
+
+// DeleteProcedureTemplate removes a procedure template. Templates that appointments, treatment
+// plans or fee schedules use are deactivated instead, and a clinic deleting a platform template
+// deactivates it for the clinic only. Deleting a clinic's override brings back the platform one.
+func DeleteProcedureTemplate(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	template, errResp := findManagedProcedureTemplate(c, user)
+	if template == nil {
+		return errResp
+	}
+
+	if template.IsPlatform() && !user.IsSuperAdmin() {
+		override, err := procedureTemplateForEdit(template, user)
+		if err != nil || database.DB.Model(override).Update("is_active", false).Error != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to deactivate procedure template"})
+		}
+		return c.JSON(fiber.Map{"message": "Procedure template deactivated for this clinic", "template": override})
+	}
+
+	if models.ProcedureTemplateInUse(database.DB, template.ID) {
+		if err := database.DB.Model(template).Update("is_active", false).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to deactivate procedure template"})
+		}
+		return c.JSON(fiber.Map{"message": "Procedure template is in use and was deactivated instead", "template": template})
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Where("procedure_template_id = ?", template.ID).Delete(&models.ProcedureResourceRequirement{}).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete procedure template"})
+	}
+	if err := tx.Unscoped().Delete(template).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete procedure template"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete procedure template"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Procedure template deleted successfully"})
+}
+
 // Diagnosis Template Handlers
+
+// GetDiagnosisTemplates lists the clinic's diagnosis catalog: the platform templates with the
+// clinic's overrides and additions. Admins can pass include_inactive=true to see deactivated ones.
 func GetDiagnosisTemplates(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
 	var templates []models.DiagnosisTemplate
-	query := database.DB.Where("is_active = ?", true)
+	query := models.DiagnosisCatalog(database.DB, templateCatalogClinic(c, user))
+	if c.Query("include_inactive") != "true" || (!user.IsSuperAdmin() && !user.HasRole(models.Admin)) {
+		query = query.Where("is_active = ?", true)
+	}
 
 	// Filter by category if provided
 	if category := c.Query("category"); category != "" {
@@ -93,6 +329,8 @@ func GetDiagnosisTemplates(c *fiber.Ctx) error {
 	return c.JSON(templates)
 }
 
+// CreateDiagnosisTemplate adds a template to the clinic's catalog, or to the platform catalog for
+// super admins. A clinic template with a platform template's code overrides it for the clinic.
 func CreateDiagnosisTemplate(c *fiber.Ctx) error {
 	user := c.Locals("user").(models.User)
 
@@ -110,9 +348,15 @@ func CreateDiagnosisTemplate(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Code and name are required"})
 	}
 
-	// Check for duplicate code
+	// Super admins create in the platform catalog unless they name a clinic
+	req.ID = 0
+	if !user.IsSuperAdmin() {
+		req.ClinicID = user.ClinicID
+	}
+
+	// Check for duplicate code within the same catalog
 	var existing models.DiagnosisTemplate
-	if err := database.DB.Where("code = ?", req.Code).First(&existing).Error; err == nil {
+	if err := database.DB.Where("code = ? AND clinic_id = ?", req.Code, req.ClinicID).First(&existing).Error; err == nil {
 		return c.Status(409).JSON(fiber.Map{"error": "Diagnosis code already exists"})
 	}
 
@@ -125,6 +369,100 @@ func CreateDiagnosisTemplate(c *fiber.Ctx) error {
 	return c.Status(201).JSON(req)
 }
 
+// UpdateDiagnosisTemplate changes a diagnosis template. Changing a platform template as a clinic
+// user changes the clinic's own copy, leaving other clinics unaffected.
+func UpdateDiagnosisTemplate(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	found, err := findManagedDiagnosisTemplate(c, user)
+	if found == nil {
+		return err
+	}
+
+	var req DiagnosisTemplateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if req.Code != nil && *req.Code != found.Code {
+		if found.IsPlatform() && !user.IsSuperAdmin() {
+			return c.Status(400).JSON(fiber.Map{"error": "The code of a platform template cannot be changed; create a new template instead"})
+		}
+		if *req.Code == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "Code is required"})
+		}
+		var existing models.DiagnosisTemplate
+		if err := database.DB.Where("code = ? AND clinic_id = ? AND id <> ?", *req.Code, found.ClinicID, found.ID).First(&existing).Error; err == nil {
+			return c.Status(409).JSON(fiber.Map{"error": "Diagnosis code already exists"})
+		}
+	}
+	if req.Name != nil && *req.Name == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Name is required"})
+	}
+
+	template, err := diagnosisTemplateForEdit(found, user)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update diagnosis template"})
+	}
+
+	if req.Code != nil {
+		template.Code = *req.Code
+	}
+	if req.Name != nil {
+		template.Name = *req.Name
+	}
+	if req.Description != nil {
+		template.Description = *req.Description
+	}
+	if req.Category != nil {
+		template.Category = *req.Category
+	}
+	if req.Severity != nil {
+		template.Severity = *req.Severity
+	}
+	if req.IsActive != nil {
+		template.IsActive = *req.IsActive
+	}
+
+	if err := database.DB.Save(template).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update diagnosis template"})
+	}
+
+	return c.JSON(template)
+}
+
+// DeleteDiagnosisTemplate removes a diagnosis template. Templates that diagnoses use are
+// deactivated instead, and a clinic deleting a platform template deactivates it for the clinic
+// only. Deleting a clinic's override brings back the platform one.
+func DeleteDiagnosisTemplate(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	template, errResp := findManagedDiagnosisTemplate(c, user)
+	if template == nil {
+		return errResp
+	}
+
+	if template.IsPlatform() && !user.IsSuperAdmin() {
+		override, err := diagnosisTemplateForEdit(template, user)
+		if err != nil || database.DB.Model(override).Update("is_active", false).Error != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to deactivate diagnosis template"})
+		}
+		return c.JSON(fiber.Map{"message": "Diagnosis template deactivated for this clinic", "template": override})
+	}
+
+	if models.DiagnosisTemplateInUse(database.DB, template.ID) {
+		if err := database.DB.Model(template).Update("is_active", false).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to deactivate diagnosis template"})
+		}
+		return c.JSON(fiber.Map{"message": "Diagnosis template is in use and was deactivated instead", "template": template})
+	}
+
+	if err := database.DB.Unscoped().Delete(template).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete diagnosis template"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Diagnosis template deleted successfully"})
+}
+
 // Appointment Procedure Handlers
 func GetAppointmentProcedures(c *fiber.Ctx) error {
 	user := c.Locals("user").(models.User)
@@ -192,14 +530,14 @@ func AddProcedureToAppointment(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Procedure template is required"})
 	}
 
-	// Verify procedure template exists
-	var template models.ProcedureTemplate
-	if err := database.DB.First(&template, req.ProcedureTemplateID).Error; err != nil {
+	// Verify the procedure is in the clinic's catalog, using the clinic's version if it has one
+	template, err := models.ResolveProcedureTemplate(database.DB, appointment.Branch.ClinicID, req.ProcedureTemplateID)
+	if err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Procedure template not found"})
 	}
 
 	procedure := models.AppointmentProcedure{
-		ProcedureTemplateID: req.ProcedureTemplateID,
+		ProcedureTemplateID: template.ID,
 		AppointmentID:       uint(appointmentID),
 		ToothNumber:         req.ToothNumber,
 		Surface:             req.Surface,
@@ -209,9 +547,10 @@ func AddProcedureToAppointment(c *fiber.Ctx) error {
 		PerformedByID:       user.ID,
 	}
 
-	// Set default cost if not provided
+	// Default the cost from the fee schedule that applies to this branch and the patient's insurer
 	if procedure.Cost == 0 {
-		procedure.Cost = template.DefaultCost
+		scope := procedureFeeScope(appointment.Branch.ClinicID, &appointment.BranchID, appointment.PatientID, appointment.StartTime)
+		procedure.Cost = models.ResolveProcedureFee(database.DB, scope, *template, appointment.StartTime.In(appointment.Location())).Price
 	}
 
 	// Set default status if not provided
@@ -223,6 +562,15 @@ func AddProcedureToAppointment(c *fiber.Ctx) error {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to add procedure"})
 	}
 
+	// Book any chairs, rooms or equipment the procedure needs that the appointment doesn't have yet
+	assignProcedureResources(&appointment, template.ID)
+
+	// A procedure recorded as already done completes the treatment plan item it is for
+	if procedure.Status == "completed" {
+		procedure.Appointment = appointment
+		syncTreatmentPlanItem(&procedure, "")
+	}
+
 	// Reload with relationships
 	database.DB.Preload("ProcedureTemplate").Preload("PerformedBy").First(&procedure, procedure.ID)
 
@@ -267,6 +615,8 @@ func UpdateAppointmentProcedure(c *fiber.Ctx) error {
 	}
 
 	// Update fields
+	previousStatus := procedure.Status
+	previousCost := procedure.Cost
 	if req.ToothNumber != "" {
 		procedure.ToothNumber = req.ToothNumber
 	}
@@ -293,6 +643,13 @@ func UpdateAppointmentProcedure(c *fiber.Ctx) error {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update procedure"})
 	}
 
+	// Carry the change over to the treatment plan item the procedure is for
+	if procedure.Status != previousStatus {
+		syncTreatmentPlanItem(&procedure, previousStatus)
+	} else if procedure.Cost != previousCost {
+		syncTreatmentPlanItemCost(&procedure)
+	}
+
 	// Reload with relationships
 	database.DB.Preload("ProcedureTemplate").Preload("PerformedBy").First(&procedure, procedure.ID)
 
@@ -366,14 +723,14 @@ func AddDiagnosisToAppointment(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Diagnosis template is required"})
 	}
 
-	// Verify diagnosis template exists
-	var template models.DiagnosisTemplate
-	if err := database.DB.First(&template, req.DiagnosisTemplateID).Error; err != nil {
+	// Verify the diagnosis is in the clinic's catalog, using the clinic's version if it has one
+	template, err := models.ResolveDiagnosisTemplate(database.DB, appointment.Branch.ClinicID, req.DiagnosisTemplateID)
+	if err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Diagnosis template not found"})
 	}
 
 	diagnosis := models.AppointmentDiagnosis{
-		DiagnosisTemplateID: req.DiagnosisTemplateID,
+		DiagnosisTemplateID: template.ID,
 		AppointmentID:       uint(appointmentID),
 		ToothNumber:         req.ToothNumber,
 		Surface:             req.Surface,
@@ -0,0 +1,541 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+	"dentika/server/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+var eventPublisher *services.EventPublisher
+
+// SetEventPublisher sets the publisher for live queue events
+func SetEventPublisher(publisher *services.EventPublisher) {
+	eventPublisher = publisher
+}
+
+type AddToQueueRequest struct {
+	AppointmentID *uint  `json:"appointment_id"`
+	PatientID     uint   `json:"patient_id"` // walk-ins only
+	DoctorID      *uint  `json:"doctor_id"`
+	Notes         string `json:"notes"`
+}
+
+type UpdateQueueStatusRequest struct {
+	Status     models.QueueStatus `json:"status"`
+	ResourceID *uint              `json:"resource_id"` // the chair when calling a patient
+	DoctorID   *uint              `json:"doctor_id"`
+	Notes      string             `json:"notes"`
+}
+
+type CreateQueueDisplayRequest struct {
+	Name string `json:"name"`
+}
+
+// queueDate is the branch's local day at the time, stored as a date
+func queueDate(branchID uint, at time.Time) time.Time {
+	local := at.In(models.BranchLocation(database.DB, branchID))
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// createQueueEntry gives the entry the next ticket number of the day at its branch and saves it
+func createQueueEntry(db *gorm.DB, entry *models.QueueEntry) error {
+	entry.QueueDate = queueDate(entry.BranchID, entry.ArrivedAt)
+
+	// Two desks checking patients in at once can pick the same number; the unique index rejects
+	// the second, which then takes the next one
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		var last int
+		db.Unscoped().Model(&models.QueueEntry{}).Where("branch_id = ? AND queue_date = ?", entry.BranchID, entry.QueueDate).
+			Select("COALESCE(MAX(ticket_number), 0)").Scan(&last)
+		entry.TicketNumber = last + 1
+		if err = db.Create(entry).Error; err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// queueAppointmentArrival adds an arrived appointment's patient to the branch queue, unless they are
+// already in it
+func queueAppointmentArrival(appointment *models.Appointment, actorID uint) (*models.QueueEntry, error) {
+	var entry models.QueueEntry
+	if err := database.DB.Where("appointment_id = ?", appointment.ID).First(&entry).Error; err == nil {
+		return &entry, nil
+	}
+
+	arrivedAt := time.Now()
+	if appointment.ArrivalTime != nil {
+		arrivedAt = *appointment.ArrivalTime
+	}
+	entry = models.QueueEntry{
+		BranchID:      appointment.BranchID,
+		AppointmentID: &appointment.ID,
+		PatientID:     appointment.PatientID,
+		DoctorID:      &appointment.DoctorID,
+		Status:        models.QueueArrived,
+		ArrivedAt:     arrivedAt,
+		UpdatedByID:   actorID,
+		ClinicID:      appointment.ClinicID,
+	}
+	if err := createQueueEntry(database.DB, &entry); err != nil {
+		return nil, err
+	}
+	go publishQueueEvent("arrived", entry)
+	return &entry, nil
+}
+
+// queueSummary counts the entries by status and works out the waits: the average over patients who
+// have been called, and the longest of those still waiting
+func queueSummary(entries []models.QueueEntry, now time.Time) fiber.Map {
+	counts := map[models.QueueStatus]int{}
+	var called int
+	var totalWait, longestWait time.Duration
+	for i := range entries {
+		entry := &entries[i]
+		counts[entry.Status]++
+		wait := entry.WaitTime(now)
+		switch entry.Status {
+		case models.QueueArrived, models.QueueWaiting:
+			if wait > longestWait {
+				longestWait = wait
+			}
+		case models.QueueLeft:
+		default:
+			called++
+			totalWait += wait
+		}
+	}
+
+	averageWait := 0
+	if called > 0 {
+		averageWait = int((totalWait / time.Duration(called)).Minutes())
+	}
+	return fiber.Map{
+		"counts":                  counts,
+		"total":                   len(entries),
+		"average_wait_minutes":    averageWait,
+		"longest_waiting_minutes": int(longestWait.Minutes()),
+	}
+}
+
+// branchQueue loads a branch's queue for a day, in ticket order
+func branchQueue(branchID uint, day time.Time, preload bool) ([]models.QueueEntry, error) {
+	query := database.DB.Where("branch_id = ? AND queue_date = ?", branchID, day)
+	if preload {
+		query = query.Preload("Patient").Preload("Doctor").Preload("Resource").Preload("Appointment")
+	} else {
+		query = query.Preload("Resource")
+	}
+
+	var entries []models.QueueEntry
+	if err := query.Order("ticket_number ASC").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range entries {
+		entries[i].Calculate(now)
+	}
+	return entries, nil
+}
+
+// queueBoard is the waiting room view of a branch's queue today: ticket numbers and chairs only,
+// since it is shown on a screen everyone can see
+func queueBoard(branchID uint) (fiber.Map, error) {
+	now := time.Now()
+	entries, err := branchQueue(branchID, queueDate(branchID, now), false)
+	if err != nil {
+		return nil, err
+	}
+
+	nowServing := []fiber.Map{}
+	waiting := []string{}
+	for i := range entries {
+		entry := &entries[i]
+		switch entry.Status {
+		case models.QueueCalled, models.QueueInTreatment:
+			chair := ""
+			if entry.Resource != nil {
+				chair = entry.Resource.Name
+			}
+			nowServing = append(nowServing, fiber.Map{
+				"ticket":    entry.Ticket,
+				"status":    entry.Status,
+				"chair":     chair,
+				"called_at": entry.CalledAt,
+			})
+		case models.QueueArrived, models.QueueWaiting:
+			waiting = append(waiting, entry.Ticket)
+		}
+	}
+
+	summary := queueSummary(entries, now)
+	return fiber.Map{
+		"branch_id":            branchID,
+		"now_serving":          nowServing,
+		"waiting":              waiting,
+		"average_wait_minutes": summary["average_wait_minutes"],
+		"updated_at":           now,
+	}, nil
+}
+
+// publishQueueEvent sends a queue change to staff screens on the branch's queue subject and the
+// refreshed board to display screens
+func publishQueueEvent(event string, entry models.QueueEntry) {
+	if eventPublisher == nil {
+		return
+	}
+
+	entry.Calculate(time.Now())
+	if err := eventPublisher.Publish(models.QueueSubject(entry.BranchID), fiber.Map{
+		"event": event,
+		"entry": entry,
+		"at":    time.Now(),
+	}); err != nil {
+		log.Printf("Failed to publish queue event for branch %d: %v", entry.BranchID, err)
+		return
+	}
+
+	board, err := queueBoard(entry.BranchID)
+	if err != nil {
+		log.Printf("Failed to build queue board for branch %d: %v", entry.BranchID, err)
+		return
+	}
+	if err := eventPublisher.Publish(models.QueueBoardSubject(entry.BranchID), board); err != nil {
+		log.Printf("Failed to publish queue board for branch %d: %v", entry.BranchID, err)
+	}
+}
+
+// GetBranchQueue lists a branch's queue for a day (YYYY-MM-DD, today by default) with wait times
+func GetBranchQueue(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	branch, err := findAccessibleBranch(c, user)
+	if branch == nil {
+		return err
+	}
+
+	day := queueDate(branch.ID, time.Now())
+	if date := c.Query("date"); date != "" {
+		parsed, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid date format, use YYYY-MM-DD"})
+		}
+		day = parsed
+	}
+
+	entries, err := branchQueue(branch.ID, day, true)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch queue"})
+	}
+
+	return c.JSON(fiber.Map{
+		"branch_id": branch.ID,
+		"date":      day.Format("2006-01-02"),
+		"subject":   models.QueueSubject(branch.ID),
+		"entries":   entries,
+		"summary":   queueSummary(entries, time.Now()),
+	})
+}
+
+// AddToBranchQueue checks a patient in at the front desk, either for an appointment at the branch
+// or as a walk-in
+func AddToBranchQueue(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	branch, err := findAccessibleBranch(c, user)
+	if branch == nil {
+		return err
+	}
+
+	var req AddToQueueRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	if req.AppointmentID != nil {
+		var appointment models.Appointment
+		if err := database.DB.Where("id = ? AND branch_id = ?", *req.AppointmentID, branch.ID).First(&appointment).Error; err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Appointment not found at this branch"})
+		}
+		if !canMarkArrived(appointment.Status) {
+			return c.Status(409).JSON(fiber.Map{"error": "Cannot check in a " + string(appointment.Status) + " appointment"})
+		}
+		var existing int64
+		database.DB.Model(&models.QueueEntry{}).Where("appointment_id = ?", appointment.ID).Count(&existing)
+		if existing > 0 {
+			return c.Status(409).JSON(fiber.Map{"error": "The patient is already in the queue for this appointment"})
+		}
+
+		if !appointment.PatientArrived {
+			appointment.MarkPatientArrived()
+			if err := database.DB.Model(&appointment).Select("patient_arrived", "arrival_time", "is_late").Updates(&appointment).Error; err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to check in patient"})
+			}
+		}
+		entry, err := queueAppointmentArrival(&appointment, user.ID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to check in patient"})
+		}
+		if req.Notes != "" {
+			database.DB.Model(entry).Update("notes", req.Notes)
+		}
+
+		database.DB.Preload("Patient").Preload("Doctor").First(entry, entry.ID)
+		entry.Calculate(time.Now())
+		return c.Status(201).JSON(entry)
+	}
+
+	if req.PatientID == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "An appointment or a patient is required"})
+	}
+	var patient models.Patient
+	if err := database.DB.Where("id = ? AND clinic_id = ?", req.PatientID, branch.ClinicID).First(&patient).Error; err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid patient for this clinic"})
+	}
+	var active int64
+	database.DB.Model(&models.QueueEntry{}).
+		Where("branch_id = ? AND patient_id = ? AND queue_date = ? AND status IN ?", branch.ID, patient.ID, queueDate(branch.ID, time.Now()), models.ActiveQueueStatuses).
+		Count(&active)
+	if active > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "The patient is already in the queue"})
+	}
+	if req.DoctorID != nil {
+		var doctor models.User
+		if err := database.DB.Where("id = ? AND clinic_id = ? AND is_active = ?", *req.DoctorID, branch.ClinicID, true).First(&doctor).Error; err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid doctor for this clinic"})
+		}
+	}
+
+	entry := models.QueueEntry{
+		BranchID:    branch.ID,
+		PatientID:   patient.ID,
+		DoctorID:    req.DoctorID,
+		Status:      models.QueueArrived,
+		Notes:       req.Notes,
+		ArrivedAt:   time.Now(),
+		UpdatedByID: user.ID,
+		ClinicID:    branch.ClinicID,
+	}
+	if err := createQueueEntry(database.DB, &entry); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to check in patient"})
+	}
+	go publishQueueEvent("arrived", entry)
+
+	database.DB.Preload("Patient").Preload("Doctor").First(&entry, entry.ID)
+	entry.Calculate(time.Now())
+	return c.Status(201).JSON(entry)
+}
+
+// UpdateQueueEntryStatus moves a patient through the queue. Starting treatment and checking out
+// move the linked appointment to in progress and completed.
+func UpdateQueueEntryStatus(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var entry models.QueueEntry
+	if err := database.DB.First(&entry, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Queue entry not found"})
+	}
+	if !user.CanAccessClinic(entry.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	var req UpdateQueueStatusRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	previousStatus := entry.Status
+	if err := entry.TransitionTo(req.Status, time.Now()); err != nil {
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if req.ResourceID != nil {
+		if req.Status != models.QueueCalled && req.Status != models.QueueInTreatment {
+			return c.Status(400).JSON(fiber.Map{"error": "A chair can only be given when calling a patient"})
+		}
+		var resource models.Resource
+		if err := database.DB.Where("id = ? AND branch_id = ? AND is_active = ?", *req.ResourceID, entry.BranchID, true).First(&resource).Error; err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid chair for this branch"})
+		}
+		if resource.Type == models.ResourceEquipment {
+			return c.Status(400).JSON(fiber.Map{"error": "Patients can only be called to a chair or room"})
+		}
+		entry.ResourceID = &resource.ID
+	}
+	if req.DoctorID != nil {
+		var doctor models.User
+		if err := database.DB.Where("id = ? AND clinic_id = ? AND is_active = ?", *req.DoctorID, entry.ClinicID, true).First(&doctor).Error; err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid doctor for this clinic"})
+		}
+		entry.DoctorID = &doctor.ID
+	}
+	if req.Notes != "" {
+		entry.Notes = req.Notes
+	}
+	entry.UpdatedByID = user.ID
+
+	// The appointment follows the patient into and out of the chair
+	var appointment models.Appointment
+	var appointmentStatus models.AppointmentStatus
+	if entry.AppointmentID != nil {
+		var target models.AppointmentStatus
+		switch entry.Status {
+		case models.QueueInTreatment:
+			target = models.StatusInProgress
+		case models.QueueCheckedOut:
+			target = models.StatusCompleted
+		}
+		if target != "" && database.DB.First(&appointment, *entry.AppointmentID).Error == nil && models.CanTransition(appointment.Status, target) {
+			appointmentStatus = appointment.Status
+			appointment.Status = target
+		}
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Omit("Patient", "Doctor", "Resource", "Appointment").Save(&entry).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update queue entry"})
+	}
+	if appointmentStatus != "" {
+		claim := tx.Model(&models.Appointment{}).Where("id = ? AND status = ?", appointment.ID, appointmentStatus).Update("status", appointment.Status)
+		if claim.Error != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update queue entry"})
+		}
+		if claim.RowsAffected == 0 {
+			appointmentStatus = ""
+		} else if err := models.RecordStatusChange(tx, &appointment, appointmentStatus, "", &user.ID); err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update queue entry"})
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update queue entry"})
+	}
+
+	if appointmentStatus != "" {
+		database.DB.Preload("Patient").First(&appointment, appointment.ID)
+		go SendAppointmentUpdate(appointment.ID, appointment.Patient.GetFullName(), string(appointment.Status), appointment.ClinicID)
+	}
+	if entry.Status != previousStatus {
+		go publishQueueEvent(string(entry.Status), entry)
+	}
+
+	database.DB.Preload("Patient").Preload("Doctor").Preload("Resource").Preload("Appointment").First(&entry, entry.ID)
+	entry.Calculate(time.Now())
+	return c.JSON(entry)
+}
+
+func queueDisplayURL(c *fiber.Ctx, display *models.QueueDisplay) string {
+	return c.BaseURL() + "/api/public/queue-board/" + display.Token
+}
+
+func queueDisplayView(c *fiber.Ctx, display *models.QueueDisplay) fiber.Map {
+	return fiber.Map{
+		"display": display,
+		"url":     queueDisplayURL(c, display),
+		"subject": models.QueueBoardSubject(display.BranchID),
+	}
+}
+
+// GetQueueDisplays lists the waiting room boards of a branch
+func GetQueueDisplays(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	branch, err := findAccessibleBranch(c, user)
+	if branch == nil {
+		return err
+	}
+
+	var displays []models.QueueDisplay
+	if err := database.DB.Where("branch_id = ?", branch.ID).Order("id ASC").Find(&displays).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch queue displays"})
+	}
+	result := make([]fiber.Map, 0, len(displays))
+	for i := range displays {
+		result = append(result, queueDisplayView(c, &displays[i]))
+	}
+	return c.JSON(result)
+}
+
+// CreateQueueDisplay issues a read-only link for a "now serving" screen at a branch
+func CreateQueueDisplay(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	branch, err := findAccessibleBranch(c, user)
+	if branch == nil {
+		return err
+	}
+
+	var req CreateQueueDisplayRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+	}
+
+	token, err := models.GenerateToken()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create queue display"})
+	}
+	display := models.QueueDisplay{
+		Token:       token,
+		Name:        firstNonEmpty(req.Name, branch.Name+" waiting room"),
+		BranchID:    branch.ID,
+		CreatedByID: user.ID,
+		ClinicID:    branch.ClinicID,
+	}
+	if err := database.DB.Create(&display).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create queue display"})
+	}
+
+	return c.Status(201).JSON(queueDisplayView(c, &display))
+}
+
+// DeleteQueueDisplay revokes a board link
+func DeleteQueueDisplay(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var display models.QueueDisplay
+	if err := database.DB.First(&display, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Queue display not found"})
+	}
+	if !user.CanAccessClinic(display.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	if err := database.DB.Delete(&display).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete queue display"})
+	}
+	return c.JSON(fiber.Map{"message": "Queue display deleted"})
+}
+
+// GetPublicQueueBoard serves the "now serving" board to a waiting room screen. The token only
+// grants this view, which carries no patient details.
+func GetPublicQueueBoard(c *fiber.Ctx) error {
+	var display models.QueueDisplay
+	token := c.Params("token")
+	if token == "" || database.DB.Preload("Branch").Where("token = ?", token).First(&display).Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Queue display not found"})
+	}
+
+	board, err := queueBoard(display.BranchID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to load queue board"})
+	}
+	board["name"] = display.Name
+	board["branch_name"] = display.Branch.Name
+	board["subject"] = models.QueueBoardSubject(display.BranchID)
+
+	database.DB.Model(&display).UpdateColumn("last_seen_at", time.Now())
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.JSON(board)
+}
@@ -0,0 +1,476 @@
+package handlers
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type ResourceRequest struct {
+	Name        string              `json:"name"`
+	Type        models.ResourceType `json:"type"`
+	Description string              `json:"description"`
+	Features    string              `json:"features"`
+	BranchID    uint                `json:"branch_id"`
+	IsActive    *bool               `json:"is_active"`
+}
+
+// resolveAppointmentResources checks the resources chosen for an appointment and adds free ones for
+// the procedures' requirements. On failure it returns the status and body to respond with.
+func resolveAppointmentResources(branchID uint, resourceIDs, procedureTemplateIDs []uint, start, end time.Time, excludeAppointmentID uint) ([]models.Resource, int, fiber.Map) {
+	chosen := []models.Resource{}
+	if len(resourceIDs) > 0 {
+		if err := database.DB.Where("id IN ? AND branch_id = ? AND is_active = ?", resourceIDs, branchID, true).
+			Find(&chosen).Error; err != nil {
+			return nil, 500, fiber.Map{"error": "Failed to load resources"}
+		}
+		if len(chosen) != len(uniqueUints(resourceIDs)) {
+			return nil, 400, fiber.Map{"error": "Resources must be active and belong to the appointment's branch"}
+		}
+
+		conflicts, err := models.FindResourceConflicts(database.DB, resourceIDs, start, end, excludeAppointmentID)
+		if err != nil {
+			return nil, 500, fiber.Map{"error": "Failed to check for conflicts"}
+		}
+		if len(conflicts) > 0 {
+			return nil, 409, fiber.Map{
+				"error":              "Resource is already booked at this time",
+				"resource_conflicts": conflicts,
+			}
+		}
+	}
+
+	if len(procedureTemplateIDs) == 0 {
+		return chosen, 0, nil
+	}
+
+	var requirements []models.ProcedureResourceRequirement
+	if err := database.DB.Where("procedure_template_id IN ?", procedureTemplateIDs).Find(&requirements).Error; err != nil {
+		return nil, 500, fiber.Map{"error": "Failed to load procedure resource requirements"}
+	}
+	resources, unmet, err := models.FillResourceRequirements(database.DB, branchID, requirements, chosen, start, end, excludeAppointmentID)
+	if err != nil {
+		return nil, 500, fiber.Map{"error": "Failed to assign resources"}
+	}
+	if len(unmet) > 0 {
+		return nil, 409, fiber.Map{
+			"error":              "Required resources are not available at this time",
+			"unmet_requirements": unmet,
+		}
+	}
+	return resources, 0, nil
+}
+
+// appointmentResourceRows turns resources into booking rows for an appointment
+func appointmentResourceRows(appointmentID uint, resources []models.Resource) []models.AppointmentResource {
+	rows := make([]models.AppointmentResource, 0, len(resources))
+	for _, resource := range resources {
+		rows = append(rows, models.AppointmentResource{AppointmentID: appointmentID, ResourceID: resource.ID})
+	}
+	return rows
+}
+
+// lockAppointmentResources checks again, in the transaction that books them, that the resources
+// are still free, and holds them until it ends. It returns any bookings in the way.
+func lockAppointmentResources(tx *gorm.DB, resources []models.Resource, start, end time.Time, excludeAppointmentID uint) ([]models.ResourceConflict, error) {
+	ids := make([]uint, len(resources))
+	for i, resource := range resources {
+		ids[i] = resource.ID
+	}
+	return models.LockResourceConflicts(tx, ids, start, end, excludeAppointmentID)
+}
+
+// assignProcedureResources books free resources for a procedure added to an existing appointment.
+// Requirements that cannot be met are left for the front desk to resolve.
+func assignProcedureResources(appointment *models.Appointment, procedureTemplateID uint) {
+	var requirements []models.ProcedureResourceRequirement
+	if err := database.DB.Where("procedure_template_id = ?", procedureTemplateID).Find(&requirements).Error; err != nil || len(requirements) == 0 {
+		return
+	}
+
+	var current []models.Resource
+	database.DB.Joins("JOIN appointment_resources ON appointment_resources.resource_id = resources.id").
+		Where("appointment_resources.appointment_id = ?", appointment.ID).Find(&current)
+
+	resources, unmet, err := models.FillResourceRequirements(database.DB, appointment.BranchID, requirements, current,
+		appointment.StartTime, appointment.EndTime, appointment.ID)
+	if err != nil {
+		log.Printf("Failed to assign resources for appointment %d: %v", appointment.ID, err)
+		return
+	}
+	if len(unmet) > 0 {
+		log.Printf("Appointment %d is missing %d required resource type(s)", appointment.ID, len(unmet))
+	}
+	if added := appointmentResourceRows(appointment.ID, resources[len(current):]); len(added) > 0 {
+		if err := database.DB.Create(&added).Error; err != nil {
+			log.Printf("Failed to book resources for appointment %d: %v", appointment.ID, err)
+		}
+	}
+}
+
+func uniqueUints(values []uint) []uint {
+	seen := map[uint]bool{}
+	result := []uint{}
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// GetResources lists chairs, rooms and equipment, optionally by branch and type
+func GetResources(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	query := database.DB.Preload("Branch")
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	} else if clinicID := c.Query("clinic_id"); clinicID != "" {
+		query = query.Where("clinic_id = ?", clinicID)
+	}
+	if branchID := c.Query("branch_id"); branchID != "" {
+		query = query.Where("branch_id = ?", branchID)
+	}
+	if resourceType := c.Query("type"); resourceType != "" {
+		query = query.Where("type = ?", resourceType)
+	}
+	if c.Query("include_inactive") != "true" {
+		query = query.Where("is_active = ?", true)
+	}
+
+	var resources []models.Resource
+	if err := query.Order("branch_id, type, name").Find(&resources).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch resources"})
+	}
+	return c.JSON(resources)
+}
+
+// CreateResource adds a chair, room or piece of equipment to a branch
+func CreateResource(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var req ResourceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if strings.TrimSpace(req.Name) == "" || req.BranchID == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Name and branch are required"})
+	}
+	if !models.IsValidResourceType(req.Type) {
+		return c.Status(400).JSON(fiber.Map{"error": "Type must be chair, room or equipment"})
+	}
+
+	var branch models.Branch
+	if err := database.DB.First(&branch, req.BranchID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Branch not found"})
+	}
+	if !user.CanAccessClinic(branch.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	resource := models.Resource{
+		Name:        strings.TrimSpace(req.Name),
+		Type:        req.Type,
+		Description: req.Description,
+		Features:    req.Features,
+		IsActive:    true,
+		BranchID:    branch.ID,
+		ClinicID:    branch.ClinicID,
+	}
+	if err := database.DB.Create(&resource).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create resource"})
+	}
+
+	database.DB.Preload("Branch").First(&resource, resource.ID)
+	return c.Status(201).JSON(resource)
+}
+
+// UpdateResource edits a resource. Moving it to another branch is not allowed.
+func UpdateResource(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var resource models.Resource
+	if err := database.DB.First(&resource, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Resource not found"})
+	}
+	if !user.CanAccessClinic(resource.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	var req ResourceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if req.BranchID != 0 && req.BranchID != resource.BranchID {
+		return c.Status(400).JSON(fiber.Map{"error": "Resources cannot be moved between branches"})
+	}
+
+	if strings.TrimSpace(req.Name) != "" {
+		resource.Name = strings.TrimSpace(req.Name)
+	}
+	if req.Type != "" {
+		if !models.IsValidResourceType(req.Type) {
+			return c.Status(400).JSON(fiber.Map{"error": "Type must be chair, room or equipment"})
+		}
+		resource.Type = req.Type
+	}
+	resource.Description = req.Description
+	resource.Features = req.Features
+	if req.IsActive != nil {
+		resource.IsActive = *req.IsActive
+	}
+
+	if err := database.DB.Save(&resource).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update resource"})
+	}
+
+	database.DB.Preload("Branch").First(&resource, resource.ID)
+	return c.JSON(resource)
+}
+
+// DeleteResource removes a resource that has no upcoming bookings
+func DeleteResource(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var resource models.Resource
+	if err := database.DB.First(&resource, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Resource not found"})
+	}
+	if !user.CanAccessClinic(resource.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	var upcoming int64
+	database.DB.Model(&models.AppointmentResource{}).
+		Joins("JOIN appointments ON appointments.id = appointment_resources.appointment_id AND appointments.deleted_at IS NULL").
+		Where("appointment_resources.resource_id = ? AND appointments.end_time > ? AND appointments.status IN ?",
+			resource.ID, time.Now(), models.BlockingStatuses).
+		Count(&upcoming)
+	if upcoming > 0 {
+		return c.Status(409).JSON(fiber.Map{
+			"error":    "Resource has upcoming appointments; deactivate it or move the bookings first",
+			"upcoming": upcoming,
+		})
+	}
+
+	if err := database.DB.Delete(&resource).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete resource"})
+	}
+	return c.JSON(fiber.Map{"message": "Resource deleted"})
+}
+
+// GetResourceDayView shows each resource at a branch with its bookings for a day, for the front desk
+func GetResourceDayView(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	branchID, err := strconv.ParseUint(c.Query("branch_id"), 10, 32)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Branch is required"})
+	}
+	var branch models.Branch
+	if err := database.DB.First(&branch, branchID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Branch not found"})
+	}
+	if !user.CanAccessClinic(branch.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	location := models.BranchLocation(database.DB, branch.ID)
+	date := time.Now().In(location)
+	if dateStr := c.Query("date"); dateStr != "" {
+		if date, err = time.ParseInLocation("2006-01-02", dateStr, location); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid date format"})
+		}
+	}
+	day := models.ClockRange(date, 0, 24*60)
+
+	var resources []models.Resource
+	query := database.DB.Where("branch_id = ? AND is_active = ?", branch.ID, true)
+	if resourceType := c.Query("type"); resourceType != "" {
+		query = query.Where("type = ?", resourceType)
+	}
+	if err := query.Order("type, name").Find(&resources).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch resources"})
+	}
+
+	var bookings []models.AppointmentResource
+	if err := database.DB.
+		Joins("JOIN appointments ON appointments.id = appointment_resources.appointment_id AND appointments.deleted_at IS NULL").
+		Where("appointments.branch_id = ? AND appointments.start_time < ? AND appointments.end_time > ? AND appointments.status NOT IN ?",
+			branch.ID, day.End, day.Start, []models.AppointmentStatus{models.StatusCancelled, models.StatusRescheduled}).
+		Find(&bookings).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch bookings"})
+	}
+
+	appointmentIDs := []uint{}
+	for _, booking := range bookings {
+		appointmentIDs = append(appointmentIDs, booking.AppointmentID)
+	}
+	appointments := map[uint]models.Appointment{}
+	if len(appointmentIDs) > 0 {
+		var list []models.Appointment
+		database.DB.Preload("Patient").Preload("Doctor").Where("id IN ?", uniqueUints(appointmentIDs)).Find(&list)
+		for _, appointment := range list {
+			appointments[appointment.ID] = appointment
+		}
+	}
+
+	byResource := map[uint][]fiber.Map{}
+	for _, booking := range bookings {
+		appointment, ok := appointments[booking.AppointmentID]
+		if !ok {
+			continue
+		}
+		byResource[booking.ResourceID] = append(byResource[booking.ResourceID], fiber.Map{
+			"appointment_id": appointment.ID,
+			"title":          appointment.Title,
+			"start_time":     appointment.StartTime,
+			"end_time":       appointment.EndTime,
+			"status":         appointment.Status,
+			"patient_id":     appointment.PatientID,
+			"patient_name":   appointment.Patient.GetFullName(),
+			"doctor_id":      appointment.DoctorID,
+			"doctor_name":    appointment.Doctor.GetDisplayName(),
+		})
+	}
+
+	view := make([]fiber.Map, 0, len(resources))
+	for _, resource := range resources {
+		slots := byResource[resource.ID]
+		if slots == nil {
+			slots = []fiber.Map{}
+		}
+		view = append(view, fiber.Map{
+			"resource":     resource,
+			"appointments": slots,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"date":      date.Format("2006-01-02"),
+		"branch_id": branch.ID,
+		"resources": view,
+	})
+}
+
+// UpdateAppointmentResources replaces the resources booked for an appointment
+func UpdateAppointmentResources(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var appointment models.Appointment
+	if err := database.DB.First(&appointment, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Appointment not found"})
+	}
+	if !user.CanAccessClinic(appointment.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	var req struct {
+		ResourceIDs []uint `json:"resource_ids"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	resources, status, body := resolveAppointmentResources(appointment.BranchID, req.ResourceIDs, nil,
+		appointment.StartTime, appointment.EndTime, appointment.ID)
+	if status != 0 {
+		return c.Status(status).JSON(body)
+	}
+
+	tx := database.DB.Begin()
+	conflicts, err := lockAppointmentResources(tx, resources, appointment.StartTime, appointment.EndTime, appointment.ID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update resources"})
+	}
+	if len(conflicts) > 0 {
+		tx.Rollback()
+		return c.Status(409).JSON(fiber.Map{"error": "Resource is already booked at this time", "resource_conflicts": conflicts})
+	}
+	if err := tx.Where("appointment_id = ?", appointment.ID).Delete(&models.AppointmentResource{}).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update resources"})
+	}
+	if rows := appointmentResourceRows(appointment.ID, resources); len(rows) > 0 {
+		if err := tx.Create(&rows).Error; err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update resources"})
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update resources"})
+	}
+
+	var rows []models.AppointmentResource
+	database.DB.Preload("Resource").Where("appointment_id = ?", appointment.ID).Find(&rows)
+	return c.JSON(rows)
+}
+
+// UpdateProcedureResourceRequirements replaces the resources a procedure template needs. For a
+// platform template this changes the clinic's own copy, as with other template changes.
+func UpdateProcedureResourceRequirements(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	found, errResp := findManagedProcedureTemplate(c, user)
+	if found == nil {
+		return errResp
+	}
+
+	var req struct {
+		Requirements []models.ProcedureResourceRequirement `json:"requirements"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	requirements := make([]models.ProcedureResourceRequirement, 0, len(req.Requirements))
+	for i, requirement := range req.Requirements {
+		if !models.IsValidResourceType(requirement.ResourceType) {
+			return c.Status(400).JSON(fiber.Map{"error": "Resource type must be chair, room or equipment", "index": i})
+		}
+		if requirement.Quantity < 1 {
+			requirement.Quantity = 1
+		}
+		requirements = append(requirements, models.ProcedureResourceRequirement{
+			ResourceType: requirement.ResourceType,
+			Feature:      strings.ToLower(strings.TrimSpace(requirement.Feature)),
+			Quantity:     requirement.Quantity,
+		})
+	}
+
+	template, err := procedureTemplateForEdit(found, user)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update resource requirements"})
+	}
+	for i := range requirements {
+		requirements[i].ProcedureTemplateID = template.ID
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Where("procedure_template_id = ?", template.ID).Delete(&models.ProcedureResourceRequirement{}).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update resource requirements"})
+	}
+	if len(requirements) > 0 {
+		if err := tx.Create(&requirements).Error; err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update resource requirements"})
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update resource requirements"})
+	}
+
+	database.DB.Preload("ResourceRequirements").First(template, template.ID)
+	return c.JSON(template)
+}
@@ -0,0 +1,795 @@
+package handlers
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// How far ahead scheduling the next phase looks for free slots
+const planSchedulingWindowDays = 90
+
+// Visit length used for procedures without an estimated duration
+const defaultPlanProcedureMinutes = 30
+
+type TreatmentPlanPhaseRequest struct {
+	Name                *string `json:"name"`
+	Description         *string `json:"description"`
+	Sequence            *int    `json:"sequence"`
+	DependsOnPhaseID    *uint   `json:"depends_on_phase_id"` // 0 clears the dependency
+	DaysAfterDependency *int    `json:"days_after_dependency"`
+}
+
+type TreatmentPlanItemRequest struct {
+	ProcedureTemplateID *uint    `json:"procedure_template_id"`
+	PhaseID             *uint    `json:"phase_id"` // 0 takes the item out of its phase
+	ToothNumber         *string  `json:"tooth_number"`
+	Surface             *string  `json:"surface"`
+	Notes               *string  `json:"notes"`
+	EstimatedCost       *float64 `json:"estimated_cost"`
+	Sequence            *int     `json:"sequence"`
+	Status              *string  `json:"status"`    // planned or skipped
+	BranchID            *uint    `json:"branch_id"` // branch whose fee schedule prices the procedure
+}
+
+type ScheduleNextPhaseRequest struct {
+	DoctorID        uint   `json:"doctor_id"` // any doctor when 0
+	BranchID        uint   `json:"branch_id"` // any branch when 0
+	From            string `json:"from"`      // 2006-01-02, today by default
+	MaxVisitMinutes int    `json:"max_visit_minutes"`
+	DaysBetween     int    `json:"days_between"`
+	Book            bool   `json:"book"`
+}
+
+// ProposedVisit is one appointment of a phase being scheduled. StartTime is nil when no free slot
+// was found for it.
+type ProposedVisit struct {
+	StartTime     *time.Time                      `json:"start_time"`
+	EndTime       *time.Time                      `json:"end_time"`
+	Duration      int                             `json:"duration"`
+	DoctorID      uint                            `json:"doctor_id,omitempty"`
+	BranchID      uint                            `json:"branch_id,omitempty"`
+	EstimatedCost float64                         `json:"estimated_cost"`
+	Items         []models.TreatmentPlanProcedure `json:"items"`
+	Appointment   *models.Appointment             `json:"appointment,omitempty"`
+
+	resources []models.Resource
+}
+
+// findPatientTreatmentPlan loads the plan in the URL, within the user's clinic. A nil plan means
+// the error response has been sent.
+func findPatientTreatmentPlan(c *fiber.Ctx, user models.User) (*models.PatientTreatmentPlan, error) {
+	patientID, err := strconv.ParseUint(c.Params("patientId"), 10, 32)
+	if err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Invalid patient ID"})
+	}
+	treatmentPlanID, err := strconv.ParseUint(c.Params("treatmentPlanId"), 10, 32)
+	if err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Invalid treatment plan ID"})
+	}
+
+	query := database.DB.Where("patient_id = ?", patientID)
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	}
+	var plan models.PatientTreatmentPlan
+	if err := query.First(&plan, treatmentPlanID).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "Patient treatment plan not found"})
+	}
+	return &plan, nil
+}
+
+// recalculateTreatmentPlan rolls the plan's items up after a change, logging failures since the
+// change itself has been saved
+func recalculateTreatmentPlan(planID uint) {
+	if err := models.RecalculateTreatmentPlan(database.DB, planID); err != nil {
+		log.Printf("Failed to recalculate treatment plan %d: %v", planID, err)
+	}
+}
+
+// GetTreatmentPlanItems returns a plan's phases with their items, the items outside any phase and
+// the plan's progress
+func GetTreatmentPlanItems(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	plan, err := findPatientTreatmentPlan(c, user)
+	if plan == nil {
+		return err
+	}
+
+	var phases []models.TreatmentPlanPhase
+	if err := database.DB.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sequence ASC, id ASC")
+	}).Preload("Items.ProcedureTemplate").
+		Where("treatment_plan_id = ?", plan.ID).Order("sequence ASC, id ASC").Find(&phases).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch treatment plan phases"})
+	}
+
+	var items []models.TreatmentPlanProcedure
+	if err := database.DB.Preload("ProcedureTemplate").Where("treatment_plan_id = ?", plan.ID).
+		Order("sequence ASC, id ASC").Find(&items).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch treatment plan items"})
+	}
+	unphased := []models.TreatmentPlanProcedure{}
+	for _, item := range items {
+		if item.PhaseID == nil {
+			unphased = append(unphased, item)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"treatment_plan": plan,
+		"phases":         phases,
+		"unphased_items": unphased,
+		"progress":       models.SummarizePlanItems(items),
+	})
+}
+
+// applyTreatmentPlanPhaseRequest copies the request onto the phase, returning a status and message
+// when it is invalid
+func applyTreatmentPlanPhaseRequest(plan *models.PatientTreatmentPlan, req TreatmentPlanPhaseRequest, phase *models.TreatmentPlanPhase) (int, string) {
+	if req.Name != nil {
+		phase.Name = strings.TrimSpace(*req.Name)
+	}
+	if phase.Name == "" {
+		return 400, "Phase name is required"
+	}
+	if req.Description != nil {
+		phase.Description = *req.Description
+	}
+	if req.Sequence != nil {
+		phase.Sequence = *req.Sequence
+	}
+	if req.DaysAfterDependency != nil {
+		if *req.DaysAfterDependency < 0 {
+			return 400, "days_after_dependency cannot be negative"
+		}
+		phase.DaysAfterDependency = *req.DaysAfterDependency
+	}
+	if req.DependsOnPhaseID != nil {
+		phase.DependsOnPhaseID = nil
+		if *req.DependsOnPhaseID != 0 {
+			var phases []models.TreatmentPlanPhase
+			database.DB.Where("treatment_plan_id = ?", plan.ID).Find(&phases)
+			dependsOn := map[uint]*uint{}
+			for _, other := range phases {
+				dependsOn[other.ID] = other.DependsOnPhaseID
+			}
+			if _, ok := dependsOn[*req.DependsOnPhaseID]; !ok {
+				return 400, "The phase depended on is not part of this treatment plan"
+			}
+			// Follow the chain to make sure it doesn't lead back to this phase
+			for id, steps := req.DependsOnPhaseID, 0; id != nil && steps <= len(phases); id, steps = dependsOn[*id], steps+1 {
+				if *id == phase.ID {
+					return 400, "Phase dependencies cannot form a cycle"
+				}
+			}
+			phase.DependsOnPhaseID = req.DependsOnPhaseID
+		}
+	}
+	return 0, ""
+}
+
+// CreateTreatmentPlanPhase adds a phase to a treatment plan
+func CreateTreatmentPlanPhase(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	plan, err := findPatientTreatmentPlan(c, user)
+	if plan == nil {
+		return err
+	}
+
+	var req TreatmentPlanPhaseRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	phase := models.TreatmentPlanPhase{TreatmentPlanID: plan.ID, Status: models.PhasePending}
+	if req.Sequence == nil {
+		var count int64
+		database.DB.Model(&models.TreatmentPlanPhase{}).Where("treatment_plan_id = ?", plan.ID).Count(&count)
+		phase.Sequence = int(count) + 1
+	}
+	if status, message := applyTreatmentPlanPhaseRequest(plan, req, &phase); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": message})
+	}
+
+	if err := database.DB.Create(&phase).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create treatment plan phase"})
+	}
+
+	return c.Status(201).JSON(phase)
+}
+
+// UpdateTreatmentPlanPhase changes a phase's name, order or dependency
+func UpdateTreatmentPlanPhase(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	plan, err := findPatientTreatmentPlan(c, user)
+	if plan == nil {
+		return err
+	}
+
+	var phase models.TreatmentPlanPhase
+	if err := database.DB.Where("treatment_plan_id = ?", plan.ID).First(&phase, c.Params("phaseId")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Treatment plan phase not found"})
+	}
+
+	var req TreatmentPlanPhaseRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if status, message := applyTreatmentPlanPhaseRequest(plan, req, &phase); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": message})
+	}
+
+	if err := database.DB.Omit("Items").Save(&phase).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update treatment plan phase"})
+	}
+
+	return c.JSON(phase)
+}
+
+// DeleteTreatmentPlanPhase removes a phase. Its items stay in the plan outside any phase, and
+// phases that depended on it no longer do.
+func DeleteTreatmentPlanPhase(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	plan, err := findPatientTreatmentPlan(c, user)
+	if plan == nil {
+		return err
+	}
+
+	var phase models.TreatmentPlanPhase
+	if err := database.DB.Where("treatment_plan_id = ?", plan.ID).First(&phase, c.Params("phaseId")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Treatment plan phase not found"})
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Model(&models.TreatmentPlanProcedure{}).Where("phase_id = ?", phase.ID).Update("phase_id", nil).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete treatment plan phase"})
+	}
+	if err := tx.Model(&models.TreatmentPlanPhase{}).Where("depends_on_phase_id = ?", phase.ID).Update("depends_on_phase_id", nil).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete treatment plan phase"})
+	}
+	if err := tx.Delete(&phase).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete treatment plan phase"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete treatment plan phase"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Treatment plan phase deleted successfully"})
+}
+
+// applyTreatmentPlanItemRequest copies the request onto the item, returning a status and message
+// when it is invalid. A new procedure is priced from the fee schedule unless a cost is given.
+func applyTreatmentPlanItemRequest(plan *models.PatientTreatmentPlan, req TreatmentPlanItemRequest, item *models.TreatmentPlanProcedure) (int, string) {
+	if item.Status == models.PlanItemCompleted {
+		return 409, "Completed treatment plan items cannot be changed"
+	}
+
+	if req.ProcedureTemplateID != nil {
+		if item.Status == models.PlanItemScheduled && *req.ProcedureTemplateID != item.ProcedureTemplateID {
+			return 409, "The item is booked into an appointment; cancel that procedure before changing it"
+		}
+		template, err := models.ResolveProcedureTemplate(database.DB, plan.ClinicID, *req.ProcedureTemplateID)
+		if err != nil {
+			return 404, "Procedure template not found"
+		}
+		if template.ID != item.ProcedureTemplateID && req.EstimatedCost == nil {
+			scope := procedureFeeScope(plan.ClinicID, req.BranchID, plan.PatientID, time.Now())
+			item.EstimatedCost = models.ResolveProcedureFee(database.DB, scope, *template, scope.Today(database.DB)).Price
+		}
+		item.ProcedureTemplateID = template.ID
+	}
+	if item.ProcedureTemplateID == 0 {
+		return 400, "Procedure template is required"
+	}
+
+	if req.PhaseID != nil {
+		item.PhaseID = nil
+		if *req.PhaseID != 0 {
+			var count int64
+			database.DB.Model(&models.TreatmentPlanPhase{}).Where("id = ? AND treatment_plan_id = ?", *req.PhaseID, plan.ID).Count(&count)
+			if count == 0 {
+				return 400, "The phase is not part of this treatment plan"
+			}
+			item.PhaseID = req.PhaseID
+		}
+	}
+	if req.ToothNumber != nil {
+		item.ToothNumber = *req.ToothNumber
+	}
+	if req.Surface != nil {
+		item.Surface = *req.Surface
+	}
+	if req.Notes != nil {
+		item.Notes = *req.Notes
+	}
+	if req.EstimatedCost != nil {
+		if *req.EstimatedCost < 0 {
+			return 400, "Estimated cost cannot be negative"
+		}
+		item.EstimatedCost = *req.EstimatedCost
+	}
+	if req.Sequence != nil {
+		item.Sequence = *req.Sequence
+	}
+	if req.Status != nil && *req.Status != item.Status {
+		switch {
+		case *req.Status == models.PlanItemSkipped && item.Status == models.PlanItemPlanned,
+			*req.Status == models.PlanItemPlanned && item.Status == models.PlanItemSkipped:
+			item.Status = *req.Status
+		case item.Status == models.PlanItemScheduled:
+			return 409, "The item is booked into an appointment; cancel that procedure first"
+		default:
+			return 400, "Status can only be set to planned or skipped; items are completed through their appointment procedure"
+		}
+	}
+	return 0, ""
+}
+
+// CreateTreatmentPlanItem adds a procedure to a treatment plan
+func CreateTreatmentPlanItem(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	plan, err := findPatientTreatmentPlan(c, user)
+	if plan == nil {
+		return err
+	}
+
+	var req TreatmentPlanItemRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	item := models.TreatmentPlanProcedure{TreatmentPlanID: plan.ID, Status: models.PlanItemPlanned}
+	if req.Sequence == nil {
+		var last models.TreatmentPlanProcedure
+		if database.DB.Where("treatment_plan_id = ?", plan.ID).Order("sequence DESC").Limit(1).Find(&last).RowsAffected > 0 {
+			item.Sequence = last.Sequence + 1
+		} else {
+			item.Sequence = 1
+		}
+	}
+	if status, message := applyTreatmentPlanItemRequest(plan, req, &item); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": message})
+	}
+
+	if err := database.DB.Create(&item).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to add procedure to treatment plan"})
+	}
+	recalculateTreatmentPlan(plan.ID)
+
+	database.DB.Preload("ProcedureTemplate").First(&item, item.ID)
+	return c.Status(201).JSON(item)
+}
+
+// UpdateTreatmentPlanItem changes a treatment plan procedure, moves it between phases or skips it
+func UpdateTreatmentPlanItem(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	plan, err := findPatientTreatmentPlan(c, user)
+	if plan == nil {
+		return err
+	}
+
+	var item models.TreatmentPlanProcedure
+	if err := database.DB.Where("treatment_plan_id = ?", plan.ID).First(&item, c.Params("itemId")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Treatment plan item not found"})
+	}
+
+	var req TreatmentPlanItemRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if status, message := applyTreatmentPlanItemRequest(plan, req, &item); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": message})
+	}
+
+	if err := database.DB.Omit("ProcedureTemplate", "TreatmentPlan").Save(&item).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update treatment plan item"})
+	}
+	recalculateTreatmentPlan(plan.ID)
+
+	database.DB.Preload("ProcedureTemplate").First(&item, item.ID)
+	return c.JSON(item)
+}
+
+// DeleteTreatmentPlanItem removes a procedure from a treatment plan. Items already booked or done
+// stay on record; skip them instead.
+func DeleteTreatmentPlanItem(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	plan, err := findPatientTreatmentPlan(c, user)
+	if plan == nil {
+		return err
+	}
+
+	var item models.TreatmentPlanProcedure
+	if err := database.DB.Where("treatment_plan_id = ?", plan.ID).First(&item, c.Params("itemId")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Treatment plan item not found"})
+	}
+	if item.Status == models.PlanItemScheduled || item.Status == models.PlanItemCompleted {
+		return c.Status(409).JSON(fiber.Map{"error": "Scheduled and completed items cannot be removed"})
+	}
+
+	if err := database.DB.Delete(&item).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete treatment plan item"})
+	}
+	recalculateTreatmentPlan(plan.ID)
+
+	return c.JSON(fiber.Map{"message": "Treatment plan item deleted successfully"})
+}
+
+// syncTreatmentPlanItem carries an appointment procedure's status change over to the plan item it
+// is for: completing it completes the item, and undoing or cancelling it reopens the item
+func syncTreatmentPlanItem(procedure *models.AppointmentProcedure, previousStatus string) {
+	var item *models.TreatmentPlanProcedure
+	var err error
+	switch {
+	case procedure.Status == "completed":
+		item, err = models.FindPlanItemForProcedure(database.DB, procedure, procedure.Appointment.PatientID)
+		if err == nil {
+			err = models.CompletePlanItem(database.DB, item, procedure)
+		}
+	case procedure.TreatmentPlanProcedureID != nil:
+		item = &models.TreatmentPlanProcedure{}
+		if err = database.DB.First(item, *procedure.TreatmentPlanProcedureID).Error; err != nil {
+			break
+		}
+		if previousStatus == "completed" {
+			err = models.ReopenPlanItem(database.DB, item.ID)
+		}
+		if err == nil && procedure.Status == "cancelled" {
+			err = models.UnschedulePlanItem(database.DB, item.ID)
+		}
+	default:
+		return
+	}
+	if err != nil {
+		// Most completed procedures aren't part of a plan
+		if item != nil {
+			log.Printf("Failed to update treatment plan item %d for procedure %d: %v", item.ID, procedure.ID, err)
+		}
+		return
+	}
+	recalculateTreatmentPlan(item.TreatmentPlanID)
+}
+
+// syncTreatmentPlanItemCost carries a completed appointment procedure's new cost over to the
+// plan item it did
+func syncTreatmentPlanItemCost(procedure *models.AppointmentProcedure) {
+	if procedure.Status != "completed" || procedure.TreatmentPlanProcedureID == nil {
+		return
+	}
+	var item models.TreatmentPlanProcedure
+	if err := database.DB.First(&item, *procedure.TreatmentPlanProcedureID).Error; err != nil {
+		return
+	}
+	if err := models.UpdatePlanItemCost(database.DB, item.ID, procedure.Cost); err != nil {
+		log.Printf("Failed to update treatment plan item %d cost for procedure %d: %v", item.ID, procedure.ID, err)
+		return
+	}
+	recalculateTreatmentPlan(item.TreatmentPlanID)
+}
+
+// planVisits packs a phase's items, in order, into visits of at most maxMinutes. A procedure
+// longer than that gets a visit of its own.
+func planVisits(items []models.TreatmentPlanProcedure, maxMinutes int) []ProposedVisit {
+	var visits []ProposedVisit
+	for _, item := range items {
+		minutes := item.ProcedureTemplate.EstimatedDuration
+		if minutes <= 0 {
+			minutes = defaultPlanProcedureMinutes
+		}
+		if len(visits) == 0 || visits[len(visits)-1].Duration+minutes > maxMinutes {
+			visits = append(visits, ProposedVisit{})
+		}
+		visit := &visits[len(visits)-1]
+		visit.Duration += minutes
+		visit.EstimatedCost += item.EstimatedCost
+		visit.Items = append(visit.Items, item)
+	}
+	return visits
+}
+
+// findPlanVisitSlot gives the visit the earliest free time on or after the from date, within the
+// scheduling window, and returns the date it was placed on
+func findPlanVisitSlot(visit *ProposedVisit, clinicID uint, branchID, doctorID *uint, from time.Time) (time.Time, bool) {
+	duration := time.Duration(visit.Duration) * time.Minute
+	var templateIDs []uint
+	for _, item := range visit.Items {
+		templateIDs = append(templateIDs, item.ProcedureTemplateID)
+	}
+
+	type candidate struct {
+		start    time.Time
+		doctorID uint
+		branchID uint
+	}
+	for day := 0; day < planSchedulingWindowDays; day++ {
+		date := from.AddDate(0, 0, day)
+		_, doctors := generateAvailableTimeSlots(date, clinicID, branchID, doctorID, duration)
+
+		var candidates []candidate
+		for _, doctor := range doctors {
+			for _, slot := range doctor.Slots {
+				candidates = append(candidates, candidate{slot.StartTime, doctor.DoctorID, doctor.BranchID})
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].start.Before(candidates[j].start) })
+
+		for _, slot := range candidates {
+			end := slot.start.Add(duration)
+			resources, reason, _ := checkOccurrence(slot.doctorID, slot.branchID, slot.start, end, 0, nil, templateIDs)
+			if reason != "" {
+				continue
+			}
+			start := slot.start
+			visit.StartTime = &start
+			visit.EndTime = &end
+			visit.DoctorID = slot.doctorID
+			visit.BranchID = slot.branchID
+			visit.resources = resources
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ScheduleNextTreatmentPlanPhase proposes appointments for the next phase of the plan that still
+// has procedures to schedule, packing them into visits and finding free slots for each. With
+// book=true the appointments are booked and the items marked scheduled.
+func ScheduleNextTreatmentPlanPhase(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	plan, err := findPatientTreatmentPlan(c, user)
+	if plan == nil {
+		return err
+	}
+	if !plan.IsActive() {
+		return c.Status(409).JSON(fiber.Map{"error": "Only active treatment plans can be scheduled"})
+	}
+
+	var req ScheduleNextPhaseRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.MaxVisitMinutes <= 0 {
+		req.MaxVisitMinutes = 120
+	}
+	if req.DaysBetween <= 0 {
+		req.DaysBetween = 7
+	}
+
+	from := time.Now()
+	if req.From != "" {
+		date, err := time.Parse("2006-01-02", req.From)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid from date, use YYYY-MM-DD"})
+		}
+		if date.After(from) {
+			from = date
+		}
+	}
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+
+	var doctorID, branchID *uint
+	if req.DoctorID != 0 {
+		var doctor models.User
+		if err := database.DB.Where("id = ? AND clinic_id = ? AND is_active = ?", req.DoctorID, plan.ClinicID, true).First(&doctor).Error; err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid doctor for this clinic"})
+		}
+		doctorID = &doctor.ID
+	}
+	if req.BranchID != 0 {
+		var branch models.Branch
+		if err := database.DB.Where("id = ? AND clinic_id = ?", req.BranchID, plan.ClinicID).First(&branch).Error; err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid branch for this clinic"})
+		}
+		branchID = &branch.ID
+	}
+
+	// The next phase is the first, in order, with procedures still to be booked; items outside any
+	// phase come last
+	var phases []models.TreatmentPlanPhase
+	database.DB.Where("treatment_plan_id = ?", plan.ID).Order("sequence ASC, id ASC").Find(&phases)
+	var planned []models.TreatmentPlanProcedure
+	if err := database.DB.Preload("ProcedureTemplate").
+		Where("treatment_plan_id = ? AND status = ?", plan.ID, models.PlanItemPlanned).
+		Order("sequence ASC, id ASC").Find(&planned).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch treatment plan items"})
+	}
+
+	var phase *models.TreatmentPlanPhase
+	var items []models.TreatmentPlanProcedure
+	for i := range phases {
+		for _, item := range planned {
+			if item.PhaseID != nil && *item.PhaseID == phases[i].ID {
+				items = append(items, item)
+			}
+		}
+		if len(items) > 0 {
+			phase = &phases[i]
+			break
+		}
+	}
+	if phase == nil {
+		for _, item := range planned {
+			if item.PhaseID == nil {
+				items = append(items, item)
+			}
+		}
+	}
+	if len(items) == 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "The treatment plan has no procedures left to schedule"})
+	}
+
+	if phase != nil && phase.DependsOnPhaseID != nil {
+		var dependency models.TreatmentPlanPhase
+		if err := database.DB.First(&dependency, *phase.DependsOnPhaseID).Error; err == nil {
+			// A phase without any procedures to do doesn't hold up the ones after it
+			var remaining int64
+			database.DB.Model(&models.TreatmentPlanProcedure{}).
+				Where("phase_id = ? AND status <> ?", dependency.ID, models.PlanItemSkipped).Count(&remaining)
+			if dependency.Status != models.PhaseCompleted && remaining > 0 {
+				return c.Status(409).JSON(fiber.Map{
+					"error":            "Phase \"" + dependency.Name + "\" has to be completed before \"" + phase.Name + "\" can be scheduled",
+					"phase":            phase,
+					"depends_on_phase": dependency,
+				})
+			}
+			if dependency.CompletedAt != nil && phase.DaysAfterDependency > 0 {
+				earliest := dependency.CompletedAt.AddDate(0, 0, phase.DaysAfterDependency)
+				earliest = time.Date(earliest.Year(), earliest.Month(), earliest.Day(), 0, 0, 0, 0, time.UTC)
+				if earliest.After(from) {
+					from = earliest
+				}
+			}
+		}
+	}
+
+	// Find a slot for each visit, leaving the requested number of days between them
+	visits := planVisits(items, req.MaxVisitMinutes)
+	unplaced := 0
+	for i := range visits {
+		placed, ok := findPlanVisitSlot(&visits[i], plan.ClinicID, branchID, doctorID, from)
+		if !ok {
+			unplaced++
+			continue
+		}
+		from = placed.AddDate(0, 0, req.DaysBetween)
+	}
+
+	if !req.Book {
+		return c.JSON(fiber.Map{
+			"phase":    phase,
+			"visits":   visits,
+			"unplaced": unplaced,
+			"booked":   false,
+		})
+	}
+	if unplaced > 0 {
+		return c.Status(409).JSON(fiber.Map{
+			"error":    "No free slot was found for every visit within " + strconv.Itoa(planSchedulingWindowDays) + " days",
+			"phase":    phase,
+			"visits":   visits,
+			"unplaced": unplaced,
+		})
+	}
+
+	title := plan.Title
+	if phase != nil {
+		title = plan.Title + " – " + phase.Name
+	}
+
+	tx := database.DB.Begin()
+	for i := range visits {
+		visit := &visits[i]
+		appointment := models.Appointment{
+			Title:         title,
+			Description:   plan.Description,
+			StartTime:     *visit.StartTime,
+			EndTime:       *visit.EndTime,
+			Duration:      visit.Duration,
+			Status:        models.StatusScheduled,
+			Timezone:      models.BranchLocation(database.DB, visit.BranchID).String(),
+			EstimatedCost: visit.EstimatedCost,
+			PatientID:     plan.PatientID,
+			DoctorID:      visit.DoctorID,
+			BranchID:      visit.BranchID,
+			ClinicID:      plan.ClinicID,
+			Resources:     appointmentResourceRows(0, visit.resources),
+		}
+		conflicts, err := lockAppointmentResources(tx, visit.resources, appointment.StartTime, appointment.EndTime, 0)
+		if err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to book treatment plan appointments"})
+		}
+		if len(conflicts) > 0 {
+			tx.Rollback()
+			return c.Status(409).JSON(fiber.Map{
+				"error":              "Resources were booked by someone else in the meantime; try again",
+				"resource_conflicts": conflicts,
+			})
+		}
+		if err := tx.Create(&appointment).Error; err != nil {
+			tx.Rollback()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to book treatment plan appointments"})
+		}
+
+		for j := range visit.Items {
+			item := &visit.Items[j]
+			procedure := models.AppointmentProcedure{
+				ProcedureTemplateID:      item.ProcedureTemplateID,
+				AppointmentID:            appointment.ID,
+				ToothNumber:              item.ToothNumber,
+				Surface:                  item.Surface,
+				Notes:                    item.Notes,
+				Cost:                     item.EstimatedCost,
+				Status:                   "planned",
+				PerformedByID:            visit.DoctorID,
+				TreatmentPlanProcedureID: &item.ID,
+			}
+			if err := tx.Create(&procedure).Error; err != nil {
+				tx.Rollback()
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to book treatment plan appointments"})
+			}
+
+			// Claim the item so two bookings can't both take it
+			claim := tx.Model(&models.TreatmentPlanProcedure{}).
+				Where("id = ? AND status = ?", item.ID, models.PlanItemPlanned).
+				Updates(map[string]interface{}{
+					"status":                   models.PlanItemScheduled,
+					"appointment_id":           appointment.ID,
+					"appointment_procedure_id": procedure.ID,
+				})
+			if claim.Error != nil {
+				tx.Rollback()
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to book treatment plan appointments"})
+			}
+			if claim.RowsAffected == 0 {
+				tx.Rollback()
+				return c.Status(409).JSON(fiber.Map{"error": "The treatment plan was changed by someone else; reload and try again"})
+			}
+			item.Status = models.PlanItemScheduled
+			item.AppointmentID = &appointment.ID
+			item.AppointmentProcedureID = &procedure.ID
+		}
+		visit.Appointment = &appointment
+	}
+	if err := models.RecalculateTreatmentPlan(tx, plan.ID); err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to book treatment plan appointments"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to book treatment plan appointments"})
+	}
+
+	var patient models.Patient
+	database.DB.First(&patient, plan.PatientID)
+	for _, visit := range visits {
+		scheduleAppointmentReminders(visit.Appointment)
+		go SendAppointmentUpdate(visit.Appointment.ID, patient.GetFullName(), "scheduled", plan.ClinicID)
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"phase":    phase,
+		"visits":   visits,
+		"unplaced": 0,
+		"booked":   true,
+	})
+}
@@ -23,6 +23,10 @@ const (
 	AvatarDir        = "avatars"
 	InventoryItemDir = "inventory-items"
 	ClinicLogoDir    = "clinic-logos"
+
+	// PrivateBaseDir holds files with patient data, e.g. signed consent PDFs. Unlike
+	// UploadBaseDir it is not served statically; its files are only sent by authenticated handlers.
+	PrivateBaseDir = "storage"
 )
 
 var AllowedImageTypes = map[string]bool{
@@ -43,6 +47,7 @@ func createUploadDirs() {
 		filepath.Join(UploadBaseDir, AvatarDir),
 		filepath.Join(UploadBaseDir, InventoryItemDir),
 		filepath.Join(UploadBaseDir, ClinicLogoDir),
+		filepath.Join(PrivateBaseDir, models.ConsentFormPDFDir),
 	}
 
 	for _, dir := range dirs {
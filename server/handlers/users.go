@@ -277,7 +277,17 @@ func DeleteUser(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
-	if err := database.DB.Delete(&models.User{}, uint(userID)).Error; err != nil {
+	// The user's calendar feed links stop working with them
+	tx := database.DB.Begin()
+	if err := tx.Delete(&models.User{}, uint(userID)).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Could not delete user"})
+	}
+	if err := tx.Where("user_id = ?", uint(userID)).Delete(&models.CalendarFeed{}).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Could not delete user"})
+	}
+	if err := tx.Commit().Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Could not delete user"})
 	}
 
@@ -0,0 +1,854 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+	"dentika/server/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// publicAppURL is the patient-facing app that opens waitlist offer links
+var publicAppURL string
+
+// SetPublicAppURL sets the base URL used in links sent to patients
+func SetPublicAppURL(url string) {
+	publicAppURL = strings.TrimRight(url, "/")
+}
+
+type WaitlistEntryRequest struct {
+	PatientID           uint                        `json:"patient_id"`
+	PreferredDoctorID   *uint                       `json:"preferred_doctor_id"`
+	BranchID            *uint                       `json:"branch_id"`
+	ProcedureTemplateID *uint                       `json:"procedure_template_id"`
+	Duration            int                         `json:"duration"`
+	Urgency             models.WaitlistUrgency      `json:"urgency"`
+	Notes               string                      `json:"notes"`
+	EarliestDate        string                      `json:"earliest_date"` // YYYY-MM-DD
+	LatestDate          string                      `json:"latest_date"`   // YYYY-MM-DD
+	TimeWindows         []models.WaitlistTimeWindow `json:"time_windows"`
+}
+
+// WaitlistSlotRequest names the slot to fill: a cancelled appointment, or a start time, doctor and branch
+type WaitlistSlotRequest struct {
+	AppointmentID    uint       `json:"appointment_id"`
+	StartTime        *time.Time `json:"start_time"`
+	DoctorID         uint       `json:"doctor_id"`
+	BranchID         uint       `json:"branch_id"`
+	ExpiresInMinutes int        `json:"expires_in_minutes"` // offers only
+}
+
+// freedSlot returns the slot a cancelled or no-show appointment left open, in the branch's
+// timezone so waitlist dates and time windows are read as local times
+func freedSlot(appointment models.Appointment) models.WaitlistSlot {
+	location := appointment.Location()
+	return models.WaitlistSlot{
+		StartTime: appointment.StartTime.In(location),
+		EndTime:   appointment.EndTime.In(location),
+		DoctorID:  appointment.DoctorID,
+		BranchID:  appointment.BranchID,
+	}
+}
+
+// localizeOffer puts an offer's times in its branch's timezone
+func localizeOffer(offer *models.WaitlistOffer) {
+	location := models.BranchLocation(database.DB, offer.BranchID)
+	offer.StartTime = offer.StartTime.In(location)
+	offer.EndTime = offer.EndTime.In(location)
+	offer.ExpiresAt = offer.ExpiresAt.In(location)
+}
+
+// releaseExpiredOffers puts entries whose offers have all lapsed back on the waitlist
+func releaseExpiredOffers(clinicID uint) {
+	database.DB.Model(&models.WaitlistEntry{}).
+		Where("clinic_id = ? AND status = ?", clinicID, models.WaitlistOffered).
+		Where("NOT EXISTS (SELECT 1 FROM waitlist_offers o WHERE o.waitlist_entry_id = waitlist_entries.id AND o.status = ? AND o.expires_at > ?)",
+			models.OfferPending, time.Now()).
+		Update("status", models.WaitlistWaiting)
+}
+
+// findWaitlistMatches ranks the clinic's waiting entries for a slot
+func findWaitlistMatches(clinicID uint, slot models.WaitlistSlot) ([]models.WaitlistMatch, error) {
+	releaseExpiredOffers(clinicID)
+
+	var entries []models.WaitlistEntry
+	if err := database.DB.Preload("Patient").Preload("TimeWindows").Preload("ProcedureTemplate").
+		Where("clinic_id = ? AND status = ?", clinicID, models.WaitlistWaiting).
+		Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return models.RankWaitlist(entries, slot, time.Now()), nil
+}
+
+// notifyWaitlistMatches tells staff about waitlisted patients who could take a freed slot,
+// with actions to book or offer it to the best matches
+func notifyWaitlistMatches(appointment models.Appointment) {
+	if notificationService == nil || !appointment.StartTime.After(time.Now()) {
+		return
+	}
+
+	slot := freedSlot(appointment)
+	matches, err := findWaitlistMatches(appointment.ClinicID, slot)
+	if err != nil {
+		log.Printf("Failed to match waitlist for appointment %d: %v", appointment.ID, err)
+		return
+	}
+	if len(matches) == 0 {
+		return
+	}
+	if len(matches) > 3 {
+		matches = matches[:3]
+	}
+
+	actions := []models.NotificationAction{}
+	names := []string{}
+	for i, match := range matches {
+		name := match.Entry.Patient.GetFullName()
+		names = append(names, name)
+		payload := map[string]interface{}{"appointment_id": appointment.ID}
+		style := "secondary"
+		if i == 0 {
+			style = "primary"
+		}
+		actions = append(actions, models.NotificationAction{
+			Label:   "Book " + name,
+			Action:  "waitlist-book",
+			URL:     fmt.Sprintf("/api/waitlist/%d/book", match.Entry.ID),
+			Method:  "POST",
+			Payload: payload,
+			Style:   style,
+		})
+		actions = append(actions, models.NotificationAction{
+			Label:   "Offer to " + name,
+			Action:  "waitlist-offer",
+			URL:     fmt.Sprintf("/api/waitlist/%d/offer", match.Entry.ID),
+			Method:  "POST",
+			Payload: payload,
+			Style:   "secondary",
+		})
+	}
+
+	expires := slot.StartTime
+	clinicID := appointment.ClinicID
+	_, err = notificationService.CreateNotification(services.CreateNotificationRequest{
+		Title:    "Slot Freed: Waitlist Matches",
+		Message:  fmt.Sprintf("%s is now free. Waitlisted patients who fit: %s", formatMessageTime(slot.StartTime), strings.Join(names, ", ")),
+		Type:     models.NotificationTypeAppointmentUpdate,
+		ClinicID: &clinicID,
+		Data: map[string]interface{}{
+			"appointment_id": appointment.ID,
+			"start_time":     slot.StartTime,
+			"doctor_id":      slot.DoctorID,
+			"branch_id":      slot.BranchID,
+			"matches":        matches,
+		},
+		Actions:   actions,
+		ExpiresAt: &expires,
+	})
+	if err != nil {
+		log.Printf("Failed to notify waitlist matches for appointment %d: %v", appointment.ID, err)
+	}
+}
+
+// applyWaitlistRequest checks the request against the patient's clinic and fills the entry.
+// A nil patient means the error response has been sent.
+func applyWaitlistRequest(c *fiber.Ctx, user models.User, req WaitlistEntryRequest, entry *models.WaitlistEntry) (*models.Patient, error) {
+	var patient models.Patient
+	if err := database.DB.First(&patient, req.PatientID).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "Patient not found"})
+	}
+	if !user.CanAccessClinic(patient.ClinicID) {
+		return nil, c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+	if req.PreferredDoctorID != nil {
+		var doctor models.User
+		if err := database.DB.First(&doctor, *req.PreferredDoctorID).Error; err != nil {
+			return nil, c.Status(404).JSON(fiber.Map{"error": "Doctor not found"})
+		}
+		if !doctor.IsSuperAdmin() && doctor.ClinicID != patient.ClinicID {
+			return nil, c.Status(400).JSON(fiber.Map{"error": "Doctor does not belong to the same clinic"})
+		}
+	}
+	if req.BranchID != nil {
+		var branch models.Branch
+		if err := database.DB.First(&branch, *req.BranchID).Error; err != nil {
+			return nil, c.Status(404).JSON(fiber.Map{"error": "Branch not found"})
+		}
+		if branch.ClinicID != patient.ClinicID {
+			return nil, c.Status(400).JSON(fiber.Map{"error": "Branch does not belong to the same clinic"})
+		}
+	}
+
+	duration := req.Duration
+	if req.ProcedureTemplateID != nil {
+		template, err := models.ResolveProcedureTemplate(database.DB, patient.ClinicID, *req.ProcedureTemplateID)
+		if err != nil {
+			return nil, c.Status(404).JSON(fiber.Map{"error": "Procedure template not found"})
+		}
+		req.ProcedureTemplateID = &template.ID
+		if duration <= 0 {
+			duration = template.EstimatedDuration
+		}
+	}
+	if duration <= 0 {
+		duration = 30
+	}
+
+	urgency := req.Urgency
+	if urgency == "" {
+		urgency = models.UrgencyNormal
+	}
+	if !models.IsValidWaitlistUrgency(urgency) {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Urgency must be low, normal, high or urgent"})
+	}
+
+	earliest, err := parseOptionalDate(req.EarliestDate)
+	if err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Invalid earliest_date, expected YYYY-MM-DD"})
+	}
+	latest, err := parseOptionalDate(req.LatestDate)
+	if err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Invalid latest_date, expected YYYY-MM-DD"})
+	}
+	if earliest != nil && latest != nil && latest.Before(*earliest) {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "latest_date must not be before earliest_date"})
+	}
+
+	windows := make([]models.WaitlistTimeWindow, 0, len(req.TimeWindows))
+	for i, window := range req.TimeWindows {
+		if err := window.Validate(); err != nil {
+			return nil, c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Time window %d: %v", i+1, err)})
+		}
+		windows = append(windows, models.WaitlistTimeWindow{DayOfWeek: window.DayOfWeek, StartTime: window.StartTime, EndTime: window.EndTime})
+	}
+
+	entry.PatientID = patient.ID
+	entry.PreferredDoctorID = req.PreferredDoctorID
+	entry.BranchID = req.BranchID
+	entry.ProcedureTemplateID = req.ProcedureTemplateID
+	entry.Duration = duration
+	entry.Urgency = urgency
+	entry.Notes = req.Notes
+	entry.EarliestDate = earliest
+	entry.LatestDate = latest
+	entry.TimeWindows = windows
+	entry.ClinicID = patient.ClinicID
+	return &patient, nil
+}
+
+// findWaitlistEntry loads the waitlist entry in the URL, checking clinic access
+func findWaitlistEntry(c *fiber.Ctx, user models.User) (*models.WaitlistEntry, error) {
+	var entry models.WaitlistEntry
+	if err := database.DB.Preload("Patient").Preload("TimeWindows").First(&entry, c.Params("id")).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "Waitlist entry not found"})
+	}
+	if !user.CanAccessClinic(entry.ClinicID) {
+		return nil, c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+	return &entry, nil
+}
+
+// GetWaitlist lists the clinic's waitlist, most urgent first
+func GetWaitlist(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	query := database.DB.Model(&models.WaitlistEntry{})
+	if !user.IsSuperAdmin() {
+		query = query.Where("clinic_id = ?", user.ClinicID)
+	} else if clinicID := c.Query("clinic_id"); clinicID != "" {
+		query = query.Where("clinic_id = ?", clinicID)
+	}
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status IN ?", strings.Split(status, ","))
+	} else {
+		query = query.Where("status IN ?", []models.WaitlistStatus{models.WaitlistWaiting, models.WaitlistOffered})
+	}
+	if branchID := c.Query("branch_id"); branchID != "" {
+		query = query.Where("branch_id = ? OR branch_id IS NULL", branchID)
+	}
+	if doctorID := c.Query("doctor_id"); doctorID != "" {
+		query = query.Where("preferred_doctor_id = ?", doctorID)
+	}
+	if patientID := c.Query("patient_id"); patientID != "" {
+		query = query.Where("patient_id = ?", patientID)
+	}
+
+	var entries []models.WaitlistEntry
+	if err := query.Preload("Patient").Preload("PreferredDoctor").Preload("ProcedureTemplate").Preload("TimeWindows").
+		Order("FIELD(urgency, 'urgent', 'high', 'normal', 'low'), created_at ASC").
+		Find(&entries).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch waitlist"})
+	}
+
+	return c.JSON(entries)
+}
+
+// CreateWaitlistEntry puts a patient on the waitlist
+func CreateWaitlistEntry(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var req WaitlistEntryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	entry := models.WaitlistEntry{Status: models.WaitlistWaiting, CreatedByID: user.ID}
+	if patient, err := applyWaitlistRequest(c, user, req, &entry); patient == nil {
+		return err
+	}
+
+	if err := database.DB.Create(&entry).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to add patient to waitlist"})
+	}
+
+	database.DB.Preload("Patient").Preload("PreferredDoctor").Preload("ProcedureTemplate").Preload("TimeWindows").First(&entry, entry.ID)
+	return c.Status(201).JSON(entry)
+}
+
+// UpdateWaitlistEntry changes a waiting patient's preferences
+func UpdateWaitlistEntry(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	entry, err := findWaitlistEntry(c, user)
+	if entry == nil {
+		return err
+	}
+	if entry.Status == models.WaitlistBooked || entry.Status == models.WaitlistCancelled {
+		return c.Status(409).JSON(fiber.Map{"error": "Waitlist entry is closed"})
+	}
+
+	var req WaitlistEntryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if req.PatientID == 0 {
+		req.PatientID = entry.PatientID
+	}
+	clinicID := entry.ClinicID
+	if patient, err := applyWaitlistRequest(c, user, req, entry); patient == nil {
+		return err
+	}
+	if entry.ClinicID != clinicID {
+		return c.Status(400).JSON(fiber.Map{"error": "Patient belongs to a different clinic"})
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Where("waitlist_entry_id = ?", entry.ID).Delete(&models.WaitlistTimeWindow{}).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update waitlist entry"})
+	}
+	if err := tx.Session(&gorm.Session{FullSaveAssociations: true}).Omit("Patient", "PreferredDoctor", "ProcedureTemplate").Save(entry).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update waitlist entry"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update waitlist entry"})
+	}
+
+	database.DB.Preload("Patient").Preload("PreferredDoctor").Preload("ProcedureTemplate").Preload("TimeWindows").First(entry, entry.ID)
+	return c.JSON(entry)
+}
+
+// DeleteWaitlistEntry takes a patient off the waitlist
+func DeleteWaitlistEntry(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	entry, err := findWaitlistEntry(c, user)
+	if entry == nil {
+		return err
+	}
+	if entry.Status == models.WaitlistBooked {
+		return c.Status(409).JSON(fiber.Map{"error": "Waitlist entry has already been booked"})
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Model(entry).Update("status", models.WaitlistCancelled).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to remove waitlist entry"})
+	}
+	if err := tx.Model(&models.WaitlistOffer{}).
+		Where("waitlist_entry_id = ? AND status = ?", entry.ID, models.OfferPending).
+		Update("status", models.OfferSuperseded).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to remove waitlist entry"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to remove waitlist entry"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Patient removed from waitlist"})
+}
+
+// GetWaitlistMatches ranks waitlisted patients for the slot a cancelled appointment left
+func GetWaitlistMatches(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	var appointment models.Appointment
+	if err := database.DB.First(&appointment, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Appointment not found"})
+	}
+	if !user.CanAccessClinic(appointment.ClinicID) {
+		return c.Status(403).JSON(fiber.Map{"error": "Access denied"})
+	}
+
+	slot := freedSlot(appointment)
+	matches, err := findWaitlistMatches(appointment.ClinicID, slot)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to match waitlist"})
+	}
+
+	return c.JSON(fiber.Map{
+		"slot":    slot,
+		"matches": matches,
+	})
+}
+
+// resolveWaitlistSlot works out the slot a book or offer request refers to
+func resolveWaitlistSlot(c *fiber.Ctx, entry *models.WaitlistEntry, req WaitlistSlotRequest) (*models.WaitlistSlot, *uint, error) {
+	if req.AppointmentID != 0 {
+		var appointment models.Appointment
+		if err := database.DB.First(&appointment, req.AppointmentID).Error; err != nil {
+			return nil, nil, c.Status(404).JSON(fiber.Map{"error": "Appointment not found"})
+		}
+		if appointment.ClinicID != entry.ClinicID {
+			return nil, nil, c.Status(400).JSON(fiber.Map{"error": "Appointment belongs to a different clinic"})
+		}
+		if appointment.Status != models.StatusCancelled && appointment.Status != models.StatusNoShow {
+			return nil, nil, c.Status(409).JSON(fiber.Map{"error": "Only cancelled or no-show appointments free a slot"})
+		}
+		slot := freedSlot(appointment)
+		return &slot, &appointment.ID, nil
+	}
+
+	if req.StartTime == nil || req.DoctorID == 0 || req.BranchID == 0 {
+		return nil, nil, c.Status(400).JSON(fiber.Map{"error": "Either appointment_id or start_time, doctor_id and branch_id are required"})
+	}
+	var branch models.Branch
+	if err := database.DB.First(&branch, req.BranchID).Error; err != nil || branch.ClinicID != entry.ClinicID {
+		return nil, nil, c.Status(400).JSON(fiber.Map{"error": "Branch does not belong to the same clinic"})
+	}
+	var doctor models.User
+	if err := database.DB.First(&doctor, req.DoctorID).Error; err != nil || (!doctor.IsSuperAdmin() && doctor.ClinicID != entry.ClinicID) {
+		return nil, nil, c.Status(400).JSON(fiber.Map{"error": "Doctor does not belong to the same clinic"})
+	}
+	start := req.StartTime.In(models.BranchLocation(database.DB, req.BranchID))
+	return &models.WaitlistSlot{
+		StartTime: start,
+		EndTime:   start.Add(time.Duration(entry.Duration) * time.Minute),
+		DoctorID:  req.DoctorID,
+		BranchID:  req.BranchID,
+	}, nil, nil
+}
+
+// bookWaitlistSlot books the entry's patient into the slot and closes the entry. Other pending
+// offers for the same slot are withdrawn. It returns the reason when the slot is no longer free.
+func bookWaitlistSlot(entry *models.WaitlistEntry, slot models.WaitlistSlot) (*models.Appointment, string, interface{}, error) {
+	start := slot.StartTime
+	end := start.Add(time.Duration(entry.Duration) * time.Minute)
+
+	var templateIDs []uint
+	if entry.ProcedureTemplateID != nil {
+		templateIDs = []uint{*entry.ProcedureTemplateID}
+	}
+	resources, reason, details := checkOccurrence(slot.DoctorID, slot.BranchID, start, end, 0, nil, templateIDs)
+	if reason != "" {
+		return nil, reason, details, nil
+	}
+
+	title := "Waitlist appointment"
+	if entry.ProcedureTemplate != nil && entry.ProcedureTemplate.Name != "" {
+		title = entry.ProcedureTemplate.Name
+	}
+	appointment := models.Appointment{
+		Title:               title,
+		StartTime:           start,
+		EndTime:             end,
+		Duration:            entry.Duration,
+		Status:              models.StatusScheduled,
+		PatientID:           entry.PatientID,
+		DoctorID:            slot.DoctorID,
+		BranchID:            slot.BranchID,
+		ClinicID:            entry.ClinicID,
+		PreAppointmentNotes: entry.Notes,
+		Resources:           appointmentResourceRows(0, resources),
+	}
+
+	tx := database.DB.Begin()
+	// Claim the entry so two bookings can't both take it
+	claim := tx.Model(&models.WaitlistEntry{}).
+		Where("id = ? AND status IN ?", entry.ID, []models.WaitlistStatus{models.WaitlistWaiting, models.WaitlistOffered}).
+		Update("status", models.WaitlistBooked)
+	if claim.Error != nil {
+		tx.Rollback()
+		return nil, "", nil, claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		tx.Rollback()
+		return nil, "entry_closed", nil, nil
+	}
+	conflicts, err := lockAppointmentResources(tx, resources, start, end, 0)
+	if err != nil {
+		tx.Rollback()
+		return nil, "", nil, err
+	}
+	if len(conflicts) > 0 {
+		tx.Rollback()
+		return nil, "resource_conflict", conflicts, nil
+	}
+	if err := tx.Create(&appointment).Error; err != nil {
+		tx.Rollback()
+		return nil, "", nil, err
+	}
+	if err := tx.Model(&models.WaitlistEntry{}).Where("id = ?", entry.ID).Update("booked_appointment_id", appointment.ID).Error; err != nil {
+		tx.Rollback()
+		return nil, "", nil, err
+	}
+	if err := tx.Model(&models.WaitlistOffer{}).
+		Where("status = ? AND (waitlist_entry_id = ? OR (doctor_id = ? AND start_time = ?))",
+			models.OfferPending, entry.ID, slot.DoctorID, slot.StartTime).
+		Update("status", models.OfferSuperseded).Error; err != nil {
+		tx.Rollback()
+		return nil, "", nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, "", nil, err
+	}
+
+	entry.Status = models.WaitlistBooked
+	entry.BookedAppointmentID = &appointment.ID
+	scheduleAppointmentReminders(&appointment)
+	go SendAppointmentUpdate(appointment.ID, entry.Patient.GetFullName(), "scheduled", appointment.ClinicID)
+
+	return &appointment, "", nil, nil
+}
+
+// BookWaitlistEntry books a waitlisted patient straight into a freed slot
+func BookWaitlistEntry(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	entry, err := findWaitlistEntry(c, user)
+	if entry == nil {
+		return err
+	}
+	database.DB.Preload("ProcedureTemplate").First(entry, entry.ID)
+
+	var req WaitlistSlotRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	slot, _, err := resolveWaitlistSlot(c, entry, req)
+	if slot == nil {
+		return err
+	}
+
+	appointment, reason, details, err := bookWaitlistSlot(entry, *slot)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to book waitlist entry"})
+	}
+	if reason == "entry_closed" {
+		return c.Status(409).JSON(fiber.Map{"error": "Waitlist entry is no longer waiting"})
+	}
+	if reason != "" {
+		return c.Status(409).JSON(fiber.Map{"error": "The slot is no longer available", "reason": reason, "details": details})
+	}
+
+	database.DB.Preload("Patient").Preload("Doctor").Preload("Branch").First(appointment, appointment.ID)
+	return c.Status(201).JSON(fiber.Map{
+		"appointment":    appointment,
+		"waitlist_entry": entry,
+	})
+}
+
+// OfferWaitlistSlot sends a waitlisted patient a link to accept a freed slot
+func OfferWaitlistSlot(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	entry, err := findWaitlistEntry(c, user)
+	if entry == nil {
+		return err
+	}
+	if entry.Status != models.WaitlistWaiting && entry.Status != models.WaitlistOffered {
+		return c.Status(409).JSON(fiber.Map{"error": "Waitlist entry is no longer waiting"})
+	}
+
+	var req WaitlistSlotRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	slot, sourceID, err := resolveWaitlistSlot(c, entry, req)
+	if slot == nil {
+		return err
+	}
+	if !slot.StartTime.After(time.Now()) {
+		return c.Status(400).JSON(fiber.Map{"error": "The slot has already started"})
+	}
+
+	ttl := models.DefaultWaitlistOfferTTL
+	if req.ExpiresInMinutes > 0 {
+		ttl = time.Duration(req.ExpiresInMinutes) * time.Minute
+	}
+	expiresAt := time.Now().In(slot.StartTime.Location()).Add(ttl)
+	if expiresAt.After(slot.StartTime) {
+		expiresAt = slot.StartTime
+	}
+
+	token, err := models.GenerateToken()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create offer"})
+	}
+	offer := models.WaitlistOffer{
+		Token:               token,
+		WaitlistEntryID:     entry.ID,
+		StartTime:           slot.StartTime,
+		EndTime:             slot.StartTime.Add(time.Duration(entry.Duration) * time.Minute),
+		DoctorID:            slot.DoctorID,
+		BranchID:            slot.BranchID,
+		SourceAppointmentID: sourceID,
+		Status:              models.OfferPending,
+		ExpiresAt:           expiresAt,
+		OfferedByID:         user.ID,
+		ClinicID:            entry.ClinicID,
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Create(&offer).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create offer"})
+	}
+	if err := tx.Model(entry).Update("status", models.WaitlistOffered).Error; err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create offer"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create offer"})
+	}
+
+	response := fiber.Map{
+		"offer":           offer,
+		"token":           offer.Token,
+		"path":            "/api/public/waitlist-offers/" + offer.Token,
+		"messages_queued": 0,
+	}
+	// Without a public app URL there is no link to send; staff share the path themselves
+	if publicAppURL != "" {
+		response["link"] = waitlistOfferLink(offer.Token)
+		response["messages_queued"] = queueWaitlistOffer(entry, &offer)
+	}
+
+	return c.Status(201).JSON(response)
+}
+
+func waitlistOfferLink(token string) string {
+	return publicAppURL + "/waitlist-offer/" + token
+}
+
+// queueWaitlistOffer emails and texts the offer link to the patient, returning how many messages were queued
+func queueWaitlistOffer(entry *models.WaitlistEntry, offer *models.WaitlistOffer) int {
+	if messagingService == nil {
+		return 0
+	}
+
+	var clinic models.Clinic
+	database.DB.Select("id", "name", "phone").First(&clinic, offer.ClinicID)
+	var branch models.Branch
+	database.DB.First(&branch, offer.BranchID)
+
+	place := clinicDisplayName(clinic, branch)
+	when := formatMessageTime(offer.StartTime)
+	link := waitlistOfferLink(offer.Token)
+	expires := formatMessageTime(offer.ExpiresAt)
+
+	var email strings.Builder
+	fmt.Fprintf(&email, "Hi %s,\n\n", entry.Patient.FirstName)
+	fmt.Fprintf(&email, "An earlier appointment has opened up at %s.\n\n", place)
+	fmt.Fprintf(&email, "When: %s\n", when)
+	if branch.Address != "" {
+		fmt.Fprintf(&email, "Where: %s\n", branch.Address)
+	}
+	fmt.Fprintf(&email, "\nTo take it, open this link before %s:\n%s\n", expires, link)
+	fmt.Fprintf(&email, "\n%s\n", clinic.Name)
+	sms := fmt.Sprintf("Hi %s, %s has an opening on %s. Accept before %s: %s",
+		entry.Patient.FirstName, place, when, expires, link)
+
+	patientID := entry.PatientID
+	messages := []models.OutboundMessage{
+		{Channel: models.ChannelEmail, Recipient: entry.Patient.Email, Subject: "An earlier appointment is available", Body: email.String()},
+		{Channel: models.ChannelSMS, Recipient: entry.Patient.Phone, Body: sms},
+	}
+	queued := 0
+	for i := range messages {
+		message := &messages[i]
+		message.Recipient = strings.TrimSpace(message.Recipient)
+		if message.Recipient == "" || !messagingService.HasChannel(message.Channel) {
+			continue
+		}
+		message.PatientID = &patientID
+		message.Category = models.MessageCategoryWaitlistOffer
+		message.ClinicID = offer.ClinicID
+		if err := messagingService.Enqueue(message); err != nil {
+			log.Printf("Failed to queue %s message for waitlist offer %d: %v", message.Channel, offer.ID, err)
+			continue
+		}
+		queued++
+	}
+	return queued
+}
+
+// findWaitlistOffer loads an offer by its public token
+func findWaitlistOffer(c *fiber.Ctx) (*models.WaitlistOffer, error) {
+	var offer models.WaitlistOffer
+	if err := database.DB.Preload("WaitlistEntry.Patient").Preload("WaitlistEntry.ProcedureTemplate").
+		Preload("Doctor").Preload("Branch").
+		Where("token = ?", c.Params("token")).First(&offer).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "Offer not found"})
+	}
+	localizeOffer(&offer)
+	return &offer, nil
+}
+
+// publicOfferView is what the patient sees of an offer
+func publicOfferView(offer *models.WaitlistOffer) fiber.Map {
+	var clinic models.Clinic
+	database.DB.Select("id", "name", "phone").First(&clinic, offer.ClinicID)
+
+	status := offer.Status
+	if status == models.OfferPending && offer.IsExpired() {
+		status = "expired"
+	}
+	return fiber.Map{
+		"clinic":          clinic.Name,
+		"branch":          offer.Branch.Name,
+		"address":         offer.Branch.Address,
+		"doctor":          "Dr. " + offer.Doctor.FirstName + " " + offer.Doctor.LastName,
+		"patient":         offer.WaitlistEntry.Patient.FirstName,
+		"start_time":      offer.StartTime,
+		"end_time":        offer.EndTime,
+		"display":         formatMessageTime(offer.StartTime),
+		"status":          status,
+		"expires_at":      offer.ExpiresAt,
+		"contact_phone":   firstNonEmpty(offer.Branch.Phone, clinic.Phone),
+		"appointment_id":  offer.AppointmentID,
+		"can_be_accepted": offer.IsOpen(),
+	}
+}
+
+// GetPublicWaitlistOffer shows a patient the slot they were offered
+func GetPublicWaitlistOffer(c *fiber.Ctx) error {
+	offer, err := findWaitlistOffer(c)
+	if offer == nil {
+		return err
+	}
+	return c.JSON(publicOfferView(offer))
+}
+
+// AcceptPublicWaitlistOffer books the offered slot for the patient
+func AcceptPublicWaitlistOffer(c *fiber.Ctx) error {
+	offer, err := findWaitlistOffer(c)
+	if offer == nil {
+		return err
+	}
+	if !offer.IsOpen() {
+		return c.Status(410).JSON(fiber.Map{"error": "This offer has expired or is no longer available"})
+	}
+
+	// Claim the offer so a double click can't book twice
+	now := time.Now()
+	claim := database.DB.Model(&models.WaitlistOffer{}).
+		Where("id = ? AND status = ? AND expires_at > ?", offer.ID, models.OfferPending, now).
+		Updates(map[string]interface{}{"status": models.OfferAccepted, "responded_at": now})
+	if claim.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to accept offer"})
+	}
+	if claim.RowsAffected == 0 {
+		return c.Status(410).JSON(fiber.Map{"error": "This offer has expired or is no longer available"})
+	}
+
+	appointment, reason, _, err := bookWaitlistSlot(&offer.WaitlistEntry, offer.Slot())
+	if err != nil || reason != "" {
+		database.DB.Model(offer).Update("status", models.OfferSuperseded)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to accept offer"})
+		}
+		return c.Status(409).JSON(fiber.Map{"error": "Sorry, this slot has just been taken. The clinic will contact you."})
+	}
+
+	database.DB.Model(offer).Update("appointment_id", appointment.ID)
+	offer.Status = models.OfferAccepted
+	offer.RespondedAt = &now
+	offer.AppointmentID = &appointment.ID
+
+	database.DB.Preload("Patient").Preload("Branch").First(appointment, appointment.ID)
+	queueAppointmentConfirmation(appointment)
+
+	go SendClinicNotification(
+		"Waitlist Offer Accepted",
+		offer.WaitlistEntry.Patient.GetFullName()+" accepted the slot on "+formatMessageTime(offer.StartTime),
+		"appointment_scheduled",
+		offer.ClinicID,
+	)
+
+	return c.JSON(publicOfferView(offer))
+}
+
+// DeclinePublicWaitlistOffer lets the patient turn down an offer and stay on the waitlist
+func DeclinePublicWaitlistOffer(c *fiber.Ctx) error {
+	offer, err := findWaitlistOffer(c)
+	if offer == nil {
+		return err
+	}
+	if !offer.IsOpen() {
+		return c.Status(410).JSON(fiber.Map{"error": "This offer has expired or is no longer available"})
+	}
+
+	// Claimed like an acceptance, so a decline cannot undo an offer accepted meanwhile
+	now := time.Now()
+	claim := database.DB.Model(&models.WaitlistOffer{}).
+		Where("id = ? AND status = ? AND expires_at > ?", offer.ID, models.OfferPending, now).
+		Updates(map[string]interface{}{"status": models.OfferDeclined, "responded_at": now})
+	if claim.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to decline offer"})
+	}
+	if claim.RowsAffected == 0 {
+		return c.Status(410).JSON(fiber.Map{"error": "This offer has expired or is no longer available"})
+	}
+	offer.Status = models.OfferDeclined
+	offer.RespondedAt = &now
+	releaseExpiredOffers(offer.ClinicID)
+
+	go SendClinicNotification(
+		"Waitlist Offer Declined",
+		offer.WaitlistEntry.Patient.GetFullName()+" declined the slot on "+formatMessageTime(offer.StartTime),
+		"waitlist_offer_declined",
+		offer.ClinicID,
+	)
+
+	return c.JSON(publicOfferView(offer))
+}
+
+// GetWaitlistOffers lists the offers made to a waitlist entry
+func GetWaitlistOffers(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.User)
+
+	entry, err := findWaitlistEntry(c, user)
+	if entry == nil {
+		return err
+	}
+
+	var offers []models.WaitlistOffer
+	if err := database.DB.Preload("Doctor").Preload("Branch").
+		Where("waitlist_entry_id = ?", entry.ID).Order("created_at DESC").Find(&offers).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch offers"})
+	}
+	for i := range offers {
+		localizeOffer(&offers[i])
+	}
+
+	return c.JSON(offers)
+}
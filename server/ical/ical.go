@@ -0,0 +1,185 @@
+// Package ical is a small, dependency-free iCalendar (RFC 5545) writer used for
+// calendar feeds and .ics attachments. Times are written in UTC, so no
+// VTIMEZONE components are needed.
+package ical
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Calendar methods (RFC 5546). Feeds use Publish; invitations sent to a
+// patient use Request, and Cancel removes the event from their calendar.
+const (
+	MethodPublish = "PUBLISH"
+	MethodRequest = "REQUEST"
+	MethodCancel  = "CANCEL"
+)
+
+// Event statuses
+const (
+	StatusTentative = "TENTATIVE"
+	StatusConfirmed = "CONFIRMED"
+	StatusCancelled = "CANCELLED"
+)
+
+// ContentType is the MIME type of an .ics file
+const ContentType = "text/calendar; charset=utf-8"
+
+// Calendar is a VCALENDAR holding events
+type Calendar struct {
+	ProdID string
+	Method string
+	Name   string        // shown by clients as the subscribed calendar's name
+	TTL    time.Duration // suggested refresh interval for feeds, zero for none
+	Events []Event
+}
+
+// Event is a VEVENT. Clients match updates to an event they already have by
+// UID, and apply them when Sequence is not lower than what they have.
+type Event struct {
+	UID          string
+	Sequence     int
+	Start        time.Time
+	End          time.Time
+	Stamp        time.Time // when the object was created, defaults to now
+	LastModified time.Time
+	Summary      string
+	Description  string
+	Location     string
+	Status       string
+	URL          string
+	Organizer    string // email address
+	Attendee     string // email address
+	AttendeeName string
+}
+
+// Bytes renders the calendar
+func (c *Calendar) Bytes() []byte {
+	var w writer
+	w.line("BEGIN:VCALENDAR")
+	w.line("VERSION:2.0")
+	w.line("PRODID:" + firstNonEmpty(c.ProdID, "-//Dentika//Dentika//EN"))
+	w.line("CALSCALE:GREGORIAN")
+	if c.Method != "" {
+		w.line("METHOD:" + c.Method)
+	}
+	if c.Name != "" {
+		w.line("X-WR-CALNAME:" + escape(c.Name))
+	}
+	if c.TTL > 0 {
+		w.line("REFRESH-INTERVAL;VALUE=DURATION:" + duration(c.TTL))
+		w.line("X-PUBLISHED-TTL:" + duration(c.TTL))
+	}
+	for i := range c.Events {
+		c.Events[i].write(&w)
+	}
+	w.line("END:VCALENDAR")
+	return w.buf.Bytes()
+}
+
+func (e *Event) write(w *writer) {
+	stamp := e.Stamp
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+
+	w.line("BEGIN:VEVENT")
+	w.line("UID:" + e.UID)
+	w.line("SEQUENCE:" + strconv.Itoa(e.Sequence))
+	w.line("DTSTAMP:" + utc(stamp))
+	w.line("DTSTART:" + utc(e.Start))
+	w.line("DTEND:" + utc(e.End))
+	if !e.LastModified.IsZero() {
+		w.line("LAST-MODIFIED:" + utc(e.LastModified))
+	}
+	w.line("SUMMARY:" + escape(e.Summary))
+	if e.Description != "" {
+		w.line("DESCRIPTION:" + escape(e.Description))
+	}
+	if e.Location != "" {
+		w.line("LOCATION:" + escape(e.Location))
+	}
+	if e.Status != "" {
+		w.line("STATUS:" + e.Status)
+	}
+	if e.URL != "" {
+		w.line("URL:" + e.URL)
+	}
+	if e.Organizer != "" {
+		w.line("ORGANIZER:mailto:" + e.Organizer)
+	}
+	if e.Attendee != "" {
+		attendee := "ATTENDEE;ROLE=REQ-PARTICIPANT"
+		if e.AttendeeName != "" {
+			attendee += ";CN=" + quote(e.AttendeeName)
+		}
+		w.line(attendee + ":mailto:" + e.Attendee)
+	}
+	w.line("END:VEVENT")
+}
+
+// writer emits content lines folded at 75 octets and ended with CRLF
+type writer struct {
+	buf bytes.Buffer
+}
+
+func (w *writer) line(s string) {
+	// Continuation lines start with a space, leaving room for 74 octets
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		w.buf.WriteString(s[:cut])
+		w.buf.WriteString("\r\n ")
+		s = s[cut:]
+		limit = 74
+	}
+	w.buf.WriteString(s)
+	w.buf.WriteString("\r\n")
+}
+
+func utc(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// escape escapes a TEXT value
+func escape(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// quote renders a parameter value, which may not contain double quotes
+func quote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "'") + `"`
+}
+
+// duration renders a positive duration such as PT1H30M
+func duration(d time.Duration) string {
+	minutes := int(d.Minutes())
+	if minutes < 1 {
+		minutes = 1
+	}
+	s := "PT"
+	if h := minutes / 60; h > 0 {
+		s += strconv.Itoa(h) + "H"
+	}
+	if m := minutes % 60; m > 0 {
+		s += strconv.Itoa(m) + "M"
+	}
+	return s
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
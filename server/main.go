@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/joho/godotenv"
@@ -29,22 +31,13 @@ func main() {
 		log.Println("No .env file found")
 	}
 
-	// Configure timezone
-	timezone := os.Getenv("TIMEZONE")
-	if timezone == "" {
-		timezone = "UTC" // Default fallback
-	}
-
-	location, err := time.LoadLocation(timezone)
-	if err != nil {
-		log.Printf("Invalid timezone '%s', falling back to UTC: %v", timezone, err)
-		location = time.UTC
-	} else {
-		log.Printf("Using timezone: %s", timezone)
+	// Timezone for clinics and branches that have none set; each branch otherwise uses its own
+	if timezone := os.Getenv("TIMEZONE"); timezone != "" {
+		if err := models.SetDefaultTimezone(timezone); err != nil {
+			log.Printf("Invalid TIMEZONE, using %s: %v", models.DefaultTimezone, err)
+		}
 	}
-
-	// Set the default timezone for the application
-	time.Local = location
+	log.Printf("Default timezone: %s", models.DefaultTimezone)
 
 	// Connect to database
 	database.ConnectDatabase()
@@ -58,8 +51,26 @@ func main() {
 		&models.Patient{},
 		&models.PatientDocument{},
 		&models.PatientSelfScheduleRequest{},
+		&models.AppointmentSeries{},
 		&models.Appointment{},
 		&models.AppointmentReminder{},
+		&models.WaitlistEntry{},
+		&models.WaitlistTimeWindow{},
+		&models.WaitlistOffer{},
+		&models.DoctorSchedule{},
+		&models.DoctorTimeOff{},
+		&models.ClinicHoliday{},
+		&models.BranchScheduleException{},
+		&models.CalendarFeed{},
+		&models.AppointmentStatusHistory{},
+		&models.QueueEntry{},
+		&models.QueueDisplay{},
+		&models.KioskDevice{},
+		&models.KioskSession{},
+		&models.KioskIdentifyFailure{},
+		&models.Resource{},
+		&models.AppointmentResource{},
+		&models.ProcedureResourceRequirement{},
 		&models.ProcedureTemplate{},
 		&models.AppointmentProcedure{},
 		&models.DiagnosisTemplate{},
@@ -67,11 +78,15 @@ func main() {
 		&models.PatientDiagnosis{},
 		&models.PatientTreatmentPlan{},
 		&models.TreatmentPlanProcedure{},
+		&models.TreatmentPlanPhase{},
 		&models.DentalRecord{},
 		&models.DentalRecordHistory{},
 		&models.DentalChartSnapshot{},
 		&models.ConsentTemplate{},
+		&models.ConsentTemplateVersion{},
 		&models.ConsentForm{},
+		&models.ConsentSigningRecord{},
+		&models.ConsentSigningLink{},
 
 		&models.DailySales{},
 		// Inventory models
@@ -88,10 +103,36 @@ func main() {
 		// Notification models
 		&models.Notification{},
 		&models.NotificationRecipient{},
+		// Billing models
+		&models.Invoice{},
+		&models.InvoiceLine{},
+		&models.Payment{},
+		&models.NumberSequence{},
+		// Insurance models
+		&models.InsurancePayer{},
+		&models.InsurancePolicy{},
+		&models.InsuranceCoverage{},
+		&models.InsuranceClaim{},
+		&models.InsuranceClaimLine{},
+		// Fee schedules
+		&models.FeeSchedule{},
+		&models.FeeScheduleItem{},
+		// Background jobs
+		&models.ScheduledJob{},
+		// Outbound patient messages
+		&models.OutboundMessage{},
 	); err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
+	// Procedure and diagnosis codes are now unique per clinic rather than globally
+	dropGlobalTemplateCodeIndexes()
+
+	// Give upcoming appointments booked before booking codes existed a random code
+	if err := models.BackfillBookingCodes(database.DB); err != nil {
+		log.Printf("Failed to backfill booking codes: %v", err)
+	}
+
 	// Create default admin user if it doesn't exist
 	createDefaultAdmin()
 
@@ -103,10 +144,17 @@ func main() {
 	notificationService := services.NewNotificationService(natsConn)
 	handlers.SetNotificationService(notificationService)
 
+	// Initialize live event publishing (front desk queue)
+	handlers.SetEventPublisher(services.NewEventPublisher(natsConn))
+
+	// Initialize outbound email/SMS to patients
+	handlers.SetMessagingService(services.NewMessagingServiceFromEnv())
+
 	// Seed sample data for testing
 	seedSampleData()
 	seedDefaultTemplates()
 	seedConsentTemplates()
+	backfillConsentTemplateVersions()
 	seedPlatformInventory()
 	seedAdditionalClinics()
 
@@ -114,11 +162,21 @@ func main() {
 	seedPatientsForClinic(2, "SmileCare Dental Clinic")
 	seedPatientsForClinic(3, "Bright Smile Dental Center")
 
-	// Start notification services (inline for now)
-	go func() {
-		log.Println("Appointment reminder service started")
-		// Reminder service will be implemented here
-	}()
+	// Reminder offsets, e.g. APPOINTMENT_REMINDER_OFFSETS=email:24h,sms:2h,in_app:30m
+	if value := os.Getenv("APPOINTMENT_REMINDER_OFFSETS"); value != "" {
+		if offsets, err := models.ParseReminderOffsets(value, models.ReminderTypeInApp); err != nil {
+			log.Printf("Invalid APPOINTMENT_REMINDER_OFFSETS, using defaults: %v", err)
+		} else {
+			handlers.SetReminderOffsets(offsets)
+		}
+	}
+
+	// Patient-facing app that opens links sent to patients, e.g. https://book.example.com
+	handlers.SetPublicAppURL(os.Getenv("PUBLIC_APP_URL"))
+
+	// Start background jobs
+	scheduler := startJobScheduler(notificationService)
+	defer scheduler.Stop()
 
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
@@ -133,7 +191,7 @@ func main() {
 	// Middleware
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "*",
-		AllowHeaders: "Origin, Content-Type, Accept, Authorization",
+		AllowHeaders: "Origin, Content-Type, Accept, Authorization, X-Kiosk-Token",
 		AllowMethods: "GET, POST, PUT, DELETE, OPTIONS",
 	}))
 	app.Use(logger.New())
@@ -159,7 +217,38 @@ func main() {
 	app.Get("/api/public/clinic/:clinicIdentifier", handlers.GetClinicInfo)
 	app.Get("/api/public/timeslots/:clinicIdentifier", handlers.GetAvailableTimeSlots)
 	app.Get("/api/public/patient/:clinicIdentifier", handlers.CheckPatientByPhone)
-	app.Post("/api/public/schedule/:clinicIdentifier", handlers.CreatePatientSelfSchedule)
+	// Each request messages the email address and phone number it gives, so keep the rate low
+	app.Post("/api/public/schedule/:clinicIdentifier", limiter.New(limiter.Config{
+		Max:        5,
+		Expiration: time.Hour,
+		LimitReached: func(c *fiber.Ctx) error {
+			return c.Status(429).JSON(fiber.Map{"error": "Too many requests, please try again later"})
+		},
+	}), handlers.CreatePatientSelfSchedule)
+
+	// Waitlist slot offers (public - authorized by offer link token)
+	app.Get("/api/public/waitlist-offers/:token", handlers.GetPublicWaitlistOffer)
+	app.Post("/api/public/waitlist-offers/:token/accept", handlers.AcceptPublicWaitlistOffer)
+	app.Post("/api/public/waitlist-offers/:token/decline", handlers.DeclinePublicWaitlistOffer)
+
+	// Calendar feeds (public - authorized by the feed's secret token)
+	app.Get("/api/public/calendar/:token", handlers.GetPublicCalendarFeed)
+
+	// Waiting room queue board (public - authorized by the display's read-only token)
+	app.Get("/api/public/queue-board/:token", handlers.GetPublicQueueBoard)
+
+	// Self check-in kiosk (public - authorized by the device token header, then the patient's check-in session)
+	app.Get("/api/public/kiosk", handlers.GetKioskInfo)
+	app.Post("/api/public/kiosk/identify", handlers.IdentifyKioskPatient)
+	app.Get("/api/public/kiosk/sessions/:token", handlers.GetKioskSession)
+	app.Put("/api/public/kiosk/sessions/:token/medical", handlers.UpdateKioskMedicalInfo)
+	app.Get("/api/public/kiosk/sessions/:token/consents/:formId", handlers.GetKioskConsentForm)
+	app.Post("/api/public/kiosk/sessions/:token/consents/:formId/sign", handlers.SignKioskConsentForm)
+	app.Post("/api/public/kiosk/sessions/:token/check-in", handlers.KioskCheckIn)
+
+	// Remote consent signing (public - authorized by single-use link token)
+	app.Get("/api/public/consent/:token", handlers.GetPublicConsentForm)
+	app.Post("/api/public/consent/:token/sign", handlers.SignPublicConsentForm)
 
 	// Protected routes
 	api := app.Group("/api", middleware.AuthMiddleware())
@@ -196,6 +285,7 @@ func main() {
 	api.Post("/patients", handlers.CreatePatient)
 	api.Put("/patients/:id", handlers.UpdatePatient)
 	api.Delete("/patients/:id", handlers.DeactivatePatient)
+	api.Get("/patients/:id/ledger", handlers.GetPatientLedger)
 
 	// Patient diagnosis routes
 	api.Get("/patients/:patientId/diagnoses", handlers.GetPatientDiagnoses)
@@ -210,6 +300,14 @@ func main() {
 	api.Post("/patients/:patientId/treatment-plans", middleware.RoleMiddleware(models.Doctor), handlers.CreatePatientTreatmentPlan)
 	api.Put("/patients/:patientId/treatment-plans/:treatmentPlanId", middleware.RoleMiddleware(models.Doctor), handlers.UpdatePatientTreatmentPlan)
 	api.Delete("/patients/:patientId/treatment-plans/:treatmentPlanId", middleware.RoleMiddleware(models.Doctor), handlers.DeletePatientTreatmentPlan)
+	api.Get("/patients/:patientId/treatment-plans/:treatmentPlanId/items", handlers.GetTreatmentPlanItems)
+	api.Post("/patients/:patientId/treatment-plans/:treatmentPlanId/items", middleware.RoleMiddleware(models.Doctor), handlers.CreateTreatmentPlanItem)
+	api.Put("/patients/:patientId/treatment-plans/:treatmentPlanId/items/:itemId", middleware.RoleMiddleware(models.Doctor), handlers.UpdateTreatmentPlanItem)
+	api.Delete("/patients/:patientId/treatment-plans/:treatmentPlanId/items/:itemId", middleware.RoleMiddleware(models.Doctor), handlers.DeleteTreatmentPlanItem)
+	api.Post("/patients/:patientId/treatment-plans/:treatmentPlanId/phases", middleware.RoleMiddleware(models.Doctor), handlers.CreateTreatmentPlanPhase)
+	api.Put("/patients/:patientId/treatment-plans/:treatmentPlanId/phases/:phaseId", middleware.RoleMiddleware(models.Doctor), handlers.UpdateTreatmentPlanPhase)
+	api.Delete("/patients/:patientId/treatment-plans/:treatmentPlanId/phases/:phaseId", middleware.RoleMiddleware(models.Doctor), handlers.DeleteTreatmentPlanPhase)
+	api.Post("/patients/:patientId/treatment-plans/:treatmentPlanId/schedule-next-phase", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Doctor, models.Secretary), handlers.ScheduleNextTreatmentPlanPhase)
 
 	// Analytics routes
 	api.Get("/analytics/dashboard", handlers.GetDashboardMetrics)
@@ -222,8 +320,74 @@ func main() {
 	api.Put("/appointments/:id", handlers.UpdateAppointment)
 	api.Put("/appointments/:id/status", handlers.UpdateAppointmentStatus)
 	api.Post("/appointments/:id/arrived", handlers.MarkPatientArrived)
+	api.Get("/appointments/:id/reminders", handlers.GetAppointmentReminders)
+	api.Get("/appointments/:id/ics", handlers.GetAppointmentCalendarFile)
+	api.Get("/appointments/:id/status-history", handlers.GetAppointmentStatusHistory)
+	api.Post("/appointments/:id/reschedule", handlers.RescheduleAppointment)
 	api.Post("/appointments/check-availability", handlers.CheckAppointmentAvailability)
 
+	// Front desk queue
+	api.Get("/branches/:id/queue", handlers.GetBranchQueue)
+	api.Post("/branches/:id/queue", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor, models.Assistant), handlers.AddToBranchQueue)
+	api.Put("/queue/:id/status", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor, models.Assistant), handlers.UpdateQueueEntryStatus)
+	api.Get("/branches/:id/queue-displays", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary), handlers.GetQueueDisplays)
+	api.Post("/branches/:id/queue-displays", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary), handlers.CreateQueueDisplay)
+	api.Delete("/queue-displays/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary), handlers.DeleteQueueDisplay)
+	api.Get("/branches/:id/kiosk-devices", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary), handlers.GetKioskDevices)
+	api.Post("/branches/:id/kiosk-devices", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.CreateKioskDevice)
+	api.Delete("/kiosk-devices/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.DeleteKioskDevice)
+
+	// Waitlist
+	api.Get("/waitlist", handlers.GetWaitlist)
+	api.Post("/waitlist", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor), handlers.CreateWaitlistEntry)
+	api.Put("/waitlist/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor), handlers.UpdateWaitlistEntry)
+	api.Delete("/waitlist/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor), handlers.DeleteWaitlistEntry)
+	api.Get("/waitlist/:id/offers", handlers.GetWaitlistOffers)
+	api.Post("/waitlist/:id/book", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor), handlers.BookWaitlistEntry)
+	api.Post("/waitlist/:id/offer", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor), handlers.OfferWaitlistSlot)
+	api.Get("/appointments/:id/waitlist-matches", handlers.GetWaitlistMatches)
+
+	// Recurring appointments
+	api.Post("/appointment-series", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor), handlers.CreateAppointmentSeries)
+	api.Get("/appointment-series/:id", handlers.GetAppointmentSeries)
+	api.Put("/appointments/:id/series", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor), handlers.UpdateAppointmentSeries)
+	api.Post("/appointments/:id/series/cancel", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor), handlers.CancelAppointmentSeries)
+
+	// Chairs, rooms and equipment
+	api.Get("/resources", handlers.GetResources)
+	api.Get("/resources/day", handlers.GetResourceDayView)
+	api.Post("/resources", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.CreateResource)
+	api.Put("/resources/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.UpdateResource)
+	api.Delete("/resources/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.DeleteResource)
+	api.Put("/appointments/:id/resources", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor, models.Assistant), handlers.UpdateAppointmentResources)
+
+	// Doctor working hours, leave and clinic holidays
+	api.Get("/doctors/availability", handlers.GetDoctorAvailability)
+	api.Get("/doctors/:id/schedule", handlers.GetDoctorSchedule)
+	api.Put("/doctors/:id/schedule", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor), handlers.UpdateDoctorSchedule)
+	api.Get("/doctors/:id/time-off", handlers.GetDoctorTimeOff)
+	api.Post("/doctors/:id/time-off", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor), handlers.CreateDoctorTimeOff)
+	api.Delete("/doctor-time-off/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor), handlers.DeleteDoctorTimeOff)
+	api.Get("/holidays", handlers.GetClinicHolidays)
+	api.Post("/holidays", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.CreateClinicHoliday)
+	api.Delete("/holidays/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.DeleteClinicHoliday)
+
+	// iCalendar feeds for doctors and branch front desks
+	api.Get("/calendar-feeds", handlers.GetCalendarFeeds)
+	api.Post("/calendar-feeds", handlers.CreateCalendarFeed)
+	api.Delete("/calendar-feeds/:id", handlers.DeleteCalendarFeed)
+
+	// Branch closures, special hours and extra days
+	api.Get("/branches/:id/exceptions", handlers.GetBranchExceptions)
+	api.Post("/branches/:id/exceptions", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.CreateBranchException)
+	api.Delete("/branch-exceptions/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.DeleteBranchException)
+
+	// Patient self-schedule request review
+	api.Get("/self-schedule-requests", handlers.GetPatientSelfScheduleRequests)
+	api.Get("/self-schedule-requests/:id", handlers.GetPatientSelfScheduleRequest)
+	api.Put("/self-schedule-requests/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor), handlers.ReviewPatientSelfScheduleRequest)
+	api.Post("/self-schedule-requests/:id/convert", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor), handlers.ConvertPatientSelfScheduleRequest)
+
 	// Dental records routes
 	api.Get("/patients/:patient_id/dental-records", handlers.GetPatientDentalRecords)
 	api.Get("/dental-records/:id", handlers.GetDentalRecord)
@@ -241,15 +405,40 @@ func main() {
 	// Procedure and diagnosis templates
 	api.Get("/procedure-templates", handlers.GetProcedureTemplates)
 	api.Post("/procedure-templates", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.CreateProcedureTemplate)
+	api.Post("/procedure-templates/import", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.ImportProcedureTemplates)
+	api.Put("/procedure-templates/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.UpdateProcedureTemplate)
+	api.Delete("/procedure-templates/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.DeleteProcedureTemplate)
+	api.Put("/procedure-templates/:id/resources", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.UpdateProcedureResourceRequirements)
 	api.Get("/diagnosis-templates", handlers.GetDiagnosisTemplates)
 	api.Post("/diagnosis-templates", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.CreateDiagnosisTemplate)
+	api.Post("/diagnosis-templates/import", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.ImportDiagnosisTemplates)
+	api.Put("/diagnosis-templates/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.UpdateDiagnosisTemplate)
+	api.Delete("/diagnosis-templates/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.DeleteDiagnosisTemplate)
+
+	// Fee schedule routes
+	api.Get("/fee-schedules", handlers.GetFeeSchedules)
+	api.Get("/fee-schedules/:id", handlers.GetFeeSchedule)
+	api.Post("/fee-schedules", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.CreateFeeSchedule)
+	api.Put("/fee-schedules/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.UpdateFeeSchedule)
+	api.Delete("/fee-schedules/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.DeleteFeeSchedule)
+	api.Post("/fee-schedules/:id/prices", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.SetFeeSchedulePrices)
+	api.Delete("/fee-schedules/:id/prices/:itemId", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.DeleteFeeSchedulePrice)
+	api.Get("/procedure-fees", handlers.GetProcedureFees)
 
 	// Consent templates
 	api.Get("/consent-templates", handlers.GetConsentTemplates)
 	api.Post("/consent-templates", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.CreateConsentTemplate)
+	api.Get("/consent-templates/merge-fields", handlers.GetConsentMergeFields)
 	api.Get("/consent-templates/:id", handlers.GetConsentTemplate)
+	api.Post("/consent-templates/:id/preview", handlers.PreviewConsentTemplate)
 	api.Put("/consent-templates/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.UpdateConsentTemplate)
 	api.Delete("/consent-templates/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.DeleteConsentTemplate)
+	api.Get("/consent-templates/:id/versions", handlers.GetConsentTemplateVersions)
+	api.Get("/consent-templates/:id/versions/diff", handlers.DiffConsentTemplateVersions)
+	api.Get("/consent-templates/:id/versions/:version", handlers.GetConsentTemplateVersion)
+	api.Post("/consent-templates/:id/versions", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.CreateConsentTemplateVersion)
+	api.Put("/consent-templates/:id/versions/:version", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.UpdateConsentTemplateVersion)
+	api.Post("/consent-templates/:id/versions/:version/publish", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.PublishConsentTemplateVersion)
 
 	// Consent forms
 	api.Get("/consent-forms", handlers.GetConsentForms)
@@ -257,6 +446,11 @@ func main() {
 	api.Get("/consent-forms/:id", handlers.GetConsentForm)
 	api.Put("/consent-forms/:id", handlers.UpdateConsentForm)
 	api.Post("/consent-forms/:id/sign", handlers.SignConsentForm)
+	api.Get("/consent-forms/:id/pdf", handlers.DownloadConsentFormPDF)
+	api.Get("/consent-forms/:id/verify", handlers.VerifyConsentForm)
+	api.Get("/consent-forms/:id/signing-links", handlers.GetConsentSigningLinks)
+	api.Post("/consent-forms/:id/signing-links", handlers.CreateConsentSigningLink)
+	api.Delete("/consent-forms/:id/signing-links", handlers.RevokeConsentSigningLinks)
 
 	// Peer Review routes (doctors only)
 	api.Get("/peer-review/cases", middleware.RoleMiddleware(models.Doctor), handlers.GetPeerReviewCases)
@@ -275,6 +469,10 @@ func main() {
 	api.Post("/notifications/test", handlers.TestNotification)
 	api.Get("/notifications/stats", middleware.RoleMiddleware(models.Admin, models.SuperAdmin), handlers.NotificationStats)
 
+	// Outbound patient email/SMS
+	api.Get("/messages", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary), handlers.GetOutboundMessages)
+	api.Post("/messages/:id/retry", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary), handlers.RetryOutboundMessage)
+
 	// Appointment procedures and diagnoses
 	api.Get("/appointments/:appointment_id/procedures", handlers.GetAppointmentProcedures)
 	api.Post("/appointments/:appointment_id/procedures", middleware.RoleMiddleware(models.Doctor, models.Admin, models.Secretary, models.Assistant), handlers.AddProcedureToAppointment)
@@ -283,6 +481,38 @@ func main() {
 	api.Post("/appointments/:appointment_id/diagnoses", middleware.RoleMiddleware(models.Doctor, models.Admin), handlers.AddDiagnosisToAppointment)
 	api.Put("/appointment-diagnoses/:id", middleware.RoleMiddleware(models.Doctor, models.Admin), handlers.UpdateAppointmentDiagnosis)
 
+	// Billing routes
+	api.Get("/invoices", handlers.GetInvoices)
+	api.Get("/invoices/:id", handlers.GetInvoice)
+	api.Post("/invoices", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor), handlers.CreateInvoice)
+	api.Post("/invoices/:id/payments", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor), handlers.RecordInvoicePayment)
+	api.Post("/invoices/:id/refunds", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.RecordInvoiceRefund)
+	api.Put("/invoices/:id/void", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.VoidInvoice)
+	api.Get("/payments", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary), handlers.GetPayments)
+	api.Put("/invoices/:id/insurance", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary), handlers.UpdateInvoiceInsurance)
+
+	// Insurance routes
+	api.Get("/insurance-payers", handlers.GetInsurancePayers)
+	api.Post("/insurance-payers", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.CreateInsurancePayer)
+	api.Put("/insurance-payers/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.UpdateInsurancePayer)
+	api.Delete("/insurance-payers/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin), handlers.DeleteInsurancePayer)
+	api.Get("/patients/:patientId/insurance-policies", handlers.GetPatientInsurancePolicies)
+	api.Post("/patients/:patientId/insurance-policies", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor), handlers.CreatePatientInsurancePolicy)
+	api.Put("/insurance-policies/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary, models.Doctor), handlers.UpdateInsurancePolicy)
+	api.Delete("/insurance-policies/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary), handlers.DeleteInsurancePolicy)
+	api.Get("/patients/:patientId/treatment-plans/:treatmentPlanId/insurance-estimate", handlers.GetTreatmentPlanInsuranceEstimate)
+	api.Get("/insurance-claims", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary), handlers.GetInsuranceClaims)
+	api.Get("/insurance-claims/:id", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary), handlers.GetInsuranceClaim)
+	api.Get("/insurance-claims/:id/export", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary), handlers.ExportInsuranceClaim)
+	api.Post("/invoices/:id/claims", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary), handlers.CreateInsuranceClaim)
+	api.Put("/insurance-claims/:id/status", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary), handlers.UpdateInsuranceClaimStatus)
+	api.Post("/insurance-claims/:id/payments", middleware.RoleMiddleware(models.SuperAdmin, models.Admin, models.Secretary), handlers.RecordInsuranceClaimPayment)
+
+	// Background job administration (super admin only)
+	api.Get("/admin/jobs", middleware.RoleMiddleware(models.SuperAdmin), handlers.GetJobs)
+	api.Post("/admin/jobs/:name/run", middleware.RoleMiddleware(models.SuperAdmin), handlers.RunJob)
+	api.Put("/admin/jobs/:name", middleware.RoleMiddleware(models.SuperAdmin), handlers.UpdateJob)
+
 	// Shop inventory management (for super admin only)
 	api.Get("/inventory/shop/items", middleware.RoleMiddleware(models.SuperAdmin), handlers.GetPlatformInventory)
 	api.Get("/inventory/shop/items/:id", middleware.RoleMiddleware(models.SuperAdmin), handlers.GetPlatformInventoryItem)
@@ -1042,6 +1272,37 @@ func seedConsentTemplates() {
 	}
 }
 
+// backfillConsentTemplateVersions gives templates created before versioning their first published version
+func backfillConsentTemplateVersions() {
+	var templates []models.ConsentTemplate
+	if err := database.DB.Where("published_version_id IS NULL").Find(&templates).Error; err != nil {
+		log.Printf("Failed to fetch consent templates for versioning: %v", err)
+		return
+	}
+
+	for _, template := range templates {
+		if _, err := template.CreateInitialVersion(database.DB, nil); err != nil {
+			log.Printf("Failed to create initial version for consent template %d: %v", template.ID, err)
+		}
+	}
+}
+
+// dropGlobalTemplateCodeIndexes removes the old global unique indexes on template codes, which
+// would stop clinics from overriding platform templates
+func dropGlobalTemplateCodeIndexes() {
+	migrator := database.DB.Migrator()
+	if migrator.HasIndex(&models.ProcedureTemplate{}, "idx_procedure_templates_code") {
+		if err := migrator.DropIndex(&models.ProcedureTemplate{}, "idx_procedure_templates_code"); err != nil {
+			log.Printf("Failed to drop procedure template code index: %v", err)
+		}
+	}
+	if migrator.HasIndex(&models.DiagnosisTemplate{}, "idx_diagnosis_templates_code") {
+		if err := migrator.DropIndex(&models.DiagnosisTemplate{}, "idx_diagnosis_templates_code"); err != nil {
+			log.Printf("Failed to drop diagnosis template code index: %v", err)
+		}
+	}
+}
+
 func seedAdditionalClinics() {
 	// Check if additional clinics already exist (beyond Dentika)
 	var clinicCount int64
@@ -1485,3 +1746,31 @@ func initNATS() *nats.Conn {
 
 	return nc
 }
+
+// startJobScheduler registers the background jobs and starts the scheduler
+func startJobScheduler(notificationService *services.NotificationService) *services.Scheduler {
+	scheduler := services.NewScheduler()
+
+	register := func(err error) {
+		if err != nil {
+			log.Printf("Failed to register job: %v", err)
+		}
+	}
+
+	register(scheduler.Every("scheduled-notifications", "Deliver notifications whose scheduled time has passed", time.Minute,
+		func(ctx context.Context) error {
+			return notificationService.ProcessScheduledNotifications()
+		}))
+	register(scheduler.Cron("notification-cleanup", "Delete expired notifications", "0 3 * * *",
+		func(ctx context.Context) error {
+			return notificationService.CleanupExpiredNotifications()
+		}))
+	register(scheduler.Every("appointment-reminders", "Send reminders for upcoming appointments", 5*time.Minute,
+		handlers.SendAppointmentReminders))
+	register(scheduler.Every("outbound-messages", "Deliver queued patient email and SMS messages", time.Minute,
+		handlers.ProcessOutboundMessages))
+
+	handlers.SetJobScheduler(scheduler)
+	scheduler.Start()
+	return scheduler
+}
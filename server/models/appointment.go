@@ -18,6 +18,10 @@ const (
 	StatusRescheduled AppointmentStatus = "rescheduled"
 )
 
+// BlockingStatuses are the appointment statuses that hold their time: neither the doctor nor
+// the chairs, rooms and equipment booked for them can be booked again then
+var BlockingStatuses = []AppointmentStatus{StatusScheduled, StatusConfirmed, StatusInProgress}
+
 type Appointment struct {
 	ID          uint              `json:"id" gorm:"primarykey"`
 	Title       string            `json:"title" gorm:"size:200"`
@@ -26,6 +30,8 @@ type Appointment struct {
 	EndTime     time.Time         `json:"end_time" gorm:"not null"`
 	Duration    int               `json:"duration"` // in minutes
 	Status      AppointmentStatus `json:"status" gorm:"type:varchar(20);default:'scheduled'"`
+	Timezone    string            `json:"timezone" gorm:"size:64"`           // the branch's IANA timezone, set when booked
+	BookingCode string            `json:"booking_code" gorm:"size:16;index"` // random code the patient checks in with, set when booked
 
 	// Patient arrival tracking
 	PatientArrived bool       `json:"patient_arrived" gorm:"default:false"`
@@ -56,9 +62,18 @@ type Appointment struct {
 	ClinicID uint   `json:"clinic_id" gorm:"not null;index"`
 	Clinic   Clinic `json:"clinic" gorm:"foreignKey:ClinicID"`
 
+	// Recurring series this appointment is an occurrence of
+	SeriesID    *uint `json:"series_id" gorm:"index"`
+	SeriesIndex int   `json:"series_index"` // 1-based occurrence number
+
+	// Rescheduling links the appointment that was moved and the one that replaced it
+	RescheduledFromID *uint `json:"rescheduled_from_id" gorm:"index"`
+	RescheduledToID   *uint `json:"rescheduled_to_id" gorm:"index"`
+
 	// Relationships
 	Procedures []AppointmentProcedure `json:"procedures,omitempty" gorm:"foreignKey:AppointmentID"`
 	Diagnoses  []AppointmentDiagnosis `json:"diagnoses,omitempty" gorm:"foreignKey:AppointmentID"`
+	Resources  []AppointmentResource  `json:"resources,omitempty" gorm:"foreignKey:AppointmentID"`
 
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
@@ -67,12 +82,14 @@ type Appointment struct {
 
 type AppointmentReminder struct {
 	ID            uint        `json:"id" gorm:"primarykey"`
-	AppointmentID uint        `json:"appointment_id" gorm:"not null;index"`
+	AppointmentID uint        `json:"appointment_id" gorm:"not null;index;uniqueIndex:idx_appointment_reminder_slot"`
 	Appointment   Appointment `json:"appointment" gorm:"foreignKey:AppointmentID"`
-	ReminderType  string      `json:"reminder_type" gorm:"size:50"` // email, sms, call
-	ReminderTime  time.Time   `json:"reminder_time" gorm:"not null"`
+	ReminderType  string      `json:"reminder_type" gorm:"size:50;uniqueIndex:idx_appointment_reminder_slot"` // in_app, email, sms, call
+	OffsetMinutes int         `json:"offset_minutes" gorm:"uniqueIndex:idx_appointment_reminder_slot"`        // minutes before the appointment start
+	ReminderTime  time.Time   `json:"reminder_time" gorm:"not null;index"`
 	IsSent        bool        `json:"is_sent" gorm:"default:false"`
 	SentAt        *time.Time  `json:"sent_at"`
+	CancelledAt   *time.Time  `json:"cancelled_at"`
 	Message       string      `json:"message" gorm:"type:text"`
 
 	CreatedAt time.Time      `json:"created_at"`
@@ -85,7 +102,7 @@ func (a *Appointment) IsUpcoming() bool {
 }
 
 func (a *Appointment) IsToday() bool {
-	now := time.Now()
+	now := time.Now().In(a.StartTime.Location())
 	appointmentDate := a.StartTime
 	return appointmentDate.Year() == now.Year() &&
 		appointmentDate.YearDay() == now.YearDay()
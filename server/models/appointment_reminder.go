@@ -0,0 +1,180 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Reminder delivery types
+const (
+	ReminderTypeInApp = "in_app"
+	ReminderTypeEmail = "email"
+	ReminderTypeSMS   = "sms"
+)
+
+// IsValidReminderType reports whether reminders can be delivered through the type
+func IsValidReminderType(reminderType string) bool {
+	switch reminderType {
+	case ReminderTypeInApp, ReminderTypeEmail, ReminderTypeSMS:
+		return true
+	}
+	return false
+}
+
+// ReminderOffset is a reminder sent a fixed time before an appointment through one channel
+type ReminderOffset struct {
+	Type   string
+	Before time.Duration
+}
+
+// DefaultReminderOffsets are used when no offsets are configured: the patient gets an email
+// the day before and an SMS two hours before, and staff get an in-app reminder
+var DefaultReminderOffsets = []ReminderOffset{
+	{Type: ReminderTypeEmail, Before: 24 * time.Hour},
+	{Type: ReminderTypeSMS, Before: 2 * time.Hour},
+	{Type: ReminderTypeInApp, Before: 2 * time.Hour},
+}
+
+// ParseReminderOffsets parses a comma separated list of offsets such as "24h,2h" or "sms:24h,email:2h".
+// Offsets without a type use defaultType. Types other than in_app, email and sms are rejected.
+func ParseReminderOffsets(value, defaultType string) ([]ReminderOffset, error) {
+	offsets := []ReminderOffset{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		reminderType := defaultType
+		if idx := strings.Index(part, ":"); idx >= 0 {
+			reminderType = strings.TrimSpace(part[:idx])
+			part = strings.TrimSpace(part[idx+1:])
+		}
+
+		if !IsValidReminderType(reminderType) {
+			return nil, fmt.Errorf("invalid reminder type %q, use in_app, email or sms", reminderType)
+		}
+		before, err := time.ParseDuration(part)
+		if err != nil || before <= 0 {
+			return nil, fmt.Errorf("invalid reminder offset %q", part)
+		}
+		offsets = append(offsets, ReminderOffset{Type: reminderType, Before: before})
+	}
+	return offsets, nil
+}
+
+// WantsReminders reports whether the appointment is in a state that should be reminded about
+func (a *Appointment) WantsReminders() bool {
+	return a.Status == StatusScheduled || a.Status == StatusConfirmed
+}
+
+// SyncAppointmentReminders makes the stored reminders match the appointment and the configured offsets.
+// Unsent reminders follow the appointment when it moves; reminders already sent for an old time are
+// re-armed if the new reminder time is still ahead. Reminders for cancelled or finished appointments,
+// or for offsets no longer configured, are cancelled. Offsets whose time has already passed are skipped.
+func SyncAppointmentReminders(tx *gorm.DB, appointment *Appointment, offsets []ReminderOffset) error {
+	var existing []AppointmentReminder
+	if err := tx.Where("appointment_id = ?", appointment.ID).Find(&existing).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	byKey := map[string]*AppointmentReminder{}
+	for i := range existing {
+		byKey[reminderKey(existing[i].ReminderType, existing[i].OffsetMinutes)] = &existing[i]
+	}
+
+	wanted := map[string]bool{}
+	if appointment.WantsReminders() {
+		for _, offset := range offsets {
+			minutes := int(offset.Before.Minutes())
+			key := reminderKey(offset.Type, minutes)
+			wanted[key] = true
+			remindAt := appointment.StartTime.Add(-offset.Before)
+
+			reminder, ok := byKey[key]
+			if !ok {
+				if !remindAt.After(now) {
+					continue
+				}
+				reminder = &AppointmentReminder{
+					AppointmentID: appointment.ID,
+					ReminderType:  offset.Type,
+					OffsetMinutes: minutes,
+					ReminderTime:  remindAt,
+				}
+				if err := tx.Create(reminder).Error; err != nil {
+					return err
+				}
+				continue
+			}
+
+			updates := map[string]interface{}{}
+			moved := !reminder.ReminderTime.Equal(remindAt)
+			if moved {
+				updates["reminder_time"] = remindAt
+			}
+
+			switch {
+			case reminder.IsSent:
+				// Sent for the old time: re-arm so the patient hears about the new time
+				if moved && remindAt.After(now) {
+					updates["is_sent"] = false
+					updates["sent_at"] = nil
+					updates["cancelled_at"] = nil
+				}
+			case remindAt.After(now) || (!moved && reminder.CancelledAt == nil):
+				if reminder.CancelledAt != nil {
+					updates["cancelled_at"] = nil
+				}
+			case reminder.CancelledAt == nil:
+				// Moved so that this reminder's time has already passed
+				updates["cancelled_at"] = now
+			}
+			if len(updates) > 0 {
+				if err := tx.Model(reminder).Updates(updates).Error; err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// Cancel anything unsent that is no longer wanted
+	for key, reminder := range byKey {
+		if wanted[key] || reminder.IsSent || reminder.CancelledAt != nil {
+			continue
+		}
+		if err := tx.Model(reminder).Update("cancelled_at", now).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func reminderKey(reminderType string, offsetMinutes int) string {
+	return fmt.Sprintf("%s:%d", reminderType, offsetMinutes)
+}
+
+// OffsetLabel describes how long before the appointment the reminder is sent, e.g. "2 hours";
+// whole days are given in days, so a 24-hour offset is "1 day"
+func (r *AppointmentReminder) OffsetLabel() string {
+	switch {
+	case r.OffsetMinutes%(24*60) == 0:
+		return pluralize(r.OffsetMinutes/(24*60), "day")
+	case r.OffsetMinutes%60 == 0:
+		return pluralize(r.OffsetMinutes/60, "hour")
+	default:
+		return pluralize(r.OffsetMinutes, "minute")
+	}
+}
+
+func pluralize(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
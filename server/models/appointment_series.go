@@ -0,0 +1,236 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RecurrenceFrequency string
+
+const (
+	FrequencyDaily   RecurrenceFrequency = "DAILY"
+	FrequencyWeekly  RecurrenceFrequency = "WEEKLY"
+	FrequencyMonthly RecurrenceFrequency = "MONTHLY"
+)
+
+// MaxSeriesOccurrences caps how many appointments a single series can book
+const MaxSeriesOccurrences = 104
+
+// Recurrence is a subset of an iCalendar RRULE: FREQ, INTERVAL and either COUNT or UNTIL
+type Recurrence struct {
+	Frequency RecurrenceFrequency `json:"frequency"`
+	Interval  int                 `json:"interval"`
+	Count     int                 `json:"count,omitempty"`
+	Until     *time.Time          `json:"until,omitempty"`
+}
+
+// ParseRRule parses rules such as "FREQ=WEEKLY;INTERVAL=2;COUNT=10" or "FREQ=MONTHLY;UNTIL=20261231".
+// A leading "RRULE:" is accepted. UNTIL dates are read in loc and include the whole day.
+func ParseRRule(rule string, loc *time.Location) (Recurrence, error) {
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+	r := Recurrence{Interval: 1}
+	for _, part := range strings.Split(rule, ";") {
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return r, fmt.Errorf("invalid rule part %q", part)
+		}
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			r.Frequency = RecurrenceFrequency(strings.ToUpper(value))
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return r, fmt.Errorf("invalid INTERVAL %q", value)
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return r, fmt.Errorf("invalid COUNT %q", value)
+			}
+			r.Count = n
+		case "UNTIL":
+			until, err := parseRRuleUntil(value, loc)
+			if err != nil {
+				return r, err
+			}
+			r.Until = &until
+		default:
+			return r, fmt.Errorf("unsupported rule part %s", key)
+		}
+	}
+	return r, r.Validate()
+}
+
+func parseRRuleUntil(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"20060102", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t.Add(24*time.Hour - time.Second), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid UNTIL %q", value)
+}
+
+// Validate checks the rule is bounded and supported
+func (r Recurrence) Validate() error {
+	switch r.Frequency {
+	case FrequencyDaily, FrequencyWeekly, FrequencyMonthly:
+	default:
+		return fmt.Errorf("FREQ must be DAILY, WEEKLY or MONTHLY")
+	}
+	if r.Interval < 1 || r.Interval > 52 {
+		return fmt.Errorf("INTERVAL must be between 1 and 52")
+	}
+	if r.Count == 0 && r.Until == nil {
+		return fmt.Errorf("either COUNT or UNTIL is required")
+	}
+	if r.Count != 0 && r.Until != nil {
+		return fmt.Errorf("COUNT and UNTIL cannot both be set")
+	}
+	if r.Count < 0 || r.Count > MaxSeriesOccurrences {
+		return fmt.Errorf("COUNT must be between 1 and %d", MaxSeriesOccurrences)
+	}
+	return nil
+}
+
+// String renders the rule in RRULE form
+func (r Recurrence) String() string {
+	parts := []string{"FREQ=" + string(r.Frequency)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Occurrences lists the start times from start, keeping the local clock time. Monthly rules on
+// days a month lacks (e.g. the 31st) skip that month, as RRULE does.
+func (r Recurrence) Occurrences(start time.Time) []time.Time {
+	occurrences := []time.Time{}
+	for i := 0; len(occurrences) < MaxSeriesOccurrences && i < MaxSeriesOccurrences*2; i++ {
+		next := r.step(start, i)
+		if r.Frequency == FrequencyMonthly && next.Day() != start.Day() {
+			continue
+		}
+		if r.Until != nil && next.After(*r.Until) {
+			break
+		}
+		occurrences = append(occurrences, next)
+		if r.Count > 0 && len(occurrences) >= r.Count {
+			break
+		}
+	}
+	return occurrences
+}
+
+// step returns the i-th candidate occurrence after start
+func (r Recurrence) step(start time.Time, i int) time.Time {
+	switch r.Frequency {
+	case FrequencyDaily:
+		return start.AddDate(0, 0, i*r.Interval)
+	case FrequencyMonthly:
+		return start.AddDate(0, i*r.Interval, 0)
+	default:
+		return start.AddDate(0, 0, 7*i*r.Interval)
+	}
+}
+
+type SeriesStatus string
+
+const (
+	SeriesActive    SeriesStatus = "active"
+	SeriesCancelled SeriesStatus = "cancelled"
+)
+
+// Series edit and cancel scopes
+const (
+	SeriesScopeThis      = "this"
+	SeriesScopeFollowing = "following"
+	SeriesScopeAll       = "all"
+)
+
+// AppointmentSeries is a recurring booking, e.g. an orthodontic adjustment every four weeks.
+// Each occurrence is an ordinary Appointment pointing back at the series.
+type AppointmentSeries struct {
+	ID          uint         `json:"id" gorm:"primarykey"`
+	RRule       string       `json:"rrule" gorm:"size:200;not null"`
+	StartTime   time.Time    `json:"start_time" gorm:"not null"` // first occurrence
+	Duration    int          `json:"duration"`                   // in minutes
+	Title       string       `json:"title" gorm:"size:200"`
+	Description string       `json:"description" gorm:"type:text"`
+	Status      SeriesStatus `json:"status" gorm:"type:varchar(20);default:'active'"`
+
+	PatientID uint    `json:"patient_id" gorm:"not null;index"`
+	Patient   Patient `json:"patient,omitempty" gorm:"foreignKey:PatientID"`
+	DoctorID  uint    `json:"doctor_id" gorm:"not null;index"`
+	Doctor    User    `json:"doctor,omitempty" gorm:"foreignKey:DoctorID"`
+	BranchID  uint    `json:"branch_id" gorm:"not null;index"`
+
+	CreatedByID uint `json:"created_by_id"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	Appointments []Appointment `json:"appointments,omitempty" gorm:"foreignKey:SeriesID"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// SplitSeries ends a series before its fromIndex-th occurrence and moves that occurrence and the
+// ones after it into a new series, so they can be changed without touching the earlier ones.
+// start is the first moved occurrence's start time.
+func SplitSeries(db *gorm.DB, series *AppointmentSeries, fromIndex int, start time.Time) (*AppointmentSeries, error) {
+	recurrence, err := ParseRRule(series.RRule, start.Location())
+	if err != nil {
+		return nil, err
+	}
+	earlier, later := recurrence, recurrence
+	if recurrence.Count > 0 {
+		earlier.Count = fromIndex - 1
+		later.Count = recurrence.Count - earlier.Count
+		if later.Count < 1 {
+			later.Count = 1
+		}
+	} else {
+		until := start.Add(-time.Second)
+		earlier.Until = &until
+	}
+
+	split := *series
+	split.ID = 0
+	split.RRule = later.String()
+	split.StartTime = start
+	split.Appointments = nil
+	split.CreatedAt, split.UpdatedAt = time.Time{}, time.Time{}
+	if err := db.Create(&split).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&Appointment{}).Where("series_id = ? AND series_index >= ?", series.ID, fromIndex).
+		Updates(map[string]interface{}{
+			"series_id":    split.ID,
+			"series_index": gorm.Expr("series_index - ?", fromIndex-1),
+		}).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(series).Update("rrule", earlier.String()).Error; err != nil {
+		return nil, err
+	}
+	return &split, nil
+}
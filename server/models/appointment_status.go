@@ -0,0 +1,100 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// appointmentTransitions lists the statuses an appointment can move to from each status.
+// Completed, cancelled, no-show and rescheduled appointments are final, so statistics on them
+// hold; a rescheduled appointment lives on as the new appointment it links to.
+var appointmentTransitions = map[AppointmentStatus][]AppointmentStatus{
+	StatusScheduled:   {StatusConfirmed, StatusInProgress, StatusCancelled, StatusNoShow, StatusRescheduled},
+	StatusConfirmed:   {StatusInProgress, StatusCancelled, StatusNoShow, StatusRescheduled},
+	StatusInProgress:  {StatusCompleted},
+	StatusNoShow:      {},
+	StatusCompleted:   {},
+	StatusCancelled:   {},
+	StatusRescheduled: {},
+}
+
+func IsValidAppointmentStatus(status AppointmentStatus) bool {
+	_, ok := appointmentTransitions[status]
+	return ok
+}
+
+// AllowedTransitions lists the statuses the appointment can move to from status
+func AllowedTransitions(status AppointmentStatus) []AppointmentStatus {
+	return appointmentTransitions[status]
+}
+
+// CanTransition reports whether an appointment may move from one status to another
+func CanTransition(from, to AppointmentStatus) bool {
+	for _, next := range appointmentTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionRequiresReason reports whether moving to the status needs a reason on record
+func TransitionRequiresReason(to AppointmentStatus) bool {
+	return to == StatusCancelled || to == StatusRescheduled
+}
+
+// ValidateTransition checks a status change, including that a reason is given where one is required
+func ValidateTransition(from, to AppointmentStatus, reason string) error {
+	if !IsValidAppointmentStatus(to) {
+		return fmt.Errorf("invalid status %q", to)
+	}
+	if !CanTransition(from, to) {
+		allowed := make([]string, 0, len(appointmentTransitions[from]))
+		for _, next := range appointmentTransitions[from] {
+			allowed = append(allowed, string(next))
+		}
+		if len(allowed) == 0 {
+			return fmt.Errorf("the status of %s appointments cannot change", from)
+		}
+		return fmt.Errorf("cannot change an appointment from %s to %s; allowed: %s", from, to, strings.Join(allowed, ", "))
+	}
+	if TransitionRequiresReason(to) && strings.TrimSpace(reason) == "" {
+		return fmt.Errorf("a reason is required to mark an appointment %s", to)
+	}
+	return nil
+}
+
+// AppointmentStatusHistory records one status change of an appointment
+type AppointmentStatusHistory struct {
+	ID            uint              `json:"id" gorm:"primarykey"`
+	AppointmentID uint              `json:"appointment_id" gorm:"not null;index"`
+	FromStatus    AppointmentStatus `json:"from_status" gorm:"type:varchar(20);not null"`
+	ToStatus      AppointmentStatus `json:"to_status" gorm:"type:varchar(20);not null;index"`
+	Reason        string            `json:"reason" gorm:"type:text"`
+
+	// Who made the change; nil for changes the system made
+	ChangedByID *uint     `json:"changed_by_id"`
+	ChangedBy   *User     `json:"changed_by,omitempty" gorm:"foreignKey:ChangedByID"`
+	ChangedAt   time.Time `json:"changed_at" gorm:"not null;index"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RecordStatusChange stores a status change made by the actor (nil for the system)
+func RecordStatusChange(db *gorm.DB, appointment *Appointment, from AppointmentStatus, reason string, actorID *uint) error {
+	return db.Create(&AppointmentStatusHistory{
+		AppointmentID: appointment.ID,
+		FromStatus:    from,
+		ToStatus:      appointment.Status,
+		Reason:        strings.TrimSpace(reason),
+		ChangedByID:   actorID,
+		ChangedAt:     time.Now(),
+		ClinicID:      appointment.ClinicID,
+	}).Error
+}
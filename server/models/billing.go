@@ -0,0 +1,314 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type InvoiceStatus string
+
+const (
+	InvoiceStatusDraft         InvoiceStatus = "draft"
+	InvoiceStatusIssued        InvoiceStatus = "issued"
+	InvoiceStatusPartiallyPaid InvoiceStatus = "partially_paid"
+	InvoiceStatusPaid          InvoiceStatus = "paid"
+	InvoiceStatusRefunded      InvoiceStatus = "refunded"
+	InvoiceStatusVoid          InvoiceStatus = "void"
+)
+
+type PaymentMethod string
+
+const (
+	PaymentMethodCash         PaymentMethod = "cash"
+	PaymentMethodCard         PaymentMethod = "card"
+	PaymentMethodBankTransfer PaymentMethod = "bank_transfer"
+	PaymentMethodEWallet      PaymentMethod = "e_wallet" // GCash, Maya, etc.
+	PaymentMethodCheck        PaymentMethod = "check"
+	PaymentMethodInsurance    PaymentMethod = "insurance"
+	PaymentMethodOther        PaymentMethod = "other"
+)
+
+type PaymentType string
+
+const (
+	PaymentTypePayment PaymentType = "payment"
+	PaymentTypeRefund  PaymentType = "refund"
+)
+
+// Invoice is a patient bill, normally generated from an appointment's procedures
+type Invoice struct {
+	ID            uint          `json:"id" gorm:"primarykey"`
+	InvoiceNumber string        `json:"invoice_number" gorm:"size:50;uniqueIndex"`
+	Status        InvoiceStatus `json:"status" gorm:"type:varchar(20);default:'issued';index"`
+
+	// Patient and appointment
+	PatientID     uint         `json:"patient_id" gorm:"not null;index"`
+	Patient       Patient      `json:"patient" gorm:"foreignKey:PatientID"`
+	AppointmentID *uint        `json:"appointment_id" gorm:"index"`
+	Appointment   *Appointment `json:"appointment,omitempty" gorm:"foreignKey:AppointmentID"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint    `json:"clinic_id" gorm:"not null;index"`
+	Clinic   Clinic  `json:"clinic" gorm:"foreignKey:ClinicID"`
+	BranchID *uint   `json:"branch_id" gorm:"index"`
+	Branch   *Branch `json:"branch,omitempty" gorm:"foreignKey:BranchID"`
+
+	// Lines and payments
+	Lines    []InvoiceLine `json:"lines" gorm:"foreignKey:InvoiceID"`
+	Payments []Payment     `json:"payments,omitempty" gorm:"foreignKey:InvoiceID"`
+
+	// Totals
+	Subtotal       float64 `json:"subtotal" gorm:"type:decimal(10,2)"`
+	DiscountAmount float64 `json:"discount_amount" gorm:"type:decimal(10,2);default:0"`
+	TaxAmount      float64 `json:"tax_amount" gorm:"type:decimal(10,2);default:0"`
+	TotalAmount    float64 `json:"total_amount" gorm:"type:decimal(10,2)"`
+	AmountPaid     float64 `json:"amount_paid" gorm:"type:decimal(10,2);default:0"`
+	BalanceDue     float64 `json:"balance_due" gorm:"type:decimal(10,2)"`
+
+	// Insurance billed for the invoice and the estimated split of the total
+	InsurancePolicyID *uint            `json:"insurance_policy_id" gorm:"index"`
+	InsurancePolicy   *InsurancePolicy `json:"insurance_policy,omitempty" gorm:"foreignKey:InsurancePolicyID"`
+	InsurerEstimate   float64          `json:"insurer_estimate" gorm:"type:decimal(10,2);default:0"`
+	PatientEstimate   float64          `json:"patient_estimate" gorm:"type:decimal(10,2);default:0"`
+
+	// Dates
+	IssuedAt time.Time  `json:"issued_at" gorm:"not null;index"`
+	DueDate  *time.Time `json:"due_date"`
+	PaidAt   *time.Time `json:"paid_at"`
+	VoidedAt *time.Time `json:"voided_at"`
+
+	Notes      string `json:"notes" gorm:"type:text"`
+	VoidReason string `json:"void_reason" gorm:"type:text"`
+
+	// Created by
+	CreatedByID uint `json:"created_by_id" gorm:"not null;index"`
+	CreatedBy   User `json:"created_by" gorm:"foreignKey:CreatedByID"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// InvoiceLine is a single billable item on an invoice
+type InvoiceLine struct {
+	ID        uint    `json:"id" gorm:"primarykey"`
+	InvoiceID uint    `json:"invoice_id" gorm:"not null;index"`
+	Invoice   Invoice `json:"-" gorm:"foreignKey:InvoiceID"`
+
+	// Source procedure (optional - lines can be added manually)
+	AppointmentProcedureID *uint                 `json:"appointment_procedure_id" gorm:"index"`
+	AppointmentProcedure   *AppointmentProcedure `json:"appointment_procedure,omitempty" gorm:"foreignKey:AppointmentProcedureID"`
+
+	ProcedureCode string  `json:"procedure_code" gorm:"size:20"`
+	Description   string  `json:"description" gorm:"size:500;not null"`
+	ToothNumber   string  `json:"tooth_number" gorm:"size:10"`
+	Quantity      int     `json:"quantity" gorm:"default:1"`
+	UnitPrice     float64 `json:"unit_price" gorm:"type:decimal(10,2)"`
+	LineTotal     float64 `json:"line_total" gorm:"type:decimal(10,2)"`
+
+	// Procedure category and the estimated insurance split of the line total
+	Category        string  `json:"category" gorm:"size:100"`
+	CoveragePercent float64 `json:"coverage_percent" gorm:"type:decimal(5,2);default:0"`
+	InsurerAmount   float64 `json:"insurer_amount" gorm:"type:decimal(10,2);default:0"`
+	PatientAmount   float64 `json:"patient_amount" gorm:"type:decimal(10,2);default:0"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Payment records money received against an invoice, or refunded back to the patient
+type Payment struct {
+	ID        uint        `json:"id" gorm:"primarykey"`
+	InvoiceID uint        `json:"invoice_id" gorm:"not null;index"`
+	Invoice   *Invoice    `json:"invoice,omitempty" gorm:"foreignKey:InvoiceID"`
+	Type      PaymentType `json:"type" gorm:"type:varchar(20);default:'payment'"`
+
+	Amount    float64       `json:"amount" gorm:"type:decimal(10,2);not null"` // always positive, Type decides direction
+	Method    PaymentMethod `json:"method" gorm:"type:varchar(20);not null"`
+	Reference string        `json:"reference" gorm:"size:100"` // receipt, card approval or transfer reference
+	Notes     string        `json:"notes" gorm:"type:text"`
+	PaidAt    time.Time     `json:"paid_at" gorm:"not null;index"`
+
+	// Refunds point at the payment they reverse (optional)
+	RefundOfID *uint `json:"refund_of_id" gorm:"index"`
+
+	// Insurer payments point at the claim they settle (optional)
+	InsuranceClaimID *uint `json:"insurance_claim_id" gorm:"index"`
+
+	// Denormalized for ledger and daily reconciliation queries
+	PatientID uint  `json:"patient_id" gorm:"not null;index"`
+	ClinicID  uint  `json:"clinic_id" gorm:"not null;index"`
+	BranchID  *uint `json:"branch_id" gorm:"index"`
+
+	// Received by
+	ReceivedByID uint `json:"received_by_id" gorm:"not null;index"`
+	ReceivedBy   User `json:"received_by" gorm:"foreignKey:ReceivedByID"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// GenerateInvoiceNumber takes the next invoice number for a clinic. It locks the clinic's invoice
+// sequence until tx ends, so the invoice must be created in tx.
+func GenerateInvoiceNumber(clinicID uint, tx *gorm.DB) (string, error) {
+	next, err := NextSequenceValue(tx, clinicID, "invoice", 0, func() (int64, error) {
+		var count int64
+		err := tx.Unscoped().Model(&Invoice{}).Where("clinic_id = ?", clinicID).Count(&count).Error
+		return count, err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Format: INV-{clinic}-{year}-{000000}
+	return fmt.Sprintf("INV-%d-%d-%06d", clinicID, time.Now().Year(), next), nil
+}
+
+func IsValidPaymentMethod(method PaymentMethod) bool {
+	switch method {
+	case PaymentMethodCash, PaymentMethodCard, PaymentMethodBankTransfer, PaymentMethodEWallet,
+		PaymentMethodCheck, PaymentMethodInsurance, PaymentMethodOther:
+		return true
+	}
+	return false
+}
+
+// roundMoney rounds to two decimal places to keep float totals in line with decimal(10,2) columns
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// Helper methods for InvoiceLine
+func (l *InvoiceLine) CalculateLineTotal() {
+	if l.Quantity <= 0 {
+		l.Quantity = 1
+	}
+	l.LineTotal = roundMoney(float64(l.Quantity) * l.UnitPrice)
+}
+
+// Helper methods for Invoice
+func (i *Invoice) IsVoid() bool {
+	return i.Status == InvoiceStatusVoid
+}
+
+func (i *Invoice) IsPaid() bool {
+	return i.Status == InvoiceStatusPaid
+}
+
+func (i *Invoice) RecalculateTotals() {
+	i.Subtotal = 0
+	for _, line := range i.Lines {
+		i.Subtotal += line.LineTotal
+	}
+	i.Subtotal = roundMoney(i.Subtotal)
+	i.TotalAmount = roundMoney(i.Subtotal - i.DiscountAmount + i.TaxAmount)
+	if i.TotalAmount < 0 {
+		i.TotalAmount = 0
+	}
+	i.BalanceDue = roundMoney(i.TotalAmount - i.AmountPaid)
+}
+
+// ApplyInsuranceEstimate splits the lines between the policy and the patient; without a policy the
+// patient pays everything. Discounts and tax fall to the patient. Call after RecalculateTotals.
+func (i *Invoice) ApplyInsuranceEstimate(policy *InsurancePolicy, usedBenefit float64) {
+	i.InsurerEstimate = 0
+	if policy == nil {
+		i.InsurancePolicyID = nil
+		for j := range i.Lines {
+			i.Lines[j].CoveragePercent = 0
+			i.Lines[j].InsurerAmount = 0
+			i.Lines[j].PatientAmount = i.Lines[j].LineTotal
+		}
+		i.PatientEstimate = i.TotalAmount
+		return
+	}
+
+	categories := make([]string, len(i.Lines))
+	charges := make([]float64, len(i.Lines))
+	for j, line := range i.Lines {
+		categories[j] = line.Category
+		charges[j] = line.LineTotal
+	}
+	for j, estimate := range policy.EstimateCoverage(categories, charges, usedBenefit) {
+		i.Lines[j].CoveragePercent = estimate.CoveragePercent
+		i.Lines[j].InsurerAmount = estimate.InsurerAmount
+		i.Lines[j].PatientAmount = estimate.PatientAmount
+		i.InsurerEstimate += estimate.InsurerAmount
+	}
+
+	policyID := policy.ID
+	i.InsurancePolicyID = &policyID
+	i.InsurerEstimate = roundMoney(math.Min(i.InsurerEstimate, i.TotalAmount))
+	i.PatientEstimate = roundMoney(i.TotalAmount - i.InsurerEstimate)
+}
+
+// ApplyPayments recomputes AmountPaid, BalanceDue and Status from the invoice's payments
+func (i *Invoice) ApplyPayments() {
+	paid := 0.0
+	refunded := 0.0
+	for _, payment := range i.Payments {
+		if payment.Type == PaymentTypeRefund {
+			refunded += payment.Amount
+		} else {
+			paid += payment.Amount
+		}
+	}
+
+	i.AmountPaid = roundMoney(paid - refunded)
+	i.BalanceDue = roundMoney(i.TotalAmount - i.AmountPaid)
+
+	if i.IsVoid() {
+		return
+	}
+
+	switch {
+	case i.AmountPaid <= 0 && refunded > 0:
+		i.Status = InvoiceStatusRefunded
+		i.PaidAt = nil
+	case i.AmountPaid <= 0:
+		i.Status = InvoiceStatusIssued
+		i.PaidAt = nil
+	case i.BalanceDue > 0:
+		i.Status = InvoiceStatusPartiallyPaid
+		i.PaidAt = nil
+	default:
+		i.Status = InvoiceStatusPaid
+		if i.PaidAt == nil {
+			now := time.Now()
+			i.PaidAt = &now
+		}
+	}
+}
+
+// RefundableAmount returns how much of the collected money can still be refunded
+func (i *Invoice) RefundableAmount() float64 {
+	if i.AmountPaid < 0 {
+		return 0
+	}
+	return i.AmountPaid
+}
+
+func (i *Invoice) Void(reason string) {
+	now := time.Now()
+	i.Status = InvoiceStatusVoid
+	i.VoidReason = reason
+	i.VoidedAt = &now
+	i.BalanceDue = 0
+}
+
+// Helper methods for Payment
+func (p *Payment) IsRefund() bool {
+	return p.Type == PaymentTypeRefund
+}
+
+// SignedAmount returns the payment amount as it affects the patient balance
+func (p *Payment) SignedAmount() float64 {
+	if p.IsRefund() {
+		return -p.Amount
+	}
+	return p.Amount
+}
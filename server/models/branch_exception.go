@@ -0,0 +1,173 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type BranchExceptionType string
+
+const (
+	ExceptionClosed       BranchExceptionType = "closed"        // closed all day
+	ExceptionSpecialHours BranchExceptionType = "special_hours" // open, but with different hours, e.g. a half day
+	ExceptionExtraDay     BranchExceptionType = "extra_day"     // open on a day the branch is normally closed
+)
+
+func IsValidBranchExceptionType(t BranchExceptionType) bool {
+	switch t {
+	case ExceptionClosed, ExceptionSpecialHours, ExceptionExtraDay:
+		return true
+	}
+	return false
+}
+
+// BranchScheduleException overrides a branch's weekly schedule on one date, e.g. a holiday closure,
+// a half day before Christmas or a Saturday clinic. Annual exceptions repeat on the same month and
+// day every year from Date on.
+type BranchScheduleException struct {
+	ID       uint                `json:"id" gorm:"primarykey"`
+	BranchID uint                `json:"branch_id" gorm:"not null;index"`
+	Branch   Branch              `json:"branch,omitempty" gorm:"foreignKey:BranchID"`
+	Date     time.Time           `json:"date" gorm:"type:date;not null;index"`
+	Type     BranchExceptionType `json:"type" gorm:"type:varchar(20);not null"`
+
+	// Opening hours for special_hours and extra_day, empty for closures
+	StartTime string `json:"start_time" gorm:"size:5"` // HH:MM
+	EndTime   string `json:"end_time" gorm:"size:5"`   // HH:MM
+
+	Reason         string `json:"reason" gorm:"size:500"`
+	RecursAnnually bool   `json:"recurs_annually" gorm:"default:false;index"`
+
+	CreatedByID uint `json:"created_by_id"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// Validate checks the type and, for open days, the hours
+func (e *BranchScheduleException) Validate() error {
+	if !IsValidBranchExceptionType(e.Type) {
+		return fmt.Errorf("type must be closed, special_hours or extra_day")
+	}
+	if e.Type == ExceptionClosed {
+		return nil
+	}
+	start, err := ParseClock(e.StartTime)
+	if err != nil {
+		return err
+	}
+	end, err := ParseClock(e.EndTime)
+	if err != nil {
+		return err
+	}
+	if end <= start {
+		return fmt.Errorf("end_time must be after start_time")
+	}
+	return nil
+}
+
+// AppliesOn reports whether the exception covers the calendar date
+func (e *BranchScheduleException) AppliesOn(date time.Time) bool {
+	if !e.RecursAnnually {
+		return date.Format("2006-01-02") == e.Date.Format("2006-01-02")
+	}
+	return date.Year() >= e.Date.Year() && date.Month() == e.Date.Month() && date.Day() == e.Date.Day()
+}
+
+// NextOccurrence returns the first date on or after from's calendar day that the exception covers,
+// as a UTC midnight
+func (e *BranchScheduleException) NextOccurrence(from time.Time) (time.Time, bool) {
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	first := time.Date(e.Date.Year(), e.Date.Month(), e.Date.Day(), 0, 0, 0, 0, time.UTC)
+	if !first.Before(day) {
+		return first, true
+	}
+	if !e.RecursAnnually {
+		return time.Time{}, false
+	}
+	// Up to eight years ahead covers 29 February
+	for year := day.Year(); year <= day.Year()+8; year++ {
+		next := time.Date(year, e.Date.Month(), e.Date.Day(), 0, 0, 0, 0, time.UTC)
+		if next.Day() == e.Date.Day() && !next.Before(day) {
+			return next, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Range returns the opening hours on the date's calendar day; closures have none
+func (e *BranchScheduleException) Range(date time.Time) (TimeRange, bool) {
+	if e.Type == ExceptionClosed {
+		return TimeRange{}, false
+	}
+	start, err := ParseClock(e.StartTime)
+	if err != nil {
+		return TimeRange{}, false
+	}
+	end, err := ParseClock(e.EndTime)
+	if err != nil {
+		return TimeRange{}, false
+	}
+	return ClockRange(date, start, end), true
+}
+
+// BranchExceptionsOn returns the exception in force at each branch on the calendar date. A one-off
+// exception wins over an annual one, and a later entry over an earlier one. Clinic holidays close
+// the branches they cover whatever their exceptions say, and come back as closures with no ID.
+func BranchExceptionsOn(db *gorm.DB, branchIDs []uint, date time.Time) (map[uint]*BranchScheduleException, error) {
+	result := map[uint]*BranchScheduleException{}
+	if len(branchIDs) == 0 {
+		return result, nil
+	}
+
+	var exceptions []BranchScheduleException
+	if err := db.Where("branch_id IN ? AND (date = ? OR recurs_annually = ?)", branchIDs, date.Format("2006-01-02"), true).
+		Order("id ASC").Find(&exceptions).Error; err != nil {
+		return nil, err
+	}
+	for i := range exceptions {
+		exception := &exceptions[i]
+		if !exception.AppliesOn(date) {
+			continue
+		}
+		if current, ok := result[exception.BranchID]; ok && !current.RecursAnnually && exception.RecursAnnually {
+			continue
+		}
+		result[exception.BranchID] = exception
+	}
+
+	var branches []Branch
+	if err := db.Select("id", "clinic_id").Where("id IN ?", branchIDs).Find(&branches).Error; err != nil {
+		return nil, err
+	}
+	clinicIDs := make([]uint, len(branches))
+	for i, branch := range branches {
+		clinicIDs[i] = branch.ClinicID
+	}
+	var holidays []ClinicHoliday
+	if err := db.Where("clinic_id IN ? AND date = ? AND (branch_id IS NULL OR branch_id IN ?)", clinicIDs, date.Format("2006-01-02"), branchIDs).
+		Order("id ASC").Find(&holidays).Error; err != nil {
+		return nil, err
+	}
+	for _, holiday := range holidays {
+		for _, branch := range branches {
+			if branch.ClinicID != holiday.ClinicID || (holiday.BranchID != nil && *holiday.BranchID != branch.ID) {
+				continue
+			}
+			result[branch.ID] = &BranchScheduleException{
+				BranchID: branch.ID,
+				Date:     holiday.Date,
+				Type:     ExceptionClosed,
+				Reason:   holiday.Name,
+				ClinicID: holiday.ClinicID,
+			}
+		}
+	}
+	return result, nil
+}
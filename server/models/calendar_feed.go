@@ -0,0 +1,45 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CalendarFeed is a secret iCalendar subscription URL. A feed with a UserID lists that user's
+// appointments as their doctor; one with a BranchID lists every appointment at the branch, for
+// the front desk. Anyone holding the token can read the feed, so it is revoked by deleting it.
+type CalendarFeed struct {
+	ID       uint    `json:"id" gorm:"primarykey"`
+	Token    string  `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	UserID   *uint   `json:"user_id" gorm:"index"`
+	User     *User   `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	BranchID *uint   `json:"branch_id" gorm:"index"`
+	Branch   *Branch `json:"branch,omitempty" gorm:"foreignKey:BranchID"`
+
+	LastAccessedAt *time.Time `json:"last_accessed_at"`
+	CreatedByID    uint       `json:"created_by_id"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// CalendarUID is the appointment's stable iCalendar UID, shared by feeds and attachments so
+// calendar clients update the event they already have instead of adding another
+func (a *Appointment) CalendarUID() string {
+	return "appointment-" + strconv.FormatUint(uint64(a.ID), 10) + "@dentika"
+}
+
+// CalendarSequence is the iCalendar revision number: seconds between booking and the last change,
+// so it grows with every update
+func (a *Appointment) CalendarSequence() int {
+	if a.UpdatedAt.Before(a.CreatedAt) {
+		return 0
+	}
+	return int(a.UpdatedAt.Sub(a.CreatedAt) / time.Second)
+}
@@ -16,6 +16,7 @@ type Clinic struct {
 	Website  string `json:"website" gorm:"size:200"`
 	Logo     string `json:"logo" gorm:"size:500"`
 	Tagline  string `json:"tagline" gorm:"size:300"` // Clinic tagline/slogan
+	Timezone string `json:"timezone" gorm:"size:64"` // IANA name, e.g. "Asia/Manila"; empty uses the server default
 	IsActive bool   `json:"is_active" gorm:"default:true"`
 
 	// Relationships
@@ -37,6 +38,7 @@ type Branch struct {
 	IsActive     bool   `json:"is_active" gorm:"default:true"`
 	Schedule     string `json:"schedule" gorm:"type:text"` // JSON string for complete schedule configuration
 	IsClosedToday bool  `json:"is_closed_today" gorm:"default:false"` // Override flag to close branch even if normally open
+	Timezone     string `json:"timezone" gorm:"size:64"` // IANA name; empty uses the clinic's timezone
 
 	// Foreign Keys
 	ClinicID uint   `json:"clinic_id" gorm:"not null;index"`
@@ -0,0 +1,669 @@
+package models
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// CodeSetKind is the template catalog a code set is imported into
+type CodeSetKind string
+
+const (
+	CodeSetProcedures CodeSetKind = "procedure"
+	CodeSetDiagnoses  CodeSetKind = "diagnosis"
+)
+
+// What an import does to a code
+const (
+	CodeSetCreate     = "create"
+	CodeSetUpdate     = "update"
+	CodeSetReactivate = "reactivate"
+	CodeSetDeactivate = "deactivate"
+)
+
+// CodeSetEntry is one code read from an import file. Empty optional fields leave the existing
+// template's value alone.
+type CodeSetEntry struct {
+	Row               int      `json:"-"`
+	Code              string   `json:"code"`
+	Name              string   `json:"name"`
+	Description       string   `json:"description"`
+	Category          string   `json:"category"`
+	Severity          string   `json:"severity"`
+	EstimatedDuration *int     `json:"estimated_duration"`
+	DefaultCost       *float64 `json:"default_cost"`
+	Status            string   `json:"status"` // active (default) or retired
+	Retired           bool     `json:"retired"`
+}
+
+// CodeSetImport describes an import of a code set into a catalog
+type CodeSetImport struct {
+	Kind     CodeSetKind
+	ClinicID uint // PlatformCatalogID for the platform catalog
+	Entries  []CodeSetEntry
+
+	// CategoryMap renames the file's categories (matched case-insensitively) to the clinic's
+	CategoryMap map[string]string
+
+	// DeactivateMissing deactivates codes in the catalog that the file leaves out, limited to
+	// codes starting with CodePrefix when one is given
+	DeactivateMissing bool
+	CodePrefix        string
+}
+
+type CodeSetFieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+type CodeSetChange struct {
+	Code    string               `json:"code"`
+	Name    string               `json:"name"`
+	Action  string               `json:"action"`
+	Changes []CodeSetFieldChange `json:"changes,omitempty"`
+
+	id        uint
+	overrides uint // the platform template a clinic copy is made from
+	record    codeSetRecord
+}
+
+type CodeSetRowError struct {
+	Row   int    `json:"row"`
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error"`
+}
+
+// CodeSetReport is the diff an import makes, or would make in a dry run
+type CodeSetReport struct {
+	Kind        CodeSetKind       `json:"kind"`
+	ClinicID    uint              `json:"clinic_id"`
+	DryRun      bool              `json:"dry_run"`
+	Applied     bool              `json:"applied"`
+	Entries     int               `json:"entries"`
+	Created     int               `json:"created"`
+	Updated     int               `json:"updated"`
+	Reactivated int               `json:"reactivated"`
+	Deactivated int               `json:"deactivated"`
+	Unchanged   int               `json:"unchanged"`
+	Changes     []CodeSetChange   `json:"changes"`
+	Errors      []CodeSetRowError `json:"errors,omitempty"`
+}
+
+// codeSetRecord is the part of a procedure or diagnosis template an import manages
+type codeSetRecord struct {
+	Code              string
+	Name              string
+	Description       string
+	Category          string
+	Severity          string
+	EstimatedDuration int
+	DefaultCost       float64
+	IsActive          bool
+	Deleted           bool
+}
+
+// IsValidCodeSetKind reports whether the kind names a template catalog
+func IsValidCodeSetKind(kind CodeSetKind) bool {
+	return kind == CodeSetProcedures || kind == CodeSetDiagnoses
+}
+
+// ParseCodeSet reads a code set from CSV (with a header row) or JSON (an array of entries, or an
+// object with an "entries" array)
+func ParseCodeSet(r io.Reader, format string) ([]CodeSetEntry, error) {
+	switch strings.ToLower(format) {
+	case "json":
+		return parseCodeSetJSON(r)
+	case "csv", "":
+		return parseCodeSetCSV(r)
+	}
+	return nil, fmt.Errorf("unsupported format %q, use csv or json", format)
+}
+
+func parseCodeSetJSON(r io.Reader) ([]CodeSetEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var entries []CodeSetEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		var wrapped struct {
+			Entries []CodeSetEntry `json:"entries"`
+		}
+		if err := json.Unmarshal(data, &wrapped); err != nil {
+			return nil, fmt.Errorf("invalid JSON code set: %v", err)
+		}
+		entries = wrapped.Entries
+	}
+	for i := range entries {
+		entries[i].Row = i + 1
+	}
+	return entries, nil
+}
+
+// codeSetColumns maps the CSV header names accepted for each field
+var codeSetColumns = map[string]string{
+	"code":               "code",
+	"name":               "name",
+	"title":              "name",
+	"description":        "description",
+	"category":           "category",
+	"severity":           "severity",
+	"estimated_duration": "estimated_duration",
+	"duration":           "estimated_duration",
+	"default_cost":       "default_cost",
+	"cost":               "default_cost",
+	"fee":                "default_cost",
+	"status":             "status",
+	"retired":            "retired",
+}
+
+func parseCodeSetCSV(r io.Reader) ([]CodeSetEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("missing CSV header: %v", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if field, ok := codeSetColumns[name]; ok {
+			columns[field] = i
+		}
+	}
+	if _, ok := columns["code"]; !ok {
+		return nil, fmt.Errorf("CSV header has no code column")
+	}
+
+	var entries []CodeSetEntry
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %v", row, err)
+		}
+		value := func(field string) string {
+			if i, ok := columns[field]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		if strings.Join(record, "") == "" {
+			continue
+		}
+
+		entry := CodeSetEntry{
+			Row:         row,
+			Code:        value("code"),
+			Name:        value("name"),
+			Description: value("description"),
+			Category:    value("category"),
+			Severity:    value("severity"),
+			Status:      value("status"),
+		}
+		if v := value("estimated_duration"); v != "" {
+			minutes, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("row %d: invalid estimated_duration %q", row, v)
+			}
+			entry.EstimatedDuration = &minutes
+		}
+		if v := value("default_cost"); v != "" {
+			cost, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("row %d: invalid default_cost %q", row, v)
+			}
+			entry.DefaultCost = &cost
+		}
+		if v := strings.ToLower(value("retired")); v == "true" || v == "1" || v == "yes" {
+			entry.Retired = true
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// IsRetired reports whether the file marks the code as no longer in use
+func (e *CodeSetEntry) IsRetired() bool {
+	switch strings.ToLower(e.Status) {
+	case "retired", "inactive", "deleted", "deprecated":
+		return true
+	}
+	return e.Retired
+}
+
+// StandardCodeCategory is the category of a CDT procedure code (D0000–D9999) or an ICD-10 dental
+// diagnosis code (K00–K14), for files that don't give one
+func StandardCodeCategory(kind CodeSetKind, code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if kind == CodeSetProcedures && len(code) == 5 && code[0] == 'D' {
+		number, err := strconv.Atoi(code[1:])
+		if err != nil {
+			return ""
+		}
+		switch {
+		case number < 1000:
+			return "diagnostic"
+		case number < 2000:
+			return "preventive"
+		case number < 3000:
+			return "restorative"
+		case number < 4000:
+			return "endodontic"
+		case number < 5000:
+			return "periodontal"
+		case number < 6000:
+			return "prosthodontic"
+		case number < 6200:
+			return "implant"
+		case number < 7000:
+			return "prosthodontic"
+		case number < 8000:
+			return "surgical"
+		case number < 9000:
+			return "orthodontic"
+		default:
+			return "adjunctive"
+		}
+	}
+	if kind == CodeSetDiagnoses && len(code) >= 3 && code[0] == 'K' {
+		switch code[:3] {
+		case "K00":
+			return "developmental"
+		case "K01":
+			return "impacted"
+		case "K02":
+			return "caries"
+		case "K03":
+			return "hard_tissue"
+		case "K04":
+			return "endodontic"
+		case "K05", "K06":
+			return "periodontal"
+		case "K07":
+			return "dentofacial"
+		case "K08":
+			return "other"
+		case "K09":
+			return "cyst"
+		case "K11":
+			return "salivary"
+		case "K12", "K13":
+			return "mucosal"
+		case "K14":
+			return "tongue"
+		}
+	}
+	return ""
+}
+
+// PlanCodeSetImport works out what importing the code set would change, without writing anything
+func PlanCodeSetImport(db *gorm.DB, opts CodeSetImport) (*CodeSetReport, error) {
+	report := &CodeSetReport{Kind: opts.Kind, ClinicID: opts.ClinicID, DryRun: true, Entries: len(opts.Entries), Changes: []CodeSetChange{}}
+	if !IsValidCodeSetKind(opts.Kind) {
+		return nil, fmt.Errorf("unknown code set kind %q", opts.Kind)
+	}
+
+	existing, err := loadCodeSetRecords(db, opts.Kind, opts.ClinicID)
+	if err != nil {
+		return nil, err
+	}
+	// A clinic changes the platform codes it sees through copies of its own
+	platform := map[string]codeSetExisting{}
+	if opts.ClinicID != PlatformCatalogID {
+		if platform, err = loadCodeSetRecords(db, opts.Kind, PlatformCatalogID); err != nil {
+			return nil, err
+		}
+	}
+	categoryMap := map[string]string{}
+	for from, to := range opts.CategoryMap {
+		categoryMap[strings.ToLower(strings.TrimSpace(from))] = strings.TrimSpace(to)
+	}
+
+	seen := map[string]bool{}
+	for _, entry := range opts.Entries {
+		code := strings.ToUpper(strings.TrimSpace(entry.Code))
+		switch {
+		case code == "":
+			report.Errors = append(report.Errors, CodeSetRowError{Row: entry.Row, Error: "code is required"})
+			continue
+		case len(code) > 20:
+			report.Errors = append(report.Errors, CodeSetRowError{Row: entry.Row, Code: code, Error: "code is longer than 20 characters"})
+			continue
+		case seen[code]:
+			report.Errors = append(report.Errors, CodeSetRowError{Row: entry.Row, Code: code, Error: "code appears more than once"})
+			continue
+		}
+		seen[code] = true
+		current, exists := existing[code]
+		base, inherited := platform[code]
+		inherited = inherited && !base.record.Deleted && (!exists || current.record.Deleted)
+
+		if entry.IsRetired() {
+			if inherited && base.record.IsActive {
+				report.add(hidePlatformCode(code, base, current, exists))
+			} else if exists && current.record.IsActive && !current.record.Deleted {
+				report.add(CodeSetChange{Code: code, Name: current.record.Name, Action: CodeSetDeactivate, id: current.id,
+					Changes: []CodeSetFieldChange{{Field: "is_active", From: true, To: false}}})
+			} else {
+				report.Unchanged++
+			}
+			continue
+		}
+
+		name := strings.TrimSpace(entry.Name)
+		if name == "" && !exists && !inherited {
+			report.Errors = append(report.Errors, CodeSetRowError{Row: entry.Row, Code: code, Error: "name is required"})
+			continue
+		}
+		if len(name) > 200 {
+			report.Errors = append(report.Errors, CodeSetRowError{Row: entry.Row, Code: code, Error: "name is longer than 200 characters"})
+			continue
+		}
+		if entry.EstimatedDuration != nil && *entry.EstimatedDuration < 0 || entry.DefaultCost != nil && *entry.DefaultCost < 0 {
+			report.Errors = append(report.Errors, CodeSetRowError{Row: entry.Row, Code: code, Error: "duration and cost cannot be negative"})
+			continue
+		}
+
+		category := strings.TrimSpace(entry.Category)
+		if mapped, ok := categoryMap[strings.ToLower(category)]; ok {
+			category = mapped
+		} else {
+			category = strings.ToLower(category)
+		}
+		if category == "" && !exists && !inherited {
+			category = StandardCodeCategory(opts.Kind, code)
+		}
+
+		// Start from what the catalog has and apply what the file gives
+		wanted := current.record
+		if inherited {
+			wanted = base.record
+		}
+		wanted.Code = code
+		if name != "" {
+			wanted.Name = name
+		}
+		if entry.Description != "" {
+			wanted.Description = strings.TrimSpace(entry.Description)
+		}
+		if category != "" {
+			wanted.Category = category
+		}
+		if opts.Kind == CodeSetDiagnoses && entry.Severity != "" {
+			wanted.Severity = strings.ToLower(strings.TrimSpace(entry.Severity))
+		}
+		if opts.Kind == CodeSetProcedures && entry.EstimatedDuration != nil {
+			wanted.EstimatedDuration = *entry.EstimatedDuration
+		}
+		if opts.Kind == CodeSetProcedures && entry.DefaultCost != nil {
+			wanted.DefaultCost = math.Round(*entry.DefaultCost*100) / 100
+		}
+		wanted.IsActive = true
+		wanted.Deleted = false
+
+		if inherited {
+			changes := base.record.diff(wanted, opts.Kind)
+			switch {
+			case len(changes) == 0:
+				report.Unchanged++
+			case exists:
+				// The clinic deleted its copy; bring it back with the platform values and the file's
+				report.add(CodeSetChange{Code: code, Name: wanted.Name, Action: CodeSetReactivate, Changes: changes, id: current.id, overrides: base.id, record: wanted})
+			default:
+				report.add(CodeSetChange{Code: code, Name: wanted.Name, Action: CodeSetCreate, Changes: changes, overrides: base.id, record: wanted})
+			}
+			continue
+		}
+		if !exists {
+			report.add(CodeSetChange{Code: code, Name: wanted.Name, Action: CodeSetCreate, record: wanted})
+			continue
+		}
+		changes := current.record.diff(wanted, opts.Kind)
+		switch {
+		case len(changes) == 0:
+			report.Unchanged++
+		case !current.record.IsActive || current.record.Deleted:
+			report.add(CodeSetChange{Code: code, Name: wanted.Name, Action: CodeSetReactivate, Changes: changes, id: current.id, record: wanted})
+		default:
+			report.add(CodeSetChange{Code: code, Name: wanted.Name, Action: CodeSetUpdate, Changes: changes, id: current.id, record: wanted})
+		}
+	}
+
+	if opts.DeactivateMissing {
+		prefix := strings.ToUpper(strings.TrimSpace(opts.CodePrefix))
+		for code, current := range existing {
+			if seen[code] || !current.record.IsActive || current.record.Deleted || !strings.HasPrefix(code, prefix) {
+				continue
+			}
+			report.add(CodeSetChange{Code: code, Name: current.record.Name, Action: CodeSetDeactivate, id: current.id,
+				Changes: []CodeSetFieldChange{{Field: "is_active", From: true, To: false}}})
+		}
+		for code, base := range platform {
+			current, exists := existing[code]
+			if seen[code] || base.record.Deleted || !base.record.IsActive || exists && !current.record.Deleted || !strings.HasPrefix(code, prefix) {
+				continue
+			}
+			report.add(hidePlatformCode(code, base, current, exists))
+		}
+	}
+
+	sort.SliceStable(report.Changes, func(i, j int) bool { return report.Changes[i].Code < report.Changes[j].Code })
+	return report, nil
+}
+
+// ApplyCodeSetImport plans the import and, if every entry is valid, writes it in one transaction
+func ApplyCodeSetImport(db *gorm.DB, opts CodeSetImport) (*CodeSetReport, error) {
+	report, err := PlanCodeSetImport(db, opts)
+	if err != nil || len(report.Errors) > 0 {
+		return report, err
+	}
+	report.DryRun = false
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, change := range report.Changes {
+			if err := applyCodeSetChange(tx, opts, change); err != nil {
+				return fmt.Errorf("%s %s: %v", change.Action, change.Code, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	report.Applied = true
+	return report, nil
+}
+
+// hidePlatformCode is the change that deactivates a platform code for a clinic: an inactive clinic
+// copy, reusing the copy the clinic deleted if there is one
+func hidePlatformCode(code string, base, current codeSetExisting, exists bool) CodeSetChange {
+	change := CodeSetChange{Code: code, Name: base.record.Name, Action: CodeSetDeactivate, overrides: base.id,
+		Changes: []CodeSetFieldChange{{Field: "is_active", From: true, To: false}}}
+	if exists {
+		change.id = current.id
+	}
+	return change
+}
+
+func (r *CodeSetReport) add(change CodeSetChange) {
+	switch change.Action {
+	case CodeSetCreate:
+		r.Created++
+	case CodeSetUpdate:
+		r.Updated++
+	case CodeSetReactivate:
+		r.Reactivated++
+	case CodeSetDeactivate:
+		r.Deactivated++
+	}
+	r.Changes = append(r.Changes, change)
+}
+
+func (rec codeSetRecord) diff(wanted codeSetRecord, kind CodeSetKind) []CodeSetFieldChange {
+	var changes []CodeSetFieldChange
+	compare := func(field string, from, to interface{}) {
+		if from != to {
+			changes = append(changes, CodeSetFieldChange{Field: field, From: from, To: to})
+		}
+	}
+	compare("name", rec.Name, wanted.Name)
+	compare("description", rec.Description, wanted.Description)
+	compare("category", rec.Category, wanted.Category)
+	if kind == CodeSetDiagnoses {
+		compare("severity", rec.Severity, wanted.Severity)
+	} else {
+		compare("estimated_duration", rec.EstimatedDuration, wanted.EstimatedDuration)
+		compare("default_cost", rec.DefaultCost, wanted.DefaultCost)
+	}
+	compare("is_active", rec.IsActive && !rec.Deleted, wanted.IsActive)
+	return changes
+}
+
+type codeSetExisting struct {
+	id     uint
+	record codeSetRecord
+}
+
+// loadCodeSetRecords loads the catalog's own templates by code, including deleted ones, which an
+// import brings back rather than colliding with on the unique code index
+func loadCodeSetRecords(db *gorm.DB, kind CodeSetKind, clinicID uint) (map[string]codeSetExisting, error) {
+	existing := map[string]codeSetExisting{}
+	if kind == CodeSetProcedures {
+		var templates []ProcedureTemplate
+		if err := db.Unscoped().Where("clinic_id = ?", clinicID).Find(&templates).Error; err != nil {
+			return nil, err
+		}
+		for _, t := range templates {
+			existing[strings.ToUpper(t.Code)] = codeSetExisting{id: t.ID, record: codeSetRecord{
+				Code: t.Code, Name: t.Name, Description: t.Description, Category: t.Category,
+				EstimatedDuration: t.EstimatedDuration, DefaultCost: t.DefaultCost,
+				IsActive: t.IsActive, Deleted: t.DeletedAt.Valid,
+			}}
+		}
+		return existing, nil
+	}
+
+	var templates []DiagnosisTemplate
+	if err := db.Unscoped().Where("clinic_id = ?", clinicID).Find(&templates).Error; err != nil {
+		return nil, err
+	}
+	for _, t := range templates {
+		existing[strings.ToUpper(t.Code)] = codeSetExisting{id: t.ID, record: codeSetRecord{
+			Code: t.Code, Name: t.Name, Description: t.Description, Category: t.Category, Severity: t.Severity,
+			IsActive: t.IsActive, Deleted: t.DeletedAt.Valid,
+		}}
+	}
+	return existing, nil
+}
+
+func applyCodeSetChange(tx *gorm.DB, opts CodeSetImport, change CodeSetChange) error {
+	var model interface{} = &DiagnosisTemplate{}
+	if opts.Kind == CodeSetProcedures {
+		model = &ProcedureTemplate{}
+	}
+
+	if change.overrides != 0 && change.id == 0 {
+		return createCodeSetOverride(tx, opts, change)
+	}
+	// A clinic copy brought back over a platform template takes over the clinic's prices for it
+	if change.overrides != 0 && opts.Kind == CodeSetProcedures {
+		if err := RepointClinicFeeItems(tx, opts.ClinicID, change.overrides, change.id); err != nil {
+			return err
+		}
+	}
+	if change.Action == CodeSetDeactivate {
+		return tx.Unscoped().Model(model).Where("id = ?", change.id).
+			Updates(map[string]interface{}{"is_active": false, "deleted_at": nil}).Error
+	}
+
+	rec := change.record
+	if change.Action == CodeSetCreate {
+		if opts.Kind == CodeSetProcedures {
+			return tx.Create(&ProcedureTemplate{
+				Code: rec.Code, Name: rec.Name, Description: rec.Description, Category: rec.Category,
+				EstimatedDuration: rec.EstimatedDuration, DefaultCost: rec.DefaultCost, IsActive: true, ClinicID: opts.ClinicID,
+			}).Error
+		}
+		return tx.Create(&DiagnosisTemplate{
+			Code: rec.Code, Name: rec.Name, Description: rec.Description, Category: rec.Category,
+			Severity: rec.Severity, IsActive: true, ClinicID: opts.ClinicID,
+		}).Error
+	}
+
+	fields := map[string]interface{}{
+		"name":        rec.Name,
+		"description": rec.Description,
+		"category":    rec.Category,
+		"is_active":   true,
+		"deleted_at":  nil,
+	}
+	if opts.Kind == CodeSetProcedures {
+		fields["estimated_duration"] = rec.EstimatedDuration
+		fields["default_cost"] = rec.DefaultCost
+	} else {
+		fields["severity"] = rec.Severity
+	}
+	return tx.Unscoped().Model(model).Where("id = ?", change.id).Updates(fields).Error
+}
+
+// createCodeSetOverride gives the clinic its own copy of a platform template, with the file's
+// values or, for a deactivation, inactive. Procedure copies keep the platform template's resource
+// requirements and take over the prices the clinic set on it.
+func createCodeSetOverride(tx *gorm.DB, opts CodeSetImport, change CodeSetChange) error {
+	rec := change.record
+	active := change.Action != CodeSetDeactivate
+
+	if opts.Kind == CodeSetProcedures {
+		var template ProcedureTemplate
+		if err := tx.Preload("ResourceRequirements").First(&template, change.overrides).Error; err != nil {
+			return err
+		}
+		override := template.OverrideFor(opts.ClinicID)
+		if active {
+			override.Name, override.Description, override.Category = rec.Name, rec.Description, rec.Category
+			override.EstimatedDuration, override.DefaultCost = rec.EstimatedDuration, rec.DefaultCost
+		}
+		override.IsActive = true
+		if err := tx.Create(&override).Error; err != nil {
+			return err
+		}
+		// is_active defaults to true, so an inactive copy is switched off after it is created
+		if !active {
+			if err := tx.Model(&override).Update("is_active", false).Error; err != nil {
+				return err
+			}
+		}
+		return RepointClinicFeeItems(tx, opts.ClinicID, template.ID, override.ID)
+	}
+
+	var template DiagnosisTemplate
+	if err := tx.First(&template, change.overrides).Error; err != nil {
+		return err
+	}
+	override := template.OverrideFor(opts.ClinicID)
+	if active {
+		override.Name, override.Description, override.Category, override.Severity = rec.Name, rec.Description, rec.Category, rec.Severity
+	}
+	override.IsActive = true
+	if err := tx.Create(&override).Error; err != nil {
+		return err
+	}
+	if !active {
+		return tx.Model(&override).Update("is_active", false).Error
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// consentMergeFieldPattern matches merge fields such as [PATIENT_NAME] in consent template content
+var consentMergeFieldPattern = regexp.MustCompile(`\[([A-Z][A-Z0-9_]+)\]`)
+
+const consentMergeDateFormat = "January 2, 2006"
+
+// ConsentMergeData holds the records a consent template is rendered against.
+// Appointment should have Branch and Procedures.ProcedureTemplate preloaded.
+type ConsentMergeData struct {
+	Patient     *Patient
+	Doctor      *User
+	Clinic      *Clinic
+	Appointment *Appointment
+	Date        time.Time
+}
+
+// ConsentMergeField describes a placeholder that can be used in consent template content
+type ConsentMergeField struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Placeholder string `json:"placeholder"`
+
+	// resolve returns the value for the field, or false when the data it needs is not available
+	resolve func(d *ConsentMergeData) (string, bool)
+}
+
+func patientField(get func(p *Patient) string) func(d *ConsentMergeData) (string, bool) {
+	return func(d *ConsentMergeData) (string, bool) {
+		if d.Patient == nil {
+			return "", false
+		}
+		value := get(d.Patient)
+		return value, value != ""
+	}
+}
+
+func clinicField(get func(c *Clinic) string) func(d *ConsentMergeData) (string, bool) {
+	return func(d *ConsentMergeData) (string, bool) {
+		if d.Clinic == nil {
+			return "", false
+		}
+		value := get(d.Clinic)
+		return value, value != ""
+	}
+}
+
+func appointmentField(get func(a *Appointment) string) func(d *ConsentMergeData) (string, bool) {
+	return func(d *ConsentMergeData) (string, bool) {
+		if d.Appointment == nil {
+			return "", false
+		}
+		value := get(d.Appointment)
+		return value, value != ""
+	}
+}
+
+func currentDateField(d *ConsentMergeData) (string, bool) {
+	return d.Date.Format(consentMergeDateFormat), true
+}
+
+var consentMergeFields = []ConsentMergeField{
+	{Name: "PATIENT_NAME", Description: "Patient full name", resolve: patientField(func(p *Patient) string { return p.GetFullName() })},
+	{Name: "PATIENT_FIRST_NAME", Description: "Patient first name", resolve: patientField(func(p *Patient) string { return p.FirstName })},
+	{Name: "PATIENT_LAST_NAME", Description: "Patient last name", resolve: patientField(func(p *Patient) string { return p.LastName })},
+	{Name: "PATIENT_NUMBER", Description: "Patient record number", resolve: patientField(func(p *Patient) string { return p.PatientNumber })},
+	{Name: "PATIENT_DOB", Description: "Patient date of birth", resolve: patientField(func(p *Patient) string {
+		if p.DateOfBirth == nil {
+			return ""
+		}
+		return p.DateOfBirth.Format(consentMergeDateFormat)
+	})},
+	{Name: "PATIENT_AGE", Description: "Patient age in years", resolve: patientField(func(p *Patient) string {
+		if p.DateOfBirth == nil {
+			return ""
+		}
+		return strconv.Itoa(p.GetAge())
+	})},
+	{Name: "PATIENT_PHONE", Description: "Patient phone number", resolve: patientField(func(p *Patient) string { return p.Phone })},
+	{Name: "PATIENT_EMAIL", Description: "Patient email address", resolve: patientField(func(p *Patient) string { return p.Email })},
+	{Name: "PATIENT_ADDRESS", Description: "Patient address", resolve: patientField(func(p *Patient) string { return p.Address })},
+	{Name: "DOCTOR_NAME", Description: "Treating doctor name", resolve: func(d *ConsentMergeData) (string, bool) {
+		if d.Doctor == nil {
+			return "", false
+		}
+		name := strings.TrimSpace(d.Doctor.GetFullName())
+		return name, name != ""
+	}},
+	{Name: "CLINIC_NAME", Description: "Clinic name", resolve: clinicField(func(c *Clinic) string { return c.Name })},
+	{Name: "CLINIC_ADDRESS", Description: "Clinic address", resolve: clinicField(func(c *Clinic) string { return c.Address })},
+	{Name: "CLINIC_PHONE", Description: "Clinic phone number", resolve: clinicField(func(c *Clinic) string { return c.Phone })},
+	{Name: "BRANCH_NAME", Description: "Branch of the linked appointment", resolve: appointmentField(func(a *Appointment) string { return a.Branch.Name })},
+	{Name: "APPOINTMENT_DATE", Description: "Date of the linked appointment", resolve: appointmentField(func(a *Appointment) string {
+		return a.StartTime.Format(consentMergeDateFormat)
+	})},
+	{Name: "APPOINTMENT_TIME", Description: "Start time of the linked appointment", resolve: appointmentField(func(a *Appointment) string {
+		return a.StartTime.Format("3:04 PM")
+	})},
+	{Name: "PROCEDURE_NAMES", Description: "Procedures on the linked appointment, comma separated", resolve: appointmentField(func(a *Appointment) string {
+		return strings.Join(appointmentProcedureNames(a), ", ")
+	})},
+	{Name: "PROCEDURE_LIST", Description: "Procedures on the linked appointment, one per line", resolve: appointmentField(func(a *Appointment) string {
+		names := appointmentProcedureNames(a)
+		if len(names) == 0 {
+			return ""
+		}
+		return "- " + strings.Join(names, "\n- ")
+	})},
+	{Name: "CURRENT_DATE", Description: "Date the form is generated", resolve: currentDateField},
+	{Name: "TODAY", Description: "Date the form is generated", resolve: currentDateField},
+	{Name: "DATE", Description: "Date the form is generated", resolve: currentDateField},
+}
+
+var consentMergeFieldIndex = func() map[string]*ConsentMergeField {
+	index := make(map[string]*ConsentMergeField, len(consentMergeFields))
+	for i := range consentMergeFields {
+		consentMergeFields[i].Placeholder = "[" + consentMergeFields[i].Name + "]"
+		index[consentMergeFields[i].Name] = &consentMergeFields[i]
+	}
+	return index
+}()
+
+// appointmentProcedureNames lists the non-cancelled procedures on an appointment, with tooth numbers
+func appointmentProcedureNames(a *Appointment) []string {
+	names := []string{}
+	for _, procedure := range a.Procedures {
+		if procedure.Status == "cancelled" || procedure.ProcedureTemplate.Name == "" {
+			continue
+		}
+		name := procedure.ProcedureTemplate.Name
+		if procedure.ToothNumber != "" {
+			name = fmt.Sprintf("%s (tooth %s)", name, procedure.ToothNumber)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// GetConsentMergeFields returns the supported consent template merge fields
+func GetConsentMergeFields() []ConsentMergeField {
+	return consentMergeFields
+}
+
+// ConsentTemplateFields returns the distinct merge fields used in template content, in order of first use
+func ConsentTemplateFields(content string) []string {
+	seen := map[string]bool{}
+	fields := []string{}
+	for _, match := range consentMergeFieldPattern.FindAllStringSubmatch(content, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			fields = append(fields, match[1])
+		}
+	}
+	return fields
+}
+
+// UnknownConsentMergeFields returns the merge fields in content that are not supported
+func UnknownConsentMergeFields(content string) []string {
+	unknown := []string{}
+	for _, field := range ConsentTemplateFields(content) {
+		if _, ok := consentMergeFieldIndex[field]; !ok {
+			unknown = append(unknown, field)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// ConsentRenderResult is the outcome of rendering consent template content
+type ConsentRenderResult struct {
+	Content       string   `json:"content"`
+	UsedFields    []string `json:"used_fields"`
+	UnknownFields []string `json:"unknown_fields"`
+	MissingFields []string `json:"missing_fields"` // known fields with no data, left as placeholders
+}
+
+// IsComplete reports whether every merge field was filled in
+func (r *ConsentRenderResult) IsComplete() bool {
+	return len(r.UnknownFields) == 0 && len(r.MissingFields) == 0
+}
+
+// RenderConsentContent replaces merge fields in content with values from data.
+// Unknown fields and fields without data are left in place and reported in the result.
+func RenderConsentContent(content string, data *ConsentMergeData) *ConsentRenderResult {
+	if data.Date.IsZero() {
+		data.Date = time.Now()
+	}
+
+	result := &ConsentRenderResult{
+		UsedFields:    ConsentTemplateFields(content),
+		UnknownFields: UnknownConsentMergeFields(content),
+		MissingFields: []string{},
+	}
+
+	missing := map[string]bool{}
+	result.Content = consentMergeFieldPattern.ReplaceAllStringFunc(content, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		field, ok := consentMergeFieldIndex[name]
+		if !ok {
+			return placeholder
+		}
+		value, ok := field.resolve(data)
+		if !ok {
+			if !missing[name] {
+				missing[name] = true
+				result.MissingFields = append(result.MissingFields, name)
+			}
+			return placeholder
+		}
+		return value
+	})
+
+	return result
+}
@@ -0,0 +1,272 @@
+package models
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"dentika/server/pdf"
+)
+
+// ConsentFormPDFDir is the directory under the private storage root where consent PDFs are stored
+const ConsentFormPDFDir = "consent-forms"
+
+// GeneratePDF renders the consent form, including the clinic logo from uploadBaseDir and both
+// signatures, to a PDF under storageDir and records its path relative to storageDir in PDFPath.
+// storageDir must not be publicly served.
+// Clinic, Patient, Doctor, Witness and SigningRecord should be preloaded.
+func (cf *ConsentForm) GeneratePDF(uploadBaseDir, storageDir string) error {
+	if cf.ID == 0 {
+		return errors.New("consent form must be saved before generating a PDF")
+	}
+
+	doc := pdf.New()
+	doc.SetFooter(fmt.Sprintf("%s - Consent form #%d - generated %s", cf.Clinic.Name, cf.ID, time.Now().Format("2006-01-02 15:04 MST")))
+
+	cf.writePDFHeader(doc, uploadBaseDir)
+
+	// Title
+	doc.SetFont(true, 16)
+	doc.Text(cf.Title)
+	doc.Ln(4)
+
+	// Patient details
+	doc.SetFont(false, 10)
+	details := []string{"Patient: " + cf.Patient.GetFullName()}
+	if cf.Patient.PatientNumber != "" {
+		details = append(details, "Patient No.: "+cf.Patient.PatientNumber)
+	}
+	if cf.Patient.DateOfBirth != nil {
+		details = append(details, "Date of Birth: "+cf.Patient.DateOfBirth.Format("January 2, 2006"))
+	}
+	if cf.Doctor != nil {
+		details = append(details, "Doctor: Dr. "+cf.Doctor.GetFullName())
+	}
+	details = append(details, "Form Date: "+cf.CreatedAt.Format("January 2, 2006"))
+	doc.Text(strings.Join(details, "    "))
+	doc.Ln(6)
+	doc.HLine()
+	doc.Ln(10)
+
+	// Template content
+	cf.writePDFContent(doc, cf.Content)
+
+	sections := []struct {
+		title string
+		body  string
+	}{
+		{"Procedure Description", cf.ProcedureDescription},
+		{"Risks", cf.Risks},
+		{"Alternatives", cf.Alternatives},
+		{"Post-Care Instructions", cf.PostCareInstructions},
+	}
+	for _, section := range sections {
+		if strings.TrimSpace(section.body) == "" {
+			continue
+		}
+		doc.Ln(6)
+		doc.SetFont(true, 11)
+		doc.EnsureSpace(40)
+		doc.Text(section.title)
+		doc.SetFont(false, 10)
+		doc.Text(section.body)
+	}
+
+	// Patient agreement checkboxes
+	doc.Ln(10)
+	doc.EnsureSpace(90)
+	doc.SetFont(true, 11)
+	doc.Text("Patient Acknowledgement")
+	doc.Ln(2)
+	doc.SetFont(false, 10)
+	doc.Checkbox(cf.UnderstandsTreatment, "I understand the proposed treatment.")
+	doc.Checkbox(cf.UnderstandsRisks, "I understand the risks and possible complications.")
+	doc.Checkbox(cf.ConsentsToTreatment, "I consent to the treatment described above.")
+	doc.Checkbox(cf.HadOpportunityToAsk, "I had the opportunity to ask questions and they were answered.")
+
+	// Signatures
+	doc.Ln(16)
+	doc.EnsureSpace(130)
+	signatureTop := doc.Y()
+	columnWidth := (doc.ContentWidth() - 30) / 2
+	leftX := doc.Margin
+	rightX := doc.Margin + columnWidth + 30
+
+	witnessName := ""
+	if cf.Witness != nil {
+		witnessName = cf.Witness.GetFullName()
+	}
+
+	cf.writePDFSignature(doc, leftX, columnWidth, "Patient Signature", cf.Patient.GetFullName(), cf.PatientSignature, cf.PatientSignedAt)
+	doc.Ln(signatureTop - doc.Y())
+	cf.writePDFSignature(doc, rightX, columnWidth, "Witness Signature", witnessName, cf.WitnessSignature, cf.WitnessSignedAt)
+
+	// Signing hash, so the printed copy can be checked against the verification endpoint
+	if cf.SigningRecord != nil {
+		doc.Ln(24)
+		doc.SetFont(false, 7)
+		doc.Text(fmt.Sprintf("Signing record #%d  %s: %s", cf.SigningRecord.ID, cf.SigningRecord.HashAlgorithm, cf.SigningRecord.ContentHash))
+	}
+
+	// Write the file
+	now := time.Now()
+	yearMonth := fmt.Sprintf("%d/%02d", now.Year(), now.Month())
+	dir := filepath.Join(storageDir, ConsentFormPDFDir, yearMonth)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("failed to create consent form directory: %v", err)
+	}
+
+	filename := fmt.Sprintf("consent-%d-%d.pdf", cf.ID, now.Unix())
+	if err := doc.WriteFile(filepath.Join(dir, filename)); err != nil {
+		return fmt.Errorf("failed to write consent form PDF: %v", err)
+	}
+
+	// Path relative to the storage directory, with forward slashes
+	cf.PDFPath = strings.ReplaceAll(filepath.Join(ConsentFormPDFDir, yearMonth, filename), "\\", "/")
+	return nil
+}
+
+func (cf *ConsentForm) writePDFHeader(doc *pdf.Document, uploadBaseDir string) {
+	top := doc.Y()
+	textX := doc.Margin
+	headerHeight := 0.0
+
+	if cf.Clinic.Logo != "" {
+		if logo, err := loadImageFile(filepath.Join(uploadBaseDir, cf.Clinic.Logo)); err == nil {
+			height := 48.0
+			width := height * float64(logo.Bounds().Dx()) / float64(logo.Bounds().Dy())
+			if width > 160 {
+				width = 160
+				height = width * float64(logo.Bounds().Dy()) / float64(logo.Bounds().Dx())
+			}
+			if err := doc.Image(logo, doc.Margin, width, height); err == nil {
+				textX += width + 12
+				headerHeight = height
+			}
+		}
+	}
+
+	textWidth := doc.ContentWidth() - (textX - doc.Margin)
+	doc.SetFont(true, 14)
+	doc.Cell(textX, textWidth, cf.Clinic.Name, pdf.AlignLeft)
+	doc.Ln(18)
+
+	doc.SetFont(false, 9)
+	for _, line := range []string{cf.Clinic.Address, strings.Trim(cf.Clinic.Phone+"  "+cf.Clinic.Email, " ")} {
+		if line == "" {
+			continue
+		}
+		doc.Cell(textX, textWidth, line, pdf.AlignLeft)
+		doc.Ln(12)
+	}
+
+	if doc.Y() < top+headerHeight {
+		doc.Ln(top + headerHeight - doc.Y())
+	}
+	doc.Ln(10)
+	doc.HLine()
+	doc.Ln(14)
+}
+
+// writePDFContent writes template text, rendering all-caps lines as section headings
+func (cf *ConsentForm) writePDFContent(doc *pdf.Document, content string) {
+	for _, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if isPDFHeading(trimmed) {
+			doc.Ln(4)
+			doc.EnsureSpace(40)
+			doc.SetFont(true, 11)
+			doc.Text(trimmed)
+			continue
+		}
+
+		doc.SetFont(false, 10)
+		if strings.HasPrefix(trimmed, "- ") {
+			doc.TextIndent(12, "•  "+strings.TrimPrefix(trimmed, "- "))
+			continue
+		}
+		doc.Text(line)
+	}
+}
+
+func (cf *ConsentForm) writePDFSignature(doc *pdf.Document, x, width float64, label, name, signature string, signedAt *time.Time) {
+	imageHeight := 60.0
+
+	doc.SetFont(true, 10)
+	doc.Cell(x, width, label, pdf.AlignLeft)
+	doc.Ln(16)
+
+	if signature != "" {
+		if img, err := decodeSignatureImage(signature); err == nil {
+			imageWidth := imageHeight * float64(img.Bounds().Dx()) / float64(img.Bounds().Dy())
+			if imageWidth > width {
+				imageWidth = width
+			}
+			doc.Image(img, x, imageWidth, imageHeight)
+		}
+	}
+	doc.Ln(imageHeight + 4)
+	doc.Line(x, doc.Y(), x+width, doc.Y())
+	doc.Ln(4)
+
+	doc.SetFont(false, 9)
+	doc.Cell(x, width, name, pdf.AlignLeft)
+	doc.Ln(12)
+	if signedAt != nil {
+		doc.Cell(x, width, "Signed: "+signedAt.Format("January 2, 2006 15:04 MST"), pdf.AlignLeft)
+	} else {
+		doc.Cell(x, width, "Not signed", pdf.AlignLeft)
+	}
+}
+
+func isPDFHeading(line string) bool {
+	if line == "" || len(line) > 80 {
+		return false
+	}
+	hasLetter := false
+	for _, r := range line {
+		if unicode.IsLower(r) {
+			return false
+		}
+		if unicode.IsLetter(r) {
+			hasLetter = true
+		}
+	}
+	return hasLetter
+}
+
+// decodeSignatureImage decodes a base64 signature, with or without a data URL prefix
+func decodeSignatureImage(signature string) (image.Image, error) {
+	if idx := strings.Index(signature, ","); strings.HasPrefix(signature, "data:") && idx >= 0 {
+		signature = signature[idx+1:]
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+func loadImageFile(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	return img, err
+}
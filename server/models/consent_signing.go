@@ -0,0 +1,293 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const ConsentHashAlgorithm = "sha256"
+
+type ConsentSigningMethod string
+
+const (
+	ConsentSignedInPerson   ConsentSigningMethod = "in_person"
+	ConsentSignedRemoteLink ConsentSigningMethod = "remote_link"
+	ConsentSignedKiosk      ConsentSigningMethod = "kiosk"
+)
+
+// Remote signing link lifetimes
+const (
+	DefaultConsentLinkTTL = 48 * time.Hour
+	MaxConsentLinkTTL     = 7 * 24 * time.Hour
+)
+
+// ErrSigningRecordImmutable is returned when something tries to change or remove a signing record
+var ErrSigningRecordImmutable = errors.New("consent signing records are immutable")
+
+// ConsentSigningRecord is the write-once evidence created when a consent form is fully signed.
+// It holds the exact payload that was signed and its hash, so later changes to the form can be detected.
+type ConsentSigningRecord struct {
+	ID            uint         `json:"id" gorm:"primarykey"`
+	ConsentFormID uint         `json:"consent_form_id" gorm:"not null;uniqueIndex"`
+	ConsentForm   *ConsentForm `json:"-" gorm:"foreignKey:ConsentFormID"`
+
+	HashAlgorithm string `json:"hash_algorithm" gorm:"size:20;not null"`
+	ContentHash   string `json:"content_hash" gorm:"size:64;not null;index"`
+	SignedPayload string `json:"-" gorm:"type:longtext;not null"` // canonical JSON that was hashed
+
+	// Signers
+	PatientID       uint      `json:"patient_id" gorm:"not null;index"`
+	PatientSignedAt time.Time `json:"patient_signed_at"`
+	WitnessID       *uint     `json:"witness_id" gorm:"index"`
+	WitnessSignedAt time.Time `json:"witness_signed_at"`
+	DoctorID        *uint     `json:"doctor_id" gorm:"index"`
+
+	// Who completed the signing and from where
+	FinalizedByID *uint  `json:"finalized_by_id" gorm:"index"`
+	FinalizedBy   *User  `json:"finalized_by,omitempty" gorm:"foreignKey:FinalizedByID"`
+	IPAddress     string `json:"ip_address" gorm:"size:45"`
+	UserAgent     string `json:"user_agent" gorm:"size:500"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ConsentSigningLink is a single-use, expiring token that lets a patient review and sign
+// a consent form from their own device without a staff session
+type ConsentSigningLink struct {
+	ID            uint        `json:"id" gorm:"primarykey"`
+	Token         string      `json:"token" gorm:"size:64;uniqueIndex;not null"`
+	ConsentFormID uint        `json:"consent_form_id" gorm:"not null;index"`
+	ConsentForm   ConsentForm `json:"-" gorm:"foreignKey:ConsentFormID"`
+
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	ViewedAt  *time.Time `json:"viewed_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+
+	// Evidence captured when the link is used
+	IPAddress string `json:"ip_address" gorm:"size:45"`
+	UserAgent string `json:"user_agent" gorm:"size:500"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	// Issued by
+	CreatedByID uint `json:"created_by_id" gorm:"not null;index"`
+	CreatedBy   User `json:"-" gorm:"foreignKey:CreatedByID"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (l *ConsentSigningLink) IsExpired() bool {
+	return time.Now().After(l.ExpiresAt)
+}
+
+// IsUsable reports whether the link can still be used to sign
+func (l *ConsentSigningLink) IsUsable() bool {
+	return l.UsedAt == nil && l.RevokedAt == nil && !l.IsExpired()
+}
+
+// BeforeUpdate keeps signing records write-once
+func (r *ConsentSigningRecord) BeforeUpdate(tx *gorm.DB) error {
+	return ErrSigningRecordImmutable
+}
+
+// BeforeDelete keeps signing records write-once
+func (r *ConsentSigningRecord) BeforeDelete(tx *gorm.DB) error {
+	return ErrSigningRecordImmutable
+}
+
+// consentSigningPayload is the canonical set of consent form fields covered by the signing hash.
+// Field order is fixed by the struct, so the JSON encoding is stable.
+type consentSigningPayload struct {
+	ConsentFormID        uint   `json:"consent_form_id"`
+	ClinicID             uint   `json:"clinic_id"`
+	PatientID            uint   `json:"patient_id"`
+	DoctorID             *uint  `json:"doctor_id"`
+	WitnessID            *uint  `json:"witness_id"`
+	Title                string `json:"title"`
+	Content              string `json:"content"`
+	ProcedureDescription string `json:"procedure_description"`
+	Risks                string `json:"risks"`
+	Alternatives         string `json:"alternatives"`
+	PostCareInstructions string `json:"post_care_instructions"`
+	UnderstandsTreatment bool   `json:"understands_treatment"`
+	UnderstandsRisks     bool   `json:"understands_risks"`
+	ConsentsToTreatment  bool   `json:"consents_to_treatment"`
+	HadOpportunityToAsk  bool   `json:"had_opportunity_to_ask"`
+	PatientSignature     string `json:"patient_signature"`
+	PatientSignedAt      string `json:"patient_signed_at"`
+	PatientSignedVia     string `json:"patient_signed_via"`
+	PatientSignedIP      string `json:"patient_signed_ip"`
+	PatientSignedAgent   string `json:"patient_signed_user_agent"`
+	WitnessSignature     string `json:"witness_signature"`
+	WitnessSignedAt      string `json:"witness_signed_at"`
+}
+
+// ConsentVerification reports whether a consent form still matches its signing record
+type ConsentVerification struct {
+	ConsentFormID   uint      `json:"consent_form_id"`
+	Valid           bool      `json:"valid"`
+	HashAlgorithm   string    `json:"hash_algorithm"`
+	StoredHash      string    `json:"stored_hash"`
+	ComputedHash    string    `json:"computed_hash"`
+	ChangedFields   []string  `json:"changed_fields"`
+	SignedAt        time.Time `json:"signed_at"`
+	SigningRecordID uint      `json:"signing_record_id"`
+}
+
+// formatSigningTime normalizes signing timestamps to UTC seconds so they survive a database round trip
+func formatSigningTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Truncate(time.Second).Format(time.RFC3339)
+}
+
+func (cf *ConsentForm) signingPayload() consentSigningPayload {
+	return consentSigningPayload{
+		ConsentFormID:        cf.ID,
+		ClinicID:             cf.ClinicID,
+		PatientID:            cf.PatientID,
+		DoctorID:             cf.DoctorID,
+		WitnessID:            cf.WitnessID,
+		Title:                cf.Title,
+		Content:              cf.Content,
+		ProcedureDescription: cf.ProcedureDescription,
+		Risks:                cf.Risks,
+		Alternatives:         cf.Alternatives,
+		PostCareInstructions: cf.PostCareInstructions,
+		UnderstandsTreatment: cf.UnderstandsTreatment,
+		UnderstandsRisks:     cf.UnderstandsRisks,
+		ConsentsToTreatment:  cf.ConsentsToTreatment,
+		HadOpportunityToAsk:  cf.HadOpportunityToAsk,
+		PatientSignature:     cf.PatientSignature,
+		PatientSignedAt:      formatSigningTime(cf.PatientSignedAt),
+		PatientSignedVia:     string(cf.PatientSignedVia),
+		PatientSignedIP:      cf.PatientSignedIP,
+		PatientSignedAgent:   cf.PatientSignedUserAgent,
+		WitnessSignature:     cf.WitnessSignature,
+		WitnessSignedAt:      formatSigningTime(cf.WitnessSignedAt),
+	}
+}
+
+// SigningHash returns the canonical signing payload and its hex encoded SHA-256 hash
+func (cf *ConsentForm) SigningHash() (string, string, error) {
+	payload, err := json.Marshal(cf.signingPayload())
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256(payload)
+	return string(payload), hex.EncodeToString(sum[:]), nil
+}
+
+// RecordPatientSigningEvidence stores how and from where the patient signature was captured
+func (cf *ConsentForm) RecordPatientSigningEvidence(method ConsentSigningMethod, ipAddress, userAgent string) {
+	cf.PatientSignedVia = method
+	cf.PatientSignedIP = ipAddress
+	cf.PatientSignedUserAgent = truncateUserAgent(userAgent)
+}
+
+// truncateUserAgent shortens a user agent to fit the 500-character columns it is stored in
+func truncateUserAgent(userAgent string) string {
+	runes := []rune(userAgent)
+	if len(runes) > 500 {
+		return string(runes[:500])
+	}
+	return userAgent
+}
+
+// IsLocked reports whether the form has been signed and can no longer be edited
+func (cf *ConsentForm) IsLocked() bool {
+	return cf.Status == DocStatusSigned || cf.Status == DocStatusCompleted || cf.SigningRecord != nil
+}
+
+// NewSigningRecord builds the signing record for a fully signed form
+func (cf *ConsentForm) NewSigningRecord(finalizedByID *uint, ipAddress, userAgent string) (*ConsentSigningRecord, error) {
+	if !cf.IsFullySigned() {
+		return nil, errors.New("consent form must be signed by the patient and witness")
+	}
+
+	payload, hash, err := cf.SigningHash()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConsentSigningRecord{
+		ConsentFormID:   cf.ID,
+		HashAlgorithm:   ConsentHashAlgorithm,
+		ContentHash:     hash,
+		SignedPayload:   payload,
+		PatientID:       cf.PatientID,
+		PatientSignedAt: *cf.PatientSignedAt,
+		WitnessID:       cf.WitnessID,
+		WitnessSignedAt: *cf.WitnessSignedAt,
+		DoctorID:        cf.DoctorID,
+		FinalizedByID:   finalizedByID,
+		IPAddress:       ipAddress,
+		UserAgent:       truncateUserAgent(userAgent),
+		ClinicID:        cf.ClinicID,
+	}, nil
+}
+
+// Verify recomputes the form hash and compares it with the signing record
+func (cf *ConsentForm) Verify(record *ConsentSigningRecord) (*ConsentVerification, error) {
+	_, hash, err := cf.SigningHash()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ConsentVerification{
+		ConsentFormID:   cf.ID,
+		Valid:           hash == record.ContentHash,
+		HashAlgorithm:   record.HashAlgorithm,
+		StoredHash:      record.ContentHash,
+		ComputedHash:    hash,
+		ChangedFields:   []string{},
+		SignedAt:        record.CreatedAt,
+		SigningRecordID: record.ID,
+	}
+
+	if !result.Valid {
+		result.ChangedFields = changedSigningFields(record.SignedPayload, cf.signingPayload())
+	}
+
+	return result, nil
+}
+
+// changedSigningFields lists the payload fields that differ from what was originally signed
+func changedSigningFields(signedPayload string, current consentSigningPayload) []string {
+	var signed, now map[string]interface{}
+	if err := json.Unmarshal([]byte(signedPayload), &signed); err != nil {
+		return []string{"signed_payload"}
+	}
+	currentJSON, _ := json.Marshal(current)
+	json.Unmarshal(currentJSON, &now)
+
+	changed := []string{}
+	for _, key := range signingPayloadFields {
+		a, _ := json.Marshal(signed[key])
+		b, _ := json.Marshal(now[key])
+		if string(a) != string(b) {
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
+var signingPayloadFields = []string{
+	"consent_form_id", "clinic_id", "patient_id", "doctor_id", "witness_id",
+	"title", "content", "procedure_description", "risks", "alternatives", "post_care_instructions",
+	"understands_treatment", "understands_risks", "consents_to_treatment", "had_opportunity_to_ask",
+	"patient_signature", "patient_signed_at", "patient_signed_via", "patient_signed_ip", "patient_signed_user_agent",
+	"witness_signature", "witness_signed_at",
+}
@@ -0,0 +1,239 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ConsentTemplateVersionStatus string
+
+const (
+	ConsentVersionDraft      ConsentTemplateVersionStatus = "draft"
+	ConsentVersionPublished  ConsentTemplateVersionStatus = "published"
+	ConsentVersionSuperseded ConsentTemplateVersionStatus = "superseded"
+)
+
+// ErrConsentVersionImmutable is returned when a published or superseded version is changed
+var ErrConsentVersionImmutable = errors.New("only draft consent template versions can be changed")
+
+// ConsentTemplateVersion is a snapshot of a consent template's wording.
+// Drafts can be edited; once published a version never changes, and forms pin the version they used.
+type ConsentTemplateVersion struct {
+	ID                uint            `json:"id" gorm:"primarykey"`
+	ConsentTemplateID uint            `json:"consent_template_id" gorm:"not null;uniqueIndex:idx_consent_template_version"`
+	ConsentTemplate   ConsentTemplate `json:"-" gorm:"foreignKey:ConsentTemplateID"`
+	Version           int             `json:"version" gorm:"not null;uniqueIndex:idx_consent_template_version"`
+
+	Status ConsentTemplateVersionStatus `json:"status" gorm:"type:varchar(20);default:'draft';index"`
+
+	// Snapshot of the template wording
+	Name        string `json:"name" gorm:"size:200;not null"`
+	Description string `json:"description" gorm:"type:text"`
+	Content     string `json:"content" gorm:"type:text;not null"`
+	Category    string `json:"category" gorm:"size:100"`
+	ChangeNotes string `json:"change_notes" gorm:"type:text"`
+
+	// Publishing
+	PublishedAt   *time.Time `json:"published_at"`
+	PublishedByID *uint      `json:"published_by_id" gorm:"index"`
+	PublishedBy   *User      `json:"published_by,omitempty" gorm:"foreignKey:PublishedByID"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	// Created by (empty for versions created by seeding or migration)
+	CreatedByID *uint `json:"created_by_id" gorm:"index"`
+	CreatedBy   *User `json:"created_by,omitempty" gorm:"foreignKey:CreatedByID"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AfterCreate gives every new template a published first version, whether it was created
+// through the API or by seeding
+func (ct *ConsentTemplate) AfterCreate(tx *gorm.DB) error {
+	_, err := ct.CreateInitialVersion(tx, nil)
+	return err
+}
+
+// CreateInitialVersion publishes the template's current wording as version 1 if it has no versions yet
+func (ct *ConsentTemplate) CreateInitialVersion(tx *gorm.DB, createdByID *uint) (*ConsentTemplateVersion, error) {
+	var count int64
+	if err := tx.Model(&ConsentTemplateVersion{}).Where("consent_template_id = ?", ct.ID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	version := ct.NewVersion(1, createdByID)
+	version.Status = ConsentVersionPublished
+	version.PublishedAt = &now
+	version.PublishedByID = createdByID
+	version.ChangeNotes = "Initial version"
+
+	if err := tx.Create(version).Error; err != nil {
+		return nil, err
+	}
+
+	ct.PublishedVersionID = &version.ID
+	ct.PublishedVersion = version.Version
+	return version, tx.Model(&ConsentTemplate{}).Where("id = ?", ct.ID).
+		Updates(map[string]interface{}{"published_version_id": version.ID, "published_version": version.Version}).Error
+}
+
+// NewVersion builds a draft version from the template's current fields
+func (ct *ConsentTemplate) NewVersion(number int, createdByID *uint) *ConsentTemplateVersion {
+	return &ConsentTemplateVersion{
+		ConsentTemplateID: ct.ID,
+		Version:           number,
+		Status:            ConsentVersionDraft,
+		Name:              ct.Name,
+		Description:       ct.Description,
+		Content:           ct.Content,
+		Category:          ct.Category,
+		ClinicID:          ct.ClinicID,
+		CreatedByID:       createdByID,
+	}
+}
+
+// NextConsentTemplateVersion returns the next version number for a template
+func NextConsentTemplateVersion(tx *gorm.DB, templateID uint) (int, error) {
+	var max int
+	err := tx.Model(&ConsentTemplateVersion{}).Where("consent_template_id = ?", templateID).
+		Select("COALESCE(MAX(version), 0)").Scan(&max).Error
+	return max + 1, err
+}
+
+func (v *ConsentTemplateVersion) IsDraft() bool {
+	return v.Status == ConsentVersionDraft
+}
+
+// Publish makes the version the template's current wording, superseding the previous published version.
+// tx should be a transaction.
+func (v *ConsentTemplateVersion) Publish(tx *gorm.DB, template *ConsentTemplate, publishedByID *uint) error {
+	if !v.IsDraft() {
+		return ErrConsentVersionImmutable
+	}
+
+	if err := tx.Model(&ConsentTemplateVersion{}).
+		Where("consent_template_id = ? AND status = ?", template.ID, ConsentVersionPublished).
+		Update("status", ConsentVersionSuperseded).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	v.Status = ConsentVersionPublished
+	v.PublishedAt = &now
+	v.PublishedByID = publishedByID
+	if err := tx.Omit(clause.Associations).Save(v).Error; err != nil {
+		return err
+	}
+
+	// The template row mirrors the published wording so existing readers keep working
+	template.Name = v.Name
+	template.Description = v.Description
+	template.Content = v.Content
+	template.Category = v.Category
+	template.PublishedVersionID = &v.ID
+	template.PublishedVersion = v.Version
+	return tx.Model(&ConsentTemplate{}).Where("id = ?", template.ID).Updates(map[string]interface{}{
+		"name":                 v.Name,
+		"description":          v.Description,
+		"content":              v.Content,
+		"category":             v.Category,
+		"published_version_id": v.ID,
+		"published_version":    v.Version,
+	}).Error
+}
+
+// ConsentDiffLine is one line of a version diff
+type ConsentDiffLine struct {
+	Op   string `json:"op"` // equal, added, removed
+	Text string `json:"text"`
+}
+
+// ConsentVersionDiff compares two template versions
+type ConsentVersionDiff struct {
+	From         int               `json:"from"`
+	To           int               `json:"to"`
+	NameChanged  bool              `json:"name_changed"`
+	FromName     string            `json:"from_name"`
+	ToName       string            `json:"to_name"`
+	Lines        []ConsentDiffLine `json:"lines"`
+	AddedLines   int               `json:"added_lines"`
+	RemovedLines int               `json:"removed_lines"`
+}
+
+// DiffConsentTemplateVersions returns a line diff of the content of two versions
+func DiffConsentTemplateVersions(from, to *ConsentTemplateVersion) *ConsentVersionDiff {
+	diff := &ConsentVersionDiff{
+		From:        from.Version,
+		To:          to.Version,
+		NameChanged: from.Name != to.Name,
+		FromName:    from.Name,
+		ToName:      to.Name,
+		Lines:       diffLines(splitLines(from.Content), splitLines(to.Content)),
+	}
+	for _, line := range diff.Lines {
+		switch line.Op {
+		case "added":
+			diff.AddedLines++
+		case "removed":
+			diff.RemovedLines++
+		}
+	}
+	return diff
+}
+
+func splitLines(content string) []string {
+	return strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+}
+
+// diffLines computes a line diff using the longest common subsequence
+func diffLines(a, b []string) []ConsentDiffLine {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := []ConsentDiffLine{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, ConsentDiffLine{Op: "equal", Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, ConsentDiffLine{Op: "removed", Text: a[i]})
+			i++
+		default:
+			lines = append(lines, ConsentDiffLine{Op: "added", Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, ConsentDiffLine{Op: "removed", Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, ConsentDiffLine{Op: "added", Text: b[j]})
+	}
+	return lines
+}
@@ -0,0 +1,228 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DoctorSchedule is a weekly working period for a doctor at a branch, e.g. Mondays 09:00-12:00 at
+// the main branch. A doctor's rows across branches are also their branch assignments.
+// Doctors with no schedule rows are treated as working whenever their clinic's branches are open.
+type DoctorSchedule struct {
+	ID        uint   `json:"id" gorm:"primarykey"`
+	DoctorID  uint   `json:"doctor_id" gorm:"not null;index"`
+	Doctor    User   `json:"doctor,omitempty" gorm:"foreignKey:DoctorID"`
+	BranchID  uint   `json:"branch_id" gorm:"not null;index"`
+	Branch    Branch `json:"branch,omitempty" gorm:"foreignKey:BranchID"`
+	DayOfWeek int    `json:"day_of_week" gorm:"not null"`       // 0 = Sunday ... 6 = Saturday
+	StartTime string `json:"start_time" gorm:"size:5;not null"` // HH:MM
+	EndTime   string `json:"end_time" gorm:"size:5;not null"`   // HH:MM
+
+	// Optional validity window, e.g. for a temporary rotation
+	EffectiveFrom  *time.Time `json:"effective_from" gorm:"type:date"`
+	EffectiveUntil *time.Time `json:"effective_until" gorm:"type:date"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+type DoctorTimeOffType string
+
+const (
+	TimeOffLeave      DoctorTimeOffType = "leave"
+	TimeOffSick       DoctorTimeOffType = "sick"
+	TimeOffConference DoctorTimeOffType = "conference"
+	TimeOffPersonal   DoctorTimeOffType = "personal"
+	TimeOffOther      DoctorTimeOffType = "other"
+)
+
+// DoctorTimeOff blocks a doctor's availability between two instants, e.g. a week of leave or an afternoon off
+type DoctorTimeOff struct {
+	ID        uint              `json:"id" gorm:"primarykey"`
+	DoctorID  uint              `json:"doctor_id" gorm:"not null;index"`
+	Doctor    User              `json:"doctor,omitempty" gorm:"foreignKey:DoctorID"`
+	StartTime time.Time         `json:"start_time" gorm:"not null;index"`
+	EndTime   time.Time         `json:"end_time" gorm:"not null;index"`
+	Type      DoctorTimeOffType `json:"type" gorm:"type:varchar(20);default:'leave'"`
+	Reason    string            `json:"reason" gorm:"size:500"`
+
+	CreatedByID uint `json:"created_by_id"`
+	CreatedBy   User `json:"created_by,omitempty" gorm:"foreignKey:CreatedByID"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// ClinicHoliday closes a whole clinic, or a single branch when BranchID is set, for a day
+type ClinicHoliday struct {
+	ID       uint      `json:"id" gorm:"primarykey"`
+	Date     time.Time `json:"date" gorm:"type:date;not null;index"`
+	Name     string    `json:"name" gorm:"size:200;not null"`
+	BranchID *uint     `json:"branch_id" gorm:"index"`
+	Branch   *Branch   `json:"branch,omitempty" gorm:"foreignKey:BranchID"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// ParseClock parses an "HH:MM" time of day into minutes after midnight
+func ParseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Validate checks the day and times of a schedule row
+func (s *DoctorSchedule) Validate() error {
+	if s.DayOfWeek < 0 || s.DayOfWeek > 6 {
+		return fmt.Errorf("day_of_week must be between 0 (Sunday) and 6 (Saturday)")
+	}
+	start, err := ParseClock(s.StartTime)
+	if err != nil {
+		return err
+	}
+	end, err := ParseClock(s.EndTime)
+	if err != nil {
+		return err
+	}
+	if end <= start {
+		return fmt.Errorf("end_time must be after start_time")
+	}
+	if s.EffectiveFrom != nil && s.EffectiveUntil != nil && s.EffectiveUntil.Before(*s.EffectiveFrom) {
+		return fmt.Errorf("effective_until must not be before effective_from")
+	}
+	return nil
+}
+
+// AppliesOn reports whether the row is a working period on the given date
+func (s *DoctorSchedule) AppliesOn(date time.Time) bool {
+	if int(date.Weekday()) != s.DayOfWeek {
+		return false
+	}
+	day := date.Format("2006-01-02")
+	if s.EffectiveFrom != nil && day < s.EffectiveFrom.Format("2006-01-02") {
+		return false
+	}
+	if s.EffectiveUntil != nil && day > s.EffectiveUntil.Format("2006-01-02") {
+		return false
+	}
+	return true
+}
+
+// Range returns the row's working period on the given date
+func (s *DoctorSchedule) Range(date time.Time) (TimeRange, bool) {
+	start, err := ParseClock(s.StartTime)
+	if err != nil {
+		return TimeRange{}, false
+	}
+	end, err := ParseClock(s.EndTime)
+	if err != nil {
+		return TimeRange{}, false
+	}
+	return ClockRange(date, start, end), true
+}
+
+// TimeRange is a half-open interval [Start, End)
+type TimeRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// ClockRange builds the range between two times of day (minutes after midnight) on the date's calendar day
+func ClockRange(date time.Time, startMinutes, endMinutes int) TimeRange {
+	midnight := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	return TimeRange{
+		Start: midnight.Add(time.Duration(startMinutes) * time.Minute),
+		End:   midnight.Add(time.Duration(endMinutes) * time.Minute),
+	}
+}
+
+func (r TimeRange) Overlaps(other TimeRange) bool {
+	return r.Start.Before(other.End) && other.Start.Before(r.End)
+}
+
+func (r TimeRange) Contains(other TimeRange) bool {
+	return !other.Start.Before(r.Start) && !other.End.After(r.End)
+}
+
+// NormalizeRanges sorts ranges and merges any that overlap or touch
+func NormalizeRanges(ranges []TimeRange) []TimeRange {
+	valid := make([]TimeRange, 0, len(ranges))
+	for _, r := range ranges {
+		if r.End.After(r.Start) {
+			valid = append(valid, r)
+		}
+	}
+	sort.Slice(valid, func(i, j int) bool { return valid[i].Start.Before(valid[j].Start) })
+
+	merged := []TimeRange{}
+	for _, r := range valid {
+		if n := len(merged); n > 0 && !r.Start.After(merged[n-1].End) {
+			if r.End.After(merged[n-1].End) {
+				merged[n-1].End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// IntersectRanges returns the parts of a that are also in b
+func IntersectRanges(a, b []TimeRange) []TimeRange {
+	result := []TimeRange{}
+	for _, x := range NormalizeRanges(a) {
+		for _, y := range NormalizeRanges(b) {
+			start, end := x.Start, x.End
+			if y.Start.After(start) {
+				start = y.Start
+			}
+			if y.End.Before(end) {
+				end = y.End
+			}
+			if end.After(start) {
+				result = append(result, TimeRange{Start: start, End: end})
+			}
+		}
+	}
+	return NormalizeRanges(result)
+}
+
+// SubtractRanges returns the parts of free not covered by any of busy
+func SubtractRanges(free, busy []TimeRange) []TimeRange {
+	result := NormalizeRanges(free)
+	for _, b := range NormalizeRanges(busy) {
+		next := []TimeRange{}
+		for _, r := range result {
+			if !r.Overlaps(b) {
+				next = append(next, r)
+				continue
+			}
+			if r.Start.Before(b.Start) {
+				next = append(next, TimeRange{Start: r.Start, End: b.Start})
+			}
+			if r.End.After(b.End) {
+				next = append(next, TimeRange{Start: b.End, End: r.End})
+			}
+		}
+		result = next
+	}
+	return result
+}
@@ -38,6 +38,10 @@ type ConsentTemplate struct {
 	IsActive  bool   `json:"is_active" gorm:"default:true"`
 	IsDefault bool   `json:"is_default" gorm:"default:false"`
 
+	// Currently published wording, mirrored into the fields above
+	PublishedVersionID *uint `json:"published_version_id"`
+	PublishedVersion   int   `json:"published_version" gorm:"default:0"`
+
 	// Clinic scoping for multi-tenancy
 	ClinicID uint   `json:"clinic_id" gorm:"not null;uniqueIndex:idx_consent_templates_code_clinic"`
 	Clinic   Clinic `json:"clinic" gorm:"foreignKey:ClinicID"`
@@ -57,6 +61,10 @@ type ConsentForm struct {
 	ConsentTemplateID *uint            `json:"consent_template_id" gorm:"index"`
 	ConsentTemplate   *ConsentTemplate `json:"consent_template,omitempty" gorm:"foreignKey:ConsentTemplateID"`
 
+	// Exact template wording the form was generated from
+	ConsentTemplateVersionID *uint                   `json:"consent_template_version_id" gorm:"index"`
+	ConsentTemplateVersion   *ConsentTemplateVersion `json:"consent_template_version,omitempty" gorm:"foreignKey:ConsentTemplateVersionID"`
+
 	// Patient and appointment details
 	PatientID     uint         `json:"patient_id" gorm:"not null;index"`
 	Patient       Patient      `json:"patient" gorm:"foreignKey:PatientID"`
@@ -87,6 +95,14 @@ type ConsentForm struct {
 	WitnessID        *uint      `json:"witness_id" gorm:"index"`
 	Witness          *User      `json:"witness,omitempty" gorm:"foreignKey:WitnessID"`
 
+	// Patient signing evidence
+	PatientSignedVia       ConsentSigningMethod `json:"patient_signed_via" gorm:"type:varchar(20)"`
+	PatientSignedIP        string               `json:"patient_signed_ip" gorm:"size:45"`
+	PatientSignedUserAgent string               `json:"patient_signed_user_agent" gorm:"size:500"`
+
+	// Tamper-evidence record, created once both parties have signed
+	SigningRecord *ConsentSigningRecord `json:"signing_record,omitempty" gorm:"foreignKey:ConsentFormID"`
+
 	// Generated PDF
 	PDFPath string `json:"pdf_path" gorm:"size:500"`
 
@@ -120,8 +136,3 @@ func (cf *ConsentForm) IsPatientAgreementComplete() bool {
 	return cf.UnderstandsTreatment && cf.UnderstandsRisks &&
 		cf.ConsentsToTreatment && cf.HadOpportunityToAsk
 }
-
-func (cf *ConsentForm) GeneratePDF() error {
-	// TODO: Implement PDF generation logic
-	return nil
-}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Where a procedure's price came from
+const (
+	FeeSourceSchedule = "fee_schedule"
+	FeeSourceDefault  = "default" // the procedure template's DefaultCost
+)
+
+// FeeSchedule is a clinic's price list. It can be narrowed to one branch and/or one insurance
+// payer; a clinic has at most one active schedule for each such scope.
+type FeeSchedule struct {
+	ID       uint            `json:"id" gorm:"primarykey"`
+	Name     string          `json:"name" gorm:"size:200;not null"`
+	BranchID *uint           `json:"branch_id" gorm:"index"` // nil for every branch
+	Branch   *Branch         `json:"branch,omitempty" gorm:"foreignKey:BranchID"`
+	PayerID  *uint           `json:"payer_id" gorm:"index"` // nil for patients paying themselves or payers without their own prices
+	Payer    *InsurancePayer `json:"payer,omitempty" gorm:"foreignKey:PayerID"`
+	Notes    string          `json:"notes" gorm:"type:text"`
+	IsActive bool            `json:"is_active" gorm:"default:true"`
+
+	Items []FeeScheduleItem `json:"items,omitempty" gorm:"foreignKey:FeeScheduleID"`
+
+	CreatedByID uint `json:"created_by_id"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// FeeScheduleItem is a procedure's price in a schedule from a date on. A price change is a new
+// item with a later EffectiveFrom, so past prices stay on record.
+type FeeScheduleItem struct {
+	ID                  uint              `json:"id" gorm:"primarykey"`
+	FeeScheduleID       uint              `json:"fee_schedule_id" gorm:"not null;uniqueIndex:idx_fee_item_effective"`
+	ProcedureTemplateID uint              `json:"procedure_template_id" gorm:"not null;uniqueIndex:idx_fee_item_effective;index"`
+	ProcedureTemplate   ProcedureTemplate `json:"procedure_template,omitempty" gorm:"foreignKey:ProcedureTemplateID"`
+	Price               float64           `json:"price" gorm:"type:decimal(10,2);not null"`
+	EffectiveFrom       time.Time         `json:"effective_from" gorm:"type:date;not null;uniqueIndex:idx_fee_item_effective"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FeeScope is what a price is looked up for: the clinic, and optionally the branch treating the
+// patient and the payer billed
+type FeeScope struct {
+	ClinicID uint
+	BranchID *uint
+	PayerID  *uint
+}
+
+// Location is the timezone prices in the scope are dated in: the branch's, or the clinic's when
+// the scope covers every branch
+func (s FeeScope) Location(db *gorm.DB) *time.Location {
+	if s.BranchID != nil {
+		return BranchLocation(db, *s.BranchID)
+	}
+	return ClinicLocation(db, s.ClinicID)
+}
+
+// Today is the current time in the scope's timezone, so price changes start at local midnight
+func (s FeeScope) Today(db *gorm.DB) time.Time {
+	return time.Now().In(s.Location(db))
+}
+
+// ProcedureFee is the price that applies to a procedure and where it came from
+type ProcedureFee struct {
+	ProcedureTemplateID uint       `json:"procedure_template_id"`
+	Price               float64    `json:"price"`
+	Source              string     `json:"source"`
+	FeeScheduleID       *uint      `json:"fee_schedule_id,omitempty"`
+	FeeScheduleItemID   *uint      `json:"fee_schedule_item_id,omitempty"`
+	EffectiveFrom       *time.Time `json:"effective_from,omitempty"`
+}
+
+// RepointClinicFeeItems moves a clinic's fee schedule prices from one procedure template to
+// another. A clinic's first change to a platform template creates its own copy, and the prices it
+// set on the platform template must follow that copy.
+func RepointClinicFeeItems(db *gorm.DB, clinicID, fromTemplateID, toTemplateID uint) error {
+	return db.Model(&FeeScheduleItem{}).
+		Where("procedure_template_id = ?", fromTemplateID).
+		Where("fee_schedule_id IN (?)", db.Model(&FeeSchedule{}).Select("id").Where("clinic_id = ?", clinicID)).
+		Update("procedure_template_id", toTemplateID).Error
+}
+
+// ResolveProcedureFee finds a procedure's price on a date. The most specific active schedule with
+// a price for it wins: payer over no payer, then branch over clinic-wide. Within a schedule the
+// latest price effective on the date applies. Without any, the template's DefaultCost is used.
+func ResolveProcedureFee(db *gorm.DB, scope FeeScope, template ProcedureTemplate, on time.Time) ProcedureFee {
+	query := db.Table("fee_schedule_items").
+		Select("fee_schedule_items.id, fee_schedule_items.fee_schedule_id, fee_schedule_items.price, fee_schedule_items.effective_from").
+		Joins("JOIN fee_schedules ON fee_schedules.id = fee_schedule_items.fee_schedule_id AND fee_schedules.deleted_at IS NULL").
+		Where("fee_schedules.clinic_id = ? AND fee_schedules.is_active = ?", scope.ClinicID, true).
+		Where("fee_schedule_items.procedure_template_id = ? AND fee_schedule_items.effective_from <= ?", template.ID, on.Format("2006-01-02"))
+
+	if scope.BranchID != nil {
+		query = query.Where("fee_schedules.branch_id IS NULL OR fee_schedules.branch_id = ?", *scope.BranchID)
+	} else {
+		query = query.Where("fee_schedules.branch_id IS NULL")
+	}
+	if scope.PayerID != nil {
+		query = query.Where("fee_schedules.payer_id IS NULL OR fee_schedules.payer_id = ?", *scope.PayerID)
+	} else {
+		query = query.Where("fee_schedules.payer_id IS NULL")
+	}
+
+	var row struct {
+		ID            uint
+		FeeScheduleID uint
+		Price         float64
+		EffectiveFrom time.Time
+	}
+	err := query.Order("fee_schedules.payer_id IS NULL ASC, fee_schedules.branch_id IS NULL ASC, fee_schedule_items.effective_from DESC").
+		Limit(1).Scan(&row).Error
+	if err != nil || row.ID == 0 {
+		return ProcedureFee{ProcedureTemplateID: template.ID, Price: template.DefaultCost, Source: FeeSourceDefault}
+	}
+
+	return ProcedureFee{
+		ProcedureTemplateID: template.ID,
+		Price:               row.Price,
+		Source:              FeeSourceSchedule,
+		FeeScheduleID:       &row.FeeScheduleID,
+		FeeScheduleItemID:   &row.ID,
+		EffectiveFrom:       &row.EffectiveFrom,
+	}
+}
@@ -0,0 +1,321 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PolicyRelationship string
+
+const (
+	RelationshipSelf   PolicyRelationship = "self"
+	RelationshipSpouse PolicyRelationship = "spouse"
+	RelationshipChild  PolicyRelationship = "child"
+	RelationshipOther  PolicyRelationship = "other"
+)
+
+type ClaimStatus string
+
+const (
+	ClaimStatusDraft         ClaimStatus = "draft"
+	ClaimStatusSubmitted     ClaimStatus = "submitted"
+	ClaimStatusApproved      ClaimStatus = "approved"
+	ClaimStatusPartiallyPaid ClaimStatus = "partially_paid"
+	ClaimStatusPaid          ClaimStatus = "paid"
+	ClaimStatusDenied        ClaimStatus = "denied"
+)
+
+// claimTransitions lists where a claim can go from each status. Payments move approved claims to
+// partially paid and paid; a denied claim can be corrected and submitted again.
+var claimTransitions = map[ClaimStatus][]ClaimStatus{
+	ClaimStatusDraft:         {ClaimStatusSubmitted},
+	ClaimStatusSubmitted:     {ClaimStatusApproved, ClaimStatusDenied},
+	ClaimStatusApproved:      {ClaimStatusPartiallyPaid, ClaimStatusPaid},
+	ClaimStatusPartiallyPaid: {ClaimStatusPaid},
+	ClaimStatusPaid:          {},
+	ClaimStatusDenied:        {ClaimStatusSubmitted},
+}
+
+// BenefitUsingClaimStatuses are the statuses of claims that count against a policy's annual maximum
+var BenefitUsingClaimStatuses = []ClaimStatus{ClaimStatusApproved, ClaimStatusPartiallyPaid, ClaimStatusPaid}
+
+// InsurancePayer is an insurance company or HMO the clinic bills
+type InsurancePayer struct {
+	ID        uint   `json:"id" gorm:"primarykey"`
+	Name      string `json:"name" gorm:"size:200;not null"`
+	PayerCode string `json:"payer_code" gorm:"size:50"` // the payer's own ID for the clinic or electronic claims
+	Phone     string `json:"phone" gorm:"size:50"`
+	Email     string `json:"email" gorm:"size:100"`
+	Address   string `json:"address" gorm:"size:500"`
+	Notes     string `json:"notes" gorm:"type:text"` // e.g. how the payer wants claims sent
+	IsActive  bool   `json:"is_active" gorm:"default:true"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// InsurancePolicy is a patient's cover with a payer. The subscriber is the policy holder, who is
+// not the patient when the patient is covered as a dependant.
+type InsurancePolicy struct {
+	ID        uint           `json:"id" gorm:"primarykey"`
+	PatientID uint           `json:"patient_id" gorm:"not null;index"`
+	Patient   *Patient       `json:"patient,omitempty" gorm:"foreignKey:PatientID"`
+	PayerID   uint           `json:"payer_id" gorm:"not null;index"`
+	Payer     InsurancePayer `json:"payer" gorm:"foreignKey:PayerID"`
+
+	PolicyNumber string `json:"policy_number" gorm:"size:100;not null"`
+	GroupNumber  string `json:"group_number" gorm:"size:100"`
+	IsPrimary    bool   `json:"is_primary" gorm:"default:true"`
+
+	// Policy holder
+	SubscriberName        string             `json:"subscriber_name" gorm:"size:200"`
+	SubscriberDateOfBirth *time.Time         `json:"subscriber_date_of_birth"`
+	Relationship          PolicyRelationship `json:"relationship" gorm:"type:varchar(20);default:'self'"`
+
+	EffectiveDate *time.Time `json:"effective_date"`
+	ExpiryDate    *time.Time `json:"expiry_date"`
+	AnnualMaximum float64    `json:"annual_maximum" gorm:"type:decimal(10,2);default:0"` // 0 means no limit
+	IsActive      bool       `json:"is_active" gorm:"default:true"`
+
+	// Share of each procedure category the payer covers
+	Coverages []InsuranceCoverage `json:"coverages" gorm:"foreignKey:PolicyID"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedByID uint `json:"created_by_id"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// InsuranceCoverage is the percentage of a procedure category a policy covers. An empty category
+// applies to procedures in categories the policy does not list.
+type InsuranceCoverage struct {
+	ID         uint    `json:"id" gorm:"primarykey"`
+	PolicyID   uint    `json:"policy_id" gorm:"not null;uniqueIndex:idx_policy_category"`
+	Category   string  `json:"category" gorm:"size:100;uniqueIndex:idx_policy_category"` // matches ProcedureTemplate.Category
+	Percentage float64 `json:"percentage" gorm:"type:decimal(5,2);not null"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// InsuranceClaim asks a payer to pay its share of an invoice
+type InsuranceClaim struct {
+	ID          uint        `json:"id" gorm:"primarykey"`
+	ClaimNumber string      `json:"claim_number" gorm:"size:50;uniqueIndex"`
+	Status      ClaimStatus `json:"status" gorm:"type:varchar(20);default:'draft';index"`
+
+	InvoiceID uint             `json:"invoice_id" gorm:"not null;index"`
+	Invoice   *Invoice         `json:"invoice,omitempty" gorm:"foreignKey:InvoiceID"`
+	PolicyID  uint             `json:"policy_id" gorm:"not null;index"`
+	Policy    *InsurancePolicy `json:"policy,omitempty" gorm:"foreignKey:PolicyID"`
+	PayerID   uint             `json:"payer_id" gorm:"not null;index"`
+	Payer     *InsurancePayer  `json:"payer,omitempty" gorm:"foreignKey:PayerID"`
+	PatientID uint             `json:"patient_id" gorm:"not null;index"`
+	Patient   *Patient         `json:"patient,omitempty" gorm:"foreignKey:PatientID"`
+
+	Lines []InsuranceClaimLine `json:"lines" gorm:"foreignKey:ClaimID"`
+
+	// Amounts
+	TotalCharge    float64 `json:"total_charge" gorm:"type:decimal(10,2)"`
+	ClaimedAmount  float64 `json:"claimed_amount" gorm:"type:decimal(10,2)"`
+	ApprovedAmount float64 `json:"approved_amount" gorm:"type:decimal(10,2);default:0"`
+	PaidAmount     float64 `json:"paid_amount" gorm:"type:decimal(10,2);default:0"`
+
+	// Progress
+	ServiceDate    time.Time  `json:"service_date" gorm:"not null"`
+	SubmittedAt    *time.Time `json:"submitted_at" gorm:"index"`
+	DecidedAt      *time.Time `json:"decided_at"`
+	PaidAt         *time.Time `json:"paid_at"`
+	PayerReference string     `json:"payer_reference" gorm:"size:100"` // the payer's claim or approval number
+	DenialReason   string     `json:"denial_reason" gorm:"type:text"`
+	Notes          string     `json:"notes" gorm:"type:text"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedByID uint `json:"created_by_id" gorm:"not null;index"`
+	CreatedBy   User `json:"created_by" gorm:"foreignKey:CreatedByID"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// InsuranceClaimLine is one billed procedure on a claim
+type InsuranceClaimLine struct {
+	ID            uint   `json:"id" gorm:"primarykey"`
+	ClaimID       uint   `json:"claim_id" gorm:"not null;index"`
+	InvoiceLineID *uint  `json:"invoice_line_id" gorm:"index"`
+	ProcedureCode string `json:"procedure_code" gorm:"size:20"`
+	Description   string `json:"description" gorm:"size:500;not null"`
+	ToothNumber   string `json:"tooth_number" gorm:"size:10"`
+	Category      string `json:"category" gorm:"size:100"`
+	Quantity      int    `json:"quantity" gorm:"default:1"`
+
+	Charge          float64 `json:"charge" gorm:"type:decimal(10,2)"`
+	CoveragePercent float64 `json:"coverage_percent" gorm:"type:decimal(5,2)"`
+	ClaimedAmount   float64 `json:"claimed_amount" gorm:"type:decimal(10,2)"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CoverageEstimate is the insurer's and the patient's share of one charge
+type CoverageEstimate struct {
+	Category        string  `json:"category"`
+	Charge          float64 `json:"charge"`
+	CoveragePercent float64 `json:"coverage_percent"`
+	InsurerAmount   float64 `json:"insurer_amount"`
+	PatientAmount   float64 `json:"patient_amount"`
+}
+
+func IsValidPolicyRelationship(relationship PolicyRelationship) bool {
+	switch relationship {
+	case RelationshipSelf, RelationshipSpouse, RelationshipChild, RelationshipOther:
+		return true
+	}
+	return false
+}
+
+// IsActiveOn reports whether the policy covers treatment on the date
+func (p *InsurancePolicy) IsActiveOn(date time.Time) bool {
+	if !p.IsActive {
+		return false
+	}
+	if p.EffectiveDate != nil && date.Before(*p.EffectiveDate) {
+		return false
+	}
+	if p.ExpiryDate != nil && date.After(p.ExpiryDate.Add(24*time.Hour)) {
+		return false
+	}
+	return true
+}
+
+// CoveragePercent is the share of a procedure category the policy covers, falling back to the
+// policy's catch-all coverage
+func (p *InsurancePolicy) CoveragePercent(category string) float64 {
+	fallback := 0.0
+	for _, coverage := range p.Coverages {
+		if coverage.Category == "" {
+			fallback = coverage.Percentage
+		} else if strings.EqualFold(coverage.Category, strings.TrimSpace(category)) {
+			return coverage.Percentage
+		}
+	}
+	return fallback
+}
+
+// EstimateCoverage splits each charge between insurer and patient. With an annual maximum, the
+// insurer's share stops once the benefit left after usedBenefit runs out, charges in order.
+func (p *InsurancePolicy) EstimateCoverage(categories []string, charges []float64, usedBenefit float64) []CoverageEstimate {
+	remaining := -1.0
+	if p.AnnualMaximum > 0 {
+		remaining = p.AnnualMaximum - usedBenefit
+		if remaining < 0 {
+			remaining = 0
+		}
+	}
+
+	estimates := make([]CoverageEstimate, 0, len(charges))
+	for i, charge := range charges {
+		percent := p.CoveragePercent(categories[i])
+		insurer := roundMoney(charge * percent / 100)
+		if remaining >= 0 {
+			if insurer > remaining {
+				insurer = roundMoney(remaining)
+			}
+			remaining -= insurer
+		}
+		estimates = append(estimates, CoverageEstimate{
+			Category:        categories[i],
+			Charge:          roundMoney(charge),
+			CoveragePercent: percent,
+			InsurerAmount:   insurer,
+			PatientAmount:   roundMoney(charge - insurer),
+		})
+	}
+	return estimates
+}
+
+// PolicyBenefitUsed is how much of the policy's benefit approved claims have used in the year
+func PolicyBenefitUsed(db *gorm.DB, policyID uint, year int) float64 {
+	start := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	var used float64
+	db.Model(&InsuranceClaim{}).
+		Where("policy_id = ? AND status IN ? AND service_date >= ? AND service_date < ?", policyID, BenefitUsingClaimStatuses, start, start.AddDate(1, 0, 0)).
+		Select("COALESCE(SUM(approved_amount), 0)").Scan(&used)
+	return used
+}
+
+// GenerateClaimNumber takes the next claim number for a clinic; numbering restarts every year. It
+// locks the clinic's claim sequence until tx ends, so the claim must be created in tx.
+func GenerateClaimNumber(clinicID uint, tx *gorm.DB) (string, error) {
+	year := time.Now().Year()
+	prefix := fmt.Sprintf("CLM-%d-%d-", clinicID, year)
+	next, err := NextSequenceValue(tx, clinicID, "insurance_claim", year, func() (int64, error) {
+		// Carry on from the highest number already used this year
+		var last int64
+		err := tx.Unscoped().Model(&InsuranceClaim{}).
+			Where("clinic_id = ? AND claim_number LIKE ?", clinicID, prefix+"%").
+			Select("COALESCE(MAX(CAST(SUBSTRING(claim_number, ?) AS UNSIGNED)), 0)", len(prefix)+1).
+			Scan(&last).Error
+		return last, err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Format: CLM-{clinic}-{year}-{000000}
+	return fmt.Sprintf("%s%06d", prefix, next), nil
+}
+
+func IsValidClaimStatus(status ClaimStatus) bool {
+	_, ok := claimTransitions[status]
+	return ok
+}
+
+// ValidateTransition checks that the claim can move to the status
+func (c *InsuranceClaim) ValidateTransition(status ClaimStatus) error {
+	if !IsValidClaimStatus(status) {
+		return fmt.Errorf("invalid claim status %q", status)
+	}
+	allowed := claimTransitions[c.Status]
+	names := make([]string, 0, len(allowed))
+	for _, next := range allowed {
+		if next == status {
+			return nil
+		}
+		names = append(names, string(next))
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("%s claims cannot change status", strings.ReplaceAll(string(c.Status), "_", " "))
+	}
+	return fmt.Errorf("cannot move a claim from %s to %s; allowed: %s", c.Status, status, strings.Join(names, ", "))
+}
+
+// OutstandingAmount is the approved amount the payer has yet to pay
+func (c *InsuranceClaim) OutstandingAmount() float64 {
+	return roundMoney(c.ApprovedAmount - c.PaidAmount)
+}
+
+// ApplyPayment records money received from the payer and moves the claim to partially paid or paid
+func (c *InsuranceClaim) ApplyPayment(amount float64, paidAt time.Time) {
+	c.PaidAmount = roundMoney(c.PaidAmount + amount)
+	if c.OutstandingAmount() > 0 {
+		c.Status = ClaimStatusPartiallyPaid
+		return
+	}
+	c.Status = ClaimStatusPaid
+	c.PaidAt = &paidAt
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// KioskSessionTTL is how long a patient has to finish checking in once identified at a kiosk
+const KioskSessionTTL = 15 * time.Minute
+
+// Booking codes are random, from an alphabet without easily confused characters (0/O, 1/I/L)
+const (
+	bookingCodeLength   = 8
+	bookingCodeAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+)
+
+// Failed identifications allowed within KioskIdentifyWindow before a kiosk device, or a phone
+// number or booking code, is locked out until the window has passed
+const (
+	KioskIdentifyWindow        = 15 * time.Minute
+	KioskDeviceMaxFailures     = 20
+	KioskIdentifierMaxFailures = 5
+)
+
+// KioskDevice is a self check-in tablet at a branch. Its token is the device's only credential
+// and only works for the kiosk endpoints of that branch.
+type KioskDevice struct {
+	ID       uint   `json:"id" gorm:"primarykey"`
+	Token    string `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	Name     string `json:"name" gorm:"size:100"` // e.g. "Reception tablet"
+	BranchID uint   `json:"branch_id" gorm:"not null;index"`
+	Branch   Branch `json:"branch,omitempty" gorm:"foreignKey:BranchID"`
+	IsActive bool   `json:"is_active" gorm:"default:true"`
+
+	LastSeenAt  *time.Time `json:"last_seen_at"`
+	CreatedByID uint       `json:"created_by_id"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// KioskSession is one patient's check-in at a kiosk, from identifying themselves to being marked
+// arrived. It also records what the patient did at the kiosk.
+type KioskSession struct {
+	ID        uint    `json:"id" gorm:"primarykey"`
+	Token     string  `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	DeviceID  uint    `json:"device_id" gorm:"not null;index"`
+	BranchID  uint    `json:"branch_id" gorm:"not null;index"`
+	PatientID uint    `json:"patient_id" gorm:"not null;index"`
+	Patient   Patient `json:"-" gorm:"foreignKey:PatientID"`
+
+	ExpiresAt              time.Time  `json:"expires_at" gorm:"not null"`
+	MedicalUpdatedAt       *time.Time `json:"medical_updated_at"`
+	CheckedInAt            *time.Time `json:"checked_in_at"`
+	CheckedInAppointmentID *uint      `json:"checked_in_appointment_id"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// IsUsable reports whether the patient can still act in the session
+func (s *KioskSession) IsUsable() bool {
+	return s.CheckedInAt == nil && time.Now().Before(s.ExpiresAt)
+}
+
+// KioskIdentifyFailure is a failed attempt to identify a patient at a kiosk, kept to throttle
+// guessing of booking codes, phone numbers and dates of birth
+type KioskIdentifyFailure struct {
+	ID         uint      `json:"id" gorm:"primarykey"`
+	DeviceID   uint      `json:"device_id" gorm:"not null;index"`
+	Identifier string    `json:"-" gorm:"size:64;index"` // hash of the phone number or booking code
+	CreatedAt  time.Time `json:"created_at" gorm:"index"`
+}
+
+// NewBookingCode generates the short random code patients are given for an appointment, used with
+// their date of birth to find it at a kiosk
+func NewBookingCode() string {
+	code := make([]byte, bookingCodeLength)
+	max := big.NewInt(int64(len(bookingCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic("crypto/rand unavailable: " + err.Error())
+		}
+		code[i] = bookingCodeAlphabet[n.Int64()]
+	}
+	return string(code)
+}
+
+// NormalizeBookingCode puts a booking code as typed into its stored form
+func NormalizeBookingCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+}
+
+// KioskIdentifier is what identification attempts are counted by: a hash of the phone number or
+// booking code, so the failures table holds no contact details
+func KioskIdentifier(value string) string {
+	sum := sha256.Sum256([]byte(strings.ToUpper(strings.TrimSpace(value))))
+	return hex.EncodeToString(sum[:])
+}
+
+// KioskIdentifyLockedUntil reports until when identification is locked for the device or the
+// identifier, or nil when it is not
+func KioskIdentifyLockedUntil(db *gorm.DB, deviceID uint, identifier string) (*time.Time, error) {
+	since := time.Now().Add(-KioskIdentifyWindow)
+	for _, check := range []struct {
+		column string
+		value  interface{}
+		limit  int
+	}{
+		{"device_id", deviceID, KioskDeviceMaxFailures},
+		{"identifier", identifier, KioskIdentifierMaxFailures},
+	} {
+		var failures []KioskIdentifyFailure
+		if err := db.Where(check.column+" = ? AND created_at > ?", check.value, since).
+			Order("created_at DESC").Limit(check.limit).Find(&failures).Error; err != nil {
+			return nil, err
+		}
+		if len(failures) >= check.limit {
+			// Locked until the oldest of the counted failures leaves the window
+			until := failures[len(failures)-1].CreatedAt.Add(KioskIdentifyWindow)
+			return &until, nil
+		}
+	}
+	return nil, nil
+}
+
+// BackfillBookingCodes gives upcoming appointments booked before booking codes existed a random one
+func BackfillBookingCodes(db *gorm.DB) error {
+	var ids []uint
+	if err := db.Model(&Appointment{}).Where("(booking_code IS NULL OR booking_code = '') AND start_time >= ?", time.Now().AddDate(0, 0, -1)).
+		Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := db.Model(&Appointment{}).Where("id = ?", id).UpdateColumn("booking_code", NewBookingCode()).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NumberSequence holds the last number handed out for a clinic's document series, such as its
+// invoice numbers. Year is zero for series that never restart.
+type NumberSequence struct {
+	ID        uint   `json:"id" gorm:"primarykey"`
+	ClinicID  uint   `json:"clinic_id" gorm:"not null;uniqueIndex:idx_number_sequence"`
+	Name      string `json:"name" gorm:"size:30;not null;uniqueIndex:idx_number_sequence"`
+	Year      int    `json:"year" gorm:"not null;default:0;uniqueIndex:idx_number_sequence"`
+	LastValue int64  `json:"last_value" gorm:"not null;default:0"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NextSequenceValue takes the next number in a clinic's series. The sequence row stays locked
+// until tx ends, so tx must also save whatever carries the number. A series seen for the first
+// time starts after seed, the count of numbers handed out before the sequence existed.
+func NextSequenceValue(tx *gorm.DB, clinicID uint, name string, year int, seed func() (int64, error)) (int64, error) {
+	var sequence NumberSequence
+	find := func() error {
+		return tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("clinic_id = ? AND name = ? AND year = ?", clinicID, name, year).
+			First(&sequence).Error
+	}
+
+	err := find()
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		start, seedErr := seed()
+		if seedErr != nil {
+			return 0, seedErr
+		}
+		// Another transaction may create the row first; then we wait on its lock below
+		created := NumberSequence{ClinicID: clinicID, Name: name, Year: year, LastValue: start}
+		if createErr := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&created).Error; createErr != nil {
+			return 0, createErr
+		}
+		sequence = NumberSequence{}
+		err = find()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	sequence.LastValue++
+	if err := tx.Model(&sequence).Update("last_value", sequence.LastValue).Error; err != nil {
+		return 0, err
+	}
+	return sequence.LastValue, nil
+}
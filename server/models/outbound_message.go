@@ -0,0 +1,106 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type MessageChannelType string
+
+const (
+	ChannelEmail MessageChannelType = "email"
+	ChannelSMS   MessageChannelType = "sms"
+)
+
+type MessageStatus string
+
+const (
+	MessageStatusPending MessageStatus = "pending"
+	MessageStatusSending MessageStatus = "sending"
+	MessageStatusSent    MessageStatus = "sent"
+	MessageStatusFailed  MessageStatus = "failed"
+)
+
+// OutboundMessage is an email or SMS to a patient, queued and delivered with retries
+type OutboundMessage struct {
+	ID        uint               `json:"id" gorm:"primarykey"`
+	Channel   MessageChannelType `json:"channel" gorm:"type:varchar(20);not null;index"`
+	Recipient string             `json:"recipient" gorm:"size:200;not null"` // email address or phone number
+	Subject   string             `json:"subject" gorm:"size:300"`
+	Body      string             `json:"body" gorm:"type:text;not null"`
+
+	// Optional file sent with emails, e.g. an appointment.ics invitation
+	AttachmentName        string `json:"attachment_name" gorm:"size:200"`
+	AttachmentContentType string `json:"attachment_content_type" gorm:"size:100"`
+	Attachment            string `json:"-" gorm:"type:mediumtext"`
+
+	// Delivery
+	Status            MessageStatus `json:"status" gorm:"type:varchar(20);default:'pending';index"`
+	Attempts          int           `json:"attempts" gorm:"default:0"`
+	MaxAttempts       int           `json:"max_attempts" gorm:"default:5"`
+	NextAttemptAt     time.Time     `json:"next_attempt_at" gorm:"index"`
+	LastError         string        `json:"last_error" gorm:"type:text"`
+	SentAt            *time.Time    `json:"sent_at"`
+	Provider          string        `json:"provider" gorm:"size:50"`
+	ProviderMessageID string        `json:"provider_message_id" gorm:"size:200"`
+
+	// What the message is about
+	PatientID             *uint  `json:"patient_id" gorm:"index"`
+	AppointmentReminderID *uint  `json:"appointment_reminder_id" gorm:"index"`
+	SelfScheduleRequestID *uint  `json:"self_schedule_request_id" gorm:"index"`
+	AppointmentID         *uint  `json:"appointment_id" gorm:"index"`
+	Category              string `json:"category" gorm:"size:50;index"` // appointment_reminder, self_schedule_confirmation, ...
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// Message categories
+const (
+	MessageCategoryAppointmentReminder      = "appointment_reminder"
+	MessageCategorySelfScheduleConfirmation = "self_schedule_confirmation"
+	MessageCategoryWaitlistOffer            = "waitlist_offer"
+	MessageCategoryAppointmentConfirmation  = "appointment_confirmation"
+	MessageCategoryAppointmentUpdate        = "appointment_update"
+)
+
+// retryBackoff is how long to wait after each failed attempt
+var retryBackoff = []time.Duration{time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour}
+
+// RecordFailure counts a failed attempt and either schedules a retry or marks the message failed
+func (m *OutboundMessage) RecordFailure(err error) {
+	m.Attempts++
+	m.LastError = err.Error()
+	if m.Attempts >= m.MaxAttempts {
+		m.Status = MessageStatusFailed
+		return
+	}
+
+	backoff := retryBackoff[len(retryBackoff)-1]
+	if m.Attempts-1 < len(retryBackoff) {
+		backoff = retryBackoff[m.Attempts-1]
+	}
+	m.Status = MessageStatusPending
+	m.NextAttemptAt = time.Now().Add(backoff)
+}
+
+// RecordSuccess marks the message delivered
+func (m *OutboundMessage) RecordSuccess(provider, providerMessageID string) {
+	now := time.Now()
+	m.Attempts++
+	m.Status = MessageStatusSent
+	m.SentAt = &now
+	m.LastError = ""
+	m.Provider = provider
+	m.ProviderMessageID = providerMessageID
+}
+
+// CanRetry reports whether a failed message can be queued again
+func (m *OutboundMessage) CanRetry() bool {
+	return m.Status == MessageStatusFailed
+}
@@ -163,6 +163,11 @@ const (
 	PatientScheduleStatusConverted PatientSelfScheduleStatus = "converted"
 )
 
+// IsClosed reports whether the request has been rejected or converted and can no longer be acted on
+func (p *PatientSelfScheduleRequest) IsClosed() bool {
+	return p.Status == PatientScheduleStatusRejected || p.Status == PatientScheduleStatusConverted
+}
+
 func (p *PatientSelfScheduleRequest) GetFullName() string {
 	return p.FirstName + " " + p.LastName
 }
@@ -8,7 +8,7 @@ import (
 
 type ProcedureTemplate struct {
 	ID                uint    `json:"id" gorm:"primarykey"`
-	Code              string  `json:"code" gorm:"uniqueIndex;size:20"`
+	Code              string  `json:"code" gorm:"size:20;uniqueIndex:idx_procedure_templates_code_clinic"`
 	Name              string  `json:"name" gorm:"size:200;not null"`
 	Description       string  `json:"description" gorm:"type:text"`
 	Category          string  `json:"category" gorm:"size:100"`
@@ -16,6 +16,13 @@ type ProcedureTemplate struct {
 	DefaultCost       float64 `json:"default_cost" gorm:"type:decimal(10,2)"`
 	IsActive          bool    `json:"is_active" gorm:"default:true"`
 
+	// Chairs, rooms and equipment the procedure needs
+	ResourceRequirements []ProcedureResourceRequirement `json:"resource_requirements,omitempty" gorm:"foreignKey:ProcedureTemplateID"`
+
+	// Clinic scoping: 0 for the platform catalog, otherwise the clinic's own template or its
+	// override of the platform template with the same code
+	ClinicID uint `json:"clinic_id" gorm:"not null;default:0;uniqueIndex:idx_procedure_templates_code_clinic"`
+
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
@@ -44,6 +51,9 @@ type AppointmentProcedure struct {
 	PerformedByID uint `json:"performed_by_id" gorm:"not null;index"`
 	PerformedBy   User `json:"performed_by" gorm:"foreignKey:PerformedByID"`
 
+	// Treatment plan item this procedure carries out, if any
+	TreatmentPlanProcedureID *uint `json:"treatment_plan_procedure_id" gorm:"index"`
+
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
@@ -51,13 +61,17 @@ type AppointmentProcedure struct {
 
 type DiagnosisTemplate struct {
 	ID          uint   `json:"id" gorm:"primarykey"`
-	Code        string `json:"code" gorm:"uniqueIndex;size:20"`
+	Code        string `json:"code" gorm:"size:20;uniqueIndex:idx_diagnosis_templates_code_clinic"`
 	Name        string `json:"name" gorm:"size:200;not null"`
 	Description string `json:"description" gorm:"type:text"`
 	Category    string `json:"category" gorm:"size:100"`
 	Severity    string `json:"severity" gorm:"size:50"` // mild, moderate, severe
 	IsActive    bool   `json:"is_active" gorm:"default:true"`
 
+	// Clinic scoping: 0 for the platform catalog, otherwise the clinic's own template or its
+	// override of the platform template with the same code
+	ClinicID uint `json:"clinic_id" gorm:"not null;default:0;uniqueIndex:idx_diagnosis_templates_code_clinic"`
+
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
@@ -192,8 +206,11 @@ type PatientTreatmentPlan struct {
 type TreatmentPlanProcedure struct {
 	ID uint `json:"id" gorm:"primarykey"`
 
-	TreatmentPlanID uint                 `json:"treatment_plan_id" gorm:"not null;index"`
-	TreatmentPlan   PatientTreatmentPlan `json:"treatment_plan" gorm:"foreignKey:TreatmentPlanID"`
+	TreatmentPlanID uint                  `json:"treatment_plan_id" gorm:"not null;index"`
+	TreatmentPlan   *PatientTreatmentPlan `json:"treatment_plan,omitempty" gorm:"foreignKey:TreatmentPlanID"`
+
+	// Phase of the plan the procedure is done in; nil for plans without phases
+	PhaseID *uint `json:"phase_id" gorm:"index"`
 
 	ProcedureTemplateID uint              `json:"procedure_template_id" gorm:"not null;index"`
 	ProcedureTemplate   ProcedureTemplate `json:"procedure_template" gorm:"foreignKey:ProcedureTemplateID"`
@@ -205,7 +222,13 @@ type TreatmentPlanProcedure struct {
 	EstimatedCost float64 `json:"estimated_cost" gorm:"type:decimal(10,2)"`
 	Sequence      int     `json:"sequence" gorm:"default:1"` // order in treatment plan
 
-	Status string `json:"status" gorm:"size:50;default:'planned'"` // planned, completed, skipped
+	Status string `json:"status" gorm:"size:50;default:'planned'"` // planned, scheduled, completed, skipped
+
+	// The appointment procedure that carries the item out, once scheduled or done
+	AppointmentID          *uint      `json:"appointment_id" gorm:"index"`
+	AppointmentProcedureID *uint      `json:"appointment_procedure_id" gorm:"index"`
+	ActualCost             float64    `json:"actual_cost" gorm:"type:decimal(10,2);default:0"`
+	CompletedAt            *time.Time `json:"completed_at"`
 
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
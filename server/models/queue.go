@@ -0,0 +1,156 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type QueueStatus string
+
+const (
+	QueueArrived     QueueStatus = "arrived"      // checked in at the front desk
+	QueueWaiting     QueueStatus = "waiting"      // ready and waiting to be called
+	QueueCalled      QueueStatus = "called"       // called to a chair
+	QueueInTreatment QueueStatus = "in_treatment" // in the chair
+	QueueCheckedOut  QueueStatus = "checked_out"  // done and gone
+	QueueLeft        QueueStatus = "left"         // left without being seen
+)
+
+// queueTransitions lists where a queue entry can go from each status. A called patient who does
+// not come to the chair can go back to waiting.
+var queueTransitions = map[QueueStatus][]QueueStatus{
+	QueueArrived:     {QueueWaiting, QueueCalled, QueueLeft},
+	QueueWaiting:     {QueueCalled, QueueLeft},
+	QueueCalled:      {QueueInTreatment, QueueWaiting, QueueLeft},
+	QueueInTreatment: {QueueCheckedOut},
+	QueueCheckedOut:  {},
+	QueueLeft:        {},
+}
+
+// ActiveQueueStatuses are the statuses of patients still in the building
+var ActiveQueueStatuses = []QueueStatus{QueueArrived, QueueWaiting, QueueCalled, QueueInTreatment}
+
+// QueueEntry is a patient's place in a branch's queue for the day. Walk-ins have no appointment.
+type QueueEntry struct {
+	ID            uint         `json:"id" gorm:"primarykey"`
+	BranchID      uint         `json:"branch_id" gorm:"not null;uniqueIndex:idx_queue_ticket"`
+	QueueDate     time.Time    `json:"queue_date" gorm:"type:date;not null;uniqueIndex:idx_queue_ticket"` // the branch's local day
+	TicketNumber  int          `json:"ticket_number" gorm:"not null;uniqueIndex:idx_queue_ticket"`
+	AppointmentID *uint        `json:"appointment_id" gorm:"index"`
+	Appointment   *Appointment `json:"appointment,omitempty" gorm:"foreignKey:AppointmentID"`
+	PatientID     uint         `json:"patient_id" gorm:"not null;index"`
+	Patient       Patient      `json:"patient" gorm:"foreignKey:PatientID"`
+	DoctorID      *uint        `json:"doctor_id"`
+	Doctor        *User        `json:"doctor,omitempty" gorm:"foreignKey:DoctorID"`
+	ResourceID    *uint        `json:"resource_id"` // the chair or room the patient was called to
+	Resource      *Resource    `json:"resource,omitempty" gorm:"foreignKey:ResourceID"`
+	Status        QueueStatus  `json:"status" gorm:"type:varchar(20);default:'arrived';index"`
+	Notes         string       `json:"notes" gorm:"type:text"`
+
+	// When each step happened
+	ArrivedAt          time.Time  `json:"arrived_at" gorm:"not null"`
+	CalledAt           *time.Time `json:"called_at"`
+	TreatmentStartedAt *time.Time `json:"treatment_started_at"`
+	FinishedAt         *time.Time `json:"finished_at"` // checked out or left
+
+	UpdatedByID uint `json:"updated_by_id"`
+
+	Ticket      string `json:"ticket" gorm:"-"`       // Calculated by Calculate, not stored
+	WaitMinutes int    `json:"wait_minutes" gorm:"-"` // Calculated by Calculate, not stored
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// TicketLabel is the short number shown on the waiting room board, e.g. "A007"
+func (q *QueueEntry) TicketLabel() string {
+	return fmt.Sprintf("A%03d", q.TicketNumber)
+}
+
+// Calculate fills in the ticket label and wait time as of now
+func (q *QueueEntry) Calculate(now time.Time) {
+	q.Ticket = q.TicketLabel()
+	q.WaitMinutes = int(q.WaitTime(now).Minutes())
+}
+
+// TransitionTo moves the entry to a new status and stamps the time of the step
+func (q *QueueEntry) TransitionTo(status QueueStatus, now time.Time) error {
+	allowed := queueTransitions[q.Status]
+	for _, next := range allowed {
+		if next != status {
+			continue
+		}
+		switch status {
+		case QueueWaiting:
+			q.CalledAt = nil // back in line after a missed call
+			q.ResourceID = nil
+		case QueueCalled:
+			q.CalledAt = &now
+		case QueueInTreatment:
+			q.TreatmentStartedAt = &now
+		case QueueCheckedOut, QueueLeft:
+			q.FinishedAt = &now
+		}
+		q.Status = status
+		return nil
+	}
+
+	names := make([]string, 0, len(allowed))
+	for _, next := range allowed {
+		names = append(names, string(next))
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("the patient has already %s", strings.ReplaceAll(string(q.Status), "_", " "))
+	}
+	return fmt.Errorf("cannot move a patient from %s to %s; allowed: %s", q.Status, status, strings.Join(names, ", "))
+}
+
+// WaitTime is how long the patient waited to be called, or has been waiting so far
+func (q *QueueEntry) WaitTime(now time.Time) time.Duration {
+	switch {
+	case q.CalledAt != nil:
+		return q.CalledAt.Sub(q.ArrivedAt)
+	case q.TreatmentStartedAt != nil:
+		return q.TreatmentStartedAt.Sub(q.ArrivedAt)
+	case q.FinishedAt != nil:
+		return q.FinishedAt.Sub(q.ArrivedAt)
+	default:
+		return now.Sub(q.ArrivedAt)
+	}
+}
+
+// QueueDisplay is a read-only token for a waiting room "now serving" board at a branch
+type QueueDisplay struct {
+	ID       uint   `json:"id" gorm:"primarykey"`
+	Token    string `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	Name     string `json:"name" gorm:"size:100"` // e.g. "Lobby TV"
+	BranchID uint   `json:"branch_id" gorm:"not null;index"`
+	Branch   Branch `json:"branch,omitempty" gorm:"foreignKey:BranchID"`
+
+	LastSeenAt  *time.Time `json:"last_seen_at"`
+	CreatedByID uint       `json:"created_by_id"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// QueueSubject is the NATS subject queue changes at a branch are published on
+func QueueSubject(branchID uint) string {
+	return fmt.Sprintf("dentika.branch.%d.queue", branchID)
+}
+
+// QueueBoardSubject carries the board view, which has no patient details, for display screens
+func QueueBoardSubject(branchID uint) string {
+	return QueueSubject(branchID) + ".board"
+}
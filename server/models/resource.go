@@ -0,0 +1,198 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ResourceType string
+
+const (
+	ResourceChair     ResourceType = "chair"
+	ResourceRoom      ResourceType = "room"
+	ResourceEquipment ResourceType = "equipment"
+)
+
+// Resource is a chair, room or piece of equipment at a branch that only one appointment can use at a time
+type Resource struct {
+	ID          uint         `json:"id" gorm:"primarykey"`
+	Name        string       `json:"name" gorm:"size:100;not null"`
+	Type        ResourceType `json:"type" gorm:"type:varchar(20);not null;index"`
+	Description string       `json:"description" gorm:"type:text"`
+	Features    string       `json:"features" gorm:"size:500"` // comma separated, e.g. "sedation,xray"
+	IsActive    bool         `json:"is_active" gorm:"default:true"`
+
+	BranchID uint   `json:"branch_id" gorm:"not null;index"`
+	Branch   Branch `json:"branch,omitempty" gorm:"foreignKey:BranchID"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// AppointmentResource books a resource for the length of an appointment
+type AppointmentResource struct {
+	ID            uint      `json:"id" gorm:"primarykey"`
+	AppointmentID uint      `json:"appointment_id" gorm:"not null;uniqueIndex:idx_appointment_resource"`
+	ResourceID    uint      `json:"resource_id" gorm:"not null;uniqueIndex:idx_appointment_resource;index"`
+	Resource      Resource  `json:"resource" gorm:"foreignKey:ResourceID"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// ProcedureResourceRequirement declares that a procedure needs resources of a type, optionally
+// with a feature, e.g. one room with "sedation"
+type ProcedureResourceRequirement struct {
+	ID                  uint         `json:"id" gorm:"primarykey"`
+	ProcedureTemplateID uint         `json:"procedure_template_id" gorm:"not null;index"`
+	ResourceType        ResourceType `json:"resource_type" gorm:"type:varchar(20);not null"`
+	Feature             string       `json:"feature" gorm:"size:50"`
+	Quantity            int          `json:"quantity" gorm:"default:1"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func IsValidResourceType(t ResourceType) bool {
+	return t == ResourceChair || t == ResourceRoom || t == ResourceEquipment
+}
+
+// FeatureList returns the resource's features, lower-cased
+func (r *Resource) FeatureList() []string {
+	features := []string{}
+	for _, feature := range strings.Split(r.Features, ",") {
+		if feature = strings.ToLower(strings.TrimSpace(feature)); feature != "" {
+			features = append(features, feature)
+		}
+	}
+	return features
+}
+
+// Satisfies reports whether the resource can fill the requirement
+func (r *Resource) Satisfies(req ProcedureResourceRequirement) bool {
+	if r.Type != req.ResourceType {
+		return false
+	}
+	if req.Feature == "" {
+		return true
+	}
+	for _, feature := range r.FeatureList() {
+		if feature == strings.ToLower(req.Feature) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResourceConflict is a resource already booked by another appointment at an overlapping time
+type ResourceConflict struct {
+	ResourceID    uint      `json:"resource_id"`
+	ResourceName  string    `json:"resource_name"`
+	AppointmentID uint      `json:"appointment_id"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+}
+
+// FindResourceConflicts returns bookings of the resources that overlap start-end, ignoring excludeAppointmentID
+func FindResourceConflicts(db *gorm.DB, resourceIDs []uint, start, end time.Time, excludeAppointmentID uint) ([]ResourceConflict, error) {
+	conflicts := []ResourceConflict{}
+	if len(resourceIDs) == 0 {
+		return conflicts, nil
+	}
+
+	err := db.Table("appointment_resources").
+		Select("appointment_resources.resource_id, resources.name AS resource_name, appointments.id AS appointment_id, appointments.start_time, appointments.end_time").
+		Joins("JOIN appointments ON appointments.id = appointment_resources.appointment_id AND appointments.deleted_at IS NULL").
+		Joins("JOIN resources ON resources.id = appointment_resources.resource_id").
+		Where("appointment_resources.resource_id IN ? AND appointments.id <> ?", resourceIDs, excludeAppointmentID).
+		Where("appointments.status IN ? AND appointments.start_time < ? AND appointments.end_time > ?", BlockingStatuses, end, start).
+		Order("appointments.start_time").
+		Scan(&conflicts).Error
+	return conflicts, err
+}
+
+// LockResourceConflicts locks the resources' rows and then finds their overlapping bookings. Run
+// in the transaction that books them, it keeps two bookings from taking a resource at once.
+func LockResourceConflicts(tx *gorm.DB, resourceIDs []uint, start, end time.Time, excludeAppointmentID uint) ([]ResourceConflict, error) {
+	if len(resourceIDs) == 0 {
+		return []ResourceConflict{}, nil
+	}
+	var locked []Resource
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", resourceIDs).Find(&locked).Error; err != nil {
+		return nil, err
+	}
+	return FindResourceConflicts(tx, resourceIDs, start, end, excludeAppointmentID)
+}
+
+// UnmetRequirement is a procedure requirement that no free resource could fill
+type UnmetRequirement struct {
+	ProcedureTemplateID uint         `json:"procedure_template_id"`
+	ResourceType        ResourceType `json:"resource_type"`
+	Feature             string       `json:"feature,omitempty"`
+	Missing             int          `json:"missing"`
+}
+
+// FillResourceRequirements picks free active resources at the branch for requirements not already
+// covered by the chosen resources. It returns the chosen resources plus the picks, and anything unmet.
+func FillResourceRequirements(db *gorm.DB, branchID uint, requirements []ProcedureResourceRequirement, chosen []Resource,
+	start, end time.Time, excludeAppointmentID uint) ([]Resource, []UnmetRequirement, error) {
+	if len(requirements) == 0 {
+		return chosen, nil, nil
+	}
+
+	var candidates []Resource
+	if err := db.Where("branch_id = ? AND is_active = ?", branchID, true).Order("name").Find(&candidates).Error; err != nil {
+		return nil, nil, err
+	}
+	ids := make([]uint, len(candidates))
+	for i, candidate := range candidates {
+		ids[i] = candidate.ID
+	}
+	conflicts, err := FindResourceConflicts(db, ids, start, end, excludeAppointmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	busy := map[uint]bool{}
+	for _, conflict := range conflicts {
+		busy[conflict.ResourceID] = true
+	}
+
+	used := map[uint]bool{}
+	result := append([]Resource{}, chosen...)
+	unmet := []UnmetRequirement{}
+	for _, req := range requirements {
+		need := req.Quantity
+		if need < 1 {
+			need = 1
+		}
+
+		// Resources the caller already chose count first
+		for _, resource := range chosen {
+			if need > 0 && !used[resource.ID] && resource.Satisfies(req) {
+				used[resource.ID] = true
+				need--
+			}
+		}
+		for _, resource := range candidates {
+			if need > 0 && !used[resource.ID] && !busy[resource.ID] && resource.Satisfies(req) {
+				used[resource.ID] = true
+				result = append(result, resource)
+				need--
+			}
+		}
+		if need > 0 {
+			unmet = append(unmet, UnmetRequirement{
+				ProcedureTemplateID: req.ProcedureTemplateID,
+				ResourceType:        req.ResourceType,
+				Feature:             req.Feature,
+				Missing:             need,
+			})
+		}
+	}
+	return result, unmet, nil
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+)
+
+type JobRunStatus string
+
+const (
+	JobStatusIdle    JobRunStatus = "idle"
+	JobStatusRunning JobRunStatus = "running"
+	JobStatusSuccess JobRunStatus = "success"
+	JobStatusFailed  JobRunStatus = "failed"
+)
+
+// ScheduledJob holds the shared state of a background job: its lock, so only one
+// server replica runs it at a time, and the outcome of its last run
+type ScheduledJob struct {
+	ID          uint   `json:"id" gorm:"primarykey"`
+	Name        string `json:"name" gorm:"size:100;uniqueIndex;not null"`
+	Description string `json:"description" gorm:"size:500"`
+	Schedule    string `json:"schedule" gorm:"size:100"` // "every 5m0s" or a cron expression
+	IsEnabled   bool   `json:"is_enabled" gorm:"default:true"`
+
+	// Lock
+	LockedBy    string     `json:"locked_by" gorm:"size:200"`
+	LockedUntil *time.Time `json:"locked_until"`
+
+	// Last run
+	LastStatus     JobRunStatus `json:"last_status" gorm:"type:varchar(20);default:'idle'"`
+	LastStartedAt  *time.Time   `json:"last_started_at"`
+	LastFinishedAt *time.Time   `json:"last_finished_at"`
+	LastDurationMs int64        `json:"last_duration_ms"`
+	LastError      string       `json:"last_error" gorm:"type:text"`
+	LastRunBy      string       `json:"last_run_by" gorm:"size:200"`
+	NextRunAt      *time.Time   `json:"next_run_at" gorm:"index"`
+
+	RunCount     int `json:"run_count" gorm:"default:0"`
+	FailureCount int `json:"failure_count" gorm:"default:0"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (j *ScheduledJob) IsLocked() bool {
+	return j.LockedUntil != nil && time.Now().Before(*j.LockedUntil)
+}
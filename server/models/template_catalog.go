@@ -0,0 +1,136 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PlatformCatalogID is the ClinicID of the procedure and diagnosis templates every clinic starts
+// from. A clinic overrides one by having its own template with the same code; an inactive
+// override hides the platform template from that clinic.
+const PlatformCatalogID uint = 0
+
+// ProcedureCatalog scopes a query to the procedure templates a clinic sees: its own, plus the
+// platform templates it has not overridden. Inactive templates are included.
+func ProcedureCatalog(db *gorm.DB, clinicID uint) *gorm.DB {
+	return catalogScope(db.Model(&ProcedureTemplate{}), "procedure_templates", clinicID)
+}
+
+// DiagnosisCatalog scopes a query to the diagnosis templates a clinic sees: its own, plus the
+// platform templates it has not overridden. Inactive templates are included.
+func DiagnosisCatalog(db *gorm.DB, clinicID uint) *gorm.DB {
+	return catalogScope(db.Model(&DiagnosisTemplate{}), "diagnosis_templates", clinicID)
+}
+
+func catalogScope(db *gorm.DB, table string, clinicID uint) *gorm.DB {
+	if clinicID == PlatformCatalogID {
+		return db.Where(table+".clinic_id = ?", PlatformCatalogID)
+	}
+	overridden := db.Session(&gorm.Session{NewDB: true}).Table(table).
+		Select("code").Where("clinic_id = ? AND deleted_at IS NULL", clinicID)
+	return db.Where(table+".clinic_id = ? OR ("+table+".clinic_id = ? AND "+table+".code NOT IN (?))",
+		clinicID, PlatformCatalogID, overridden)
+}
+
+// ResolveProcedureTemplate finds an active procedure template a clinic can use. A platform
+// template the clinic has overridden resolves to the clinic's version.
+func ResolveProcedureTemplate(db *gorm.DB, clinicID, id uint) (*ProcedureTemplate, error) {
+	var template ProcedureTemplate
+	if err := db.Where("clinic_id IN ?", []uint{PlatformCatalogID, clinicID}).First(&template, id).Error; err != nil {
+		return nil, err
+	}
+	if template.ClinicID == PlatformCatalogID && clinicID != PlatformCatalogID {
+		var override ProcedureTemplate
+		if err := db.Where("code = ? AND clinic_id = ?", template.Code, clinicID).First(&override).Error; err == nil {
+			template = override
+		}
+	}
+	if !template.IsActive {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &template, nil
+}
+
+// ResolveDiagnosisTemplate finds an active diagnosis template a clinic can use. A platform
+// template the clinic has overridden resolves to the clinic's version.
+func ResolveDiagnosisTemplate(db *gorm.DB, clinicID, id uint) (*DiagnosisTemplate, error) {
+	var template DiagnosisTemplate
+	if err := db.Where("clinic_id IN ?", []uint{PlatformCatalogID, clinicID}).First(&template, id).Error; err != nil {
+		return nil, err
+	}
+	if template.ClinicID == PlatformCatalogID && clinicID != PlatformCatalogID {
+		var override DiagnosisTemplate
+		if err := db.Where("code = ? AND clinic_id = ?", template.Code, clinicID).First(&override).Error; err == nil {
+			template = override
+		}
+	}
+	if !template.IsActive {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &template, nil
+}
+
+// IsPlatform reports whether the template belongs to the platform catalog
+func (pt *ProcedureTemplate) IsPlatform() bool {
+	return pt.ClinicID == PlatformCatalogID
+}
+
+// IsPlatform reports whether the template belongs to the platform catalog
+func (dt *DiagnosisTemplate) IsPlatform() bool {
+	return dt.ClinicID == PlatformCatalogID
+}
+
+// OverrideFor copies a platform template as the starting point of a clinic's own version
+func (pt *ProcedureTemplate) OverrideFor(clinicID uint) ProcedureTemplate {
+	override := *pt
+	override.ID = 0
+	override.ClinicID = clinicID
+	override.ResourceRequirements = nil
+	override.CreatedAt = time.Time{}
+	override.UpdatedAt = time.Time{}
+	for _, requirement := range pt.ResourceRequirements {
+		override.ResourceRequirements = append(override.ResourceRequirements, ProcedureResourceRequirement{
+			ResourceType: requirement.ResourceType,
+			Feature:      requirement.Feature,
+			Quantity:     requirement.Quantity,
+		})
+	}
+	return override
+}
+
+// OverrideFor copies a platform template as the starting point of a clinic's own version
+func (dt *DiagnosisTemplate) OverrideFor(clinicID uint) DiagnosisTemplate {
+	override := *dt
+	override.ID = 0
+	override.ClinicID = clinicID
+	override.CreatedAt = time.Time{}
+	override.UpdatedAt = time.Time{}
+	return override
+}
+
+// ProcedureTemplateInUse reports whether appointments, treatment plans, waitlist entries or fee
+// schedules refer to the template, in which case it is deactivated rather than deleted
+func ProcedureTemplateInUse(db *gorm.DB, id uint) bool {
+	for _, model := range []interface{}{&AppointmentProcedure{}, &TreatmentPlanProcedure{}, &WaitlistEntry{}, &FeeScheduleItem{}} {
+		var count int64
+		db.Model(model).Where("procedure_template_id = ?", id).Count(&count)
+		if count > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// DiagnosisTemplateInUse reports whether appointment or patient diagnoses refer to the template,
+// in which case it is deactivated rather than deleted
+func DiagnosisTemplateInUse(db *gorm.DB, id uint) bool {
+	for _, model := range []interface{}{&AppointmentDiagnosis{}, &PatientDiagnosis{}} {
+		var count int64
+		db.Model(model).Where("diagnosis_template_id = ?", id).Count(&count)
+		if count > 0 {
+			return true
+		}
+	}
+	return false
+}
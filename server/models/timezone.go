@@ -0,0 +1,124 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultTimezone is used for clinics and branches that have no timezone set. It is the TIMEZONE
+// setting, which defaulted to UTC before timezones were configurable per branch.
+var DefaultTimezone = "UTC"
+
+var locations sync.Map // IANA name -> *time.Location
+
+// SetDefaultTimezone changes the fallback timezone, e.g. from the TIMEZONE setting
+func SetDefaultTimezone(name string) error {
+	if _, err := time.LoadLocation(name); err != nil || name == "" {
+		return fmt.Errorf("invalid timezone %q", name)
+	}
+	DefaultTimezone = name
+	return nil
+}
+
+// IsValidTimezone reports whether name is a known IANA timezone such as "America/New_York"
+func IsValidTimezone(name string) bool {
+	if name == "" || name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// LoadLocation returns the named timezone, falling back to the default and then UTC
+func LoadLocation(name string) *time.Location {
+	if name == "" {
+		name = DefaultTimezone
+	}
+	if cached, ok := locations.Load(name); ok {
+		return cached.(*time.Location)
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		if name != DefaultTimezone {
+			return LoadLocation(DefaultTimezone)
+		}
+		return time.UTC
+	}
+	locations.Store(name, location)
+	return location
+}
+
+// Location returns the clinic's timezone
+func (c *Clinic) Location() *time.Location {
+	return LoadLocation(c.Timezone)
+}
+
+// Location returns the branch's timezone, or its clinic's when the branch has none.
+// The clinic must be loaded for the fallback; use BranchLocation otherwise.
+func (b *Branch) Location() *time.Location {
+	if b.Timezone != "" {
+		return LoadLocation(b.Timezone)
+	}
+	return LoadLocation(b.Clinic.Timezone)
+}
+
+// BranchLocation looks up the timezone a branch operates in
+func BranchLocation(db *gorm.DB, branchID uint) *time.Location {
+	var row struct {
+		BranchTimezone string
+		ClinicTimezone string
+	}
+	db.Table("branches").
+		Select("branches.timezone AS branch_timezone, clinics.timezone AS clinic_timezone").
+		Joins("JOIN clinics ON clinics.id = branches.clinic_id").
+		Where("branches.id = ?", branchID).
+		Scan(&row)
+	if row.BranchTimezone != "" {
+		return LoadLocation(row.BranchTimezone)
+	}
+	return LoadLocation(row.ClinicTimezone)
+}
+
+// ClinicLocation looks up a clinic's timezone
+func ClinicLocation(db *gorm.DB, clinicID uint) *time.Location {
+	var name string
+	db.Model(&Clinic{}).Where("id = ?", clinicID).Pluck("timezone", &name)
+	return LoadLocation(name)
+}
+
+// SyncAppointmentTimezones re-points a branch's appointments at its current timezone after it changes
+func SyncAppointmentTimezones(db *gorm.DB, branchID uint) error {
+	return db.Model(&Appointment{}).Where("branch_id = ?", branchID).
+		Update("timezone", BranchLocation(db, branchID).String()).Error
+}
+
+// Location returns the timezone of the branch the appointment was booked at
+func (a *Appointment) Location() *time.Location {
+	return LoadLocation(a.Timezone)
+}
+
+// BeforeCreate records the branch's timezone on new appointments
+func (a *Appointment) BeforeCreate(tx *gorm.DB) error {
+	if a.Timezone == "" && a.BranchID != 0 {
+		a.Timezone = BranchLocation(tx.Session(&gorm.Session{NewDB: true}), a.BranchID).String()
+	}
+	if a.BookingCode == "" {
+		a.BookingCode = NewBookingCode()
+	}
+	return nil
+}
+
+// AfterFind puts loaded times in the appointment's timezone, so they render with the branch's offset
+func (a *Appointment) AfterFind(tx *gorm.DB) error {
+	location := a.Location()
+	a.StartTime = a.StartTime.In(location)
+	a.EndTime = a.EndTime.In(location)
+	if a.ArrivalTime != nil {
+		arrival := a.ArrivalTime.In(location)
+		a.ArrivalTime = &arrival
+	}
+	return nil
+}
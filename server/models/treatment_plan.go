@@ -0,0 +1,296 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Treatment plan item statuses
+const (
+	PlanItemPlanned   = "planned"
+	PlanItemScheduled = "scheduled" // booked into an appointment
+	PlanItemCompleted = "completed"
+	PlanItemSkipped   = "skipped"
+)
+
+// Treatment plan phase statuses, derived from the phase's items
+const (
+	PhasePending    = "pending"
+	PhaseInProgress = "in_progress"
+	PhaseCompleted  = "completed"
+)
+
+// TreatmentPlanPhase groups a treatment plan's procedures into a stage, e.g. "Hygiene" before
+// "Restorative". Phases are worked through in Sequence order; a phase that depends on another is
+// only scheduled once that one is completed.
+type TreatmentPlanPhase struct {
+	ID uint `json:"id" gorm:"primarykey"`
+
+	TreatmentPlanID uint `json:"treatment_plan_id" gorm:"not null;index"`
+
+	Name        string `json:"name" gorm:"size:200;not null"`
+	Description string `json:"description" gorm:"type:text"`
+	Sequence    int    `json:"sequence" gorm:"default:1"`
+
+	// Phase that has to be completed first, and how many days after its completion this one starts
+	DependsOnPhaseID    *uint `json:"depends_on_phase_id" gorm:"index"`
+	DaysAfterDependency int   `json:"days_after_dependency" gorm:"default:0"`
+
+	Status      string     `json:"status" gorm:"size:50;default:'pending'"` // pending, in_progress, completed
+	CompletedAt *time.Time `json:"completed_at"`
+
+	Items []TreatmentPlanProcedure `json:"items,omitempty" gorm:"foreignKey:PhaseID"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// TreatmentPlanProgress counts a plan's or phase's items by status. Skipped items don't count
+// towards the total.
+type TreatmentPlanProgress struct {
+	TotalItems      int     `json:"total_items"`
+	Planned         int     `json:"planned"`
+	Scheduled       int     `json:"scheduled"`
+	Completed       int     `json:"completed"`
+	Skipped         int     `json:"skipped"`
+	EstimatedCost   float64 `json:"estimated_cost"`
+	ActualCost      float64 `json:"actual_cost"`
+	CompletedVisits int     `json:"completed_visits"`
+	Percentage      float64 `json:"percentage"`
+}
+
+// SummarizePlanItems works out the progress of a set of treatment plan items. Completed visits
+// are the distinct appointments completed items were done in.
+func SummarizePlanItems(items []TreatmentPlanProcedure) TreatmentPlanProgress {
+	var progress TreatmentPlanProgress
+	visits := map[uint]bool{}
+	for _, item := range items {
+		switch item.Status {
+		case PlanItemSkipped:
+			progress.Skipped++
+			continue
+		case PlanItemCompleted:
+			progress.Completed++
+			progress.ActualCost += item.ActualCost
+			if item.AppointmentID != nil {
+				visits[*item.AppointmentID] = true
+			}
+		case PlanItemScheduled:
+			progress.Scheduled++
+		default:
+			progress.Planned++
+		}
+		progress.TotalItems++
+		progress.EstimatedCost += item.EstimatedCost
+	}
+	progress.CompletedVisits = len(visits)
+	if progress.TotalItems > 0 {
+		progress.Percentage = float64(progress.Completed) / float64(progress.TotalItems) * 100
+	}
+	return progress
+}
+
+// phaseStatus derives a phase's status from its progress. A phase whose items were all skipped
+// has nothing left to do, so it is completed.
+func phaseStatus(progress TreatmentPlanProgress) string {
+	switch {
+	case progress.TotalItems+progress.Skipped > 0 && progress.Completed == progress.TotalItems:
+		return PhaseCompleted
+	case progress.Completed > 0 || progress.Scheduled > 0:
+		return PhaseInProgress
+	default:
+		return PhasePending
+	}
+}
+
+// RecalculateTreatmentPlan rolls a plan's items up into its phases and the plan itself: each
+// phase's status, and the plan's estimated and actual cost and completed visits. An active plan
+// whose items are all done is completed, and a completed one reopened when items are added.
+// Plans without items keep their hand-entered figures.
+func RecalculateTreatmentPlan(db *gorm.DB, planID uint) error {
+	var plan PatientTreatmentPlan
+	if err := db.First(&plan, planID).Error; err != nil {
+		return err
+	}
+	var items []TreatmentPlanProcedure
+	if err := db.Where("treatment_plan_id = ?", planID).Find(&items).Error; err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	var phases []TreatmentPlanPhase
+	if err := db.Where("treatment_plan_id = ?", planID).Find(&phases).Error; err != nil {
+		return err
+	}
+	byPhase := map[uint][]TreatmentPlanProcedure{}
+	for _, item := range items {
+		if item.PhaseID != nil {
+			byPhase[*item.PhaseID] = append(byPhase[*item.PhaseID], item)
+		}
+	}
+	now := time.Now()
+	for _, phase := range phases {
+		status := phaseStatus(SummarizePlanItems(byPhase[phase.ID]))
+		if status == phase.Status {
+			continue
+		}
+		updates := map[string]interface{}{"status": status, "completed_at": nil}
+		if status == PhaseCompleted {
+			updates["completed_at"] = now
+		}
+		if err := db.Model(&TreatmentPlanPhase{}).Where("id = ?", phase.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+
+	progress := SummarizePlanItems(items)
+	updates := map[string]interface{}{
+		"estimated_cost":   progress.EstimatedCost,
+		"actual_cost":      progress.ActualCost,
+		"completed_visits": progress.CompletedVisits,
+	}
+	if progress.CompletedVisits > plan.EstimatedVisits {
+		updates["estimated_visits"] = progress.CompletedVisits
+	}
+	done := progress.TotalItems > 0 && progress.Completed == progress.TotalItems
+	if done && plan.IsActive() {
+		updates["status"] = "completed"
+		updates["completed_at"] = now
+	} else if !done && plan.IsCompleted() {
+		updates["status"] = "active"
+		updates["completed_at"] = nil
+	}
+	return db.Model(&PatientTreatmentPlan{}).Where("id = ?", planID).Updates(updates).Error
+}
+
+// FindPlanItemForProcedure finds the treatment plan item an appointment procedure carries out:
+// the one it is linked to, else the first open item of the patient's active plans for the same
+// procedure code and tooth, preferring items scheduled into the procedure's appointment.
+func FindPlanItemForProcedure(db *gorm.DB, procedure *AppointmentProcedure, patientID uint) (*TreatmentPlanProcedure, error) {
+	var item TreatmentPlanProcedure
+	if procedure.TreatmentPlanProcedureID != nil {
+		if err := db.First(&item, *procedure.TreatmentPlanProcedureID).Error; err != nil {
+			return nil, err
+		}
+		return &item, nil
+	}
+
+	var template ProcedureTemplate
+	if err := db.Unscoped().First(&template, procedure.ProcedureTemplateID).Error; err != nil {
+		return nil, err
+	}
+
+	// A plan may list the platform template where the appointment uses the clinic's override
+	sameProcedure := db.Session(&gorm.Session{NewDB: true}).Model(&ProcedureTemplate{}).Select("id").
+		Where("id = ? OR (code <> '' AND code = ?)", template.ID, template.Code)
+	query := db.Model(&TreatmentPlanProcedure{}).
+		Joins("JOIN patient_treatment_plans ON patient_treatment_plans.id = treatment_plan_procedures.treatment_plan_id AND patient_treatment_plans.deleted_at IS NULL").
+		Where("patient_treatment_plans.patient_id = ? AND patient_treatment_plans.status = ?", patientID, "active").
+		Where("treatment_plan_procedures.procedure_template_id IN (?)", sameProcedure).
+		Where("treatment_plan_procedures.status IN ?", []string{PlanItemPlanned, PlanItemScheduled}).
+		Where("treatment_plan_procedures.appointment_procedure_id IS NULL OR treatment_plan_procedures.appointment_procedure_id = ?", procedure.ID)
+	if procedure.ToothNumber != "" {
+		query = query.Where("treatment_plan_procedures.tooth_number IN ?", []string{"", procedure.ToothNumber})
+	}
+	err := query.
+		Clauses(clause.OrderBy{Expression: clause.Expr{
+			SQL: "CASE WHEN treatment_plan_procedures.appointment_id = ? THEN 0 ELSE 1 END, " +
+				"CASE WHEN treatment_plan_procedures.tooth_number = ? THEN 0 ELSE 1 END, " +
+				"treatment_plan_procedures.sequence ASC, treatment_plan_procedures.id ASC",
+			Vars: []interface{}{procedure.AppointmentID, procedure.ToothNumber},
+		}}).
+		Take(&item).Error
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// CompletePlanItem marks a plan item done by an appointment procedure, linking the two and
+// taking the procedure's cost as the item's actual cost
+func CompletePlanItem(db *gorm.DB, item *TreatmentPlanProcedure, procedure *AppointmentProcedure) error {
+	now := time.Now()
+	item.Status = PlanItemCompleted
+	item.AppointmentID = &procedure.AppointmentID
+	item.AppointmentProcedureID = &procedure.ID
+	item.ActualCost = procedure.Cost
+	item.CompletedAt = &now
+	if err := db.Model(&TreatmentPlanProcedure{}).Where("id = ?", item.ID).Updates(map[string]interface{}{
+		"status":                   item.Status,
+		"appointment_id":           item.AppointmentID,
+		"appointment_procedure_id": item.AppointmentProcedureID,
+		"actual_cost":              item.ActualCost,
+		"completed_at":             item.CompletedAt,
+	}).Error; err != nil {
+		return err
+	}
+	procedure.TreatmentPlanProcedureID = &item.ID
+	return db.Model(&AppointmentProcedure{}).Where("id = ?", procedure.ID).
+		Update("treatment_plan_procedure_id", item.ID).Error
+}
+
+// UpdatePlanItemCost takes a completed plan item's actual cost from its appointment procedure
+// when the procedure's cost is corrected after it was done
+func UpdatePlanItemCost(db *gorm.DB, itemID uint, cost float64) error {
+	return db.Model(&TreatmentPlanProcedure{}).Where("id = ? AND status = ?", itemID, PlanItemCompleted).
+		Update("actual_cost", cost).Error
+}
+
+// ReopenPlanItem puts a completed plan item back to scheduled when its appointment procedure is
+// no longer completed
+func ReopenPlanItem(db *gorm.DB, itemID uint) error {
+	return db.Model(&TreatmentPlanProcedure{}).Where("id = ? AND status = ?", itemID, PlanItemCompleted).
+		Updates(map[string]interface{}{"status": PlanItemScheduled, "actual_cost": 0, "completed_at": nil}).Error
+}
+
+// UnschedulePlanItem returns a scheduled plan item to planned when its appointment procedure is
+// cancelled
+func UnschedulePlanItem(db *gorm.DB, itemID uint) error {
+	return db.Model(&TreatmentPlanProcedure{}).Where("id = ? AND status = ?", itemID, PlanItemScheduled).
+		Updates(map[string]interface{}{"status": PlanItemPlanned, "appointment_id": nil, "appointment_procedure_id": nil}).Error
+}
+
+// ReleasePlanItems returns the plan items scheduled into an appointment that won't take place to
+// planned, cancelling their appointment procedures, and recalculates the plans concerned
+func ReleasePlanItems(db *gorm.DB, appointmentID uint) error {
+	var planIDs []uint
+	if err := db.Model(&TreatmentPlanProcedure{}).Where("appointment_id = ? AND status = ?", appointmentID, PlanItemScheduled).
+		Distinct().Pluck("treatment_plan_id", &planIDs).Error; err != nil {
+		return err
+	}
+	if len(planIDs) == 0 {
+		return nil
+	}
+	if err := db.Model(&AppointmentProcedure{}).
+		Where("appointment_id = ? AND treatment_plan_procedure_id IS NOT NULL AND status NOT IN ?", appointmentID, []string{"completed", "cancelled"}).
+		Update("status", "cancelled").Error; err != nil {
+		return err
+	}
+	if err := db.Model(&TreatmentPlanProcedure{}).Where("appointment_id = ? AND status = ?", appointmentID, PlanItemScheduled).
+		Updates(map[string]interface{}{"status": PlanItemPlanned, "appointment_id": nil, "appointment_procedure_id": nil}).Error; err != nil {
+		return err
+	}
+	for _, planID := range planIDs {
+		if err := RecalculateTreatmentPlan(db, planID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MovePlanItems carries the plan items scheduled into an appointment, and their appointment
+// procedures, over to the appointment it was rescheduled to
+func MovePlanItems(db *gorm.DB, fromAppointmentID, toAppointmentID uint) error {
+	if err := db.Model(&AppointmentProcedure{}).
+		Where("appointment_id = ? AND treatment_plan_procedure_id IS NOT NULL AND status NOT IN ?", fromAppointmentID, []string{"completed", "cancelled"}).
+		Update("appointment_id", toAppointmentID).Error; err != nil {
+		return err
+	}
+	return db.Model(&TreatmentPlanProcedure{}).Where("appointment_id = ? AND status = ?", fromAppointmentID, PlanItemScheduled).
+		Update("appointment_id", toAppointmentID).Error
+}
@@ -0,0 +1,252 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type WaitlistStatus string
+
+const (
+	WaitlistWaiting   WaitlistStatus = "waiting"
+	WaitlistOffered   WaitlistStatus = "offered"
+	WaitlistBooked    WaitlistStatus = "booked"
+	WaitlistCancelled WaitlistStatus = "cancelled"
+)
+
+type WaitlistUrgency string
+
+const (
+	UrgencyLow    WaitlistUrgency = "low"
+	UrgencyNormal WaitlistUrgency = "normal"
+	UrgencyHigh   WaitlistUrgency = "high"
+	UrgencyUrgent WaitlistUrgency = "urgent"
+)
+
+// urgencyWeight orders entries by urgency before anything else
+var urgencyWeight = map[WaitlistUrgency]int{
+	UrgencyLow:    0,
+	UrgencyNormal: 1000,
+	UrgencyHigh:   2000,
+	UrgencyUrgent: 3000,
+}
+
+func IsValidWaitlistUrgency(u WaitlistUrgency) bool {
+	_, ok := urgencyWeight[u]
+	return ok
+}
+
+// WaitlistEntry is a patient waiting for an earlier or freed-up slot
+type WaitlistEntry struct {
+	ID        uint    `json:"id" gorm:"primarykey"`
+	PatientID uint    `json:"patient_id" gorm:"not null;index"`
+	Patient   Patient `json:"patient,omitempty" gorm:"foreignKey:PatientID"`
+
+	// Preferences; a nil doctor or branch means any
+	PreferredDoctorID   *uint              `json:"preferred_doctor_id" gorm:"index"`
+	PreferredDoctor     *User              `json:"preferred_doctor,omitempty" gorm:"foreignKey:PreferredDoctorID"`
+	BranchID            *uint              `json:"branch_id" gorm:"index"`
+	ProcedureTemplateID *uint              `json:"procedure_template_id"`
+	ProcedureTemplate   *ProcedureTemplate `json:"procedure_template,omitempty" gorm:"foreignKey:ProcedureTemplateID"`
+	Duration            int                `json:"duration" gorm:"default:30"` // minutes needed
+	Urgency             WaitlistUrgency    `json:"urgency" gorm:"type:varchar(20);default:'normal'"`
+	Notes               string             `json:"notes" gorm:"type:text"`
+
+	// Date range the patient can come in, inclusive
+	EarliestDate *time.Time `json:"earliest_date" gorm:"type:date"`
+	LatestDate   *time.Time `json:"latest_date" gorm:"type:date"`
+
+	// Times of the week the patient can come in; none means any time
+	TimeWindows []WaitlistTimeWindow `json:"time_windows,omitempty" gorm:"foreignKey:WaitlistEntryID"`
+
+	Status              WaitlistStatus `json:"status" gorm:"type:varchar(20);default:'waiting';index"`
+	BookedAppointmentID *uint          `json:"booked_appointment_id"`
+
+	CreatedByID uint `json:"created_by_id"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// WaitlistTimeWindow is a weekly period a waitlisted patient is free, e.g. weekdays 14:00-17:00
+type WaitlistTimeWindow struct {
+	ID              uint   `json:"id" gorm:"primarykey"`
+	WaitlistEntryID uint   `json:"waitlist_entry_id" gorm:"not null;index"`
+	DayOfWeek       *int   `json:"day_of_week"`                       // 0 = Sunday ... 6 = Saturday, nil for every day
+	StartTime       string `json:"start_time" gorm:"size:5;not null"` // HH:MM
+	EndTime         string `json:"end_time" gorm:"size:5;not null"`   // HH:MM
+}
+
+func (w *WaitlistTimeWindow) Validate() error {
+	if w.DayOfWeek != nil && (*w.DayOfWeek < 0 || *w.DayOfWeek > 6) {
+		return fmt.Errorf("day_of_week must be between 0 (Sunday) and 6 (Saturday)")
+	}
+	start, err := ParseClock(w.StartTime)
+	if err != nil {
+		return err
+	}
+	end, err := ParseClock(w.EndTime)
+	if err != nil {
+		return err
+	}
+	if end <= start {
+		return fmt.Errorf("end_time must be after start_time")
+	}
+	return nil
+}
+
+// Covers reports whether the window contains the whole of start-end, read in start's location
+func (w *WaitlistTimeWindow) Covers(start, end time.Time) bool {
+	if w.DayOfWeek != nil && int(start.Weekday()) != *w.DayOfWeek {
+		return false
+	}
+	from, err := ParseClock(w.StartTime)
+	if err != nil {
+		return false
+	}
+	to, err := ParseClock(w.EndTime)
+	if err != nil {
+		return false
+	}
+	return ClockRange(start, from, to).Contains(TimeRange{Start: start, End: end})
+}
+
+// WaitlistSlot is a freed appointment time that waitlisted patients may fill
+type WaitlistSlot struct {
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	DoctorID  uint      `json:"doctor_id"`
+	BranchID  uint      `json:"branch_id"`
+}
+
+// Fits reports whether the entry can take the slot: the patient is free then, the branch matches
+// and the slot is long enough. A preferred doctor only affects ranking.
+func (e *WaitlistEntry) Fits(slot WaitlistSlot) bool {
+	if e.Status != WaitlistWaiting {
+		return false
+	}
+	if e.BranchID != nil && *e.BranchID != slot.BranchID {
+		return false
+	}
+	if slot.EndTime.Sub(slot.StartTime) < time.Duration(e.Duration)*time.Minute {
+		return false
+	}
+	day := slot.StartTime.Format("2006-01-02")
+	if e.EarliestDate != nil && day < e.EarliestDate.Format("2006-01-02") {
+		return false
+	}
+	if e.LatestDate != nil && day > e.LatestDate.Format("2006-01-02") {
+		return false
+	}
+	if len(e.TimeWindows) == 0 {
+		return true
+	}
+	end := slot.StartTime.Add(time.Duration(e.Duration) * time.Minute)
+	for _, window := range e.TimeWindows {
+		if window.Covers(slot.StartTime, end) {
+			return true
+		}
+	}
+	return false
+}
+
+// Score ranks an entry for a slot: urgency first, then a matching preferred doctor, then time waited
+func (e *WaitlistEntry) Score(slot WaitlistSlot, now time.Time) int {
+	score := urgencyWeight[e.Urgency]
+	if e.PreferredDoctorID != nil && *e.PreferredDoctorID == slot.DoctorID {
+		score += 500
+	}
+	waited := int(now.Sub(e.CreatedAt).Hours() / 24)
+	if waited > 365 {
+		waited = 365
+	}
+	return score + waited
+}
+
+// WaitlistMatch is a ranked candidate for a slot
+type WaitlistMatch struct {
+	Entry WaitlistEntry `json:"entry"`
+	Score int           `json:"score"`
+}
+
+// RankWaitlist returns the entries that fit the slot, best first. Ties go to whoever joined first.
+func RankWaitlist(entries []WaitlistEntry, slot WaitlistSlot, now time.Time) []WaitlistMatch {
+	matches := []WaitlistMatch{}
+	for _, entry := range entries {
+		if entry.Fits(slot) {
+			matches = append(matches, WaitlistMatch{Entry: entry, Score: entry.Score(slot, now)})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Entry.CreatedAt.Before(matches[j].Entry.CreatedAt)
+	})
+	return matches
+}
+
+type WaitlistOfferStatus string
+
+const (
+	OfferPending    WaitlistOfferStatus = "pending"
+	OfferAccepted   WaitlistOfferStatus = "accepted"
+	OfferDeclined   WaitlistOfferStatus = "declined"
+	OfferSuperseded WaitlistOfferStatus = "superseded" // the slot went to someone else
+)
+
+// DefaultWaitlistOfferTTL is how long a patient has to accept an offered slot
+const DefaultWaitlistOfferTTL = 2 * time.Hour
+
+// WaitlistOffer offers a freed slot to a waitlisted patient through a public accept link
+type WaitlistOffer struct {
+	ID              uint          `json:"id" gorm:"primarykey"`
+	Token           string        `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	WaitlistEntryID uint          `json:"waitlist_entry_id" gorm:"not null;index"`
+	WaitlistEntry   WaitlistEntry `json:"waitlist_entry,omitempty" gorm:"foreignKey:WaitlistEntryID"`
+
+	// The slot on offer
+	StartTime time.Time `json:"start_time" gorm:"not null;index"`
+	EndTime   time.Time `json:"end_time" gorm:"not null"`
+	DoctorID  uint      `json:"doctor_id" gorm:"not null"`
+	Doctor    User      `json:"doctor,omitempty" gorm:"foreignKey:DoctorID"`
+	BranchID  uint      `json:"branch_id" gorm:"not null"`
+	Branch    Branch    `json:"branch,omitempty" gorm:"foreignKey:BranchID"`
+
+	// The cancelled appointment that freed the slot, if any
+	SourceAppointmentID *uint `json:"source_appointment_id" gorm:"index"`
+
+	Status        WaitlistOfferStatus `json:"status" gorm:"type:varchar(20);default:'pending';index"`
+	ExpiresAt     time.Time           `json:"expires_at" gorm:"not null"`
+	RespondedAt   *time.Time          `json:"responded_at"`
+	AppointmentID *uint               `json:"appointment_id"` // booked on acceptance
+
+	OfferedByID uint `json:"offered_by_id"`
+
+	// Clinic scoping for multi-tenancy
+	ClinicID uint `json:"clinic_id" gorm:"not null;index"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (o *WaitlistOffer) IsExpired() bool {
+	return time.Now().After(o.ExpiresAt)
+}
+
+// IsOpen reports whether the patient can still accept or decline the offer
+func (o *WaitlistOffer) IsOpen() bool {
+	return o.Status == OfferPending && !o.IsExpired()
+}
+
+// Slot returns the time on offer
+func (o *WaitlistOffer) Slot() WaitlistSlot {
+	return WaitlistSlot{StartTime: o.StartTime, EndTime: o.EndTime, DoctorID: o.DoctorID, BranchID: o.BranchID}
+}
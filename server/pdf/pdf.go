@@ -0,0 +1,479 @@
+// Package pdf is a small, dependency-free PDF writer used for generated clinic
+// documents. It supports the standard Helvetica fonts, word-wrapped text,
+// simple vector shapes and raster images on A4 pages.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"strings"
+)
+
+// Page dimensions in points (A4)
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+type Align int
+
+const (
+	AlignLeft Align = iota
+	AlignCenter
+	AlignRight
+)
+
+type imageObject struct {
+	name   string
+	width  int
+	height int
+	data   []byte // zlib-compressed RGB samples
+}
+
+// Document is an in-memory PDF being laid out top-to-bottom
+type Document struct {
+	Margin float64
+
+	pages   []*bytes.Buffer
+	current *bytes.Buffer
+	y       float64 // distance from the top edge of the current page
+
+	bold     bool
+	fontSize float64
+
+	images []*imageObject
+	footer string
+
+	footersWritten bool
+}
+
+// New creates a document with a single empty A4 page
+func New() *Document {
+	d := &Document{
+		Margin:   50,
+		fontSize: 11,
+	}
+	d.AddPage()
+	return d
+}
+
+// AddPage starts a new page and moves the cursor to the top margin
+func (d *Document) AddPage() {
+	d.current = &bytes.Buffer{}
+	d.pages = append(d.pages, d.current)
+	d.y = d.Margin
+}
+
+// SetFooter sets text printed at the bottom of every page, next to the page number
+func (d *Document) SetFooter(text string) {
+	d.footer = text
+}
+
+func (d *Document) SetFont(bold bool, size float64) {
+	d.bold = bold
+	d.fontSize = size
+}
+
+// Y returns the cursor position measured from the top of the page
+func (d *Document) Y() float64 {
+	return d.y
+}
+
+// ContentWidth is the usable width between the left and right margins
+func (d *Document) ContentWidth() float64 {
+	return PageWidth - 2*d.Margin
+}
+
+func (d *Document) lineHeight() float64 {
+	return d.fontSize * 1.35
+}
+
+// Ln moves the cursor down by h points
+func (d *Document) Ln(h float64) {
+	d.y += h
+}
+
+// EnsureSpace starts a new page if less than h points remain above the bottom margin
+func (d *Document) EnsureSpace(h float64) {
+	if d.y+h > PageHeight-d.Margin {
+		d.AddPage()
+	}
+}
+
+// TextWidth measures s in the current font
+func (d *Document) TextWidth(s string) float64 {
+	widths := &helveticaWidths
+	if d.bold {
+		widths = &helveticaBoldWidths
+	}
+
+	total := 0
+	for _, b := range encodeWinAnsi(s) {
+		if b >= 32 && b <= 126 {
+			total += widths[b-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * d.fontSize / 1000
+}
+
+// Cell writes a single line of text within [x, x+width] at the cursor without advancing it
+func (d *Document) Cell(x, width float64, s string, align Align) {
+	switch align {
+	case AlignCenter:
+		x += (width - d.TextWidth(s)) / 2
+	case AlignRight:
+		x += width - d.TextWidth(s)
+	}
+	d.writeText(d.current, x, d.y, s)
+}
+
+// Text writes a word-wrapped paragraph across the content width and advances the cursor
+func (d *Document) Text(s string) {
+	d.TextIndent(0, s)
+}
+
+// TextIndent writes a word-wrapped paragraph starting indent points from the left margin
+func (d *Document) TextIndent(indent float64, s string) {
+	width := d.ContentWidth() - indent
+	for _, paragraph := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		lines := d.wrap(paragraph, width)
+		if len(lines) == 0 {
+			d.EnsureSpace(d.lineHeight())
+			d.y += d.lineHeight()
+			continue
+		}
+		for _, line := range lines {
+			d.EnsureSpace(d.lineHeight())
+			d.writeText(d.current, d.Margin+indent, d.y, line)
+			d.y += d.lineHeight()
+		}
+	}
+}
+
+// HLine draws a horizontal rule across the content width at the cursor
+func (d *Document) HLine() {
+	top := PageHeight - d.y
+	fmt.Fprintf(d.current, "0.5 w %.2f %.2f m %.2f %.2f l S\n", d.Margin, top, PageWidth-d.Margin, top)
+}
+
+// Line draws a line between two points given in top-left page coordinates
+func (d *Document) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(d.current, "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, PageHeight-y1, x2, PageHeight-y2)
+}
+
+// Checkbox draws a ticked or empty box followed by a wrapped label
+func (d *Document) Checkbox(checked bool, label string) {
+	size := d.fontSize
+	d.EnsureSpace(d.lineHeight())
+
+	x := d.Margin
+	top := d.y + (d.lineHeight()-size)/2
+	fmt.Fprintf(d.current, "0.8 w %.2f %.2f %.2f %.2f re S\n", x, PageHeight-top-size, size, size)
+	if checked {
+		fmt.Fprintf(d.current, "1.2 w %.2f %.2f m %.2f %.2f l %.2f %.2f l S\n",
+			x+size*0.2, PageHeight-top-size*0.55,
+			x+size*0.42, PageHeight-top-size*0.8,
+			x+size*0.82, PageHeight-top-size*0.2)
+	}
+
+	d.TextIndent(size+8, label)
+}
+
+// Image draws img with its top-left corner at (x, cursor) scaled to width x height points.
+// The cursor is not advanced.
+func (d *Document) Image(img image.Image, x, width, height float64) error {
+	obj, err := newImageObject(fmt.Sprintf("Im%d", len(d.images)+1), img)
+	if err != nil {
+		return err
+	}
+	d.images = append(d.images, obj)
+
+	fmt.Fprintf(d.current, "q %.2f 0 0 %.2f %.2f %.2f cm /%s Do Q\n",
+		width, height, x, PageHeight-d.y-height, obj.name)
+	return nil
+}
+
+// WriteFile renders the document and writes it to path
+func (d *Document) WriteFile(path string) error {
+	data, err := d.Bytes()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Bytes renders the complete PDF file
+func (d *Document) Bytes() ([]byte, error) {
+	// Footers carry the page count, so they go on once the layout is finished
+	if !d.footersWritten {
+		d.writeFooters()
+		d.footersWritten = true
+	}
+
+	var out bytes.Buffer
+	var offsets []int
+
+	beginObject := func() int {
+		offsets = append(offsets, out.Len())
+		id := len(offsets)
+		fmt.Fprintf(&out, "%d 0 obj\n", id)
+		return id
+	}
+	endObject := func() {
+		out.WriteString("endobj\n")
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Object layout: 1 catalog, 2 page tree, 3-4 fonts, then images, then page/content pairs
+	firstImage := 5
+	firstPage := firstImage + len(d.images)
+
+	beginObject()
+	out.WriteString("<< /Type /Catalog /Pages 2 0 R >>\n")
+	endObject()
+
+	beginObject()
+	var kids []string
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", firstPage+i*2))
+	}
+	fmt.Fprintf(&out, "<< /Type /Pages /Kids [%s] /Count %d >>\n", strings.Join(kids, " "), len(d.pages))
+	endObject()
+
+	beginObject()
+	out.WriteString("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>\n")
+	endObject()
+
+	beginObject()
+	out.WriteString("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>\n")
+	endObject()
+
+	for _, img := range d.images {
+		beginObject()
+		fmt.Fprintf(&out, "<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n",
+			img.width, img.height, len(img.data))
+		out.Write(img.data)
+		out.WriteString("\nendstream\n")
+		endObject()
+	}
+
+	var xobjects strings.Builder
+	for i, img := range d.images {
+		fmt.Fprintf(&xobjects, "/%s %d 0 R ", img.name, firstImage+i)
+	}
+	resources := "<< /Font << /F1 3 0 R /F2 4 0 R >>"
+	if xobjects.Len() > 0 {
+		resources += " /XObject << " + xobjects.String() + ">>"
+	}
+	resources += " >>"
+
+	for i, page := range d.pages {
+		content, err := deflate(page.Bytes())
+		if err != nil {
+			return nil, err
+		}
+
+		pageID := beginObject()
+		fmt.Fprintf(&out, "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources %s /Contents %d 0 R >>\n",
+			PageWidth, PageHeight, resources, pageID+1)
+		endObject()
+
+		beginObject()
+		fmt.Fprintf(&out, "<< /Filter /FlateDecode /Length %d >>\nstream\n", len(content))
+		out.Write(content)
+		out.WriteString("\nendstream\n")
+		endObject()
+
+		if firstPage+i*2 != pageID {
+			return nil, fmt.Errorf("pdf: unexpected object number %d for page %d", pageID, i+1)
+		}
+	}
+
+	xrefOffset := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xrefOffset)
+
+	return out.Bytes(), nil
+}
+
+func (d *Document) writeFooters() {
+	bold, size := d.bold, d.fontSize
+	d.SetFont(false, 8)
+
+	total := len(d.pages)
+	y := PageHeight - d.Margin/2 - d.fontSize
+	for i, page := range d.pages {
+		if d.footer != "" {
+			d.writeText(page, d.Margin, y, d.footer)
+		}
+		label := fmt.Sprintf("Page %d of %d", i+1, total)
+		d.writeText(page, PageWidth-d.Margin-d.TextWidth(label), y, label)
+	}
+
+	d.SetFont(bold, size)
+}
+
+func (d *Document) writeText(buf *bytes.Buffer, x, top float64, s string) {
+	font := "F1"
+	if d.bold {
+		font = "F2"
+	}
+	baseline := PageHeight - top - d.fontSize
+	fmt.Fprintf(buf, "BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n", font, d.fontSize, x, baseline, escape(encodeWinAnsi(s)))
+}
+
+// wrap splits a paragraph into lines no wider than width
+func (d *Document) wrap(s string, width float64) []string {
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		return nil
+	}
+
+	var lines []string
+	line := ""
+	for _, word := range words {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if d.TextWidth(candidate) <= width {
+			line = candidate
+			continue
+		}
+
+		if line != "" {
+			lines = append(lines, line)
+			line = ""
+		}
+
+		// Hard-break words that are wider than the line on their own
+		for d.TextWidth(word) > width {
+			runes := []rune(word)
+			cut := len(runes)
+			for cut > 1 && d.TextWidth(string(runes[:cut])) > width {
+				cut--
+			}
+			lines = append(lines, string(runes[:cut]))
+			word = string(runes[cut:])
+		}
+		line = word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+func newImageObject(name string, img image.Image) (*imageObject, error) {
+	bounds := img.Bounds()
+	rgb := make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+
+	// Flatten transparency onto white so canvas signatures render as ink on paper
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			a := uint32(c.A)
+			rgb = append(rgb,
+				byte((uint32(c.R)*a+255*(255-a))/255),
+				byte((uint32(c.G)*a+255*(255-a))/255),
+				byte((uint32(c.B)*a+255*(255-a))/255),
+			)
+		}
+	}
+
+	data, err := deflate(rgb)
+	if err != nil {
+		return nil, err
+	}
+
+	return &imageObject{
+		name:   name,
+		width:  bounds.Dx(),
+		height: bounds.Dy(),
+		data:   data,
+	}, nil
+}
+
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func escape(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		switch c {
+		case '(', ')', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// winAnsiExtras maps the punctuation commonly pasted into templates onto WinAnsiEncoding
+var winAnsiExtras = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92,
+	'“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+// encodeWinAnsi converts s to the single-byte encoding used by the standard fonts
+func encodeWinAnsi(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r == '\t':
+			out = append(out, ' ', ' ', ' ', ' ')
+		case r < 32:
+			continue
+		case r < 127 || (r >= 160 && r <= 255):
+			out = append(out, byte(r))
+		default:
+			if b, ok := winAnsiExtras[r]; ok {
+				out = append(out, b)
+			} else {
+				out = append(out, '?')
+			}
+		}
+	}
+	return out
+}
+
+// Glyph widths for characters 32-126, in 1/1000 em
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"dentika/server/models"
+)
+
+// MessageChannel delivers outbound messages through one provider.
+// Send returns the provider's message ID when it has one.
+type MessageChannel interface {
+	Name() string
+	Send(ctx context.Context, message *models.OutboundMessage) (string, error)
+}
+
+// SMTPConfig configures the SMTP email channel
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// smtpTimeout bounds one message's whole SMTP exchange
+const smtpTimeout = time.Minute
+
+// SMTPEmailChannel sends email through an SMTP server. Port 465 uses implicit TLS;
+// other ports upgrade with STARTTLS when the server offers it.
+type SMTPEmailChannel struct {
+	config SMTPConfig
+}
+
+func NewSMTPEmailChannel(config SMTPConfig) *SMTPEmailChannel {
+	if config.Port == "" {
+		config.Port = "587"
+	}
+	return &SMTPEmailChannel{config: config}
+}
+
+func (ch *SMTPEmailChannel) Name() string {
+	return "smtp"
+}
+
+func (ch *SMTPEmailChannel) Send(ctx context.Context, message *models.OutboundMessage) (string, error) {
+	messageID := fmt.Sprintf("<%d.%d@dentika>", message.ID, time.Now().UnixNano())
+
+	var body bytes.Buffer
+	body.WriteString("From: " + ch.config.From + "\r\n")
+	body.WriteString("To: " + message.Recipient + "\r\n")
+	body.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", message.Subject) + "\r\n")
+	body.WriteString("Message-ID: " + messageID + "\r\n")
+	body.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	body.WriteString("MIME-Version: 1.0\r\n")
+	if message.Attachment == "" {
+		body.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+		body.WriteString("\r\n")
+		body.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	} else if err := writeMultipartBody(&body, message); err != nil {
+		return "", err
+	}
+
+	addr := net.JoinHostPort(ch.config.Host, ch.config.Port)
+	var auth smtp.Auth
+	if ch.config.Username != "" {
+		auth = smtp.PlainAuth("", ch.config.Username, ch.config.Password, ch.config.Host)
+	}
+
+	// The whole exchange runs against a deadline, so a stalled server can't hold up the outbox
+	dialer := &net.Dialer{Timeout: 15 * time.Second}
+	var conn net.Conn
+	var err error
+	if ch.config.Port == "465" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: ch.config.Host})
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return "", err
+	}
+	deadline := time.Now().Add(smtpTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return "", err
+	}
+	client, err := smtp.NewClient(conn, ch.config.Host)
+	if err != nil {
+		conn.Close()
+		return "", err
+	}
+	defer client.Close()
+
+	if ch.config.Port != "465" {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: ch.config.Host}); err != nil {
+				return "", err
+			}
+		}
+	}
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return "", err
+		}
+	}
+	if err := client.Mail(ch.config.From); err != nil {
+		return "", err
+	}
+	if err := client.Rcpt(message.Recipient); err != nil {
+		return "", err
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return "", err
+	}
+	if _, err := writer.Write(body.Bytes()); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return messageID, client.Quit()
+}
+
+// writeMultipartBody writes the text body and the attachment as a multipart/mixed message
+func writeMultipartBody(body *bytes.Buffer, message *models.OutboundMessage) error {
+	writer := multipart.NewWriter(body)
+	body.WriteString("Content-Type: multipart/mixed; boundary=" + writer.Boundary() + "\r\n")
+	body.WriteString("\r\n")
+
+	text, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/plain; charset=UTF-8"},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := text.Write([]byte(strings.ReplaceAll(message.Body, "\n", "\r\n"))); err != nil {
+		return err
+	}
+
+	contentType := message.AttachmentContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	attachment, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": message.AttachmentName})},
+	})
+	if err != nil {
+		return err
+	}
+	// Base64 in lines of 76 characters, as MIME requires
+	encoded := base64.StdEncoding.EncodeToString([]byte(message.Attachment))
+	var lines strings.Builder
+	for len(encoded) > 76 {
+		lines.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	lines.WriteString(encoded + "\r\n")
+	if _, err := attachment.Write([]byte(lines.String())); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// HTTPSMSConfig configures the generic HTTP SMS gateway channel
+type HTTPSMSConfig struct {
+	URL      string
+	Token    string
+	SenderID string
+}
+
+// HTTPSMSChannel posts SMS messages as JSON to a gateway:
+// {"to": "...", "message": "...", "sender": "..."} with a bearer token.
+// Any 2xx response is a success; an "id" or "message_id" in the response is kept.
+type HTTPSMSChannel struct {
+	config HTTPSMSConfig
+	client *http.Client
+}
+
+func NewHTTPSMSChannel(config HTTPSMSConfig) *HTTPSMSChannel {
+	return &HTTPSMSChannel{config: config, client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+func (ch *HTTPSMSChannel) Name() string {
+	return "http_sms"
+}
+
+func (ch *HTTPSMSChannel) Send(ctx context.Context, message *models.OutboundMessage) (string, error) {
+	payload, err := json.Marshal(map[string]string{
+		"to":      message.Recipient,
+		"message": message.Body,
+		"sender":  ch.config.SenderID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ch.config.URL, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if ch.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+ch.config.Token)
+	}
+
+	resp, err := ch.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("SMS gateway returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	var result struct {
+		ID        interface{} `json:"id"`
+		MessageID interface{} `json:"message_id"`
+	}
+	if json.Unmarshal(respBody, &result) == nil {
+		if result.MessageID != nil {
+			return fmt.Sprint(result.MessageID), nil
+		}
+		if result.ID != nil {
+			return fmt.Sprint(result.ID), nil
+		}
+	}
+	return "", nil
+}
+
+// LogChannel is a development stand-in that writes messages to a file, or to the server log
+// when no file is configured, instead of sending them
+type LogChannel struct {
+	channel models.MessageChannelType
+	path    string
+	mu      sync.Mutex
+}
+
+func NewLogChannel(channel models.MessageChannelType, path string) *LogChannel {
+	return &LogChannel{channel: channel, path: path}
+}
+
+func (ch *LogChannel) Name() string {
+	return "log"
+}
+
+func (ch *LogChannel) Send(ctx context.Context, message *models.OutboundMessage) (string, error) {
+	entry := fmt.Sprintf("[%s] %s to %s\nSubject: %s\n%s\n",
+		time.Now().Format(time.RFC3339), strings.ToUpper(string(ch.channel)), message.Recipient, message.Subject, message.Body)
+	if message.Attachment != "" && ch.channel == models.ChannelEmail {
+		entry += fmt.Sprintf("Attachment: %s (%s, %d bytes)\n", message.AttachmentName, message.AttachmentContentType, len(message.Attachment))
+	}
+	entry += "---\n"
+
+	if ch.path == "" {
+		log.Print(entry)
+		return fmt.Sprintf("log-%d", message.ID), nil
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(ch.path), 0755); err != nil {
+		return "", err
+	}
+	file, err := os.OpenFile(ch.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(entry); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("log-%d", message.ID), nil
+}
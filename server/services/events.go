@@ -0,0 +1,30 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// EventPublisher publishes JSON events to NATS subjects for live screens such as the queue board
+type EventPublisher struct {
+	nats *nats.Conn
+}
+
+func NewEventPublisher(natsConn *nats.Conn) *EventPublisher {
+	return &EventPublisher{nats: natsConn}
+}
+
+// Publish marshals the event to JSON and publishes it on the subject
+func (p *EventPublisher) Publish(subject string, event interface{}) error {
+	if p == nil || p.nats == nil {
+		return fmt.Errorf("NATS connection not available")
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+	return p.nats.Publish(subject, data)
+}
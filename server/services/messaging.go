@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrChannelNotConfigured is returned when a message is queued for a channel with no provider
+var ErrChannelNotConfigured = errors.New("message channel is not configured")
+
+// MessagingService queues outbound patient messages and delivers them through the configured channels
+type MessagingService struct {
+	db       *gorm.DB
+	channels map[models.MessageChannelType]MessageChannel
+}
+
+func NewMessagingService(channels map[models.MessageChannelType]MessageChannel) *MessagingService {
+	return &MessagingService{
+		db:       database.DB,
+		channels: channels,
+	}
+}
+
+// NewMessagingServiceFromEnv builds the channels from the environment.
+//
+//	EMAIL_DRIVER=smtp|log  SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD, SMTP_FROM
+//	SMS_DRIVER=http|log    SMS_GATEWAY_URL, SMS_GATEWAY_TOKEN, SMS_SENDER_ID
+//	MESSAGING_LOG_FILE     file the log driver appends to (server log when empty)
+//
+// Drivers default to log, so development setups never send real messages.
+func NewMessagingServiceFromEnv() *MessagingService {
+	logFile := os.Getenv("MESSAGING_LOG_FILE")
+	channels := map[models.MessageChannelType]MessageChannel{}
+
+	switch driver := os.Getenv("EMAIL_DRIVER"); driver {
+	case "smtp":
+		channels[models.ChannelEmail] = NewSMTPEmailChannel(SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		})
+	case "", "log":
+		channels[models.ChannelEmail] = NewLogChannel(models.ChannelEmail, logFile)
+	default:
+		log.Printf("Unknown EMAIL_DRIVER %q, email is disabled", driver)
+	}
+
+	switch driver := os.Getenv("SMS_DRIVER"); driver {
+	case "http":
+		channels[models.ChannelSMS] = NewHTTPSMSChannel(HTTPSMSConfig{
+			URL:      os.Getenv("SMS_GATEWAY_URL"),
+			Token:    os.Getenv("SMS_GATEWAY_TOKEN"),
+			SenderID: os.Getenv("SMS_SENDER_ID"),
+		})
+	case "", "log":
+		channels[models.ChannelSMS] = NewLogChannel(models.ChannelSMS, logFile)
+	default:
+		log.Printf("Unknown SMS_DRIVER %q, SMS is disabled", driver)
+	}
+
+	for channel, provider := range channels {
+		log.Printf("Messaging: %s via %s", channel, provider.Name())
+	}
+	return NewMessagingService(channels)
+}
+
+// HasChannel reports whether a provider is configured for the channel
+func (ms *MessagingService) HasChannel(channel models.MessageChannelType) bool {
+	_, ok := ms.channels[channel]
+	return ok
+}
+
+// Enqueue stores a message for delivery on the next outbox run
+func (ms *MessagingService) Enqueue(message *models.OutboundMessage) error {
+	if !ms.HasChannel(message.Channel) {
+		return ErrChannelNotConfigured
+	}
+	if message.Recipient == "" {
+		return fmt.Errorf("message has no recipient")
+	}
+
+	message.Status = models.MessageStatusPending
+	message.NextAttemptAt = time.Now()
+	if message.MaxAttempts == 0 {
+		message.MaxAttempts = 5
+	}
+	return ms.db.Create(message).Error
+}
+
+// sendingLease is how long a message may stay claimed. A send is bounded well within it, so a
+// message still sending after that was left behind by a process that stopped mid-delivery.
+const sendingLease = 15 * time.Minute
+
+// ProcessOutbox sends pending messages that are due. Each message is claimed by moving it
+// from pending to sending, so overlapping runs and replicas cannot send it twice.
+func (ms *MessagingService) ProcessOutbox(ctx context.Context) error {
+	if err := ms.reclaimStaleMessages(); err != nil {
+		log.Printf("Failed to reclaim interrupted messages: %v", err)
+	}
+
+	var messages []models.OutboundMessage
+	err := ms.db.Where("status = ? AND next_attempt_at <= ?", models.MessageStatusPending, time.Now()).
+		Order("next_attempt_at ASC").
+		Limit(100).
+		Find(&messages).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		ms.deliver(ctx, &messages[i])
+	}
+	return nil
+}
+
+// reclaimStaleMessages counts a claim that outlived its lease as a failed attempt, putting the
+// message back in the queue, or failing it once it is out of attempts
+func (ms *MessagingService) reclaimStaleMessages() error {
+	cutoff := time.Now().Add(-sendingLease)
+	stale := func() *gorm.DB {
+		return ms.db.Model(&models.OutboundMessage{}).Where("status = ? AND updated_at < ?", models.MessageStatusSending, cutoff)
+	}
+	const interrupted = "delivery was interrupted"
+	if err := stale().Where("attempts + 1 >= max_attempts").Updates(map[string]interface{}{
+		"status":     models.MessageStatusFailed,
+		"attempts":   gorm.Expr("attempts + 1"),
+		"last_error": interrupted,
+	}).Error; err != nil {
+		return err
+	}
+	return stale().Updates(map[string]interface{}{
+		"status":          models.MessageStatusPending,
+		"attempts":        gorm.Expr("attempts + 1"),
+		"last_error":      interrupted,
+		"next_attempt_at": time.Now(),
+	}).Error
+}
+
+func (ms *MessagingService) deliver(ctx context.Context, message *models.OutboundMessage) {
+	claim := ms.db.Model(&models.OutboundMessage{}).
+		Where("id = ? AND status = ?", message.ID, models.MessageStatusPending).
+		Update("status", models.MessageStatusSending)
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		return
+	}
+
+	channel, ok := ms.channels[message.Channel]
+	if !ok {
+		message.RecordFailure(ErrChannelNotConfigured)
+	} else if providerID, err := channel.Send(ctx, message); err != nil {
+		message.RecordFailure(err)
+	} else {
+		message.RecordSuccess(channel.Name(), providerID)
+	}
+
+	if message.Status == models.MessageStatusFailed {
+		log.Printf("Giving up on %s message %d to %s after %d attempts: %s",
+			message.Channel, message.ID, message.Recipient, message.Attempts, message.LastError)
+	}
+
+	err := ms.db.Model(&models.OutboundMessage{}).Where("id = ?", message.ID).Updates(map[string]interface{}{
+		"status":              message.Status,
+		"attempts":            message.Attempts,
+		"next_attempt_at":     message.NextAttemptAt,
+		"last_error":          message.LastError,
+		"sent_at":             message.SentAt,
+		"provider":            message.Provider,
+		"provider_message_id": message.ProviderMessageID,
+	}).Error
+	if err != nil {
+		log.Printf("Failed to record delivery status for message %d: %v", message.ID, err)
+	}
+}
+
+// Retry queues a failed message for another round of attempts
+func (ms *MessagingService) Retry(message *models.OutboundMessage) error {
+	result := ms.db.Model(&models.OutboundMessage{}).
+		Where("id = ? AND status = ?", message.ID, models.MessageStatusFailed).
+		Updates(map[string]interface{}{
+			"status":          models.MessageStatusPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("message is not in a failed state")
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"dentika/server/database"
+	"dentika/server/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrJobNotFound      = errors.New("job not found")
+	ErrJobAlreadyLocked = errors.New("job is already running")
+)
+
+// JobFunc is the work a scheduled job performs
+type JobFunc func(ctx context.Context) error
+
+// Job is a named unit of background work run on an interval or cron schedule
+type Job struct {
+	Name        string
+	Description string
+	Interval    time.Duration // used when Cron is nil
+	Cron        *CronSchedule
+	Timeout     time.Duration // also how long the lock is held; defaults to 10 minutes
+	Run         JobFunc
+}
+
+func (j *Job) schedule() string {
+	if j.Cron != nil {
+		return j.Cron.String()
+	}
+	return "every " + j.Interval.String()
+}
+
+// next returns when the job should run after t. Cron schedules follow the default clinic
+// timezone, so "0 3 * * *" runs at 3 AM clinic time whatever the server's zone.
+func (j *Job) next(t time.Time) time.Time {
+	if j.Cron != nil {
+		return j.Cron.Next(t.In(models.LoadLocation(models.DefaultTimezone)))
+	}
+	return t.Add(j.Interval)
+}
+
+// JobInfo combines a registered job with its shared state
+type JobInfo struct {
+	models.ScheduledJob
+	Running bool `json:"running"`
+}
+
+// Scheduler runs registered jobs in-process. Job state lives in the scheduled_jobs table,
+// and runs are claimed with a conditional update so replicas never run a job twice.
+type Scheduler struct {
+	db         *gorm.DB
+	instanceID string
+	tick       time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewScheduler() *Scheduler {
+	hostname, _ := os.Hostname()
+	return &Scheduler{
+		db:         database.DB,
+		instanceID: fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		tick:       15 * time.Second,
+		jobs:       map[string]*Job{},
+		stop:       make(chan struct{}),
+	}
+}
+
+// Every registers a job that runs on a fixed interval
+func (s *Scheduler) Every(name, description string, interval time.Duration, run JobFunc) error {
+	if interval <= 0 {
+		return fmt.Errorf("job %s: interval must be positive", name)
+	}
+	return s.Register(&Job{Name: name, Description: description, Interval: interval, Run: run})
+}
+
+// Cron registers a job that runs on a cron expression, evaluated in the default timezone
+// (models.DefaultTimezone)
+func (s *Scheduler) Cron(name, description, expr string, run JobFunc) error {
+	schedule, err := ParseCron(expr)
+	if err != nil {
+		return fmt.Errorf("job %s: %v", name, err)
+	}
+	return s.Register(&Job{Name: name, Description: description, Cron: schedule, Run: run})
+}
+
+// Register adds a job and makes sure it has a state row
+func (s *Scheduler) Register(job *Job) error {
+	if job.Timeout <= 0 {
+		job.Timeout = 10 * time.Minute
+	}
+
+	s.mu.Lock()
+	if _, exists := s.jobs[job.Name]; exists {
+		s.mu.Unlock()
+		return fmt.Errorf("job %s is already registered", job.Name)
+	}
+	s.jobs[job.Name] = job
+	s.mu.Unlock()
+
+	next := job.next(time.Now())
+	state := models.ScheduledJob{
+		Name:        job.Name,
+		Description: job.Description,
+		Schedule:    job.schedule(),
+		IsEnabled:   true,
+		LastStatus:  models.JobStatusIdle,
+		NextRunAt:   &next,
+	}
+
+	var existing models.ScheduledJob
+	if err := s.db.Where("name = ?", job.Name).First(&existing).Error; err == nil {
+		// Keep run history, but pick up schedule changes from code
+		updates := map[string]interface{}{"description": job.Description, "schedule": state.Schedule}
+		if existing.Schedule != state.Schedule || existing.NextRunAt == nil {
+			updates["next_run_at"] = next
+		}
+		return s.db.Model(&existing).Updates(updates).Error
+	}
+	return s.db.Create(&state).Error
+}
+
+// Start runs the scheduler loop until Stop is called
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.tick)
+		defer ticker.Stop()
+
+		log.Printf("Job scheduler started (%s, %d jobs)", s.instanceID, len(s.jobs))
+		s.runDueJobs()
+		for {
+			select {
+			case <-ticker.C:
+				s.runDueJobs()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops scheduling new runs and waits for running jobs to finish
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+func (s *Scheduler) runDueJobs() {
+	var due []models.ScheduledJob
+	if err := s.db.Where("is_enabled = ? AND next_run_at <= ?", true, time.Now()).Find(&due).Error; err != nil {
+		log.Printf("Job scheduler: failed to load due jobs: %v", err)
+		return
+	}
+
+	for _, state := range due {
+		s.mu.Lock()
+		job, ok := s.jobs[state.Name]
+		s.mu.Unlock()
+		if !ok {
+			continue // registered by another version of the server
+		}
+		if err := s.start(job, true); err != nil && err != ErrJobAlreadyLocked {
+			log.Printf("Job scheduler: failed to start %s: %v", job.Name, err)
+		}
+	}
+}
+
+// Trigger runs a job now, regardless of its schedule
+func (s *Scheduler) Trigger(name string) error {
+	s.mu.Lock()
+	job, ok := s.jobs[name]
+	s.mu.Unlock()
+	if !ok {
+		return ErrJobNotFound
+	}
+	return s.start(job, false)
+}
+
+// start claims the job lock and runs the job in the background. Scheduled runs only
+// claim the lock while the job is still due, so a replica that lost the race does nothing.
+func (s *Scheduler) start(job *Job, scheduled bool) error {
+	now := time.Now()
+	lockUntil := now.Add(job.Timeout)
+
+	claim := s.db.Model(&models.ScheduledJob{}).
+		Where("name = ? AND (locked_until IS NULL OR locked_until < ?)", job.Name, now)
+	if scheduled {
+		claim = claim.Where("is_enabled = ? AND next_run_at <= ?", true, now)
+	}
+	result := claim.Updates(map[string]interface{}{
+		"locked_by":       s.instanceID,
+		"locked_until":    lockUntil,
+		"last_status":     models.JobStatusRunning,
+		"last_started_at": now,
+		"last_run_by":     s.instanceID,
+		"next_run_at":     job.next(now),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrJobAlreadyLocked
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.execute(job, now)
+	}()
+	return nil
+}
+
+func (s *Scheduler) execute(job *Job, startedAt time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), job.Timeout)
+	defer cancel()
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return job.Run(ctx)
+	}()
+
+	finishedAt := time.Now()
+	updates := map[string]interface{}{
+		"locked_by":        "",
+		"locked_until":     nil,
+		"last_finished_at": finishedAt,
+		"last_duration_ms": finishedAt.Sub(startedAt).Milliseconds(),
+		"run_count":        gorm.Expr("run_count + 1"),
+	}
+	if err != nil {
+		log.Printf("Job %s failed: %v", job.Name, err)
+		updates["last_status"] = models.JobStatusFailed
+		updates["last_error"] = err.Error()
+		updates["failure_count"] = gorm.Expr("failure_count + 1")
+	} else {
+		updates["last_status"] = models.JobStatusSuccess
+		updates["last_error"] = ""
+	}
+
+	if dbErr := s.db.Model(&models.ScheduledJob{}).Where("name = ? AND locked_by = ?", job.Name, s.instanceID).
+		Updates(updates).Error; dbErr != nil {
+		log.Printf("Job scheduler: failed to record result of %s: %v", job.Name, dbErr)
+	}
+}
+
+// SetEnabled pauses or resumes a job across all replicas
+func (s *Scheduler) SetEnabled(name string, enabled bool) error {
+	s.mu.Lock()
+	job, ok := s.jobs[name]
+	s.mu.Unlock()
+	if !ok {
+		return ErrJobNotFound
+	}
+
+	updates := map[string]interface{}{"is_enabled": enabled}
+	if enabled {
+		updates["next_run_at"] = job.next(time.Now())
+	}
+	return s.db.Model(&models.ScheduledJob{}).Where("name = ?", name).Updates(updates).Error
+}
+
+// Jobs lists the registered jobs with their shared state
+func (s *Scheduler) Jobs() ([]JobInfo, error) {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.jobs))
+	for name := range s.jobs {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	sort.Strings(names)
+
+	var states []models.ScheduledJob
+	if err := s.db.Where("name IN ?", names).Find(&states).Error; err != nil {
+		return nil, err
+	}
+	byName := map[string]models.ScheduledJob{}
+	for _, state := range states {
+		byName[state.Name] = state
+	}
+
+	jobs := make([]JobInfo, 0, len(names))
+	for _, name := range names {
+		state := byName[name]
+		jobs = append(jobs, JobInfo{ScheduledJob: state, Running: state.IsLocked()})
+	}
+	return jobs, nil
+}